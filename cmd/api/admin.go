@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// handleListLimiterClients returns the clients currently tracked by the rate
+// limiter, the ones with the most rejected requests first, so that we can tell
+// whether 429s are down to a single noisy client. The number of clients returned
+// is set with the limit query string parameter (default 10, at most 100).
+func (app *application) handleListLimiterClients(w http.ResponseWriter, r *http.Request) {
+    v := validator.New()
+
+    limit := app.readInt(r.URL.Query(), "limit", 10, v)
+    v.Check(limit > 0, "limit", "greater_than_zero")
+    v.CheckParams(limit <= 100, "limit", "too_large", map[string]string{"max": "100"})
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    clients := app.limiters.top(limit, app.clock.Now())
+
+    err := app.writeJSON(w, http.StatusOK, app.envelopeLimiterClients(clients), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleResetLimiterClient forgets the rate limiter for a client, identified by the
+// key shown by handleListLimiterClients (currently its IP address), so that its
+// next request starts with a full bucket.
+func (app *application) handleResetLimiterClient(w http.ResponseWriter, r *http.Request) {
+    key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+    if !app.limiters.reset(key) {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    err := app.writeJSON(w, http.StatusOK, app.envelopeMessage("rate limiter successfully reset"), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleSetReadOnly turns read-only mode on or off at runtime, with a body of
+// {"enabled": true} or {"enabled": false}. The change only applies to this
+// instance, and lasts until it is restarted (when the -read-only flag applies
+// again).
+func (app *application) handleSetReadOnly(w http.ResponseWriter, r *http.Request) {
+    enabled, ok := app.readEnabled(w, r)
+    if !ok {
+        return
+    }
+
+    if app.readOnly.Swap(enabled) != enabled {
+        app.logger.PrintWarn("read-only mode changed", map[string]string{
+            "read_only": strconv.FormatBool(enabled),
+        })
+    }
+
+    err := app.writeJSON(w, http.StatusOK, app.envelopeReadOnly(enabled), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleSetMaintenance turns maintenance mode on or off at runtime, in the same way
+// as handleSetReadOnly. It can be called from any address, even while maintenance
+// mode is on.
+func (app *application) handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+    enabled, ok := app.readEnabled(w, r)
+    if !ok {
+        return
+    }
+
+    if app.maintenance.Swap(enabled) != enabled {
+        app.logger.PrintWarn("maintenance mode changed", map[string]string{
+            "maintenance": strconv.FormatBool(enabled),
+        })
+    }
+
+    err := app.writeJSON(w, http.StatusOK, app.envelopeMaintenance(enabled), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// readEnabled reads a body of {"enabled": true} or {"enabled": false}, for the
+// endpoints which turn a mode on and off. If the body is invalid it sends the error
+// response itself, and returns false as its second value.
+func (app *application) readEnabled(w http.ResponseWriter, r *http.Request) (bool, bool) {
+    var input struct {
+        Enabled *bool `json:"enabled"`
+    }
+
+    err := app.readJSON(w, r, &input)
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return false, false
+    }
+
+    v := validator.New()
+
+    if v.Check(input.Enabled != nil, "enabled", "required"); !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return false, false
+    }
+
+    return *input.Enabled, true
+}
+
+// handleListBrokenLinks returns the movies whose trailer or homepage links were
+// found to be broken by the link checker, most recently checked first.
+func (app *application) handleListBrokenLinks(w http.ResponseWriter, r *http.Request) {
+    movies, err := app.models.Movies.GetBrokenLinks(r.Context())
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovieLinks(movies), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// summarySection is one part of the admin summary. Each section is gathered
+// independently, so that one failing doesn't stop the others from being reported.
+type summarySection struct {
+    name string
+    gather func(ctx context.Context) (interface{}, error)
+}
+
+// handleShowAdminSummary answers "is the system healthy and busy?" in a single call,
+// combining the recent request and error counts, the connection pool statistics,
+// the rate limiter counters, the requests in flight and today's sign-ups and new
+// movies. The sections are gathered concurrently under a shared timeout. A section
+// which fails is reported as {"error": "..."} and the rest of the summary is still
+// returned.
+func (app *application) handleShowAdminSummary(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+    defer cancel()
+
+    sections := []summarySection{
+        {"requests", app.summaryRequests},
+        {"database", app.summaryDatabase},
+        {"rate_limiter", app.summaryRateLimiter},
+        {"concurrency", app.summaryConcurrency},
+        {"today", app.summaryToday},
+    }
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    summary := make(map[string]interface{}, len(sections))
+
+    for _, section := range sections {
+        section := section
+
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+
+            result, err := section.gather(ctx)
+            if err != nil {
+                result = map[string]string{"error": err.Error()}
+            }
+
+            mu.Lock()
+            summary[section.name] = result
+            mu.Unlock()
+        }()
+    }
+
+    wg.Wait()
+
+    err := app.writeJSON(w, http.StatusOK, app.envelopeAdminSummary(summary), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// summaryRequests reports the requests of the last five minutes, as counted by the
+// metrics() middleware.
+func (app *application) summaryRequests(ctx context.Context) (interface{}, error) {
+    counts := app.requestStats.counts(app.clock.Now())
+
+    var errorRate float64
+    if counts.Total > 0 {
+        errorRate = math.Round(float64(counts.ServerErrors)/float64(counts.Total)*10000) / 10000
+    }
+
+    return map[string]interface{}{
+        "window": (requestWindowMinutes * time.Minute).String(),
+        "total": counts.Total,
+        "client_errors": counts.ClientErrors,
+        "server_errors": counts.ServerErrors,
+        "server_error_rate": errorRate,
+    }, nil
+}
+
+// summaryDatabase reports the state of the connection pool.
+func (app *application) summaryDatabase(ctx context.Context) (interface{}, error) {
+    stats := app.models.DBStats()
+
+    return map[string]interface{}{
+        "max_open": stats.MaxOpenConnections,
+        "open": stats.OpenConnections,
+        "in_use": stats.InUse,
+        "idle": stats.Idle,
+        "wait_count": stats.WaitCount,
+        "wait_duration": stats.WaitDuration.String(),
+    }, nil
+}
+
+// summaryRateLimiter reports the allowed and rejected request counts of the rate
+// limiter since the server started, by key mode, and how many clients it is
+// currently tracking.
+func (app *application) summaryRateLimiter(ctx context.Context) (interface{}, error) {
+    return map[string]interface{}{
+        "allowed": expvarCounts(limiterAllowed),
+        "rejected": expvarCounts(limiterRejected),
+        "clients": app.limiters.size(),
+    }, nil
+}
+
+// summaryToday reports the number of users who registered and movies which were
+// added since midnight (UTC).
+func (app *application) summaryToday(ctx context.Context) (interface{}, error) {
+    now := app.clock.Now().UTC()
+    midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+    users, err := app.models.Users.CountCreatedSince(ctx, midnight)
+    if err != nil {
+        return nil, err
+    }
+
+    movies, err := app.models.Movies.CountCreatedSince(ctx, midnight)
+    if err != nil {
+        return nil, err
+    }
+
+    return map[string]int{
+        "users_registered": users,
+        "movies_created": movies,
+    }, nil
+}
+
+// expvarCounts returns the integer values of an expvar map, keyed by name.
+func expvarCounts(m *expvar.Map) map[string]int64 {
+    counts := make(map[string]int64)
+
+    m.Do(func(kv expvar.KeyValue) {
+        if i, ok := kv.Value.(*expvar.Int); ok {
+            counts[kv.Key] = i.Value()
+        }
+    })
+
+    return counts
+}
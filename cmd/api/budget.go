@@ -0,0 +1,67 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// responsesTruncated counts the list responses which were cut short to fit within
+// the -response-size-budget, so that we can tell whether the budget is too tight.
+var responsesTruncated = expvar.NewInt("responses_truncated")
+
+// fitSizeBudget encodes a list response, keeping it within the -response-size-budget
+// if it can. build(n) must return the envelope holding the first n of the count
+// items. If all of them don't fit, the largest number that does is found by binary
+// search, encoding the whole envelope each time; as the size is measured on the
+// same encoding that is sent, the result is always valid JSON however the body is
+// formatted. The budget is soft: at least one item is always included, so that a
+// client can make progress however large the items are. It returns the encoded
+// body and the number of items in it. A budget of 0 disables the check.
+func (app *application) fitSizeBudget(count int, build func(n int) envelope) ([]byte, int, error) {
+    js, err := app.marshalJSON(build(count))
+    if err != nil {
+        return nil, 0, err
+    }
+
+    budget := app.config.responseSizeBudget
+    if budget <= 0 || len(js) <= budget || count <= 1 {
+        return js, count, nil
+    }
+
+    best, bestJS := 1, []byte(nil)
+    lo, hi := 1, count-1
+    for lo <= hi {
+        mid := (lo + hi) / 2
+
+        candidate, err := app.marshalJSON(build(mid))
+        if err != nil {
+            return nil, 0, err
+        }
+
+        if len(candidate) <= budget {
+            best, bestJS = mid, candidate
+            lo = mid + 1
+        } else {
+            hi = mid - 1
+        }
+    }
+
+    // Not even a single item fits, so send one anyway.
+    if bestJS == nil {
+        bestJS, err = app.marshalJSON(build(1))
+        if err != nil {
+            return nil, 0, err
+        }
+    }
+
+    responsesTruncated.Add(1)
+
+    return bestJS, best, nil
+}
+
+// truncationWarning sets a Warning header on a response whose list was cut short by
+// fitSizeBudget(), telling the client to narrow its query.
+func truncationWarning(header http.Header, served, count int, items string) {
+    header.Set("Warning", fmt.Sprintf(`199 - "response truncated to %d of %d %s to fit the size budget; narrow the query or use a smaller page_size"`, served, count, items))
+}
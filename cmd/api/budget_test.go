@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// budgetModes are the two ways that JSON responses can be formatted, which the
+// size budget must work with.
+var budgetModes = []struct {
+    name string
+    compact bool
+}{
+    {"pretty", false},
+    {"compact", true},
+}
+
+// checkFormatting fails the test if body isn't valid JSON formatted as the mode asks.
+func checkFormatting(t *testing.T, body []byte, compact bool) {
+    t.Helper()
+
+    if !json.Valid(body) {
+        t.Fatalf("body isn't valid JSON: %q", body)
+    }
+    if indented := strings.Contains(string(body), "\n\t"); indented == compact {
+        t.Errorf("indented = %t with compact = %t: %q", indented, compact, body)
+    }
+}
+
+func TestFitSizeBudget(t *testing.T) {
+    items := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot"}
+    build := func(n int) envelope {
+        return envelope{"items": items[:n], "metadata": data.Metadata{}.Truncate(n, len(items))}
+    }
+
+    for _, mode := range budgetModes {
+        t.Run(mode.name, func(t *testing.T) {
+            app := newTestApplication(t, nil)
+            app.config.compactJSON = mode.compact
+
+            full, err := app.marshalJSON(build(len(items)))
+            if err != nil {
+                t.Fatal(err)
+            }
+            two, err := app.marshalJSON(build(2))
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            tests := []struct {
+                name string
+                budget int
+                wantServed int
+            }{
+                {"disabled", 0, len(items)},
+                {"everything fits", len(full), len(items)},
+                {"exactly two fit", len(two), 2},
+                {"one byte short of two", len(two) - 1, 1},
+                {"nothing fits", 1, 1},
+            }
+
+            for _, tt := range tests {
+                app.config.responseSizeBudget = tt.budget
+                truncatedBefore := responsesTruncated.Value()
+
+                js, served, err := app.fitSizeBudget(len(items), build)
+                if err != nil {
+                    t.Fatalf("%s: %v", tt.name, err)
+                }
+                if served != tt.wantServed {
+                    t.Errorf("%s: served = %d; want %d", tt.name, served, tt.wantServed)
+                }
+                checkFormatting(t, js, mode.compact)
+                if tt.wantServed > 1 && tt.budget > 0 && len(js) > tt.budget {
+                    t.Errorf("%s: %d bytes; want at most %d", tt.name, len(js), tt.budget)
+                }
+
+                var body struct {
+                    Items []string `json:"items"`
+                    Metadata data.Metadata `json:"metadata"`
+                }
+                if err := json.Unmarshal(js, &body); err != nil {
+                    t.Fatalf("%s: %v", tt.name, err)
+                }
+                if len(body.Items) != served {
+                    t.Errorf("%s: %d items in the body; want %d", tt.name, len(body.Items), served)
+                }
+
+                truncated := served < len(items)
+                if body.Metadata.Truncated != truncated || (truncated && body.Metadata.Served != served) {
+                    t.Errorf("%s: metadata = %+v; want truncated %t, served %d", tt.name, body.Metadata, truncated, served)
+                }
+                if delta := responsesTruncated.Value() - truncatedBefore; (delta == 1) != truncated {
+                    t.Errorf("%s: responses_truncated went up by %d", tt.name, delta)
+                }
+            }
+        })
+    }
+}
+
+// The list endpoint cuts the page short to fit the budget in either mode, saying so
+// in the metadata and a Warning header.
+func TestListMoviesSizeBudget(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    for _, title := range []string{"Moana", "Coco", "Up", "Soul"} {
+        createTestMovie(t, ts, title)
+    }
+
+    type listBody struct {
+        Movies []movieResponse `json:"movies"`
+        Metadata data.Metadata `json:"metadata"`
+    }
+
+    for _, mode := range budgetModes {
+        t.Run(mode.name, func(t *testing.T) {
+            app.config.compactJSON = mode.compact
+            app.config.responseSizeBudget = 0
+
+            full := ts.get(t, "/v1/movies")
+            if full.status != http.StatusOK {
+                t.Fatalf("status = %d (body %q)", full.status, full.body)
+            }
+            checkFormatting(t, full.body, mode.compact)
+            if strings.Contains(string(full.body), "truncated") || full.header.Get("Warning") != "" {
+                t.Errorf("untruncated response has truncation metadata or a warning: %q", full.body)
+            }
+
+            tests := []struct {
+                name string
+                budget int
+                wantMax int
+                wantMin int
+            }{
+                {"one byte short", len(full.body) - 1, 3, 1},
+                {"nothing fits", 1, 1, 1},
+            }
+
+            for _, tt := range tests {
+                app.config.responseSizeBudget = tt.budget
+
+                res := ts.get(t, "/v1/movies")
+                if res.status != http.StatusOK {
+                    t.Fatalf("%s: status = %d (body %q)", tt.name, res.status, res.body)
+                }
+                checkFormatting(t, res.body, mode.compact)
+
+                var body listBody
+                res.decode(t, &body)
+
+                served := len(body.Movies)
+                if served < tt.wantMin || served > tt.wantMax {
+                    t.Errorf("%s: served %d movies; want %d to %d", tt.name, served, tt.wantMin, tt.wantMax)
+                }
+                if !body.Metadata.Truncated || body.Metadata.Served != served || body.Metadata.TotalRecords != 4 {
+                    t.Errorf("%s: metadata = %+v; want truncated, served %d of 4", tt.name, body.Metadata, served)
+                }
+                if served > 1 && len(res.body) > tt.budget {
+                    t.Errorf("%s: %d bytes; want at most %d", tt.name, len(res.body), tt.budget)
+                }
+
+                warning := res.header.Get("Warning")
+                if want := fmt.Sprintf("199 - \"response truncated to %d of 4 movies", served); !strings.HasPrefix(warning, want) {
+                    t.Errorf("%s: Warning = %q; want it to start with %q", tt.name, warning, want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// changelogPath is the path of the changelog endpoint, which the Link header of
+// deprecated routes points to.
+const changelogPath = "/v1/changelog"
+
+// apiChange is an entry in the changelog: the version of the application which
+// made the change, the path of the endpoint it affects, and a description of it
+// for client developers. Deprecations also have the date after which the endpoint
+// may stop working, and the endpoint replacing it, if there is one.
+type apiChange struct {
+    Version string `json:"version"`
+    Endpoint string `json:"endpoint"`
+    Description string `json:"description"`
+    Deprecated bool `json:"deprecated"`
+    Sunset *time.Time `json:"sunset,omitempty"`
+    Successor string `json:"successor,omitempty"`
+}
+
+// apiChanges is the registry of changes to the behaviour of existing endpoints,
+// newest first. Add an entry here whenever a change could surprise a client, even
+// if it is backwards compatible, in the version it is released in. Deprecations
+// aren't listed here: they are registered in deprecations.go, so that the routes
+// get the deprecation headers, and the changelog includes them from there.
+var apiChanges = []apiChange{
+    {
+        Version: "1.0.0",
+        Endpoint: "/v1/movies",
+        Description: "The list can be requested as newline-delimited JSON, one movie per line followed by the metadata, with \"Accept: application/x-ndjson\".",
+    },
+    {
+        Version: "1.0.0",
+        Endpoint: "/v1/movies",
+        Description: "?sort=random shuffles the list; add an integer ?seed to page through the same shuffled order.",
+    },
+    {
+        Version: "1.0.0",
+        Endpoint: "/v1/movies/:id",
+        Description: "The ID may have a .json or .xml suffix to choose the format of the response.",
+    },
+    {
+        Version: "1.0.0",
+        Endpoint: "/v1/movies",
+        Description: "Validation error responses include the untranslated message key of each field under \"codes\", and the messages are in the language of the Accept-Language header.",
+    },
+    {
+        Version: "1.0.0",
+        Endpoint: "/v2/movies",
+        Description: "Version 2 of the movie endpoints writes runtimes as a plain number of minutes rather than as \"<n> mins\".",
+    },
+}
+
+// changelog returns the deprecations, by path, followed by the other changes.
+func changelog() []apiChange {
+    paths := make([]string, 0, len(deprecations))
+    for path := range deprecations {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+
+    changes := make([]apiChange, 0, len(deprecations)+len(apiChanges))
+    for _, path := range paths {
+        d := deprecations[path]
+        sunset := d.sunset
+
+        changes = append(changes, apiChange{
+            Version: d.version,
+            Endpoint: path,
+            Description: d.description,
+            Deprecated: true,
+            Sunset: &sunset,
+            Successor: d.successor,
+        })
+    }
+
+    return append(changes, apiChanges...)
+}
+
+// handleShowChangelog lists the changes to the API which clients should know
+// about, including the deprecated endpoints and when they will be removed.
+func (app *application) handleShowChangelog(w http.ResponseWriter, r *http.Request) {
+    err := app.writeJSON(w, http.StatusOK, app.envelopeChangelog(changelog()), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
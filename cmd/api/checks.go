@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/mailer"
+)
+
+// A deployment can come up looking healthy with a wrong SMTP password or a missing
+// database privilege, which only shows up hours later when something needs them.
+// The checks below verify the external dependencies up front. They are run by the
+// "check" command (e.g. `api -db-dsn=... check`), which prints the results and
+// exits non-zero on any failure, and at startup when -check-on-start is set.
+
+// dependencyCheck is a named check of a single external dependency.
+type dependencyCheck struct {
+    name string
+    run func(ctx context.Context) error
+}
+
+// checkResult is the outcome of a dependencyCheck.
+type checkResult struct {
+    Name string `json:"name"`
+    OK bool `json:"ok"`
+    Latency string `json:"latency"`
+    Error string `json:"error,omitempty"`
+}
+
+// dependencyChecks returns the checks for the database and the SMTP server.
+func dependencyChecks(db *sql.DB, m mailer.Mailer) []dependencyCheck {
+    return append(databaseChecks(db), smtpCheck(m))
+}
+
+// databaseChecks returns the checks that the database can be reached, that the
+// migrations have been applied, and that we have the privileges we need.
+func databaseChecks(db *sql.DB) []dependencyCheck {
+    return []dependencyCheck{
+        {name: "database", run: db.PingContext},
+        {name: "database_schema", run: func(ctx context.Context) error {
+            return data.CheckSchema(ctx, db)
+        }},
+        {name: "database_privileges", run: func(ctx context.Context) error {
+            return data.CheckPrivileges(ctx, db)
+        }},
+    }
+}
+
+// smtpCheck returns a check that we can connect and authenticate to the SMTP server.
+func smtpCheck(m mailer.Mailer) dependencyCheck {
+    return dependencyCheck{name: "smtp", run: func(ctx context.Context) error {
+        return m.Check()
+    }}
+}
+
+// runChecks runs the checks in order, each with a 5-second timeout, and reports
+// whether they all passed.
+func runChecks(ctx context.Context, checks []dependencyCheck) ([]checkResult, bool) {
+    results := make([]checkResult, 0, len(checks))
+    ok := true
+
+    for _, check := range checks {
+        ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+
+        start := time.Now()
+        err := check.run(ctx)
+        cancel()
+
+        result := checkResult{
+            Name: check.name,
+            OK: err == nil,
+            Latency: time.Since(start).String(),
+        }
+        if err != nil {
+            result.Error = err.Error()
+            ok = false
+        }
+
+        results = append(results, result)
+    }
+
+    return results, ok
+}
+
+// runCheckCommand runs the dependency checks, writes the results to stdout as a JSON
+// document, and returns the exit code for the process. If the database can't be
+// reached at all, the other database checks are not attempted.
+func runCheckCommand(cfg config) int {
+    var results []checkResult
+    var ok bool
+
+    m := newMailer(cfg)
+
+    start := time.Now()
+
+    db, err := openDB(cfg)
+    if err != nil {
+        results = []checkResult{{Name: "database", Latency: time.Since(start).String(), Error: err.Error()}}
+        smtp, _ := runChecks(context.Background(), []dependencyCheck{smtpCheck(m)})
+        results = append(results, smtp...)
+    } else {
+        defer db.Close()
+        results, ok = runChecks(context.Background(), dependencyChecks(db, m))
+    }
+
+    js, err := json.MarshalIndent(map[string]interface{}{"ok": ok, "checks": results}, "", "\t")
+    if err != nil {
+        return 1
+    }
+    os.Stdout.Write(append(js, '\n'))
+
+    if !ok {
+        return 1
+    }
+
+    return 0
+}
+
+// checkCache caches the results of a set of checks for a short time, so that the
+// readiness endpoint can run the dependency checks without every probe from every
+// load balancer hitting the database.
+type checkCache struct {
+    mu sync.Mutex
+    checks []dependencyCheck
+    ttl time.Duration
+    results []checkResult
+    ok bool
+    checkedAt time.Time
+}
+
+// run returns the cached results if they are fresh enough, and otherwise runs the
+// checks again. The mutex is held while the checks run, so that concurrent probes
+// wait for one run rather than each starting their own. The checks don't use the
+// context of the request which triggered them, because the results are shared:
+// one impatient client shouldn't cause a failure to be cached for everyone.
+func (c *checkCache) run(now time.Time) ([]checkResult, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.results == nil || now.Sub(c.checkedAt) >= c.ttl {
+        c.results, c.ok = runChecks(context.Background(), c.checks)
+        c.checkedAt = now
+    }
+
+    return c.results, c.ok
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+)
+
+// The limitConcurrency() middleware sorts requests into classes, each of which can
+// have its own cap on the number of requests in flight. Exports stream the whole
+// catalog and can run for minutes, so they get a small cap of their own; everything
+// else is in the default class, and only counts towards the global cap.
+//
+// Long polls for a movie change (see movie_changes.go) are the exception: they
+// spend nearly all of their time idle, waiting for a change, so counting them
+// towards the global cap would let a few hundred clients watching movies turn
+// everyone else away. They get a cap of their own instead, and don't count towards
+// the global one.
+const (
+    concurrencyClassDefault = "default"
+    concurrencyClassExport = "export"
+    concurrencyClassLongPoll = "long_poll"
+)
+
+// The occupancy of each class (and the global total) is published under the
+// "concurrency" expvar, so that it shows up at /debug/vars, along with the number
+// of requests per class which were turned away.
+var (
+    concurrencyMetrics = expvar.NewMap("concurrency")
+    concurrencyRejected = new(expvar.Map).Init()
+)
+
+func init() {
+    concurrencyMetrics.Set("rejected", concurrencyRejected)
+}
+
+// semaphore is a counting semaphore, made from a buffered channel with a slot for
+// each request which may be in flight. A nil semaphore has no limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+    if n <= 0 {
+        return nil
+    }
+    return make(semaphore, n)
+}
+
+// acquire takes a slot, waiting until one is free or the done channel is closed,
+// and reports whether it got one.
+func (s semaphore) acquire(done <-chan struct{}) bool {
+    if s == nil {
+        return true
+    }
+
+    select {
+    case s <- struct{}{}:
+        return true
+    default:
+    }
+
+    select {
+    case s <- struct{}{}:
+        return true
+    case <-done:
+        return false
+    }
+}
+
+func (s semaphore) release() {
+    if s != nil {
+        <-s
+    }
+}
+
+// concurrencyLimiter holds the semaphores used by the limitConcurrency()
+// middleware: one for the global cap, and one for each class which has a cap of
+// its own. Requests in the classes listed in separate only take a slot in their
+// class, and not in the global total.
+type concurrencyLimiter struct {
+    total semaphore
+    classes map[string]semaphore
+    separate map[string]bool
+}
+
+func newConcurrencyLimiter(total int, classes map[string]int, separate ...string) *concurrencyLimiter {
+    l := &concurrencyLimiter{
+        total: newSemaphore(total),
+        classes: make(map[string]semaphore),
+        separate: make(map[string]bool),
+    }
+    for class, n := range classes {
+        l.classes[class] = newSemaphore(n)
+    }
+    for _, class := range separate {
+        l.separate[class] = true
+    }
+
+    concurrencyMetrics.Set("in_flight", expvar.Func(func() interface{} {
+        return l.occupancy()
+    }))
+
+    return l
+}
+
+// occupancy returns the number of requests in flight, in total and for each class
+// with a cap. Requests in the default class are only counted in the total, and
+// those in a separate class aren't counted in it.
+func (l *concurrencyLimiter) occupancy() map[string]int {
+    occupancy := map[string]int{"total": len(l.total)}
+    for class, s := range l.classes {
+        occupancy[class] = len(s)
+    }
+
+    return occupancy
+}
+
+// limits returns the caps, in the same shape as occupancy(). Zero means no cap.
+func (l *concurrencyLimiter) limits() map[string]int {
+    limits := map[string]int{"total": cap(l.total)}
+    for class, s := range l.classes {
+        limits[class] = cap(s)
+    }
+
+    return limits
+}
+
+// acquire takes a slot in the request's class and then in the global total, waiting
+// until the context is done for them to become free. The class slot is taken
+// first, so that a request waiting behind its class's cap doesn't hold one of the
+// global slots meanwhile. A request in a separate class only takes the class slot.
+// It reports whether the slots were taken; if so, release() must be called when
+// the request is finished.
+func (l *concurrencyLimiter) acquire(ctx context.Context, class string) bool {
+    if !l.classes[class].acquire(ctx.Done()) {
+        return false
+    }
+
+    if l.separate[class] {
+        return true
+    }
+
+    if !l.total.acquire(ctx.Done()) {
+        l.classes[class].release()
+        return false
+    }
+
+    return true
+}
+
+func (l *concurrencyLimiter) release(class string) {
+    if !l.separate[class] {
+        l.total.release()
+    }
+    l.classes[class].release()
+}
+
+// concurrencyClass returns the class of a request, from its path. A request to show
+// a movie is a long poll if it has the wait_version_gt parameter.
+func (app *application) concurrencyClass(r *http.Request) string {
+    if r.Method == http.MethodGet && r.URL.Query().Has("wait_version_gt") {
+        id, ok := strings.CutPrefix(r.URL.Path, app.apiPath("/v1/movies/"))
+        if ok && id != "" && !strings.Contains(id, "/") {
+            return concurrencyClassLongPoll
+        }
+    }
+
+    switch r.URL.Path {
+    case app.apiPath("/v1/admin/movies/export"):
+        return concurrencyClassExport
+    default:
+        return concurrencyClassDefault
+    }
+}
+
+// The limitConcurrency() middleware caps the number of requests in flight, so that
+// a handful of slow requests (such as exports) can't tie up the server while
+// everything else queues behind them. Unlike rateLimit(), which limits how often
+// each client may call us, this limits how much work we take on at once. When a
+// cap is reached the request waits for a slot for up to the -concurrency-wait
+// duration, and then gets a 503 response with a Retry-After header rather than
+// queueing for as long as it takes. The healthcheck, readiness and metrics
+// endpoints are exempt, so that we can still see what is going on when the server
+// is overloaded.
+func (app *application) limitConcurrency(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case app.apiPath("/v1/healthcheck"), app.apiPath("/v1/healthz/ready"), app.apiPath(debugVarsPath):
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        class := app.concurrencyClass(r)
+
+        ctx, cancel := context.WithTimeout(r.Context(), app.config.concurrency.wait)
+        defer cancel()
+
+        if !app.concurrency.acquire(ctx, class) {
+            concurrencyRejected.Add(class, 1)
+            app.serverBusyResponse(w, r)
+            return
+        }
+        defer app.concurrency.release(class)
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// summaryConcurrency reports the number of requests in flight against the caps,
+// and how many were turned away, as counted by the limitConcurrency() middleware.
+func (app *application) summaryConcurrency(ctx context.Context) (interface{}, error) {
+    return map[string]interface{}{
+        "in_flight": app.concurrency.occupancy(),
+        "limits": app.concurrency.limits(),
+        "rejected": expvarCounts(concurrencyRejected),
+    }, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyClass(t *testing.T) {
+    tests := []struct {
+        method string
+        path string
+        want string
+    }{
+        {http.MethodGet, "/v1/movies/1?wait_version_gt=2", concurrencyClassLongPoll},
+        {http.MethodGet, "/v1/movies/1.xml?wait_version_gt=2&timeout=5", concurrencyClassLongPoll},
+        {http.MethodGet, "/v1/movies/1", concurrencyClassDefault},
+        {http.MethodGet, "/v1/movies?wait_version_gt=2", concurrencyClassDefault},
+        {http.MethodGet, "/v1/movies/1/versions?wait_version_gt=2", concurrencyClassDefault},
+        {http.MethodPatch, "/v1/movies/1?wait_version_gt=2", concurrencyClassDefault},
+        {http.MethodGet, "/v1/admin/movies/export", concurrencyClassExport},
+    }
+
+    app := newTestApplication(t, nil)
+
+    for _, tt := range tests {
+        r := httptest.NewRequest(tt.method, tt.path, nil)
+        if got := app.concurrencyClass(r); got != tt.want {
+            t.Errorf("%s %s: class = %q; want %q", tt.method, tt.path, got, tt.want)
+        }
+    }
+}
+
+// Long polls have their own cap and don't take a slot in the global one, so that
+// idle clients waiting for a change can't crowd out everything else.
+func TestConcurrencyLimiterLongPolls(t *testing.T) {
+    l := newConcurrencyLimiter(1, map[string]int{concurrencyClassLongPoll: 2}, concurrencyClassLongPoll)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+
+    for i := 0; i < 2; i++ {
+        if !l.acquire(ctx, concurrencyClassLongPoll) {
+            t.Fatalf("long poll %d didn't get a slot", i+1)
+        }
+    }
+    if l.acquire(ctx, concurrencyClassLongPoll) {
+        t.Fatal("a third long poll got a slot; want the cap of 2")
+    }
+
+    if !l.acquire(context.Background(), concurrencyClassDefault) {
+        t.Fatal("a request didn't get a slot while only long polls were in flight")
+    }
+    if got := l.occupancy(); got["total"] != 1 || got[concurrencyClassLongPoll] != 2 {
+        t.Errorf("occupancy() = %v; want total 1 and long_poll 2", got)
+    }
+
+    l.release(concurrencyClassLongPoll)
+    l.release(concurrencyClassDefault)
+    if got := l.occupancy(); got["total"] != 0 || got[concurrencyClassLongPoll] != 1 {
+        t.Errorf("after releasing: occupancy() = %v; want total 0 and long_poll 1", got)
+    }
+}
+
+// When every slot is taken, the health checks and the metrics endpoint still get
+// through, under the base path too.
+func TestLimitConcurrencyExemptions(t *testing.T) {
+    app := newTestApplication(t, nil)
+    app.config.basePath = "/catalog"
+    app.config.concurrency.wait = 10 * time.Millisecond
+    app.concurrency = newConcurrencyLimiter(1, nil)
+
+    if !app.concurrency.acquire(context.Background(), concurrencyClassDefault) {
+        t.Fatal("couldn't take the only slot")
+    }
+    defer app.concurrency.release(concurrencyClassDefault)
+
+    handler := app.limitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    tests := []struct {
+        path string
+        wantStatus int
+    }{
+        {"/catalog/v1/healthcheck", http.StatusOK},
+        {"/catalog/v1/healthz/ready", http.StatusOK},
+        {"/catalog/debug/vars", http.StatusOK},
+        {"/catalog/v1/movies", http.StatusServiceUnavailable},
+    }
+
+    for _, tt := range tests {
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+        if rr.Code != tt.wantStatus {
+            t.Errorf("%s: status = %d; want %d", tt.path, rr.Code, tt.wantStatus)
+        }
+    }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// Define a custom contextKey type, with the underlying type string, so that our
+// keys can't collide with keys set by other packages using the request context.
+type contextKey string
+
+// The apiVersionContextKey holds the major version of the API (1 or 2) that the
+// request was routed to.
+const apiVersionContextKey = contextKey("apiVersion")
+
+// The contextSetAPIVersion() method returns a new copy of the request with the API
+// version added to its context.
+func (app *application) contextSetAPIVersion(r *http.Request, version int) *http.Request {
+    ctx := context.WithValue(r.Context(), apiVersionContextKey, version)
+    return r.WithContext(ctx)
+}
+
+// The contextGetAPIVersion() method returns the API version that the request was
+// routed to. Handlers are shared between the versions, so a request which didn't
+// come through a versioned route (which shouldn't happen) is treated as version 1.
+func (app *application) contextGetAPIVersion(r *http.Request) int {
+    version, ok := r.Context().Value(apiVersionContextKey).(int)
+    if !ok {
+        return 1
+    }
+
+    return version
+}
+
+// The formatContextKey holds the response format (formatJSON or formatXML) that the
+// request asked for with a suffix, as set by formatSuffix().
+const formatContextKey = contextKey("format")
+
+func (app *application) contextSetFormat(r *http.Request, format string) *http.Request {
+    ctx := context.WithValue(r.Context(), formatContextKey, format)
+    return r.WithContext(ctx)
+}
+
+// The contextGetFormat() method returns the response format that the request asked
+// for, which is formatJSON unless it used a suffix saying otherwise.
+func (app *application) contextGetFormat(r *http.Request) string {
+    format, ok := r.Context().Value(formatContextKey).(string)
+    if !ok {
+        return formatJSON
+    }
+
+    return format
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// logDBStats writes a log entry with the connection pool statistics at the given
+// interval, until the context is cancelled. When the pool runs out of connections
+// these entries show how it got there, which the current values at /debug/vars
+// can't tell us after the fact.
+func (app *application) logDBStats(ctx context.Context, interval time.Duration) {
+    ticker := app.clock.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C():
+        }
+
+        app.logger.PrintInfo("database connection pool", dbStatsProperties(app.models.DBStats()))
+    }
+}
+
+// dbStatsProperties returns the connection pool statistics as log entry properties.
+func dbStatsProperties(stats sql.DBStats) map[string]string {
+    return map[string]string{
+        "max_open": strconv.Itoa(stats.MaxOpenConnections),
+        "open": strconv.Itoa(stats.OpenConnections),
+        "in_use": strconv.Itoa(stats.InUse),
+        "idle": strconv.Itoa(stats.Idle),
+        "wait_count": strconv.FormatInt(stats.WaitCount, 10),
+        "wait_duration": stats.WaitDuration.String(),
+        "max_idle_closed": strconv.FormatInt(stats.MaxIdleClosed, 10),
+        "max_idle_time_closed": strconv.FormatInt(stats.MaxIdleTimeClosed, 10),
+    }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// debugVarsPath is the path of the expvar endpoint, which shows the runtime memory
+// statistics and our own counters, such as the circuit breaker states of the
+// outbound HTTP clients. It isn't versioned, as it isn't part of the API proper,
+// and it is only open to admins, as the counters describe our traffic.
+const debugVarsPath = "/debug/vars"
+
+// redactedHeaders are the request headers whose values the echo endpoint hides,
+// as they carry credentials.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// debugEchoMethods are the methods that the echo endpoint is registered for.
+var debugEchoMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// debugEcho describes a request as the API received it.
+type debugEcho struct {
+    Method string `json:"method"`
+    Path string `json:"path"`
+    Headers http.Header `json:"headers"`
+    Query map[string][]string `json:"query"`
+    Body string `json:"body"`
+}
+
+// handleDebugEcho returns the method, path, headers, query string and body of the
+// request as the API received it, so that client developers can check what
+// actually reaches us through proxies and gateways. Credentials in the headers are
+// redacted, and the body is read up to 1MB. It is only registered when -env is
+// "development".
+func (app *application) handleDebugEcho(w http.ResponseWriter, r *http.Request) {
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return
+    }
+
+    headers := r.Header.Clone()
+    for _, name := range redactedHeaders {
+        if _, ok := headers[name]; ok {
+            headers[name] = []string{"[REDACTED]"}
+        }
+    }
+
+    echo := debugEcho{
+        Method: r.Method,
+        Path: r.URL.Path,
+        Headers: headers,
+        Query: r.URL.Query(),
+        Body: string(body),
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeDebugEcho(echo), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deprecation describes a route which is going to be removed: the version in which
+// it was deprecated and why, the date after which it may stop working, and
+// (optionally) the path of the route replacing it. Once the sunset has passed the
+// tests fail while the route is still registered (see checkSunsets()), unless
+// keepPastSunset is set, which should come with a comment saying why the route has
+// to stay for longer.
+type deprecation struct {
+    version string
+    description string
+    sunset time.Time
+    successor string
+    keepPastSunset bool
+}
+
+// deprecations is the registry of deprecated routes, keyed by their path in the
+// route table. Every method of a listed path is deprecated. Responses from these
+// routes carry a "Deprecation: true" header and a Sunset header with the date
+// (RFC 8594), plus Links to the successor and to the changelog, so that clients
+// can find out about the change before the route goes away. The deprecations are
+// also listed by the changelog endpoint.
+var deprecations = map[string]deprecation{
+    "/v1/healthcheck": {
+        version: "1.0.0",
+        description: "The healthcheck predates the readiness endpoint, which also checks that the database is usable. Load balancers should move over to that.",
+        sunset: time.Date(2027, time.April, 16, 0, 0, 0, 0, time.UTC),
+        successor: "/v1/healthz/ready",
+    },
+}
+
+// checkSunsets returns an error listing the routes in the table whose sunset has
+// passed, unless they are marked to be kept. It is run by TestSunsets and by the
+// "routes" command, both of which run in CI, so that a route we promised to remove
+// can't quietly outlive its sunset. The server doesn't run it at startup, where it
+// would stop a working release from restarting once the date passed.
+func checkSunsets(routes []route, now time.Time) error {
+    var expired []string
+    seen := make(map[string]bool)
+
+    for _, rt := range routes {
+        d, ok := deprecations[rt.path]
+        if !ok || d.keepPastSunset || seen[rt.path] || now.Before(d.sunset) {
+            continue
+        }
+
+        seen[rt.path] = true
+        expired = append(expired, fmt.Sprintf("%s (sunset %s)", rt.path, d.sunset.Format("2006-01-02")))
+    }
+
+    if len(expired) > 0 {
+        return fmt.Errorf("routes past their sunset are still registered: %s; remove them, or set keepPastSunset in deprecations.go", strings.Join(expired, ", "))
+    }
+
+    return nil
+}
+
+// The deprecated() middleware adds the deprecation headers to the responses of a
+// deprecated route, and logs a warning the first time each client calls it, so
+// that we know who still needs to be told about the change.
+func (app *application) deprecated(path string, d deprecation, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Deprecation", "true")
+        w.Header().Set("Sunset", d.sunset.Format(http.TimeFormat))
+        if d.successor != "" {
+            w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", app.apiPath(d.successor)))
+        }
+        w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", app.apiPath(changelogPath)))
+
+        client, _, err := net.SplitHostPort(r.RemoteAddr)
+        if err != nil {
+            client = r.RemoteAddr
+        }
+
+        if app.deprecationWarnings.first(path, client) {
+            app.logger.PrintWarn("deprecated route called", map[string]string{
+                "route": path,
+                "client": client,
+                "sunset": d.sunset.Format("2006-01-02"),
+            })
+        }
+
+        next(w, r)
+    }
+}
+
+// maxWarnedClients bounds the memory used by warnedClients. When it is reached the
+// record is cleared, so at worst some clients are warned about more than once.
+const maxWarnedClients = 10000
+
+// warnedClients records the clients which have been warned about calling each
+// deprecated route. The zero value is ready to use.
+type warnedClients struct {
+    mu sync.Mutex
+    seen map[string]bool
+}
+
+// first reports whether this is the first time that the client has called the
+// route, and records that it has.
+func (c *warnedClients) first(route, client string) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    key := route + " " + client
+    if c.seen[key] {
+        return false
+    }
+
+    if c.seen == nil || len(c.seen) >= maxWarnedClients {
+        c.seen = make(map[string]bool)
+    }
+    c.seen[key] = true
+
+    return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSunsets fails once a deprecated route is past its sunset but still
+// registered, as a reminder to remove it.
+func TestSunsets(t *testing.T) {
+    app := newTestApplication(t, nil)
+
+    err := checkSunsets(app.routeTable(), time.Now())
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestCheckSunsets(t *testing.T) {
+    d := deprecations["/v1/healthcheck"]
+    routes := []route{
+        {http.MethodGet, "/v1/healthcheck", nil, accessPublic, ""},
+        {http.MethodHead, "/v1/healthcheck", nil, accessPublic, ""},
+    }
+
+    err := checkSunsets(routes, d.sunset.Add(-time.Second))
+    if err != nil {
+        t.Errorf("before the sunset: err = %v; want nil", err)
+    }
+
+    err = checkSunsets(routes, d.sunset)
+    if err == nil {
+        t.Fatal("at the sunset: err = nil; want an error")
+    }
+    if strings.Count(err.Error(), "/v1/healthcheck") != 1 {
+        t.Errorf("err = %q; want the route listed once", err)
+    }
+
+    err = checkSunsets(routes[:0], d.sunset)
+    if err != nil {
+        t.Errorf("with the route removed: err = %v; want nil", err)
+    }
+}
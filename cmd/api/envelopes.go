@@ -0,0 +1,275 @@
+package main
+
+import (
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// The envelope constructors below are the only place where the top-level keys of
+// our JSON responses are spelled out. Handlers should always build their response
+// bodies with one of these helpers rather than with an envelope{} literal, so that
+// a given resource is returned under the same key by every endpoint.
+
+// envelopeMovie wraps a single movie under the "movie" key, with its runtime in
+// the given format.
+func (app *application) envelopeMovie(movie *data.Movie, runtimeFormat string) envelope {
+    return envelope{"movie": app.toMovieResponse(movie, runtimeFormat)}
+}
+
+// envelopeMovies wraps a page of movies and its pagination metadata under the
+// "movies" and "metadata" keys, with their runtimes in the given format.
+func (app *application) envelopeMovies(movies []*data.Movie, metadata data.Metadata, runtimeFormat string) envelope {
+    responses := make([]*movieResponse, len(movies))
+    for i, movie := range movies {
+        responses[i] = app.toMovieResponse(movie, runtimeFormat)
+    }
+
+    return envelope{"movies": responses, "metadata": metadata}
+}
+
+// envelopeUser wraps a single user under the "user" key.
+func (app *application) envelopeUser(user *data.User) envelope {
+    return envelope{"user": user}
+}
+
+// envelopeMessage wraps a human-readable confirmation message under the
+// "message" key.
+func (app *application) envelopeMessage(message string) envelope {
+    return envelope{"message": message}
+}
+
+// envelopeError wraps an error message (either a string or a map of validation
+// errors) under the "error" key.
+func (app *application) envelopeError(message interface{}) envelope {
+    return envelope{"error": message}
+}
+
+// envelopeValidationError wraps the translated validation messages for each field
+// under the "error" key, and their message keys under the "codes" key.
+func (app *application) envelopeValidationError(messages, codes map[string]string) envelope {
+    return envelope{"error": messages, "codes": codes}
+}
+
+// envelopeErrorWithInput wraps validation errors as envelopeValidationError does,
+// along with the rejected input under the "input" key.
+func (app *application) envelopeErrorWithInput(messages, codes map[string]string, input interface{}) envelope {
+    return envelope{"error": messages, "codes": codes, "input": input}
+}
+
+// envelopeRouteError wraps an error message under the "error" key, along with the
+// method and path of the request which couldn't be routed.
+func (app *application) envelopeRouteError(message string, method, path string) envelope {
+    return envelope{"error": message, "method": method, "path": path}
+}
+
+// envelopeHealthCheck wraps the application status and system information
+// returned by the healthcheck endpoint.
+func (app *application) envelopeHealthCheck(status string, systemInfo map[string]string) envelope {
+    return envelope{"status": status, "system_info": systemInfo}
+}
+
+// envelopeChangelog wraps the list of API changes under the "changelog" key.
+func (app *application) envelopeChangelog(changes []apiChange) envelope {
+    return envelope{"changelog": changes}
+}
+
+// envelopeMovieVersions wraps the version history of a movie under the "versions"
+// key.
+func (app *application) envelopeMovieVersions(versions []*data.MovieVersion) envelope {
+    return envelope{"versions": versions}
+}
+
+// envelopeMovieDiff wraps the field-level changes between two versions of a movie
+// under the "diff" key.
+func (app *application) envelopeMovieDiff(from, to int32, changes map[string]data.FieldChange) envelope {
+    return envelope{"diff": map[string]interface{}{
+        "from": from,
+        "to": to,
+        "changes": changes,
+    }}
+}
+
+// envelopeTranslation wraps a single movie translation under the "translation" key.
+func (app *application) envelopeTranslation(translation *data.MovieTranslation) envelope {
+    return envelope{"translation": app.hideMovieID(translation)}
+}
+
+// envelopeTranslations wraps the translations of a movie under the "translations"
+// key.
+func (app *application) envelopeTranslations(translations []*data.MovieTranslation) envelope {
+    hidden := make([]*data.MovieTranslation, len(translations))
+    for i, translation := range translations {
+        hidden[i] = app.hideMovieID(translation)
+    }
+
+    return envelope{"translations": hidden}
+}
+
+// hideMovieID returns a copy of the translation without the sequential movie ID
+// when movies are identified by UUID, so that the ID isn't leaked. The client
+// already knows which movie it is from the URL.
+func (app *application) hideMovieID(translation *data.MovieTranslation) *data.MovieTranslation {
+    if app.config.idType != idTypeUUID {
+        return translation
+    }
+
+    hidden := *translation
+    hidden.MovieID = 0
+
+    return &hidden
+}
+
+// envelopeLimiterClients wraps the clients returned by the admin limiter endpoint
+// under the "clients" key.
+func (app *application) envelopeLimiterClients(clients []limiterClientInfo) envelope {
+    return envelope{"clients": clients}
+}
+
+// envelopeGenreRename holds the number of movies changed by a genre rename.
+func (app *application) envelopeGenreRename(updated int64) envelope {
+    return envelope{"movies_updated": updated}
+}
+
+// envelopeBulkDeletePreview holds the number of movies that a bulk delete would
+// delete, a sample of their titles, and the token which confirms the delete.
+func (app *application) envelopeBulkDeletePreview(count int64, titles []string, confirm string) envelope {
+    return envelope{"movies_matched": count, "sample_titles": titles, "confirm": confirm}
+}
+
+// envelopeBulkDelete holds the number of movies deleted by a bulk delete, and the ID
+// of its audit record in the movie_deletions table.
+func (app *application) envelopeBulkDelete(deleted, deletionID int64) envelope {
+    return envelope{"movies_deleted": deleted, "deletion_id": deletionID}
+}
+
+// envelopeReadOnly holds whether the API is in read-only mode.
+func (app *application) envelopeReadOnly(readOnly bool) envelope {
+    return envelope{"read_only": readOnly}
+}
+
+// envelopeMaintenance holds whether the API is in maintenance mode.
+func (app *application) envelopeMaintenance(maintenance bool) envelope {
+    return envelope{"maintenance": maintenance}
+}
+
+// envelopeFeatureFlags wraps a list of feature flags under the "features" key.
+func (app *application) envelopeFeatureFlags(flags []*data.FeatureFlag) envelope {
+    return envelope{"features": flags}
+}
+
+// envelopeFeatureFlag wraps a single feature flag under the "feature" key.
+func (app *application) envelopeFeatureFlag(flag *data.FeatureFlag) envelope {
+    return envelope{"feature": flag}
+}
+
+// envelopeMovieUnchanged tells a long-polling client that the movie didn't change
+// before its timeout, along with the current version.
+func (app *application) envelopeMovieUnchanged(version int32) envelope {
+    return envelope{"changed": false, "version": version}
+}
+
+// envelopeNewMovieShare wraps a newly created share under the "share" key, along with
+// its link under the "url" key. The link can't be built again later, as it isn't
+// stored.
+func (app *application) envelopeNewMovieShare(share *data.MovieShare, link string) envelope {
+    return envelope{"share": share, "url": link}
+}
+
+// envelopeMovieShare wraps a single share under the "share" key.
+func (app *application) envelopeMovieShare(share *data.MovieShare) envelope {
+    return envelope{"share": share}
+}
+
+// envelopeMovieShares wraps a list of shares under the "shares" key.
+func (app *application) envelopeMovieShares(shares []*data.MovieShare) envelope {
+    return envelope{"shares": shares}
+}
+
+// envelopeRevalidation wraps a revalidation sweep under the "revalidation" key and,
+// if they were read, the movies it found to be invalid under the "results" key.
+func (app *application) envelopeRevalidation(run *data.Revalidation, results []*data.RevalidationResult) envelope {
+    env := envelope{"revalidation": run}
+    if results != nil {
+        env["results"] = results
+    }
+
+    return env
+}
+
+// envelopeDebugEcho wraps the description of a request under the "echo" key.
+func (app *application) envelopeDebugEcho(echo debugEcho) envelope {
+    return envelope{"echo": echo}
+}
+
+// envelopeMailTemplates wraps the names of the email templates under the
+// "templates" key.
+func (app *application) envelopeMailTemplates(names []string) envelope {
+    return envelope{"templates": names}
+}
+
+// envelopeAdminMovieDetails wraps a movie under the "movie" key, along with the
+// number of views it had in the last 30 days.
+func (app *application) envelopeAdminMovieDetails(movie *data.Movie, views int64) envelope {
+    return envelope{"movie": app.toMovieResponse(movie, data.RuntimeFormatMins), "views_last_30_days": views}
+}
+
+// envelopeAdminSummary wraps the sections of the admin summary under the "summary"
+// key.
+func (app *application) envelopeAdminSummary(summary map[string]interface{}) envelope {
+    return envelope{"summary": summary}
+}
+
+// envelopeMovieLinks wraps the links of a list of movies under the "movies" key.
+func (app *application) envelopeMovieLinks(movies []*data.Movie) envelope {
+    responses := make([]*movieLinksResponse, len(movies))
+    for i, movie := range movies {
+        responses[i] = app.toMovieLinksResponse(movie)
+    }
+
+    return envelope{"movies": responses}
+}
+
+// envelopeReadiness wraps the readiness status and the results of the dependency
+// checks (if they were run) under the "status" and "checks" keys.
+func (app *application) envelopeReadiness(status string, checks []checkResult) envelope {
+    return envelope{"status": status, "checks": checks}
+}
+
+// envelopeSuggestions wraps the ID, title and year of each suggested movie under
+// the "suggestions" key.
+func (app *application) envelopeSuggestions(movies []*data.Movie) envelope {
+    suggestions := make([]*movieSummaryResponse, len(movies))
+    for i, movie := range movies {
+        suggestions[i] = app.toMovieSummaryResponse(movie)
+    }
+
+    return envelope{"suggestions": suggestions}
+}
+
+// envelopeMovieStats wraps the catalog statistics under the "stats" key.
+func (app *application) envelopeMovieStats(stats *data.MovieStats) envelope {
+    return envelope{"stats": app.toMovieStatsResponse(stats)}
+}
+
+// envelopeListMetadata wraps the pagination metadata of a list under the "metadata"
+// key, for the last line of a list streamed as NDJSON.
+func (app *application) envelopeListMetadata(metadata data.Metadata) envelope {
+    return envelope{"metadata": metadata}
+}
+
+// envelopeImportFailure wraps a line of an import which couldn't be imported under
+// the "failed" key, for a line of the import response.
+func (app *application) envelopeImportFailure(failure importLineError) envelope {
+    return envelope{"failed": failure}
+}
+
+// envelopeImportProgress wraps the running totals of an import under the "progress"
+// key, for the line written after each batch.
+func (app *application) envelopeImportProgress(progress importProgress) envelope {
+    return envelope{"progress": progress}
+}
+
+// envelopeImportResult wraps the final totals of an import under the "result" key,
+// for the last line of the import response.
+func (app *application) envelopeImportResult(progress importProgress) envelope {
+    return envelope{"result": progress}
+}
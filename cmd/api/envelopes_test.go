@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// envelopeKeys lists the top-level keys of our JSON responses. A response has
+// exactly one of the primary keys (true), naming the resource it holds, and may
+// have any of the secondary keys (false) alongside it.
+var envelopeKeys = map[string]bool{
+    "movie": true, "movies": true, "user": true, "message": true, "error": true,
+    "status": true, "changelog": true, "versions": true, "diff": true,
+    "translation": true, "translations": true, "clients": true, "movies_updated": true,
+    "movies_matched": true, "movies_deleted": true, "read_only": true,
+    "maintenance": true, "features": true, "feature": true, "changed": true,
+    "share": true, "shares": true, "revalidation": true, "echo": true,
+    "templates": true, "summary": true, "suggestions": true, "stats": true,
+
+    "metadata": false, "system_info": false, "checks": false, "codes": false,
+    "input": false, "method": false, "path": false, "views_last_30_days": false,
+    "sample_titles": false, "confirm": false, "deletion_id": false, "version": false,
+    "url": false, "results": false,
+}
+
+// checkEnvelope reports an error if the JSON object doesn't have exactly one primary
+// key from envelopeKeys, or has a key which isn't in envelopeKeys at all.
+func checkEnvelope(body []byte) error {
+    var env map[string]json.RawMessage
+    if err := json.Unmarshal(body, &env); err != nil {
+        return fmt.Errorf("body is not a JSON object: %v", err)
+    }
+
+    var primary, unknown []string
+    for key := range env {
+        isPrimary, known := envelopeKeys[key]
+        switch {
+        case !known:
+            unknown = append(unknown, key)
+        case isPrimary:
+            primary = append(primary, key)
+        }
+    }
+    sort.Strings(primary)
+    sort.Strings(unknown)
+
+    if len(unknown) > 0 {
+        return fmt.Errorf("unknown top-level keys %q", unknown)
+    }
+    if len(primary) != 1 {
+        return fmt.Errorf("top-level keys %q; want exactly one primary key", primary)
+    }
+
+    return nil
+}
+
+// TestEnvelopeLiterals checks that envelopes are only built in envelopes.go, so that
+// every top-level key is spelled out there.
+func TestEnvelopeLiterals(t *testing.T) {
+    files, err := filepath.Glob("*.go")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    fset := token.NewFileSet()
+
+    for _, file := range files {
+        if file == "envelopes.go" || strings.HasSuffix(file, "_test.go") {
+            continue
+        }
+
+        f, err := parser.ParseFile(fset, file, nil, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        ast.Inspect(f, func(n ast.Node) bool {
+            lit, ok := n.(*ast.CompositeLit)
+            if !ok {
+                return true
+            }
+            if ident, ok := lit.Type.(*ast.Ident); ok && ident.Name == "envelope" {
+                t.Errorf("%s: envelope literal; use a constructor from envelopes.go", fset.Position(lit.Pos()))
+            }
+            return true
+        })
+    }
+}
+
+// TestEnvelopeKeys walks the GET routes, with a movie in the database for the routes
+// which take an ID, and checks the top-level keys of every successful JSON
+// response.
+func TestEnvelopeKeys(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    app.config.admin.token = "secret"
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+
+    params := strings.NewReplacer(
+        ":id", fmt.Sprint(id),
+        ":version", "1",
+        ":name", "welcome",
+    )
+
+    checked := 0
+
+    for _, rt := range app.routeTable() {
+        // The expvar endpoint has the format of the expvar package, not ours.
+        if rt.method != http.MethodGet || rt.path == debugVarsPath {
+            continue
+        }
+
+        path := params.Replace(rt.path)
+
+        t.Run(path, func(t *testing.T) {
+            res := ts.do(t, http.MethodGet, path, nil, http.Header{"Authorization": {"Bearer secret"}})
+            if res.status < 200 || res.status > 299 || !strings.HasPrefix(res.header.Get("Content-Type"), "application/json") {
+                t.Skipf("status %d, Content-Type %q", res.status, res.header.Get("Content-Type"))
+            }
+
+            checked++
+            if err := checkEnvelope(res.body); err != nil {
+                t.Errorf("%v (body %s)", err, res.body)
+            }
+        })
+    }
+
+    if checked < 10 {
+        t.Errorf("only %d routes gave a JSON response to check", checked)
+    }
+}
+
+func TestCheckEnvelope(t *testing.T) {
+    tests := []struct {
+        body string
+        wantErr bool
+    }{
+        {`{"movie": {}}`, false},
+        {`{"movies": [], "metadata": {}}`, false},
+        {`{"Movie": {}}`, true},
+        {`{"data": {}}`, true},
+        {`{"movie": {}, "movies": []}`, true},
+        {`{"metadata": {}}`, true},
+        {`[]`, true},
+    }
+
+    for _, tt := range tests {
+        err := checkEnvelope([]byte(tt.body))
+        if (err != nil) != tt.wantErr {
+            t.Errorf("checkEnvelope(%s) = %v; want error %v", tt.body, err, tt.wantErr)
+        }
+    }
+}
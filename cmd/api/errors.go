@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/i18n"
+	"github.com/agpelkey/greenlight/internal/validator"
 )
 
 func(app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
@@ -10,13 +18,90 @@ func(app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.
     app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// method will be used to send a 429 Too Many Requests status code and JSON response
+// to clients long-polling for a change to a movie which already has as many
+// waiting requests as the -movie-max-waiters setting allows
+func (app *application) tooManyWaitersResponse(w http.ResponseWriter, r *http.Request) {
+	message := "too many requests are already waiting for this movie to change, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// method will be used to send a 401 Unauthorized status code and JSON response to
+// clients using a movie share link which is invalid, expired or revoked. The error
+// holds a code saying which, as well as the message
+func (app *application) invalidShareLinkResponse(w http.ResponseWriter, r *http.Request, code string) {
+	messages := map[string]string{
+		shareLinkInvalid: "the share link is invalid",
+		shareLinkExpired: "the share link has expired",
+		shareLinkRevoked: "the share link has been revoked",
+	}
+
+	app.errorResponse(w, r, http.StatusUnauthorized, map[string]string{"code": code, "message": messages[code]})
+}
+
+// method will be used to send a 409 Conflict status code and JSON response to
+// clients which start a revalidation sweep while another one is still running
+func (app *application) revalidationRunningResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a revalidation is already running, please wait for it to finish"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// The validator records message keys rather than literal text, so before sending
+// the errors to the client we translate each of them into the best language
+// requested in the Accept-Language header (falling back to English). The keys
+// themselves are sent too, under "codes", as they are stable whereas the wording
+// of the messages may change; the Content-Language header says which language the
+// messages are in.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.writeValidationErrors(w, r, v, app.envelopeValidationError)
+}
+
+// failedValidationEchoResponse is used instead of failedValidationResponse by handlers
+// which decode a request body. If the client asked for it with ?echo=true, the
+// response also includes the input as the server parsed it, which makes it easy to
+// spot client fields that didn't map to what was expected. This is off by default
+// so that we don't reflect request data back unless explicitly asked to.
+func (app *application) failedValidationEchoResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, input interface{}) {
+	if r.URL.Query().Get("echo") != "true" {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	app.writeValidationErrors(w, r, v, func(messages, codes map[string]string) envelope {
+		return app.envelopeErrorWithInput(messages, codes, input)
+	})
+}
+
+func (app *application) writeValidationErrors(w http.ResponseWriter, r *http.Request, v *validator.Validator, wrap func(messages, codes map[string]string) envelope) {
+	lang := i18n.Match(r.Header.Get("Accept-Language"))
+
+	headers := make(http.Header)
+	headers.Set("Content-Language", lang)
+
+	err := app.writeJSON(w, http.StatusUnprocessableEntity, wrap(translateErrors(lang, v), v.Errors), headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+func (app *application) validationMessages(r *http.Request, v *validator.Validator) map[string]string {
+	return translateErrors(i18n.Match(r.Header.Get("Accept-Language")), v)
+}
+
+// translateErrors translates the validation errors into the given language.
+func translateErrors(lang string, v *validator.Validator) map[string]string {
+	messages := make(map[string]string, len(v.Errors))
+	for field, messageKey := range v.Errors {
+		messages[field] = i18n.Translate(lang, messageKey, v.Params[field])
+	}
+
+	return messages
 }
 
 func (app *application) logError(r *http.Request, err error) {
@@ -32,7 +117,7 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 
-	env := envelope{"error": message}
+	env := app.envelopeError(message)
 
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
@@ -44,7 +129,31 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 // used when the application encounters an error at run time. This method will log the detailed error message
 // then uses the errorResponse() helper to send a 500 Internal Server Error status code and JSON response
 // (containing a generic error message) to the client.
+//
+// If the request context's deadline has passed, the error is almost certainly a
+// query which was cancelled because of it. The only deadline on a request context
+// is one the client asked for with a Request-Timeout header, so in that case we
+// send the client a 503 response saying so instead.
+//
+// Likewise, a query which timed out waiting for a connection because the pool was
+// exhausted gets a 503 response with a Retry-After header, as the client can
+// reasonably try again shortly, rather than a 500.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		app.requestTimeoutResponse(w, r)
+		return
+	}
+
+	if errors.Is(err, data.ErrPoolExhausted) {
+		app.databaseBusyResponse(w, r, err, "database connection pool exhausted")
+		return
+	}
+
+	if err = data.CheckTooManyConnections(err); errors.Is(err, data.ErrTooManyConnections) {
+		app.databaseBusyResponse(w, r, err, "database server out of connections")
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
@@ -57,8 +166,122 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request)
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
-// method will be used to send a 405 method not allowed status code and JSON response to the client
+// method will be used by the router to send a 404 Not Found status code and JSON
+// response to clients requesting a path that doesn't match any route. Unlike
+// notFoundResponse(), the response says which method and path were requested, to
+// help with debugging wrong URLs
+func (app *application) routeNotFoundResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource could not be found"
+	app.routeErrorResponse(w, r, http.StatusNotFound, message)
+}
+
+// method will be used by the router to send a 405 method not allowed status code and
+// JSON response to the client. The router sets the Allow header before calling it
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.routeErrorResponse(w, r, http.StatusMethodNotAllowed, message)
+}
+
+// routeErrorResponse sends an error response for a request which the router couldn't
+// route, including the method and path that were requested. Responses to HEAD
+// requests are sent without a body.
+func (app *application) routeErrorResponse(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		return
+	}
+
+	err := app.writeJSON(w, status, app.envelopeRouteError(message, r.Method, r.URL.Path), nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// method will be used to send a 503 Service Unavailable status code and JSON response to
+// clients which make a request after a graceful shutdown has begun
+func (app *application) serverShuttingDownResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "10")
+
+	message := "server is shutting down"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// method will be used to send a 401 Unauthorized status code and JSON response to
+// clients which call an admin endpoint without a valid admin token
+func (app *application) invalidAdminTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing admin token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// method will be used to send a 415 Unsupported Media Type status code and JSON
+// response to clients which send a request body in a format we don't accept. The
+// Accept-Post or Accept-Patch header lists the accepted types in machine-readable form.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, accepted []string) {
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Set("Accept-Post", strings.Join(accepted, ", "))
+	case http.MethodPatch:
+		w.Header().Set("Accept-Patch", strings.Join(accepted, ", "))
+	}
+
+	message := fmt.Sprintf("the Content-Type header must be one of: %s", strings.Join(accepted, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// method will be used to send a 503 Service Unavailable status code and JSON response
+// to clients which make a request while the API is in maintenance mode. Retry-After
+// is set from the -maintenance-retry-after flag
+func (app *application) maintenanceModeResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(app.config.maintenance.retryAfter.Seconds())))
+
+	message := "the service is down for maintenance, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// method will be used to send a 503 Service Unavailable status code and JSON response
+// to clients whose request couldn't get a slot from the concurrency limiter in time
+func (app *application) serverBusyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+
+	message := "the server is too busy, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// method will be used to send a 503 Service Unavailable status code and JSON response
+// to clients which make a write request while the API is in read-only mode
+func (app *application) readOnlyModeResponse(w http.ResponseWriter, r *http.Request) {
+	message := "service is in read-only mode"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// method will be used to send a 503 Service Unavailable status code and JSON response
+// to clients whose request couldn't get a database connection, either because the
+// pool was exhausted or because the database server had no connections to spare.
+// The error is logged as a warning rather than an error, with a message saying
+// which (so that each can be alerted on separately), along with the pool
+// statistics, which show whether the pool is too small or connections are being
+// held for too long. The client is asked to retry shortly
+func (app *application) databaseBusyResponse(w http.ResponseWriter, r *http.Request, err error, logMessage string) {
+	properties := dbStatsProperties(app.models.DBStats())
+	properties["error"] = err.Error()
+	properties["request_method"] = r.Method
+	properties["request_url"] = r.URL.String()
+	app.logger.PrintWarn(logMessage, properties)
+
+	w.Header().Set("Retry-After", "1")
+
+	message := "the server is too busy to handle your request, please try again shortly"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// method will be used to send a 503 Service Unavailable status code and JSON response
+// to clients whose request couldn't be completed within their Request-Timeout
+func (app *application) requestTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request could not be completed within the requested timeout"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/agpelkey/greenlight/internal/jsonlog"
+	"github.com/lib/pq"
+)
+
+// With a pool of one connection held elsewhere, a request which needs the database
+// gets a 503 response asking it to retry, rather than a 500 or waiting for the
+// whole query timeout.
+func TestPoolExhaustedResponse(t *testing.T) {
+    db := newTestDB(t)
+    db.SetMaxOpenConns(1)
+
+    app := newTestApplication(t, db)
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+    path := fmt.Sprintf("/v1/movies/%d", id)
+
+    held, err := db.Conn(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    res := ts.get(t, path)
+    if res.status != http.StatusServiceUnavailable {
+        t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusServiceUnavailable, res.body)
+    }
+    if got := res.header.Get("Retry-After"); got != "1" {
+        t.Errorf("Retry-After = %q; want %q", got, "1")
+    }
+
+    held.Close()
+
+    if res := ts.get(t, path); res.status != http.StatusOK {
+        t.Errorf("after the connection was released: status = %d; want %d", res.status, http.StatusOK)
+    }
+}
+
+// A database server at its connection limit gets the same response as an exhausted
+// pool, but is logged with its own message so that the two can be told apart.
+func TestTooManyConnectionsResponse(t *testing.T) {
+    // The pool is never connected; it is only there for its statistics.
+    db, err := sql.Open("postgres", "host=localhost")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    app := newTestApplication(t, db)
+
+    var logs bytes.Buffer
+    app.logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+
+    rec := httptest.NewRecorder()
+    r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+    app.serverErrorResponse(rec, r, &pq.Error{Code: "53300", Message: "sorry, too many clients already"})
+
+    if rec.Code != http.StatusServiceUnavailable {
+        t.Errorf("status = %d; want %d", rec.Code, http.StatusServiceUnavailable)
+    }
+    if got := rec.Header().Get("Retry-After"); got != "1" {
+        t.Errorf("Retry-After = %q; want %q", got, "1")
+    }
+    if !strings.Contains(logs.String(), "database server out of connections") {
+        t.Errorf("log = %q; want the out of connections warning", logs.String())
+    }
+}
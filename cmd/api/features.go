@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+// Feature flags let us ship new behavior turned off, and then turn it on (for
+// everyone, or for a percentage of users) without a deploy. A handler checks a flag
+// with app.featureEnabled(r, "some.feature"), which reads from an in-memory cache
+// rather than the database. The cache is reloaded at the -feature-refresh-interval
+// by refreshFeatureFlags() below, and straight away when a flag is changed.
+//
+// A feature which is off should look like it doesn't exist, so handlers respond to
+// requests for it with a 404 Not Found rather than a 403 Forbidden.
+
+// The feature flags which gate behavior in the handlers.
+const (
+    featureFuzzySearch = "movies.fuzzy_search"
+)
+
+// featureEnabled reports whether the named feature flag is on for the request.
+// Requests aren't authenticated yet, so they all share the rollout bucket of user 0
+// and a partial rollout is on for everyone or no one; once they are, the user's ID
+// goes here.
+func (app *application) featureEnabled(r *http.Request, name string) bool {
+    return app.models.Features.IsEnabled(name, 0)
+}
+
+// refreshFeatureFlags reloads the feature flags at the given interval, until the
+// context is cancelled. If a reload fails, the flags from the last one stay in use.
+// It also listens for the notifications sent when a flag is changed, and reloads
+// as soon as one arrives, so that changes made through another instance apply
+// within moments. The listener has its own connection (outside of the pool), which
+// it re-establishes if it is lost; a nil notification means that it has been, and
+// any notifications sent in the meantime missed, so we reload then too.
+func (app *application) refreshFeatureFlags(ctx context.Context, interval time.Duration) {
+    ticker := app.clock.NewTicker(interval)
+    defer ticker.Stop()
+
+    listener := pq.NewListener(app.config.db.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+        if err != nil {
+            app.logger.PrintError(err, map[string]string{"task": "feature_flags"})
+        }
+    })
+    defer listener.Close()
+
+    err := listener.Listen(data.FeatureFlagsChannel)
+    if err != nil {
+        app.logger.PrintError(err, map[string]string{"task": "feature_flags"})
+    }
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C():
+        case <-listener.Notify:
+        }
+
+        err := app.models.Features.Refresh(ctx)
+        if err != nil && ctx.Err() == nil {
+            app.logger.PrintError(err, map[string]string{"task": "feature_flags"})
+        }
+    }
+}
+
+// handleListFeatureFlags returns every feature flag, as stored in the database.
+func (app *application) handleListFeatureFlags(w http.ResponseWriter, r *http.Request) {
+    flags, err := app.models.Features.GetAll(r.Context())
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeFeatureFlags(flags), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleUpdateFeatureFlag creates or updates the named feature flag, with a body
+// of {"enabled": true, "rollout_percent": 25}. The rollout percentage defaults to
+// 100 if it is left out.
+func (app *application) handleUpdateFeatureFlag(w http.ResponseWriter, r *http.Request) {
+    var input struct {
+        Enabled *bool `json:"enabled"`
+        RolloutPercent *int `json:"rollout_percent"`
+    }
+
+    err := app.readJSON(w, r, &input)
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return
+    }
+
+    flag := &data.FeatureFlag{
+        Name: httprouter.ParamsFromContext(r.Context()).ByName("name"),
+        RolloutPercent: 100,
+    }
+    if input.Enabled != nil {
+        flag.Enabled = *input.Enabled
+    }
+    if input.RolloutPercent != nil {
+        flag.RolloutPercent = *input.RolloutPercent
+    }
+
+    v := validator.New()
+
+    v.Check(input.Enabled != nil, "enabled", "required")
+
+    if data.ValidateFeatureFlag(v, flag); !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    err = app.models.Features.Upsert(r.Context(), flag)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeFeatureFlag(flag), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleDeleteFeatureFlag deletes the named feature flag, which turns it off for
+// everyone.
+func (app *application) handleDeleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+    name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+    err := app.models.Features.Delete(r.Context(), name)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMessage("feature flag successfully deleted"), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The response formats which can be asked for with a suffix on the ID of a movie,
+// as in /v1/movies/123.json or /v1/movies/123.xml. Without a suffix the response is
+// JSON, as for every other endpoint.
+const (
+    formatJSON = "json"
+    formatXML = "xml"
+)
+
+// formatSuffix wraps a handler for a route with an :id parameter, so that the ID
+// may end in a format suffix. httprouter matches a parameter up to the next slash,
+// so "123.xml" arrives as the ID; we strip the suffix and put the bare ID back in
+// the request's parameters, so that the handler reads it as usual, and record the
+// format in the request context for writeFormatted(). An unknown suffix gets a
+// 404 Not Found, just as an unknown ID would. The suffix takes precedence over
+// anything the client says in its Accept header.
+func (app *application) formatSuffix(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        params := httprouter.ParamsFromContext(r.Context())
+
+        id, format, found := strings.Cut(params.ByName("id"), ".")
+        if !found {
+            next(w, r)
+            return
+        }
+
+        if format != formatJSON && format != formatXML {
+            app.notFoundResponse(w, r)
+            return
+        }
+
+        // The parameters are copied rather than modified in place, as the router
+        // reuses them.
+        rewritten := make(httprouter.Params, len(params))
+        copy(rewritten, params)
+        for i := range rewritten {
+            if rewritten[i].Key == "id" {
+                rewritten[i].Value = id
+            }
+        }
+
+        ctx := context.WithValue(r.Context(), httprouter.ParamsKey, rewritten)
+        next(w, app.contextSetFormat(r.WithContext(ctx), format))
+    }
+}
+
+// writeFormatted writes a response in the format the request asked for: the JSON
+// envelope, or if it asked for XML the value returned by toXML, which is only called
+// then.
+func (app *application) writeFormatted(w http.ResponseWriter, r *http.Request, status int, env envelope, toXML func() (interface{}, error), header http.Header) error {
+    if app.contextGetFormat(r) != formatXML {
+        return app.writeJSON(w, status, env, header)
+    }
+
+    value, err := toXML()
+    if err != nil {
+        return err
+    }
+
+    return app.writeXML(w, status, value, header)
+}
+
+// writeXML is the XML counterpart of writeJSON().
+func (app *application) writeXML(w http.ResponseWriter, status int, value interface{}, header http.Header) error {
+    x, err := xml.MarshalIndent(value, "", "\t")
+    if err != nil {
+        return err
+    }
+
+    x = append([]byte(xml.Header), x...)
+    x = append(x, '\n')
+
+    for key, value := range header {
+        w.Header()[key] = value
+    }
+
+    w.Header().Set("Content-Type", "application/xml")
+    w.WriteHeader(status)
+    w.Write(x)
+
+    return nil
+}
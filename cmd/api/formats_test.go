@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestFormatSuffix(t *testing.T) {
+    app := newTestApplication(t, nil)
+
+    // The handler writes the ID it reads from the parameters, in the format that
+    // the request asked for.
+    router := httprouter.New()
+    router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.formatSuffix(func(w http.ResponseWriter, r *http.Request) {
+        id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+        toXML := func() (interface{}, error) {
+            return struct {
+                XMLName xml.Name `xml:"movie"`
+                ID string `xml:"id"`
+            }{ID: id}, nil
+        }
+        err := app.writeFormatted(w, r, http.StatusOK, envelope{"movie": id}, toXML, nil)
+        if err != nil {
+            t.Error(err)
+        }
+    }))
+
+    tests := []struct {
+        path string
+        accept string
+        wantStatus int
+        wantFormat string
+    }{
+        {"/v1/movies/123", "", http.StatusOK, formatJSON},
+        {"/v1/movies/123.json", "", http.StatusOK, formatJSON},
+        {"/v1/movies/123.xml", "", http.StatusOK, formatXML},
+        {"/v1/movies/123.json", "application/xml", http.StatusOK, formatJSON},
+        {"/v1/movies/123.xml", "application/json", http.StatusOK, formatXML},
+        {"/v1/movies/123.yaml", "", http.StatusNotFound, ""},
+        {"/v1/movies/123.XML", "", http.StatusNotFound, ""},
+        {"/v1/movies/123.", "", http.StatusNotFound, ""},
+        {"/v1/movies/123.xml.json", "", http.StatusNotFound, ""},
+    }
+
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+        if tt.accept != "" {
+            r.Header.Set("Accept", tt.accept)
+        }
+        rr := httptest.NewRecorder()
+        router.ServeHTTP(rr, r)
+
+        name := fmt.Sprintf("%s (Accept %q)", tt.path, tt.accept)
+        if rr.Code != tt.wantStatus {
+            t.Errorf("%s: status = %d; want %d", name, rr.Code, tt.wantStatus)
+            continue
+        }
+
+        contentType := rr.Header().Get("Content-Type")
+
+        switch tt.wantFormat {
+        case formatJSON:
+            var body struct {
+                Movie string `json:"movie"`
+            }
+            if !strings.HasPrefix(contentType, "application/json") || json.Unmarshal(rr.Body.Bytes(), &body) != nil || body.Movie != "123" {
+                t.Errorf("%s: Content-Type %q, body %q; want JSON with the bare ID", name, contentType, rr.Body)
+            }
+        case formatXML:
+            var body struct {
+                ID string `xml:"id"`
+            }
+            if contentType != "application/xml" || xml.Unmarshal(rr.Body.Bytes(), &body) != nil || body.ID != "123" {
+                t.Errorf("%s: Content-Type %q, body %q; want XML with the bare ID", name, contentType, rr.Body)
+            }
+        }
+    }
+}
+
+// The show movie endpoint writes the whole movie as XML when asked.
+func TestShowMovieXML(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+
+    res := ts.get(t, fmt.Sprintf("/v1/movies/%d.xml", id))
+    if res.status != http.StatusOK || res.header.Get("Content-Type") != "application/xml" {
+        t.Fatalf("status = %d, Content-Type %q; want %d and XML (body %q)", res.status, res.header.Get("Content-Type"), http.StatusOK, res.body)
+    }
+
+    var movie movieXMLResponse
+    if err := xml.Unmarshal(res.body, &movie); err != nil {
+        t.Fatalf("decoding %q: %v", res.body, err)
+    }
+    if movie.Title != "Moana" || movie.Runtime != "107 mins" || movie.Version != 1 || len(movie.Genres) != 2 {
+        t.Errorf("movie = %+v; want Moana, 107 mins, version 1, with two genres", movie)
+    }
+
+    if res := ts.get(t, fmt.Sprintf("/v1/movies/%d.yaml", id)); res.status != http.StatusNotFound {
+        t.Errorf(".yaml: status = %d; want %d", res.status, http.StatusNotFound)
+    }
+}
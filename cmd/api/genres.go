@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// handleRenameGenre renames a genre across every movie which has it, such as when
+// standardizing the vocabulary (e.g. "sci-fi" to "Science Fiction"). The rename is
+// all or nothing, and the response gives the number of movies changed. The match
+// on the old name is exact, including its case.
+func (app *application) handleRenameGenre(w http.ResponseWriter, r *http.Request) {
+    var input struct {
+        From string `json:"from"`
+        To string `json:"to"`
+    }
+
+    err := app.readJSON(w, r, &input)
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return
+    }
+
+    v := validator.New()
+
+    // As for the other writes, a dry run reports how many movies would be changed
+    // without changing them.
+    dryRun := app.readDryRun(r, v)
+
+    if data.ValidateGenreRename(v, input.From, input.To, app.movieRules()); !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    ids, err := app.movieModel(dryRun).RenameGenre(r.Context(), input.From, input.To)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    headers := make(http.Header)
+    if dryRun {
+        headers.Set("Preference-Applied", "dry-run")
+    } else {
+        // Each renamed movie has a new version, so wake any requests waiting for it
+        // to change.
+        for _, id := range ids {
+            app.movieChanges.notify(id)
+        }
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeGenreRename(int64(len(ids))), headers)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
@@ -1,22 +1,76 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// The healthcheck is registered for both GET and HEAD requests, because uptime
+// monitors often use HEAD. By default it doesn't touch the database, so that it
+// stays cheap to call every few seconds. A "deep" check (?deep=true) also pings the
+// database, and responds with 503 Service Unavailable if that fails.
 func (app *application) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+
+    status := "available"
+    code := http.StatusOK
+
+    if r.URL.Query().Get("deep") == "true" {
+        ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+        defer cancel()
+
+        err := app.db.PingContext(ctx)
+        if err != nil {
+            app.logger.PrintError(err, map[string]string{"check": "database"})
+            status = "unavailable"
+            code = http.StatusServiceUnavailable
+        }
+    }
+
+    // For a HEAD request the status line is all the monitor needs, so we don't
+    // bother encoding a body.
+    if r.Method == http.MethodHead {
+        w.WriteHeader(code)
+        return
+    }
     
-    env := envelope{
-        "status": "available",
-        "system_info": map[string]string{
-            "environment": app.config.env,
-            "version": version,
-        },
+    env := app.envelopeHealthCheck(status, map[string]string{
+        "environment": app.config.env,
+        "version": version,
+        "read_only": strconv.FormatBool(app.readOnly.Load()),
+        "maintenance": strconv.FormatBool(app.maintenance.Load()),
+    })
+
+    err := app.writeJSON(w, code, env, nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// The readiness check tells orchestrators and load balancers whether this instance
+// should be sent traffic. It responds with 503 Service Unavailable until the startup
+// work is complete (see application.ready), and after that whenever the database or
+// its schema checks fail. The check results are cached for a few seconds.
+func (app *application) handleReadinessCheck(w http.ResponseWriter, r *http.Request) {
+    if !app.ready.Load() {
+        err := app.writeJSON(w, http.StatusServiceUnavailable, app.envelopeReadiness("not ready", nil), nil)
+        if err != nil {
+            app.serverErrorResponse(w, r, err)
+        }
+        return
     }
 
+    status := "ready"
+    code := http.StatusOK
 
+    results, ok := app.readinessChecks.run(app.clock.Now())
+    if !ok {
+        status = "not ready"
+        code = http.StatusServiceUnavailable
+    }
 
-    err := app.writeJSON(w, http.StatusOK, env, nil)
+    err := app.writeJSON(w, code, app.envelopeReadiness(status, results), nil)
     if err != nil {
         app.serverErrorResponse(w, r, err)
     }
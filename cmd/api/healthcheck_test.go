@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHealthCheck(t *testing.T) {
+    tests := []struct {
+        name string
+        method string
+        readOnly bool
+        maintenance bool
+        wantStatus int
+        wantBody bool
+        wantReadOnly string
+        wantMaintenance string
+    }{
+        {"get", http.MethodGet, false, false, http.StatusOK, true, "false", "false"},
+        {"head", http.MethodHead, false, false, http.StatusOK, false, "", ""},
+        {"read-only", http.MethodGet, true, false, http.StatusOK, true, "true", "false"},
+        {"maintenance", http.MethodGet, false, true, http.StatusOK, true, "false", "true"},
+        {"post", http.MethodPost, false, false, http.StatusMethodNotAllowed, true, "", ""},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t, nil)
+            app.readOnly.Store(tt.readOnly)
+            app.maintenance.Store(tt.maintenance)
+            ts := newTestServer(t, app.routes())
+
+            res := ts.do(t, tt.method, "/v1/healthcheck", nil, nil)
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, tt.wantStatus, res.body)
+            }
+            if !tt.wantBody {
+                if len(res.body) != 0 {
+                    t.Errorf("body = %q; want none", res.body)
+                }
+                return
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+
+            var body struct {
+                Status string `json:"status"`
+                SystemInfo map[string]string `json:"system_info"`
+            }
+            res.decode(t, &body)
+
+            if body.Status != "available" {
+                t.Errorf("status = %q; want available", body.Status)
+            }
+            if got := body.SystemInfo["environment"]; got != "development" {
+                t.Errorf("environment = %q; want development", got)
+            }
+            if got := body.SystemInfo["read_only"]; got != tt.wantReadOnly {
+                t.Errorf("read_only = %q; want %q", got, tt.wantReadOnly)
+            }
+            if got := body.SystemInfo["maintenance"]; got != tt.wantMaintenance {
+                t.Errorf("maintenance = %q; want %q", got, tt.wantMaintenance)
+            }
+        })
+    }
+}
+
+func TestHealthCheckDeep(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    res := ts.get(t, "/v1/healthcheck?deep=true")
+    if res.status != http.StatusOK {
+        t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusOK, res.body)
+    }
+
+    // Once the database has gone away, the deep check reports it.
+    app.db.Close()
+
+    res = ts.get(t, "/v1/healthcheck?deep=true")
+    if res.status != http.StatusServiceUnavailable {
+        t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusServiceUnavailable, res.body)
+    }
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The rateLimit() middleware keys clients by their IP address. The key mode is
+// recorded alongside the allowed and rejected counters, so that the metrics stay
+// meaningful if we add other ways of identifying clients (such as by API key).
+const limiterKeyIP = "ip"
+
+// The limiter metrics are published under the "limiter" expvar, so that they show
+// up at /debug/vars: counts of allowed and rejected requests per key mode, the
+// number of clients evicted for being idle, and the number currently tracked.
+var (
+    limiterMetrics = expvar.NewMap("limiter")
+    limiterAllowed = new(expvar.Map).Init()
+    limiterRejected = new(expvar.Map).Init()
+    limiterEvictions = new(expvar.Int)
+)
+
+func init() {
+    limiterMetrics.Set("allowed", limiterAllowed)
+    limiterMetrics.Set("rejected", limiterRejected)
+    limiterMetrics.Set("evictions", limiterEvictions)
+}
+
+// limiterClient holds the rate limiter for a single client, along with when it was
+// last seen and how many of its requests have been rejected.
+type limiterClient struct {
+    limiter *rate.Limiter
+    lastSeen time.Time
+    rejected int
+}
+
+// limiterClientInfo is a point-in-time description of a client's limiter, as
+// returned by the admin limiter endpoint.
+type limiterClientInfo struct {
+    Key string `json:"key"`
+    Tokens float64 `json:"tokens"`
+    Rejected int `json:"rejected"`
+    LastSeen time.Time `json:"last_seen"`
+}
+
+// clientLimiters holds the per-client rate limiters used by the rateLimit()
+// middleware. It lives on the application struct, rather than inside the
+// middleware, so that the admin endpoints can inspect and reset it.
+type clientLimiters struct {
+    mu sync.Mutex
+    clients map[string]*limiterClient
+}
+
+func newClientLimiters() *clientLimiters {
+    l := &clientLimiters{clients: make(map[string]*limiterClient)}
+
+    limiterMetrics.Set("clients", expvar.Func(func() interface{} {
+        return l.size()
+    }))
+
+    return l
+}
+
+// allow reports whether a request from the client with the given key may go ahead,
+// creating a limiter for the client if it hasn't been seen before.
+func (l *clientLimiters) allow(mode, key string, now time.Time, rps float64, burst int) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    client, found := l.clients[key]
+    if !found {
+        client = &limiterClient{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+        l.clients[key] = client
+    }
+
+    client.lastSeen = now
+
+    if !client.limiter.AllowN(now, 1) {
+        client.rejected++
+        limiterRejected.Add(mode, 1)
+        return false
+    }
+
+    limiterAllowed.Add(mode, 1)
+    return true
+}
+
+// evict removes the clients which haven't been seen since the given time.
+func (l *clientLimiters) evict(before time.Time) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    for key, client := range l.clients {
+        if client.lastSeen.Before(before) {
+            delete(l.clients, key)
+            limiterEvictions.Add(1)
+        }
+    }
+}
+
+// top returns up to n clients, ordered by the number of requests of theirs that
+// have been rejected (most first) and then by when they were last seen.
+func (l *clientLimiters) top(n int, now time.Time) []limiterClientInfo {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    infos := make([]limiterClientInfo, 0, len(l.clients))
+    for key, client := range l.clients {
+        infos = append(infos, limiterClientInfo{
+            Key: key,
+            Tokens: client.limiter.TokensAt(now),
+            Rejected: client.rejected,
+            LastSeen: client.lastSeen,
+        })
+    }
+
+    sort.Slice(infos, func(i, j int) bool {
+        if infos[i].Rejected != infos[j].Rejected {
+            return infos[i].Rejected > infos[j].Rejected
+        }
+        return infos[i].LastSeen.After(infos[j].LastSeen)
+    })
+
+    if len(infos) > n {
+        infos = infos[:n]
+    }
+
+    return infos
+}
+
+// size returns the number of clients currently being tracked.
+func (l *clientLimiters) size() int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    return len(l.clients)
+}
+
+// reset forgets the limiter for the client with the given key, so that its next
+// request starts with a full bucket. It reports whether the client was tracked.
+func (l *clientLimiters) reset(key string) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    _, found := l.clients[key]
+    delete(l.clients, key)
+
+    return found
+}
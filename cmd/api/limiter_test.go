@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/clock"
+)
+
+// waitFor polls cond until it is true, failing the test if it isn't within a
+// second. It is for state changed by background goroutines.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+
+    deadline := time.Now().Add(time.Second)
+    for !cond() {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+        time.Sleep(time.Millisecond)
+    }
+}
+
+func TestRateLimitUsesClock(t *testing.T) {
+    fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+
+    app := newTestApplication(t, nil)
+    app.clock = fake
+    app.config.limiter.enabled = true
+    app.config.limiter.rps = 2
+    app.config.limiter.burst = 4
+
+    handler := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    status := func() int {
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+        return rr.Code
+    }
+
+    // The burst is used up without any time passing...
+    for i := 0; i < 4; i++ {
+        if got := status(); got != http.StatusOK {
+            t.Fatalf("request %d: status = %d; want %d", i+1, got, http.StatusOK)
+        }
+    }
+    if got := status(); got != http.StatusTooManyRequests {
+        t.Fatalf("request 5: status = %d; want %d", got, http.StatusTooManyRequests)
+    }
+
+    // ...and a token comes back when the clock moves on by 1/rps, and not before.
+    fake.Advance(499 * time.Millisecond)
+    if got := status(); got != http.StatusTooManyRequests {
+        t.Fatalf("after 499ms: status = %d; want %d", got, http.StatusTooManyRequests)
+    }
+    fake.Advance(501 * time.Millisecond)
+    if got := status(); got != http.StatusOK {
+        t.Fatalf("after 1s: status = %d; want %d", got, http.StatusOK)
+    }
+}
+
+func TestRateLimitEviction(t *testing.T) {
+    fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+
+    app := newTestApplication(t, nil)
+    app.clock = fake
+    app.config.limiter.enabled = true
+    app.config.limiter.rps = 2
+    app.config.limiter.burst = 4
+
+    handler := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    waitFor(t, "the eviction ticker", func() bool { return fake.Tickers() == 1 })
+
+    handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+    if app.limiters.size() != 1 {
+        t.Fatalf("size() = %d; want 1", app.limiters.size())
+    }
+
+    // Clients are evicted once they haven't been seen for three minutes, which the
+    // cleanup notices on its next tick. At exactly three minutes the client stays.
+    fake.Advance(3 * time.Minute)
+    time.Sleep(10 * time.Millisecond)
+    if app.limiters.size() != 1 {
+        t.Fatalf("after 3m: size() = %d; want 1", app.limiters.size())
+    }
+
+    fake.Advance(time.Minute)
+    waitFor(t, "the client to be evicted", func() bool { return app.limiters.size() == 0 })
+}
+
+// The limiter counters are published at /debug/vars.
+func TestRateLimitMetrics(t *testing.T) {
+    type limiterVars struct {
+        Allowed map[string]int64 `json:"allowed"`
+        Rejected map[string]int64 `json:"rejected"`
+        Evictions int64 `json:"evictions"`
+        Clients int `json:"clients"`
+    }
+
+    // The counters are read through a server without rate limiting, so that reading
+    // them doesn't change them.
+    reader := newTestApplication(t, nil)
+    reader.config.admin.token = "secret"
+    ts := newTestServer(t, reader.routes())
+
+    var before limiterVars
+    ts.debugVar(t, "limiter", &before)
+
+    app := newTestApplication(t, nil)
+    app.clock = clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+    app.config.limiter.enabled = true
+    app.config.limiter.rps = 1
+    app.config.limiter.burst = 1
+
+    handler := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    for i := 0; i < 3; i++ {
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+    }
+
+    var after limiterVars
+    ts.debugVar(t, "limiter", &after)
+
+    if got := after.Allowed[limiterKeyIP] - before.Allowed[limiterKeyIP]; got != 1 {
+        t.Errorf("allowed went up by %d; want 1", got)
+    }
+    if got := after.Rejected[limiterKeyIP] - before.Rejected[limiterKeyIP]; got != 2 {
+        t.Errorf("rejected went up by %d; want 2", got)
+    }
+    if after.Clients != 1 {
+        t.Errorf("clients = %d; want 1", after.Clients)
+    }
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/httpclient"
+)
+
+// The link checker looks for movies whose links haven't been checked for a week,
+// once an hour, and checks up to a batch of them at a time.
+const (
+    linkCheckAge = 7 * 24 * time.Hour
+    linkCheckPollInterval = time.Hour
+    linkCheckBatchSize = 100
+)
+
+// linkCheckTimeout bounds the check of a single link, including its retries and the
+// fallback from HEAD to GET, so that a server which stalls us (with a long
+// Retry-After, or by never answering) can't hold up the rest of the batch. It is a
+// variable so that tests can shorten it.
+var linkCheckTimeout = 30 * time.Second
+
+// checkLinks runs the link checker until the context is cancelled. It requests the
+// trailer and homepage URL of each movie, and records in the link_status column
+// whether they are still alive, so that broken links can be listed by the admin
+// endpoint.
+func (app *application) checkLinks(ctx context.Context) {
+    // The requests go through the shared outbound client, with a short timeout and a
+    // single retry, as a link that can't answer within a few seconds is as good as
+    // broken for our users. The links are supplied by users, so the client refuses
+    // to connect to anything but public addresses; a link which points into our own
+    // network is reported as broken.
+    client := newOutboundClient(app.config, httpclient.Config{
+        Name: "linkcheck",
+        Timeout: 5 * time.Second,
+        MaxRetries: 1,
+        PublicOnly: true,
+        Logger: app.logger,
+    })
+
+    ticker := app.clock.NewTicker(linkCheckPollInterval)
+    defer ticker.Stop()
+
+    for {
+        app.checkLinksBatch(ctx, client)
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C():
+        }
+    }
+}
+
+func (app *application) checkLinksBatch(ctx context.Context, client *httpclient.Client) {
+    movies, err := app.models.Movies.GetLinksToCheck(ctx, app.clock.Now().Add(-linkCheckAge), linkCheckBatchSize)
+    if err != nil {
+        app.logger.PrintError(err, map[string]string{"task": "linkcheck"})
+        return
+    }
+
+    for _, movie := range movies {
+        if ctx.Err() != nil {
+            return
+        }
+
+        status, conclusive := checkMovieLinks(ctx, client, movie)
+        if !conclusive {
+            // Nothing is recorded, so that the movie is checked again next time
+            // rather than a week later.
+            continue
+        }
+
+        err := app.models.Movies.SetLinkStatus(ctx, movie, status)
+        if err != nil {
+            app.logger.PrintError(err, map[string]string{"task": "linkcheck", "movie_id": app.movieIDString(movie)})
+        }
+    }
+}
+
+// checkMovieLinks checks the links of a movie, and returns its link status and
+// whether the status is conclusive. A movie's links are broken if either of them
+// is, and OK if every one of them is alive. Otherwise one of the links didn't give
+// a clear answer, and the result isn't conclusive.
+func checkMovieLinks(ctx context.Context, client *httpclient.Client, movie *data.Movie) (string, bool) {
+    allAlive := true
+    for _, link := range []*string{movie.TrailerURL, movie.HomepageURL} {
+        if link == nil {
+            continue
+        }
+
+        alive, conclusive := checkLink(ctx, client, *link)
+        if conclusive && !alive {
+            return data.LinkStatusBroken, true
+        }
+        if !conclusive {
+            allAlive = false
+        }
+    }
+
+    if !allAlive {
+        return movie.LinkStatus, false
+    }
+
+    return data.LinkStatusOK, true
+}
+
+// checkLink requests the URL and reports whether it is alive, and whether the
+// answer is conclusive. Rate limiting, authorization errors (which some sites send
+// to clients that look like bots) and an open circuit breaker aren't conclusive,
+// and nor is a check cut short by ctx being cancelled. A link which runs out of
+// linkCheckTimeout is broken, like one whose server doesn't answer.
+func checkLink(ctx context.Context, client *httpclient.Client, link string) (alive bool, conclusive bool) {
+    linkCtx, cancel := context.WithTimeout(ctx, linkCheckTimeout)
+    defer cancel()
+
+    code, err := requestLink(linkCtx, client, http.MethodHead, link)
+
+    // Some servers don't support HEAD requests, so fall back to a GET.
+    if err == nil && (code == http.StatusMethodNotAllowed || code == http.StatusNotImplemented) {
+        code, err = requestLink(linkCtx, client, http.MethodGet, link)
+    }
+
+    switch {
+    case errors.Is(err, httpclient.ErrCircuitOpen) || ctx.Err() != nil:
+        return false, false
+    case err != nil:
+        return false, true
+    case code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusTooManyRequests:
+        return false, false
+    default:
+        return code < 400, true
+    }
+}
+
+func requestLink(ctx context.Context, client *httpclient.Client, method, link string) (int, error) {
+    req, err := http.NewRequestWithContext(ctx, method, link, nil)
+    if err != nil {
+        return 0, err
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    resp.Body.Close()
+
+    return resp.StatusCode, nil
+}
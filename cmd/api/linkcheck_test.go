@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/httpclient"
+)
+
+// roundTripFunc lets a function stand in for the transport of a client.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+    return f(r)
+}
+
+func TestCheckMovieLinks(t *testing.T) {
+    // Each link answers with the status code in its path.
+    client := httpclient.New(httpclient.Config{
+        MaxRetries: -1,
+        Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+            codes := map[string]int{"/ok": http.StatusOK, "/missing": http.StatusNotFound, "/limited": http.StatusTooManyRequests}
+            return &http.Response{StatusCode: codes[r.URL.Path], Body: http.NoBody, Request: r}, nil
+        }),
+    })
+
+    link := func(path string) *string {
+        s := "https://example.com" + path
+        return &s
+    }
+
+    tests := []struct {
+        name string
+        trailer, homepage *string
+        wantStatus string
+        wantConclusive bool
+    }{
+        {"both alive", link("/ok"), link("/ok"), data.LinkStatusOK, true},
+        {"one link alive", nil, link("/ok"), data.LinkStatusOK, true},
+        {"one broken", link("/ok"), link("/missing"), data.LinkStatusBroken, true},
+        {"broken and rate limited", link("/limited"), link("/missing"), data.LinkStatusBroken, true},
+        {"alive and rate limited", link("/ok"), link("/limited"), data.LinkStatusUnchecked, false},
+        {"rate limited", link("/limited"), nil, data.LinkStatusUnchecked, false},
+    }
+
+    for _, tt := range tests {
+        movie := &data.Movie{TrailerURL: tt.trailer, HomepageURL: tt.homepage, LinkStatus: data.LinkStatusUnchecked}
+
+        status, conclusive := checkMovieLinks(context.Background(), client, movie)
+        if status != tt.wantStatus || conclusive != tt.wantConclusive {
+            t.Errorf("%s: checkMovieLinks() = %q, %v; want %q, %v", tt.name, status, conclusive, tt.wantStatus, tt.wantConclusive)
+        }
+    }
+}
+
+// A link whose server never answers is given up on after linkCheckTimeout, and
+// counts as broken, while a check cut short by shutdown isn't conclusive.
+func TestCheckLinkTimeout(t *testing.T) {
+    defer func(timeout time.Duration) { linkCheckTimeout = timeout }(linkCheckTimeout)
+    linkCheckTimeout = 50 * time.Millisecond
+
+    client := httpclient.New(httpclient.Config{
+        Timeout: time.Hour,
+        Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+            <-r.Context().Done()
+            return nil, r.Context().Err()
+        }),
+    })
+
+    start := time.Now()
+    alive, conclusive := checkLink(context.Background(), client, "https://example.com/stalls")
+    if alive || !conclusive {
+        t.Errorf("checkLink() = %v, %v; want false, true", alive, conclusive)
+    }
+    if elapsed := time.Since(start); elapsed > 5*time.Second {
+        t.Errorf("checkLink() took %v", elapsed)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, conclusive := checkLink(ctx, client, "https://example.com/stalls"); conclusive {
+        t.Error("after cancellation: checkLink() is conclusive; want inconclusive")
+    }
+}
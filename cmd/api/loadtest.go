@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/httpclient"
+	"golang.org/x/time/rate"
+)
+
+// loadTestEndpoint is one of the requests that the load test sends. Each worker
+// picks an endpoint at random for every request, in proportion to its weight.
+type loadTestEndpoint struct {
+    name string
+    weight int
+    path func(lt *loadTest) string
+}
+
+// The mix of requests sent by the load test. It leans towards reads of single
+// movies, as those are the most common requests in production.
+var loadTestEndpoints = []loadTestEndpoint{
+    {"healthcheck", 1, func(lt *loadTest) string { return "/v1/healthcheck" }},
+    {"list movies", 4, (*loadTest).listMoviesPath},
+    {"show movie", 5, (*loadTest).showMoviePath},
+}
+
+// loadTest holds the settings and results of a run of the "loadtest" command.
+type loadTest struct {
+    baseURL string
+    token string
+    client *httpclient.Client
+    // movieIDs holds the IDs of some existing movies, which the "show movie"
+    // requests pick from. They are read from the API before the test starts, so
+    // they may be integers or UUIDs depending on its -id-type.
+    movieIDs []string
+
+    mu sync.Mutex
+    latencies map[string][]time.Duration
+    errors map[string]int
+}
+
+// runLoadTestCommand implements the "loadtest" command, which sends a mix of
+// requests to a running instance of the API for a while and then reports the
+// throughput, latencies and errors for each endpoint. It is a quick sanity check
+// of capacity before a launch, not a replacement for proper load testing tools.
+func runLoadTestCommand(cfg config, args []string) int {
+    fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+
+    baseURL := fs.String("url", fmt.Sprintf("http://localhost:%d%s", cfg.port, cfg.basePath), "Base URL of the API to test")
+    workers := fs.Int("workers", 10, "Number of concurrent workers")
+    duration := fs.Duration("duration", 30*time.Second, "How long to run the test for")
+    rps := fs.Float64("rps", 0, "Maximum requests per second across all workers (0 for no limit)")
+    token := fs.String("token", "", "Bearer token to authenticate the requests with")
+    force := fs.Bool("force", false, "Run even if the target is a production instance")
+
+    err := fs.Parse(args)
+    if err != nil {
+        return 2
+    }
+
+    if *workers < 1 {
+        fmt.Fprintln(os.Stderr, "-workers must be at least 1")
+        return 2
+    }
+
+    // Retries are turned off, so that every failed request is counted as an error
+    // and the latencies are those of single requests.
+    lt := &loadTest{
+        baseURL: strings.TrimSuffix(*baseURL, "/"),
+        token: *token,
+        client: newOutboundClient(cfg, httpclient.Config{Name: "loadtest", MaxRetries: -1}),
+        latencies: make(map[string][]time.Duration),
+        errors: make(map[string]int),
+    }
+
+    // Refuse to load test a production instance by accident.
+    env, err := lt.environment()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "checking target environment: %v\n", err)
+        return 1
+    }
+    if env == "production" && !*force {
+        fmt.Fprintf(os.Stderr, "%s is a production instance; use -force to test it anyway\n", lt.baseURL)
+        return 1
+    }
+
+    lt.movieIDs, err = lt.readMovieIDs()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "reading movie IDs: %v\n", err)
+        return 1
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), *duration)
+    defer cancel()
+
+    // A single limiter is shared by all of the workers, so that -rps caps the
+    // total rate of requests.
+    limiter := rate.NewLimiter(rate.Inf, 1)
+    if *rps > 0 {
+        limiter = rate.NewLimiter(rate.Limit(*rps), 1)
+    }
+
+    start := time.Now()
+
+    var wg sync.WaitGroup
+    for i := 0; i < *workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            lt.work(ctx, limiter)
+        }()
+    }
+    wg.Wait()
+
+    lt.report(os.Stdout, time.Since(start))
+
+    return 0
+}
+
+// work sends requests until the context is done.
+func (lt *loadTest) work(ctx context.Context, limiter *rate.Limiter) {
+    total := 0
+    for _, e := range loadTestEndpoints {
+        total += e.weight
+    }
+
+    for {
+        if limiter.Wait(ctx) != nil {
+            return
+        }
+
+        n := rand.Intn(total)
+        endpoint := loadTestEndpoints[0]
+        for _, e := range loadTestEndpoints {
+            if n < e.weight {
+                endpoint = e
+                break
+            }
+            n -= e.weight
+        }
+
+        path := endpoint.path(lt)
+        if path == "" {
+            continue
+        }
+
+        start := time.Now()
+        err := lt.get(ctx, path, nil)
+        latency := time.Since(start)
+
+        // Requests cut short by the end of the test aren't counted.
+        if ctx.Err() != nil {
+            return
+        }
+
+        lt.record(endpoint.name, latency, err)
+    }
+}
+
+func (lt *loadTest) record(name string, latency time.Duration, err error) {
+    lt.mu.Lock()
+    defer lt.mu.Unlock()
+
+    lt.latencies[name] = append(lt.latencies[name], latency)
+    if err != nil {
+        lt.errors[name]++
+    }
+}
+
+// get sends a GET request to the API and decodes the response body into dst, if it
+// isn't nil. Responses with a 4xx or 5xx status are returned as errors.
+func (lt *loadTest) get(ctx context.Context, path string, dst interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, lt.baseURL+path, nil)
+    if err != nil {
+        return err
+    }
+
+    if lt.token != "" {
+        req.Header.Set("Authorization", "Bearer "+lt.token)
+    }
+
+    resp, err := lt.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 400 {
+        io.Copy(io.Discard, resp.Body)
+        return fmt.Errorf("%s responded with %s", path, resp.Status)
+    }
+
+    if dst == nil {
+        _, err = io.Copy(io.Discard, resp.Body)
+        return err
+    }
+
+    return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// environment returns the environment that the target reports in its healthcheck.
+func (lt *loadTest) environment() (string, error) {
+    var body struct {
+        SystemInfo map[string]string `json:"system_info"`
+    }
+
+    err := lt.get(context.Background(), "/v1/healthcheck", &body)
+    if err != nil {
+        return "", err
+    }
+
+    env, ok := body.SystemInfo["environment"]
+    if !ok {
+        return "", errors.New("healthcheck response has no environment")
+    }
+
+    return env, nil
+}
+
+// readMovieIDs returns the IDs of the first page of movies.
+func (lt *loadTest) readMovieIDs() ([]string, error) {
+    var body struct {
+        Movies []struct {
+            ID json.RawMessage `json:"id"`
+        } `json:"movies"`
+    }
+
+    err := lt.get(context.Background(), "/v1/movies?page_size=100", &body)
+    if err != nil {
+        return nil, err
+    }
+
+    ids := make([]string, 0, len(body.Movies))
+    for _, movie := range body.Movies {
+        ids = append(ids, strings.Trim(string(movie.ID), `"`))
+    }
+
+    return ids, nil
+}
+
+// listMoviesPath returns a movie list request with a random filter, sort order and
+// page, so that the test doesn't just measure a single cached query plan.
+func (lt *loadTest) listMoviesPath() string {
+    qs := url.Values{}
+
+    switch rand.Intn(3) {
+    case 0:
+        qs.Set("title", []string{"the", "love", "night", "man", "war"}[rand.Intn(5)])
+    case 1:
+        qs.Set("genres", []string{"drama", "comedy", "action", "horror", "sci-fi"}[rand.Intn(5)])
+    }
+
+    qs.Set("sort", []string{"id", "title", "-year", "runtime"}[rand.Intn(4)])
+    qs.Set("page", fmt.Sprint(rand.Intn(3)+1))
+
+    return "/v1/movies?" + qs.Encode()
+}
+
+// showMoviePath returns a request for a random existing movie, or the empty string
+// if there are no movies to pick from.
+func (lt *loadTest) showMoviePath() string {
+    if len(lt.movieIDs) == 0 {
+        return ""
+    }
+
+    return "/v1/movies/" + lt.movieIDs[rand.Intn(len(lt.movieIDs))]
+}
+
+// report writes the results of the test as a table, with one row per endpoint.
+func (lt *loadTest) report(w io.Writer, elapsed time.Duration) {
+    lt.mu.Lock()
+    defer lt.mu.Unlock()
+
+    tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+    fmt.Fprintln(tw, "ENDPOINT\tREQUESTS\tREQ/S\tERRORS\tP50\tP90\tP99\tMAX")
+
+    total := 0
+    for _, e := range loadTestEndpoints {
+        latencies := lt.latencies[e.name]
+        if len(latencies) == 0 {
+            continue
+        }
+        total += len(latencies)
+
+        sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+        fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%s\t%s\t%s\t%s\n",
+            e.name,
+            len(latencies),
+            float64(len(latencies))/elapsed.Seconds(),
+            lt.errors[e.name],
+            percentile(latencies, 50),
+            percentile(latencies, 90),
+            percentile(latencies, 99),
+            latencies[len(latencies)-1].Round(time.Microsecond),
+        )
+    }
+
+    tw.Flush()
+
+    fmt.Fprintf(w, "\n%d requests in %s (%.1f req/s)\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+}
+
+// percentile returns the p-th percentile of a sorted, non-empty slice of latencies.
+func percentile(latencies []time.Duration, p int) time.Duration {
+    i := (len(latencies)*p + 99) / 100
+    if i > 0 {
+        i--
+    }
+
+    return latencies[i].Round(time.Microsecond)
+}
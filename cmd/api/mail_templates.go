@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/agpelkey/greenlight/internal/mailer"
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// handleListMailTemplates returns the names of the email templates, which are the
+// names accepted by the preview and test-send endpoints.
+func (app *application) handleListMailTemplates(w http.ResponseWriter, r *http.Request) {
+    names, err := mailer.Templates()
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMailTemplates(names), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handlePreviewMailTemplate renders an email template with its fixture data and
+// returns the HTML body (the default) or, with ?format=text, the plain-text body,
+// so that template changes can be checked in a browser before they ship. The
+// subject is sent in the Mail-Subject header. Nothing is sent to the SMTP server.
+func (app *application) handlePreviewMailTemplate(w http.ResponseWriter, r *http.Request) {
+    v := validator.New()
+
+    format := app.readString(r.URL.Query(), "format", "html")
+
+    if v.Check(validator.In(format, "html", "text"), "format", "invalid_preview_format"); !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    message, ok := app.previewMailTemplate(w, r)
+    if !ok {
+        return
+    }
+
+    body, contentType := message.HTMLBody, "text/html; charset=utf-8"
+    if format == "text" {
+        body, contentType = message.PlainBody, "text/plain; charset=utf-8"
+    }
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Mail-Subject", message.Subject)
+    w.WriteHeader(http.StatusOK)
+    w.Write([]byte(body))
+}
+
+// handleTestSendMailTemplate renders an email template with its fixture data and
+// sends it through the normal mailer to the -admin-email address (or, if that isn't
+// set, to the -smtp-sender address), so that it can be checked in real email
+// clients. The recipient can't be chosen in the request, so that the endpoint can't
+// be used to send email to arbitrary addresses. The subject is prefixed with
+// "[TEST]" so that the email can't be mistaken for a real one.
+func (app *application) handleTestSendMailTemplate(w http.ResponseWriter, r *http.Request) {
+    recipient := app.config.admin.email
+    if recipient == "" {
+        recipient = app.config.smtp.sender
+    }
+
+    message, ok := app.previewMailTemplate(w, r)
+    if !ok {
+        return
+    }
+
+    message.Subject = "[TEST] " + message.Subject
+
+    // Both addresses are checked at startup, so any error is a server error.
+    err := app.mailer.SendMessage(recipient, message)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMessage("test email sent to "+recipient), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// previewMailTemplate renders the template named in the URL with its fixture data.
+// If the template doesn't exist or can't be rendered it sends the error response
+// itself, and returns false as its second value.
+func (app *application) previewMailTemplate(w http.ResponseWriter, r *http.Request) (*mailer.Message, bool) {
+    name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+    message, err := mailer.Preview(name)
+    if err != nil {
+        switch {
+        case errors.Is(err, mailer.ErrUnknownTemplate):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return nil, false
+    }
+
+    return message, true
+}
@@ -3,18 +3,32 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/agpelkey/greenlight/internal/clock"
 	"github.com/agpelkey/greenlight/internal/data"
 	"github.com/agpelkey/greenlight/internal/jsonlog"
 	"github.com/agpelkey/greenlight/internal/mailer"
+	"github.com/agpelkey/greenlight/internal/validator"
 	_ "github.com/lib/pq"
 )
 
 const version = "1.0.0"
 
+// The values accepted by the -id-type flag.
+const (
+    idTypeBigserial = "bigserial"
+    idTypeUUID = "uuid"
+)
+
 // application config
 type config struct {
     port int
@@ -24,26 +38,127 @@ type config struct {
         maxOpenConns int 
         maxIdleConns int
         maxIdleTime string 
+        acquireTimeout time.Duration
+        slowQueryThreshold time.Duration
+        statsInterval time.Duration
     }
     limiter struct {
         rps float64
         burst int
         enabled bool
     }
+    concurrency struct {
+        max int
+        export int
+        longPoll int
+        wait time.Duration
+    }
     smtp struct {
         host string
         port int
         username string
         password string
         sender string
+        checkMX bool
+        encryption string
+        timeout time.Duration
+        skipVerify bool
+    }
+    movies struct {
+        genresAllowlist []string
+        maxGenres int
+        maxTitleBytes int
+        checkLinks bool
+        maxWaiters int
+        fuzzyThreshold float64
+        ftsLanguage string
+    }
+    pagination struct {
+        defaultPage int
+        defaultPageSize int
+        maxPageSize int
+    }
+    admin struct {
+        token string
+        email string
+    }
+    share struct {
+        key string
+        maxTTL time.Duration
+    }
+    recoverPanics bool
+    idType string
+    basePath string
+    maxRequestTimeout time.Duration
+    responseSizeBudget int
+    compactJSON bool
+    checkOnStart bool
+    readOnly bool
+    insecureDefaults string
+    features struct {
+        refreshInterval time.Duration
+    }
+    maintenance struct {
+        enabled bool
+        allowlist []*net.IPNet
+        retryAfter time.Duration
+    }
+    outbound struct {
+        contactURL string
+        bindIP net.IP
     }
 }
 
 type application struct {
     config config
     logger *jsonlog.Logger
+    // db is the connection pool behind the models, kept so that the healthcheck
+    // can ping the database.
+    db *sql.DB
     models data.Models
     mailer mailer.Mailer
+    clock clock.Clock
+    // limiters holds the per-client rate limiters used by the rateLimit()
+    // middleware.
+    limiters *clientLimiters
+    // concurrency holds the semaphores used by the limitConcurrency() middleware.
+    concurrency *concurrencyLimiter
+    // ready is set once all of the startup work (connecting to the database, and any
+    // checks or migrations) is complete, which serve() does after it has started
+    // listening. Until then the readiness endpoint reports that the instance is not
+    // ready to receive traffic.
+    ready atomic.Bool
+    // readinessChecks caches the dependency checks run by the readiness endpoint.
+    readinessChecks *checkCache
+    // shuttingDown is set once a graceful shutdown has begun, after which new
+    // requests are turned away with a 503 response.
+    shuttingDown atomic.Bool
+    // readOnly is set while the API is in read-only mode, in which writes are turned
+    // away with a 503 response. It starts out as the -read-only flag, and can be
+    // changed at runtime through the admin endpoint.
+    readOnly atomic.Bool
+    // maintenance is set while the API is in maintenance mode, in which all requests
+    // other than the healthcheck and those from allowlisted IP addresses are turned
+    // away. Like readOnly, it starts out as its flag and can be changed at runtime.
+    maintenance atomic.Bool
+    // deprecationWarnings records which clients we have already warned about for
+    // using a deprecated route (see deprecations.go).
+    deprecationWarnings warnedClients
+    // requestStats counts the requests of the last few minutes, for the admin
+    // summary.
+    requestStats requestWindow
+    // movieViews counts the views of each movie until they are flushed to the
+    // database (see movie_views.go).
+    movieViews viewCounter
+    // movieChanges wakes the requests which are long-polling for a change to a movie
+    // (see movie_changes.go).
+    movieChanges movieChangeHub
+    // insecureDefaults holds the names of the flags whose credentials were left at a
+    // known default, which are warned about periodically (see defaults.go).
+    insecureDefaults []string
+    // revalidationStarted wakes the revalidation worker when a sweep is started (see
+    // movie_revalidations.go).
+    revalidationStarted chan struct{}
 }
 
 func main() {
@@ -54,13 +169,31 @@ func main() {
     flag.IntVar(&cfg.port, "port", 8080, "API Server Port")
     flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
 
-    flag.StringVar(&cfg.db.dsn, "db-dsn", "user=greenlight password=greenlight dbname=greenlight sslmode=disable", "PostgreSQL DSN")
+    // The database and SMTP credentials have no defaults of their own, except in
+    // development (see defaults.go). Outside of development, -insecure-defaults says
+    // whether a credential left at one of the development values stops the server
+    // from starting, or is only warned about.
+    flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+    flag.StringVar(&cfg.insecureDefaults, "insecure-defaults", insecureDefaultsRefuse, "What to do if a credential is left at a known default outside development (refuse|warn)")
 
     // Read the connection pool settings from the command-line flags into
     // the config struct. Note the default values being passed here
     flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
     flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
     flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connections idle time")
+
+    // How long a query waits for a connection from the pool before the request gets
+    // a 503 response saying that the server is busy. This is separate from the
+    // timeout of the query itself, so that a full pool is reported as such.
+    flag.DurationVar(&cfg.db.acquireTimeout, "db-acquire-timeout", time.Second, "How long a query waits for a connection from the pool (0 for no limit)")
+
+    // How often to log the connection pool statistics, so that the state of the pool
+    // can be reconstructed after an incident. The default of zero turns this off.
+    flag.DurationVar(&cfg.db.statsInterval, "db-stats-interval", 0, "Interval between connection pool statistics log entries (0 to disable)")
+
+    // Movie queries which take longer than this are logged, to help find slow
+    // database access. The default of zero turns the logging off.
+    flag.DurationVar(&cfg.db.slowQueryThreshold, "slow-query-threshold", 0, "Log movie queries slower than this (0 to disable)")
     
     // Command line flags to reat the setting values into the config struct.
     // Notice that we use true as the default for the 'enabled' setting
@@ -68,16 +201,266 @@ func main() {
     flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
     flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
-    //Read the SMTP server config settings into the config struct, using the
-    // Mailtrap settings as the default values.
-    flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp.mailtrap.io", "SMTP host")
+    // The caps on the number of requests in flight, in total, for exports and for
+    // long polls (which don't count towards the total), and how long a request waits
+    // for a slot before getting a 503 response (see concurrency.go). A cap of zero
+    // turns it off.
+    flag.IntVar(&cfg.concurrency.max, "concurrency-max", 200, "Maximum number of requests in flight (0 for no limit)")
+    flag.IntVar(&cfg.concurrency.export, "concurrency-export", 4, "Maximum number of exports in flight (0 for no limit)")
+    flag.IntVar(&cfg.concurrency.longPoll, "concurrency-long-poll", 1000, "Maximum number of long polls in flight (0 for no limit)")
+    flag.DurationVar(&cfg.concurrency.wait, "concurrency-wait", 500*time.Millisecond, "How long a request waits for a concurrency slot before it is rejected")
+
+    // Whether to install the recoverPanic() middleware. See routes() for the
+    // trade-off.
+    flag.BoolVar(&cfg.recoverPanics, "recover-panics", true, "Recover from panics in handlers and send a 500 response")
+
+    // Read the bearer token which grants access to the /v1/admin endpoints. If it is
+    // not set, the admin endpoints are disabled.
+    flag.StringVar(&cfg.admin.token, "admin-token", "", "Bearer token for the admin endpoints (disabled if empty)")
+
+    // The address that test emails sent through the admin endpoint go to. It is
+    // fixed here, rather than given in the request, so that the admin token can't
+    // be used to send email to anyone.
+    flag.StringVar(&cfg.admin.email, "admin-email", "", "Address for test emails from the admin endpoint (the -smtp-sender if empty)")
+    flag.StringVar(&cfg.share.key, "share-key", "", "Secret key for signing movie share links (disabled if empty)")
+    flag.DurationVar(&cfg.share.maxTTL, "share-max-ttl", 30*24*time.Hour, "Maximum lifetime of a movie share link")
+
+    //Read the SMTP server config settings into the config struct. In development the
+    // Mailtrap settings are used unless others are given.
+    flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
     flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-    flag.StringVar(&cfg.smtp.username, "smtp-username", "36fe81c7938608", "SMTP username")
-    flag.StringVar(&cfg.smtp.password, "smtp-password", "5e34c7bf673796", "SMTP password")
+    flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+    flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
     flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
+    // Whether to look up the MX records of a new user's email domain when they
+    // register, to catch typos such as "gmial.com".
+    flag.BoolVar(&cfg.smtp.checkMX, "smtp-check-mx", false, "Check that registering users' email domains can receive email")
+    // How the connection to the SMTP server is secured, and how long each stage of
+    // sending an email (dialing, the handshake and sending) may take. Skipping
+    // certificate verification is only meant for test servers, and is logged
+    // loudly at startup.
+    flag.StringVar(&cfg.smtp.encryption, "smtp-encryption", string(mailer.EncryptionSTARTTLS), "SMTP connection security (none|starttls|tls)")
+    flag.DurationVar(&cfg.smtp.timeout, "smtp-timeout", 5*time.Second, "Timeout for each stage of sending an email")
+    flag.BoolVar(&cfg.smtp.skipVerify, "smtp-skip-verify", false, "Don't verify the SMTP server's TLS certificate (test environments only)")
+
+    // Read the pagination settings for list endpoints into the config struct.
+    flag.IntVar(&cfg.pagination.defaultPage, "pagination-default-page", 1, "Default page number for list endpoints")
+    flag.IntVar(&cfg.pagination.defaultPageSize, "pagination-default-page-size", 20, "Default page size for list endpoints")
+    flag.IntVar(&cfg.pagination.maxPageSize, "pagination-max-page-size", 100, "Maximum page size for list endpoints")
+
+    // Read the movie validation limits into the config struct. Operators can relax or
+    // tighten these without recompiling.
+    flag.IntVar(&cfg.movies.maxGenres, "movie-max-genres", data.DefaultMaxGenres, "Maximum number of genres per movie")
+    flag.IntVar(&cfg.movies.maxTitleBytes, "movie-max-title-bytes", data.DefaultMaxTitleBytes, "Maximum length of a movie title in bytes")
+
+    // Use the flag.Func() function to process the -genres-allowlist command line flag.
+    // The genres are separated by commas (rather than spaces) because genres such as
+    // "Science Fiction" may contain spaces themselves. If the flag is not set the
+    // allowlist is empty and genres remain free-text.
+    flag.Func("genres-allowlist", "Approved movie genres (comma separated)", func(val string) error {
+        for _, genre := range strings.Split(val, ",") {
+            if genre = strings.TrimSpace(genre); genre != "" {
+                cfg.movies.genresAllowlist = append(cfg.movies.genresAllowlist, genre)
+            }
+        }
+        return nil
+    })
+
+    // How similar a title must be to the search term for a fuzzy title search
+    // (?fuzzy=true) to match it, as a pg_trgm similarity between 0 and 1. Lower
+    // values tolerate more typos, at the cost of more unrelated matches.
+    flag.Float64Var(&cfg.movies.fuzzyThreshold, "fuzzy-search-threshold", 0.3, "Minimum trigram similarity (0 to 1) for a fuzzy title search to match")
+    // The text search configuration used to match titles, which decides how words
+    // are stemmed. Migrations create title indexes for "simple" and "english"; any
+    // other language needs an index of its own to search a large catalog quickly.
+    flag.StringVar(&cfg.movies.ftsLanguage, "fts-language", "simple", "PostgreSQL text search configuration for title searches (e.g. simple, english)")
+
+    // The number of requests which may long-poll for changes to any one movie (with
+    // ?wait_version_gt=N) at once. Requests beyond it get a 429 response, rather than
+    // letting one popular movie hold an unbounded number of connections open.
+    flag.IntVar(&cfg.movies.maxWaiters, "movie-max-waiters", 50, "Maximum number of requests long-polling for changes to a single movie")
+
+    // Whether to run the link checker, which checks the movies' trailer and homepage
+    // links once a week and records any which are broken.
+    flag.BoolVar(&cfg.movies.checkLinks, "check-links", false, "Check movie trailer and homepage links for broken ones weekly")
+
+    // How movies are identified in the API: by their sequential bigserial ID (the
+    // default), or by a UUID, which doesn't reveal how many movies exist.
+    flag.StringVar(&cfg.idType, "id-type", idTypeBigserial, "Movie identifiers used in the API (bigserial|uuid)")
+
+    // The path prefix that all routes are served under, for deployments behind a
+    // path-prefixing gateway. It is empty by default, so the routes start at /v1.
+    flag.StringVar(&cfg.basePath, "base-path", "", "Path prefix for all routes (e.g. /api)")
+
+    // The size that list responses are kept within, by cutting the page short if
+    // the client asked for more than fits (see budget.go).
+    flag.IntVar(&cfg.responseSizeBudget, "response-size-budget", 1<<20, "Soft limit in bytes on the size of list responses (0 for no limit)")
+
+    // Whether to send JSON responses without indentation, which makes them smaller
+    // but harder to read in a terminal.
+    flag.BoolVar(&cfg.compactJSON, "compact-json", false, "Send JSON responses without indentation")
+
+    // The longest deadline that a client can ask for with a Request-Timeout header.
+    flag.DurationVar(&cfg.maxRequestTimeout, "max-request-timeout", 30*time.Second, "Maximum deadline a client can set with the Request-Timeout header")
+
+    // Whether to run the dependency checks (see checks.go) before starting the
+    // server, and refuse to start if any of them fail.
+    flag.BoolVar(&cfg.checkOnStart, "check-on-start", false, "Check external dependencies before starting the server")
+
+    // Whether to start in read-only mode, such as during a maintenance window. Reads
+    // are served as usual, but writes are rejected until it is turned off.
+    flag.BoolVar(&cfg.readOnly, "read-only", false, "Start in read-only mode, rejecting writes")
+
+    // How often the feature flags are reloaded from the database. Changes made
+    // through the admin endpoint apply straight away on the instance which handled
+    // them, and on the others within this interval.
+    flag.DurationVar(&cfg.features.refreshInterval, "feature-refresh-interval", 30*time.Second, "Interval between reloads of the feature flags")
+
+    // Whether to start in maintenance mode, such as during a deploy, in which every
+    // request gets a 503 response except for the healthcheck and requests from the
+    // allowlisted addresses (so that we can check the API before reopening it). The
+    // allowlist holds IP addresses and CIDR ranges, separated by commas.
+    flag.BoolVar(&cfg.maintenance.enabled, "maintenance", false, "Start in maintenance mode, rejecting all requests but the health checks")
+    flag.DurationVar(&cfg.maintenance.retryAfter, "maintenance-retry-after", 5*time.Minute, "Retry-After sent with responses in maintenance mode")
+    flag.Func("maintenance-allowlist", "IP addresses and CIDR ranges allowed through in maintenance mode (comma separated)", func(val string) error {
+        for _, entry := range strings.Split(val, ",") {
+            if entry = strings.TrimSpace(entry); entry == "" {
+                continue
+            }
+
+            network, err := parseIPNet(entry)
+            if err != nil {
+                return err
+            }
+            cfg.maintenance.allowlist = append(cfg.maintenance.allowlist, network)
+        }
+        return nil
+    })
+
+    // How our calls to third-party services identify themselves. The contact URL is
+    // added to the User-Agent header, for services (such as TMDB) which ask clients
+    // to say who they are, and the bind IP is the local address that connections are
+    // made from, for deployments with a dedicated egress IP.
+    flag.StringVar(&cfg.outbound.contactURL, "outbound-contact-url", "", "Contact URL added to the User-Agent of outbound requests")
+    flag.Func("outbound-bind-ip", "Local IP address to make outbound connections from", func(val string) error {
+        cfg.outbound.bindIP = net.ParseIP(val)
+        if cfg.outbound.bindIP == nil {
+            return fmt.Errorf("invalid IP address %q", val)
+        }
+        return nil
+    })
 
     flag.Parse()
 
+    if err := applyDevelopmentDefaults(flag.CommandLine, cfg.env); err != nil {
+        fmt.Fprintf(os.Stderr, "applying development defaults: %v\n", err)
+        os.Exit(2)
+    }
+
+    if cfg.insecureDefaults != insecureDefaultsRefuse && cfg.insecureDefaults != insecureDefaultsWarn {
+        fmt.Fprintf(os.Stderr, "invalid -insecure-defaults %q: must be %s or %s\n", cfg.insecureDefaults, insecureDefaultsRefuse, insecureDefaultsWarn)
+        os.Exit(2)
+    }
+
+    // The "config" command prints the value of every flag, after the development
+    // defaults are applied, and exits. Credentials are redacted (see printConfig()).
+    // It comes before the check for default credentials, so that a deploy which
+    // would be refused can still be inspected.
+    if flag.Arg(0) == "config" {
+        if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
+    // Refuse to run outside of development with any of the development credentials,
+    // unless told to carry on with a warning. Only the flag names are printed, not
+    // their values.
+    insecure, err := checkInsecureDefaults(flag.CommandLine, cfg.env, cfg.insecureDefaults)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+
+    cfg.basePath = normalizeBasePath(cfg.basePath)
+
+    if cfg.db.acquireTimeout < 0 {
+        fmt.Fprintf(os.Stderr, "invalid -db-acquire-timeout %v: must not be negative\n", cfg.db.acquireTimeout)
+        os.Exit(2)
+    }
+
+    if err := validatePagination(cfg); err != nil {
+        fmt.Fprintf(os.Stderr, "invalid pagination settings: %v\n", err)
+        os.Exit(2)
+    }
+
+    if cfg.movies.maxWaiters <= 0 {
+        fmt.Fprintf(os.Stderr, "invalid -movie-max-waiters %d: must be greater than 0\n", cfg.movies.maxWaiters)
+        os.Exit(2)
+    }
+
+    if cfg.movies.fuzzyThreshold <= 0 || cfg.movies.fuzzyThreshold > 1 {
+        fmt.Fprintf(os.Stderr, "invalid -fuzzy-search-threshold %v: must be greater than 0 and at most 1\n", cfg.movies.fuzzyThreshold)
+        os.Exit(2)
+    }
+
+    if !validator.In(cfg.movies.ftsLanguage, data.TextSearchLanguages...) {
+        fmt.Fprintf(os.Stderr, "invalid -fts-language %q: must be one of %s\n", cfg.movies.ftsLanguage, strings.Join(data.TextSearchLanguages, ", "))
+        os.Exit(2)
+    }
+
+    if cfg.idType != idTypeBigserial && cfg.idType != idTypeUUID {
+        fmt.Fprintf(os.Stderr, "invalid -id-type %q: must be %s or %s\n", cfg.idType, idTypeBigserial, idTypeUUID)
+        os.Exit(2)
+    }
+
+    // Check the sender address now, rather than finding out that it is malformed
+    // when the first email fails to send.
+    if _, err := mailer.ParseAddress(cfg.smtp.sender); err != nil {
+        fmt.Fprintf(os.Stderr, "invalid -smtp-sender %q: %v\n", cfg.smtp.sender, err)
+        os.Exit(2)
+    }
+
+    if cfg.admin.email != "" {
+        if _, err := mailer.ParseAddress(cfg.admin.email); err != nil {
+            fmt.Fprintf(os.Stderr, "invalid -admin-email %q: %v\n", cfg.admin.email, err)
+            os.Exit(2)
+        }
+    }
+
+    if _, err := mailer.ParseEncryption(cfg.smtp.encryption); err != nil {
+        fmt.Fprintf(os.Stderr, "invalid -smtp-encryption: %v\n", err)
+        os.Exit(2)
+    }
+
+    // If the program was run with the "check" command after the flags, run the
+    // dependency checks and exit instead of starting the server.
+    if flag.Arg(0) == "check" {
+        os.Exit(runCheckCommand(cfg))
+    }
+
+    // The "loadtest" command sends a mix of requests to a running instance and
+    // reports how it coped. It has flags of its own, which follow the command name.
+    if flag.Arg(0) == "loadtest" {
+        os.Exit(runLoadTestCommand(cfg, flag.Args()[1:]))
+    }
+
+    // The "routes" command lists the API's endpoints and exits. It fails if a route
+    // is past its sunset (see deprecations.go), so that CI can catch it; the server
+    // itself doesn't check, as a release shouldn't stop starting because of the date.
+    if flag.Arg(0) == "routes" {
+        app := &application{config: cfg}
+        err := app.printRoutes(os.Stdout)
+        if err != nil {
+            os.Exit(1)
+        }
+        if err := checkSunsets(app.routeTable(), time.Now()); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
     // initialize logger which writes messages to STDOUT
     // prefix logger with current date and time
     logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
@@ -91,22 +474,122 @@ func main() {
 
     logger.PrintInfo("database connection pool established", nil)
 
+    // Publish the current connection pool statistics under the "database" expvar,
+    // so that they show up at /debug/vars.
+    expvar.Publish("database", expvar.Func(func() interface{} {
+        return db.Stats()
+    }))
+
+    if cfg.smtp.skipVerify {
+        logger.PrintWarn("SMTP TLS CERTIFICATE VERIFICATION IS DISABLED; do not use -smtp-skip-verify in production", map[string]string{
+            "smtp_host": cfg.smtp.host,
+            "env": cfg.env,
+        })
+    }
+
+    models := data.NewModels(db)
+    models.SetAcquireTimeout(cfg.db.acquireTimeout)
+    models.Movies.Logger = logger
+    models.Movies.SlowQueryThreshold = cfg.db.slowQueryThreshold
+
     // Declare an instance of the application struct, containing the config struct and the logger
     app := &application{
         config: cfg,
         logger: logger,
-        models: data.NewModels(db),
-        mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+        db: db,
+        models: models,
+        mailer: newMailer(cfg),
+        clock: clock.Real{},
+        limiters: newClientLimiters(),
+        concurrency: newConcurrencyLimiter(cfg.concurrency.max, map[string]int{
+            concurrencyClassExport: cfg.concurrency.export,
+            concurrencyClassLongPoll: cfg.concurrency.longPoll,
+        }, concurrencyClassLongPoll),
+        revalidationStarted: make(chan struct{}, 1),
+        // The readiness endpoint checks that the database is reachable and its schema
+        // is in place. The privileges check is left out, as it writes to the database.
+        readinessChecks: &checkCache{checks: databaseChecks(db)[:2], ttl: 10 * time.Second},
+    }
+
+    app.insecureDefaults = insecure
+
+    app.readOnly.Store(cfg.readOnly)
+    app.maintenance.Store(cfg.maintenance.enabled)
+
+    // The rest of the startup work is done once the server is listening, so that the
+    // readiness endpoint can report that the instance isn't ready yet in the
+    // meantime. Any other startup work (such as running migrations) belongs here, so
+    // that the instance isn't sent traffic before its schema is ready.
+    startup := func() error {
+        // Load the feature flags before the instance is sent traffic. If they can't be
+        // loaded every flag is off until the next refresh, which is safe, so we carry
+        // on.
+        err := models.Features.Refresh(context.Background())
+        if err != nil {
+            logger.PrintError(err, map[string]string{"task": "feature_flags"})
+        }
+
+        if cfg.checkOnStart {
+            results, ok := runChecks(context.Background(), dependencyChecks(db, app.mailer))
+            for _, result := range results {
+                if !result.OK {
+                    logger.PrintError(errors.New(result.Error), map[string]string{"check": result.Name})
+                }
+            }
+            if !ok {
+                return errors.New("dependency checks failed")
+            }
+
+            logger.PrintInfo("dependency checks passed", nil)
+        }
+
+        return nil
     }
 
     // Call app.serve() to start the server
-    err = app.serve()
+    err = app.serve(startup)
     if err != nil {
         logger.PrintFatal(err, nil)
     }
 }
 
 
+// newMailer returns a Mailer with the SMTP settings from the config. The encryption
+// mode has already been checked by main(), so the error can be ignored here.
+func newMailer(cfg config) mailer.Mailer {
+    encryption, _ := mailer.ParseEncryption(cfg.smtp.encryption)
+
+    return mailer.New(mailer.Config{
+        Host: cfg.smtp.host,
+        Port: cfg.smtp.port,
+        Username: cfg.smtp.username,
+        Password: cfg.smtp.password,
+        Sender: cfg.smtp.sender,
+        Encryption: encryption,
+        Timeout: cfg.smtp.timeout,
+        SkipVerify: cfg.smtp.skipVerify,
+    })
+}
+
+// validatePagination checks that the pagination settings allow the requests which
+// rely on the defaults: a default page or page size which failed validation would
+// turn every list request without page and page_size parameters away.
+func validatePagination(cfg config) error {
+    p := cfg.pagination
+
+    if p.defaultPage < 1 {
+        return fmt.Errorf("-pagination-default-page %d: must be at least 1", p.defaultPage)
+    }
+    if p.maxPageSize < 1 {
+        return fmt.Errorf("-pagination-max-page-size %d: must be at least 1", p.maxPageSize)
+    }
+    if p.defaultPageSize < 1 || p.defaultPageSize > p.maxPageSize {
+        return fmt.Errorf("-pagination-default-page-size %d: must be between 1 and -pagination-max-page-size (%d)", p.defaultPageSize, p.maxPageSize)
+    }
+
+    return nil
+}
+
 func openDB(cfg config) (*sql.DB, error) {
     
     // use sql.open to create connection pool
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestValidatePagination(t *testing.T) {
+    tests := []struct {
+        name string
+        page, size, max int
+        wantErr bool
+    }{
+        {"defaults", 1, 20, 100, false},
+        {"default size at the max", 3, 100, 100, false},
+        {"page zero", 0, 20, 100, true},
+        {"negative page", -1, 20, 100, true},
+        {"size zero", 1, 0, 100, true},
+        {"size above the max", 1, 200, 100, true},
+        {"max zero", 1, 20, 0, true},
+    }
+
+    for _, tt := range tests {
+        cfg := newTestConfig()
+        cfg.pagination.defaultPage = tt.page
+        cfg.pagination.defaultPageSize = tt.size
+        cfg.pagination.maxPageSize = tt.max
+
+        err := validatePagination(cfg)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: validatePagination() = %v; want error %v", tt.name, err, tt.wantErr)
+        }
+    }
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The upper bounds (in bytes) of the buckets of the body size histograms. Bodies
+// larger than the last bound are counted in the "+Inf" bucket.
+var bodySizeBuckets = []int64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// The request and response body sizes are published as the "request_body_bytes"
+// and "response_body_bytes" expvars, so that they show up at /debug/vars.
+var (
+    requestBodySizes = newSizeHistogram("request_body_bytes")
+    responseBodySizes = newSizeHistogram("response_body_bytes")
+)
+
+// sizeHistogram is a cumulative histogram of sizes, in the style of Prometheus:
+// each bucket, keyed by its upper bound (e.g. "le_1024"), counts the observations
+// at or below that bound, alongside the total count and sum of all observations.
+type sizeHistogram struct {
+    m *expvar.Map
+}
+
+func newSizeHistogram(name string) sizeHistogram {
+    return sizeHistogram{m: expvar.NewMap(name)}
+}
+
+func (h sizeHistogram) observe(n int64) {
+    for _, bound := range bodySizeBuckets {
+        if n <= bound {
+            h.m.Add("le_"+strconv.FormatInt(bound, 10), 1)
+        }
+    }
+    h.m.Add("le_+Inf", 1)
+    h.m.Add("count", 1)
+    h.m.Add("sum", n)
+}
+
+// The metrics() middleware records the size of each request and response body, and
+// counts the request and its status in app.requestStats. The sizes are counted as
+// the bodies are read and written, rather than taken from the Content-Length
+// headers, so that chunked bodies are measured too.
+//
+// The request is recorded in a deferred function, so that it is counted even if the
+// handler panics. A panic which gets this far wasn't recovered (because of
+// -recover-panics=false), so net/http will drop the connection, and it is counted
+// as a 500.
+func (app *application) metrics(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body := &countingReader{ReadCloser: r.Body}
+        r.Body = body
+
+        mw := &metricsResponseWriter{ResponseWriter: w, status: http.StatusOK}
+
+        completed := false
+        defer func() {
+            status := mw.status
+            if !completed {
+                status = http.StatusInternalServerError
+            }
+
+            requestBodySizes.observe(body.n)
+            responseBodySizes.observe(mw.n)
+            app.requestStats.add(app.clock.Now(), status)
+        }()
+
+        next.ServeHTTP(mw, r)
+        completed = true
+    })
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+    io.ReadCloser
+    n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.ReadCloser.Read(p)
+    c.n += int64(n)
+    return n, err
+}
+
+// metricsResponseWriter records the status code of a response and counts the bytes
+// written to its body.
+type metricsResponseWriter struct {
+    http.ResponseWriter
+    status int
+    wroteHeader bool
+    n int64
+}
+
+func (mw *metricsResponseWriter) WriteHeader(status int) {
+    if !mw.wroteHeader {
+        mw.status = status
+        mw.wroteHeader = true
+    }
+    mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+    mw.wroteHeader = true
+    n, err := mw.ResponseWriter.Write(b)
+    mw.n += int64(n)
+    return n, err
+}
+
+// Flush passes flushes through to the underlying http.ResponseWriter, so that
+// streaming handlers (such as the movie export) still work behind the middleware.
+func (mw *metricsResponseWriter) Flush() {
+    if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+        mw.wroteHeader = true
+        flusher.Flush()
+    }
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for http.ResponseController.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+    return mw.ResponseWriter
+}
+
+// requestWindowMinutes is the length of the window covered by requestWindow.
+const requestWindowMinutes = 5
+
+// requestCounts holds the number of requests in a period, and how many of them
+// ended in a client (4xx) or server (5xx) error.
+type requestCounts struct {
+    Total int `json:"total"`
+    ClientErrors int `json:"client_errors"`
+    ServerErrors int `json:"server_errors"`
+}
+
+// requestWindow counts the requests of the last few minutes, in one bucket per
+// minute. A bucket is reused (after being reset) when the minute it was for has
+// dropped out of the window. The zero value is ready to use.
+type requestWindow struct {
+    mu sync.Mutex
+    minutes [requestWindowMinutes]int64
+    buckets [requestWindowMinutes]requestCounts
+}
+
+func (rw *requestWindow) add(now time.Time, status int) {
+    rw.mu.Lock()
+    defer rw.mu.Unlock()
+
+    minute := now.Unix() / 60
+    i := minute % requestWindowMinutes
+
+    if rw.minutes[i] != minute {
+        rw.minutes[i] = minute
+        rw.buckets[i] = requestCounts{}
+    }
+
+    rw.buckets[i].Total++
+    switch {
+    case status >= 500:
+        rw.buckets[i].ServerErrors++
+    case status >= 400:
+        rw.buckets[i].ClientErrors++
+    }
+}
+
+// counts returns the totals of the buckets which are still inside the window.
+func (rw *requestWindow) counts(now time.Time) requestCounts {
+    rw.mu.Lock()
+    defer rw.mu.Unlock()
+
+    minute := now.Unix() / 60
+
+    var total requestCounts
+    for i, m := range rw.minutes {
+        if minute-m < requestWindowMinutes {
+            total.Total += rw.buckets[i].Total
+            total.ClientErrors += rw.buckets[i].ClientErrors
+            total.ServerErrors += rw.buckets[i].ServerErrors
+        }
+    }
+
+    return total
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// A request whose handler panics is counted as a server error, whether or not the
+// panic is recovered.
+func TestMetricsCountsPanics(t *testing.T) {
+    t.Run("recovered", func(t *testing.T) {
+        // Without a database, listing movies panics on the nil connection pool, and
+        // recoverPanic() sends a 500.
+        app := newTestApplication(t, nil)
+        ts := newTestServer(t, app.routes())
+
+        if res := ts.get(t, "/v1/movies"); res.status != http.StatusInternalServerError {
+            t.Fatalf("status = %d; want %d", res.status, http.StatusInternalServerError)
+        }
+
+        counts := app.requestStats.counts(app.clock.Now())
+        if counts.Total != 1 || counts.ServerErrors != 1 {
+            t.Errorf("counts = %+v; want one server error", counts)
+        }
+    })
+
+    t.Run("unrecovered", func(t *testing.T) {
+        app := newTestApplication(t, nil)
+        handler := app.metrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            panic("boom")
+        }))
+
+        func() {
+            defer func() {
+                if err := recover(); err != "boom" {
+                    t.Errorf("recovered %v; want the handler's panic", err)
+                }
+            }()
+            handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+        }()
+
+        counts := app.requestStats.counts(app.clock.Now())
+        if counts.Total != 1 || counts.ServerErrors != 1 {
+            t.Errorf("counts = %+v; want one server error", counts)
+        }
+    })
+}
+
+// The body size histograms are published at /debug/vars. Reading them is a
+// request too, and is observed after its response is sent, so the test only
+// checks what that request can't change: the sum of the request sizes (it has no
+// body) and the count of responses over 1MB.
+func TestMetricsBodySizes(t *testing.T) {
+    app := newTestApplication(t, nil)
+    app.config.admin.token = "secret"
+    ts := newTestServer(t, app.routes())
+
+    overMB := func(h map[string]int64) int64 {
+        return h["le_+Inf"] - h["le_1048576"]
+    }
+
+    var reqBefore, resBefore map[string]int64
+    ts.debugVar(t, "request_body_bytes", &reqBefore)
+    ts.debugVar(t, "response_body_bytes", &resBefore)
+
+    handler := app.metrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        io.Copy(io.Discard, r.Body)
+        w.Write(make([]byte, 1<<20+1))
+    }))
+    handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(strings.Repeat("x", 300))))
+
+    var reqAfter, resAfter map[string]int64
+    ts.debugVar(t, "request_body_bytes", &reqAfter)
+    ts.debugVar(t, "response_body_bytes", &resAfter)
+
+    if got := reqAfter["sum"] - reqBefore["sum"]; got != 300 {
+        t.Errorf("request body sum went up by %d; want 300", got)
+    }
+    if got := overMB(resAfter) - overMB(resBefore); got != 1 {
+        t.Errorf("responses over 1MB went up by %d; want 1", got)
+    }
+}
@@ -1,49 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
-	"golang.org/x/time/rate"
+	"github.com/agpelkey/greenlight/internal/validator"
 )
 
 func (app *application) rateLimit(next http.Handler) http.Handler {
 
-    //Define a client struct to hold the rate limiter and last seen
-    // time for each client.
-    type client struct {
-        limiter *rate.Limiter
-        lastSeen time.Time
-    }
-
-    var (
-        mu sync.Mutex
-        clients = make(map[string]*client)
-    )
-
-    // Launch a background goroutine which removes old entries from the clients map
-    // once every minute.
+    // Launch a background goroutine which removes old entries from the client
+    // limiters once every minute. The ticker comes from the application clock so
+    // that tests can trigger the cleanup by advancing a fake clock.
     go func() {
-        for {
-            time.Sleep(time.Minute)
-
-            // Lock the mutex to prevent any rate limiter checks from happening while
-            // the cleanup is taking place
-            mu.Lock()
-
-            // Loop through all clients. If they havent been seen within the last three minutes,
-            // delete the corresponding entry from the map
-            for ip, client := range clients {
-                if time.Since(client.lastSeen) > 3*time.Minute {
-                    delete(clients, ip)
-                }
-            }
+        ticker := app.clock.NewTicker(time.Minute)
+        defer ticker.Stop()
 
-            // Importantly, unlock the mutex when the cleanup is complete
-            mu.Unlock()
+        for range ticker.C() {
+            // Remove any clients which haven't been seen within the last three minutes.
+            app.limiters.evict(app.clock.Now().Add(-3 * time.Minute))
         }
     }()
 
@@ -56,31 +39,13 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
                 return
             }
 
-            // Lock the mutex to prevent this code from being executed concurrently
-            mu.Lock()
-
-            // Check to see if the IP address already exists in the map. If it doesn't, then
-            // initialize a new rate limiter and add the IP address and limiter to the map
-            if _, found := clients[ip]; !found {
-                clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
-            }
-
-            clients[ip].lastSeen = time.Now()
-
-            // Call the Allow() method on the rate limiter for the current IP Address.
-            // If the request isnt' allowed, unlock the mutex and send a 429 Too
-            // Many Requests response, just like before.
-            if !clients[ip].limiter.Allow() {
-                mu.Unlock()
+            // Check the rate limiter for the current IP address (creating it if this
+            // is the first request we've seen from it). If the request isn't allowed,
+            // send a 429 Too Many Requests response.
+            if !app.limiters.allow(limiterKeyIP, ip, app.clock.Now(), app.config.limiter.rps, app.config.limiter.burst) {
                 app.rateLimitExceededResponse(w, r)
                 return
             }
-
-            // Very importantly, unlock the mutex before calling the next handler in the chain.
-            // Notive that we DON'T use defer to unlock the mutex, as that would mean that
-            // the mutex isnt unlocked until all the handlers downstream of this middleware
-            // have also returned
-            mu.Unlock()
         }
     next.ServeHTTP(w, r)
     })
@@ -109,3 +74,213 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
         next.ServeHTTP(w, r)
     })
 }
+
+// The rejectDuringShutdown() middleware sends a 503 response to any request which
+// arrives once a graceful shutdown has begun, instead of running the handler (and
+// its database queries) when we are about to exit. Requests which got past this
+// middleware before the shutdown started carry on as normal. The "Connection: close"
+// header tells clients and load balancers not to reuse the connection.
+func (app *application) rejectDuringShutdown(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if app.shuttingDown.Load() {
+            app.serverShuttingDownResponse(w, r)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// The rejectDuringMaintenance() middleware sends a 503 response, with a Retry-After header, to
+// every request while the API is in maintenance mode. The exceptions are requests
+// from the addresses in the -maintenance-allowlist, so that we can check the API
+// before reopening it, the healthcheck and readiness check, so that monitoring and
+// the load balancer carry on, and the endpoints which turn maintenance mode and
+// read-only mode on and off, so that the API can be moved from maintenance mode
+// into read-only mode without fully reopening it in between.
+func (app *application) rejectDuringMaintenance(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !app.maintenance.Load() {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        switch r.URL.Path {
+        case app.apiPath("/v1/healthcheck"), app.apiPath("/v1/healthz/ready"), app.apiPath("/v1/admin/maintenance"), app.apiPath("/v1/admin/read-only"):
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        ip, _, err := net.SplitHostPort(r.RemoteAddr)
+        if err == nil && app.maintenanceAllowed(net.ParseIP(ip)) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        app.maintenanceModeResponse(w, r)
+    })
+}
+
+// maintenanceAllowed reports whether the IP address is in the maintenance allowlist.
+func (app *application) maintenanceAllowed(ip net.IP) bool {
+    if ip == nil {
+        return false
+    }
+
+    for _, network := range app.config.maintenance.allowlist {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// The rejectWritesWhenReadOnly() middleware sends a 503 response to POST, PUT, PATCH
+// and DELETE requests while the API is in read-only mode, and lets GET, HEAD and
+// OPTIONS requests through as usual. The endpoints which turn read-only mode and
+// maintenance mode on and off are exempt: the first as otherwise it couldn't be
+// turned off again, and the second so that the API can be moved from read-only mode
+// into maintenance mode without reopening it for writes in between.
+func (app *application) rejectWritesWhenReadOnly(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !app.readOnly.Load() {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        switch r.Method {
+        case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+            switch r.URL.Path {
+            case app.apiPath("/v1/admin/read-only"), app.apiPath("/v1/admin/maintenance"):
+            default:
+                app.readOnlyModeResponse(w, r)
+                return
+            }
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// The requireAdmin() middleware only lets a request through to the handler if it
+// carries the admin token from the -admin-token flag, in an "Authorization: Bearer
+// <token>" header. If no admin token is configured the admin endpoints are disabled
+// and respond with 404 Not Found, as though they didn't exist. The tokens are
+// compared in constant time so that the comparison doesn't leak how much of a
+// guessed token was right.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if app.config.admin.token == "" {
+            app.notFoundResponse(w, r)
+            return
+        }
+
+        // A bare token without the "Bearer " scheme is rejected, rather than compared
+        // as though the scheme had been there.
+        token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+        if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.admin.token)) != 1 {
+            app.invalidAdminTokenResponse(w, r)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    }
+}
+
+// The requireJSON() middleware rejects POST, PUT and PATCH requests with a body
+// whose Content-Type isn't one that we can decode, with a 415 Unsupported Media Type
+// response. Without it a client which sends form-encoded data, or JSON labelled as
+// text/plain, gets a confusing error from the JSON decoder instead. Parameters
+// such as charset are ignored. PATCH requests may also use the JSON Merge Patch
+// media type (RFC 7396), because that is how our partial updates behave, and the
+// movie import endpoint takes newline-delimited JSON instead. Requests without a
+// body (and all other methods, such as DELETE) are passed through unchecked.
+func (app *application) requireJSON(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var accepted []string
+
+        // The echo endpoint takes any body, as its point is to show what was sent.
+        if r.URL.Path == app.apiPath("/v1/debug/echo") {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        switch r.Method {
+        case http.MethodPost, http.MethodPut:
+            accepted = []string{"application/json"}
+            if r.URL.Path == app.apiPath("/v1/admin/movies/import") {
+                accepted = []string{"application/x-ndjson"}
+            }
+        case http.MethodPatch:
+            accepted = []string{"application/json", "application/merge-patch+json"}
+        default:
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        // A ContentLength of -1 means that the length is unknown (e.g. a chunked
+        // body), so we have to assume there is a body.
+        if r.ContentLength == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+        if err != nil || !validator.In(strings.ToLower(mediaType), accepted...) {
+            app.unsupportedMediaTypeResponse(w, r, accepted)
+            return
+        }
+
+        next.ServeHTTP(w, r)
+    })
+}
+
+// The requestTimeout() middleware lets latency-sensitive clients cap how long they
+// are willing to wait, with a Request-Timeout header holding either a duration such
+// as "2s" or "500ms", or a whole number of seconds. The request context is given
+// that deadline (capped at the -max-request-timeout setting), so the database
+// queries made by the handler are cancelled when it passes, and the client gets a
+// 503 response (see serverErrorResponse()) rather than a late answer.
+func (app *application) requestTimeout(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        value := r.Header.Get("Request-Timeout")
+        if value == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        timeout, err := parseRequestTimeout(value)
+        if err != nil {
+            app.badRequestResponse(w, r, err)
+            return
+        }
+
+        if timeout > app.config.maxRequestTimeout {
+            timeout = app.config.maxRequestTimeout
+        }
+
+        ctx, cancel := context.WithTimeout(r.Context(), timeout)
+        defer cancel()
+
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+func parseRequestTimeout(value string) (time.Duration, error) {
+    timeout, err := time.ParseDuration(value)
+    if err != nil {
+        seconds, err := strconv.Atoi(value)
+        if err != nil {
+            return 0, errors.New("invalid Request-Timeout header")
+        }
+        timeout = time.Duration(seconds) * time.Second
+    }
+
+    if timeout <= 0 {
+        return 0, errors.New("Request-Timeout header must be greater than zero")
+    }
+
+    return timeout, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireJSON(t *testing.T) {
+    tests := []struct {
+        name string
+        method string
+        path string
+        contentType string
+        body string
+        wantStatus int
+        wantAccept string
+    }{
+        {"post json", http.MethodPost, "/v1/movies", "application/json", `{}`, http.StatusOK, ""},
+        {"post json with charset", http.MethodPost, "/v1/movies", "application/json; charset=utf-8", `{}`, http.StatusOK, ""},
+        {"post json in capitals", http.MethodPost, "/v1/movies", "Application/JSON", `{}`, http.StatusOK, ""},
+        {"post text", http.MethodPost, "/v1/movies", "text/plain", `{}`, http.StatusUnsupportedMediaType, "application/json"},
+        {"post form", http.MethodPost, "/v1/movies", "application/x-www-form-urlencoded", `title=Moana`, http.StatusUnsupportedMediaType, "application/json"},
+        {"post without content type", http.MethodPost, "/v1/movies", "", `{}`, http.StatusUnsupportedMediaType, "application/json"},
+        {"post malformed content type", http.MethodPost, "/v1/movies", "application/", `{}`, http.StatusUnsupportedMediaType, "application/json"},
+        {"post without body", http.MethodPost, "/v1/movies", "", ``, http.StatusOK, ""},
+        {"put json", http.MethodPut, "/v1/admin/read-only", "application/json", `{}`, http.StatusOK, ""},
+        {"put text", http.MethodPut, "/v1/admin/read-only", "text/plain", `{}`, http.StatusUnsupportedMediaType, ""},
+        {"patch json", http.MethodPatch, "/v1/movies/1", "application/json", `{}`, http.StatusOK, ""},
+        {"patch merge patch", http.MethodPatch, "/v1/movies/1", "application/merge-patch+json", `{}`, http.StatusOK, ""},
+        {"patch text", http.MethodPatch, "/v1/movies/1", "text/plain", `{}`, http.StatusUnsupportedMediaType, "application/json, application/merge-patch+json"},
+        {"import ndjson", http.MethodPost, "/v1/admin/movies/import", "application/x-ndjson", `{}`, http.StatusOK, ""},
+        {"import json", http.MethodPost, "/v1/admin/movies/import", "application/json", `{}`, http.StatusUnsupportedMediaType, "application/x-ndjson"},
+        {"echo takes anything", http.MethodPost, "/v1/debug/echo", "text/plain", `hello`, http.StatusOK, ""},
+        {"delete without body", http.MethodDelete, "/v1/movies/1", "", ``, http.StatusOK, ""},
+        {"delete with body", http.MethodDelete, "/v1/movies/1", "text/plain", `x`, http.StatusOK, ""},
+        {"get", http.MethodGet, "/v1/movies", "text/plain", ``, http.StatusOK, ""},
+    }
+
+    app := newTestApplication(t, nil)
+    handler := app.requireJSON(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var body io.Reader
+            if tt.body != "" {
+                body = strings.NewReader(tt.body)
+            }
+
+            r := httptest.NewRequest(tt.method, tt.path, body)
+            if tt.contentType != "" {
+                r.Header.Set("Content-Type", tt.contentType)
+            }
+
+            rr := httptest.NewRecorder()
+            handler.ServeHTTP(rr, r)
+
+            if rr.Code != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", rr.Code, tt.wantStatus, rr.Body)
+            }
+
+            var accept string
+            switch tt.method {
+            case http.MethodPost:
+                accept = rr.Header().Get("Accept-Post")
+            case http.MethodPatch:
+                accept = rr.Header().Get("Accept-Patch")
+            }
+            if accept != tt.wantAccept {
+                t.Errorf("accepted types header = %q; want %q", accept, tt.wantAccept)
+            }
+            if tt.wantStatus == http.StatusUnsupportedMediaType && !strings.Contains(rr.Body.String(), "the Content-Type header must be one of") {
+                t.Errorf("body = %q; want it to list the accepted types", rr.Body)
+            }
+        })
+    }
+}
+
+func TestRequireAdmin(t *testing.T) {
+    tests := []struct {
+        name string
+        authorization string
+        wantStatus int
+    }{
+        {"bearer token", "Bearer secret", http.StatusOK},
+        {"token without the scheme", "secret", http.StatusUnauthorized},
+        {"wrong token", "Bearer wrong", http.StatusUnauthorized},
+        {"other scheme", "Basic secret", http.StatusUnauthorized},
+        {"scheme only", "Bearer ", http.StatusUnauthorized},
+        {"no header", "", http.StatusUnauthorized},
+    }
+
+    app := newTestApplication(t, nil)
+    app.config.admin.token = "secret"
+    handler := app.requireAdmin(func(w http.ResponseWriter, r *http.Request) {})
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodGet, "/v1/admin/summary", nil)
+            if tt.authorization != "" {
+                r.Header.Set("Authorization", tt.authorization)
+            }
+
+            rr := httptest.NewRecorder()
+            handler.ServeHTTP(rr, r)
+
+            if rr.Code != tt.wantStatus {
+                t.Errorf("status = %d; want %d (body %q)", rr.Code, tt.wantStatus, rr.Body)
+            }
+        })
+    }
+}
+
+// In maintenance mode only the health checks and the maintenance endpoint get
+// through, so that the load balancer's readiness probe keeps working.
+func TestRejectDuringMaintenance(t *testing.T) {
+    tests := []struct {
+        path string
+        wantStatus int
+    }{
+        {"/v1/healthcheck", http.StatusOK},
+        {"/v1/healthz/ready", http.StatusOK},
+        {"/v1/admin/maintenance", http.StatusOK},
+        {"/v1/admin/read-only", http.StatusOK},
+        {"/v1/movies", http.StatusServiceUnavailable},
+        {"/v1/healthz/ready/extra", http.StatusServiceUnavailable},
+    }
+
+    app := newTestApplication(t, nil)
+    app.maintenance.Store(true)
+    handler := app.rejectDuringMaintenance(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, r)
+
+        if rr.Code != tt.wantStatus {
+            t.Errorf("%s: status = %d; want %d", tt.path, rr.Code, tt.wantStatus)
+        }
+    }
+}
+
+// In read-only mode writes are rejected, except to the two mode toggles, so that
+// read-only mode can be turned off or swapped for maintenance mode.
+func TestRejectWritesWhenReadOnly(t *testing.T) {
+    tests := []struct {
+        method string
+        path string
+        wantStatus int
+    }{
+        {http.MethodGet, "/v1/movies", http.StatusOK},
+        {http.MethodHead, "/v1/movies", http.StatusOK},
+        {http.MethodPost, "/v1/movies", http.StatusServiceUnavailable},
+        {http.MethodPatch, "/v1/movies/1", http.StatusServiceUnavailable},
+        {http.MethodDelete, "/v1/movies/1", http.StatusServiceUnavailable},
+        {http.MethodPut, "/v1/admin/read-only", http.StatusOK},
+        {http.MethodPut, "/v1/admin/maintenance", http.StatusOK},
+        {http.MethodPut, "/v1/admin/maintenance/extra", http.StatusServiceUnavailable},
+    }
+
+    app := newTestApplication(t, nil)
+    app.readOnly.Store(true)
+    handler := app.rejectWritesWhenReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    for _, tt := range tests {
+        r := httptest.NewRequest(tt.method, tt.path, nil)
+        rr := httptest.NewRecorder()
+        handler.ServeHTTP(rr, r)
+
+        if rr.Code != tt.wantStatus {
+            t.Errorf("%s %s: status = %d; want %d", tt.method, tt.path, rr.Code, tt.wantStatus)
+        }
+    }
+}
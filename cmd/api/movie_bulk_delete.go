@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// bulkDeleteSampleSize is the number of titles listed by a dry run of a bulk delete.
+const bulkDeleteSampleSize = 20
+
+// handleBulkDeleteMovies deletes every movie matching the filters in the query
+// string, which are the same as those of the list endpoint (title, genres,
+// certification, unrated and search_translations). It is meant for cleaning up the
+// catalog, such as removing a bad import, without a request per movie.
+//
+// Deleting many movies is hard to undo, so the endpoint is guarded in three ways.
+// A request without any filters is rejected, rather than deleting every movie. A
+// dry run (?dry_run=true) deletes nothing, and responds with the number of movies
+// which match, a sample of their titles, and a confirm token. The delete itself
+// only goes ahead when that token is passed back as ?confirm=..., which ties it to
+// a dry run of the same filters. The movies are only soft-deleted, and every bulk
+// delete is recorded in the movie_deletions table with the client which made it,
+// its filters and the IDs of the movies deleted (see data.MovieModel.DeleteWhere).
+func (app *application) handleBulkDeleteMovies(w http.ResponseWriter, r *http.Request) {
+    v := validator.New()
+    qs := r.URL.Query()
+
+    search := app.readMovieSearch(qs, v)
+    dryRun := app.readDryRun(r, v)
+    confirm := app.readString(qs, "confirm", "")
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    filter, err := json.Marshal(search)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    token := bulkDeleteConfirmToken(filter)
+
+    v.Check(!search.IsEmpty(), "filters", "bulk_delete_no_filters")
+    if !dryRun {
+        v.Check(confirm == token, "confirm", "invalid_confirm_token")
+    }
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    if dryRun {
+        count, titles, err := app.models.Movies.PreviewDeleteWhere(r.Context(), search, bulkDeleteSampleSize)
+        if err != nil {
+            app.serverErrorResponse(w, r, err)
+            return
+        }
+
+        headers := make(http.Header)
+        headers.Set("Preference-Applied", "dry-run")
+
+        err = app.writeJSON(w, http.StatusOK, app.envelopeBulkDeletePreview(count, titles, token), headers)
+        if err != nil {
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    // Every admin uses the same token, so the client's address and user agent are
+    // the best record that we have of who made the delete.
+    clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        clientIP = r.RemoteAddr
+    }
+
+    deletion := &data.MovieDeletion{
+        ClientIP: clientIP,
+        UserAgent: r.UserAgent(),
+        Filter: filter,
+    }
+
+    // If a batch fails, the batches before it have still been deleted, so the
+    // delete is logged either way.
+    deleted, err := app.models.Movies.DeleteWhere(r.Context(), search, deletion)
+
+    // Wake any requests waiting for a deleted movie to change, so that they get a
+    // 404 straight away rather than waiting for their timeout.
+    for _, id := range deletion.MovieIDs {
+        app.movieChanges.notify(id)
+    }
+
+    app.logger.PrintInfo("movies bulk deleted", map[string]string{
+        "deletion_id": strconv.FormatInt(deletion.ID, 10),
+        "filter": string(filter),
+        "deleted": strconv.FormatInt(deleted, 10),
+        "client_ip": clientIP,
+        "request_url": r.URL.String(),
+    })
+
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeBulkDelete(deleted, deletion.ID), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// bulkDeleteConfirmToken returns the confirm token for a bulk delete with the given
+// filter (as JSON). It isn't a secret, only a way of making sure that the client
+// has seen a dry run of the delete that it is about to make.
+func bulkDeleteConfirmToken(filter []byte) string {
+    sum := sha256.Sum256(filter)
+    return hex.EncodeToString(sum[:8])
+}
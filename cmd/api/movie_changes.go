@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// maxLongPollTimeout caps the timeout of a long-polling request for a movie. It is
+// kept below the server's 30-second WriteTimeout, so that the "no change" response
+// can still be written when it passes.
+const maxLongPollTimeout = 25 * time.Second
+
+// movieChangeHub lets requests wait for a movie to change. The handlers which
+// change a movie call notify() with its ID once the change is committed, which
+// wakes every request waiting on that movie. It only knows about the changes made
+// through this instance of the API, so when we run more than one instance a waiting
+// request may not hear about a change until its timeout passes.
+type movieChangeHub struct {
+    mu sync.Mutex
+    waiters map[int64]map[chan struct{}]struct{}
+    // done is closed when the server begins shutting down, to release all of the
+    // waiting requests so that the graceful shutdown doesn't have to wait for them.
+    done chan struct{}
+    closed bool
+}
+
+// wait registers a waiter for the movie with the given ID. It returns a channel
+// which is closed when the movie changes, and a function which must be called to
+// unregister the waiter once the request is done with it. If the movie already has
+// max waiters, it returns false as its third value instead.
+func (h *movieChangeHub) wait(id int64, max int) (<-chan struct{}, func(), bool) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if h.waiters == nil {
+        h.waiters = make(map[int64]map[chan struct{}]struct{})
+    }
+
+    if len(h.waiters[id]) >= max {
+        return nil, nil, false
+    }
+
+    ch := make(chan struct{})
+    if h.waiters[id] == nil {
+        h.waiters[id] = make(map[chan struct{}]struct{})
+    }
+    h.waiters[id][ch] = struct{}{}
+
+    release := func() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+
+        delete(h.waiters[id], ch)
+        if len(h.waiters[id]) == 0 {
+            delete(h.waiters, id)
+        }
+    }
+
+    return ch, release, true
+}
+
+// notify wakes the requests waiting on the movie with the given ID.
+func (h *movieChangeHub) notify(id int64) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for ch := range h.waiters[id] {
+        close(ch)
+    }
+    delete(h.waiters, id)
+}
+
+// shutdown releases all of the waiting requests, and any which start waiting later.
+func (h *movieChangeHub) shutdown() {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if h.done == nil {
+        h.done = make(chan struct{})
+    }
+    if !h.closed {
+        close(h.done)
+        h.closed = true
+    }
+}
+
+// stopped returns a channel which is closed once shutdown() has been called.
+func (h *movieChangeHub) stopped() <-chan struct{} {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    if h.done == nil {
+        h.done = make(chan struct{})
+    }
+
+    return h.done
+}
+
+// readLongPoll reads the wait_version_gt and timeout query string parameters of a
+// long-polling request for a movie. The third value reports whether the request
+// asked to wait at all.
+func (app *application) readLongPoll(r *http.Request, v *validator.Validator) (int32, time.Duration, bool) {
+    qs := r.URL.Query()
+
+    if qs.Get("wait_version_gt") == "" {
+        return 0, 0, false
+    }
+
+    version := app.readInt(qs, "wait_version_gt", 0, v)
+    v.Check(version > 0, "wait_version_gt", "greater_than_zero")
+
+    // Versions are int32s, so a larger value would wrap around to another version.
+    v.CheckParams(version <= math.MaxInt32, "wait_version_gt", "too_large", map[string]string{"max": strconv.Itoa(math.MaxInt32)})
+
+    max := int(maxLongPollTimeout / time.Second)
+
+    timeout := app.readInt(qs, "timeout", max, v)
+    v.Check(timeout > 0, "timeout", "greater_than_zero")
+    v.CheckParams(timeout <= max, "timeout", "too_large", map[string]string{"max": strconv.Itoa(max)})
+
+    return int32(version), time.Duration(timeout) * time.Second, true
+}
+
+// awaitMovieChange blocks until the changed channel (from movieChangeHub.wait()) is
+// closed, the timeout passes, the request is cancelled or the server begins
+// shutting down. It reports whether the movie changed.
+func (app *application) awaitMovieChange(ctx context.Context, changed <-chan struct{}, timeout time.Duration) bool {
+    ticker := app.clock.NewTicker(timeout)
+    defer ticker.Stop()
+
+    select {
+    case <-changed:
+        return true
+    case <-ticker.C():
+    case <-ctx.Done():
+    case <-app.movieChanges.stopped():
+    }
+
+    return false
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/clock"
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// waitersOn returns the number of requests waiting on the movie with the given ID.
+func waitersOn(h *movieChangeHub, id int64) int {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    return len(h.waiters[id])
+}
+
+func TestMovieChangeHub(t *testing.T) {
+    var h movieChangeHub
+
+    first, releaseFirst, ok := h.wait(1, 2)
+    if !ok {
+        t.Fatal("first waiter refused")
+    }
+    defer releaseFirst()
+
+    _, releaseSecond, ok := h.wait(1, 2)
+    if !ok {
+        t.Fatal("second waiter refused")
+    }
+
+    // The cap is per movie, and a released waiter frees its place.
+    if _, _, ok := h.wait(1, 2); ok {
+        t.Error("third waiter accepted; want the cap of 2")
+    }
+    other, releaseOther, ok := h.wait(2, 2)
+    if !ok {
+        t.Fatal("waiter on another movie refused")
+    }
+    defer releaseOther()
+
+    releaseSecond()
+    third, releaseThird, ok := h.wait(1, 2)
+    if !ok {
+        t.Fatal("waiter refused after one was released")
+    }
+    defer releaseThird()
+
+    // A change wakes every waiter on the movie, and only them.
+    h.notify(1)
+    for i, ch := range []<-chan struct{}{first, third} {
+        select {
+        case <-ch:
+        default:
+            t.Errorf("waiter %d wasn't woken", i+1)
+        }
+    }
+    select {
+    case <-other:
+        t.Error("the waiter on another movie was woken")
+    default:
+    }
+    if n := waitersOn(&h, 1); n != 0 {
+        t.Errorf("%d waiters left after the change; want 0", n)
+    }
+
+    // Shutting down (more than once) closes the stopped channel.
+    h.shutdown()
+    h.shutdown()
+    select {
+    case <-h.stopped():
+    default:
+        t.Error("stopped() isn't closed after shutdown()")
+    }
+}
+
+func TestAwaitMovieChange(t *testing.T) {
+    fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+
+    app := newTestApplication(t, nil)
+    app.clock = fake
+
+    await := func(ctx context.Context, changed <-chan struct{}) <-chan bool {
+        result := make(chan bool, 1)
+        go func() {
+            result <- app.awaitMovieChange(ctx, changed, 10*time.Second)
+        }()
+        waitFor(t, "the waiter's ticker", func() bool { return fake.Tickers() == 1 })
+        return result
+    }
+
+    receive := func(what string, result <-chan bool, want bool) {
+        t.Helper()
+
+        select {
+        case got := <-result:
+            if got != want {
+                t.Errorf("%s: awaitMovieChange() = %v; want %v", what, got, want)
+            }
+        case <-time.After(time.Second):
+            t.Fatalf("%s: awaitMovieChange() didn't return", what)
+        }
+        waitFor(t, "the ticker to stop", func() bool { return fake.Tickers() == 0 })
+    }
+
+    changed, release, _ := app.movieChanges.wait(1, 10)
+    defer release()
+    result := await(context.Background(), changed)
+    app.movieChanges.notify(1)
+    receive("change", result, true)
+
+    changed, release, _ = app.movieChanges.wait(1, 10)
+    defer release()
+    result = await(context.Background(), changed)
+    fake.Advance(9 * time.Second)
+    select {
+    case <-result:
+        t.Fatal("awaitMovieChange() returned before the timeout")
+    case <-time.After(10 * time.Millisecond):
+    }
+    fake.Advance(time.Second)
+    receive("timeout", result, false)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    result = await(ctx, changed)
+    cancel()
+    receive("cancelled", result, false)
+
+    result = await(context.Background(), changed)
+    app.movieChanges.shutdown()
+    receive("shutdown", result, false)
+}
+
+// startGet sends a GET request for the path in the background, and returns a
+// channel for its response. It doesn't use the testing.T, as it may only be used
+// on the test's own goroutine.
+func startGet(ts *testServer, path string) <-chan testResponse {
+    res := make(chan testResponse, 1)
+    go func() {
+        resp, err := ts.Client().Get(ts.URL + path)
+        if err != nil {
+            res <- testResponse{body: []byte(err.Error())}
+            return
+        }
+        defer resp.Body.Close()
+
+        body, _ := io.ReadAll(resp.Body)
+        res <- testResponse{status: resp.StatusCode, header: resp.Header, body: body}
+    }()
+    return res
+}
+
+func receiveResponse(t *testing.T, res <-chan testResponse) testResponse {
+    t.Helper()
+
+    select {
+    case r := <-res:
+        return r
+    case <-time.After(5 * time.Second):
+        t.Fatal("the long poll didn't return")
+        return testResponse{}
+    }
+}
+
+// A long poll returns as soon as the movie is changed by an update, a genre rename
+// or a bulk delete, and otherwise when its timeout passes or the server shuts
+// down. The number of waiters per movie is capped.
+func TestShowMovieLongPoll(t *testing.T) {
+    fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+
+    app := newTestApplication(t, newTestDB(t))
+    app.clock = fake
+    app.config.admin.token = "secret"
+    app.config.movies.maxWaiters = 1
+    ts := newTestServer(t, app.routes())
+
+    admin := http.Header{"Authorization": {"Bearer secret"}, "Content-Type": {"application/json"}}
+
+    id := createTestMovie(t, ts, "Moana")
+    path := func(query string) string {
+        return fmt.Sprintf("/v1/movies/%d?%s", id, query)
+    }
+    waiting := func() {
+        t.Helper()
+        waitFor(t, "the request to wait", func() bool { return waitersOn(&app.movieChanges, id) == 1 })
+    }
+    checkVersion := func(what string, res testResponse, want int32) {
+        t.Helper()
+
+        if res.status != http.StatusOK {
+            t.Fatalf("%s: status = %d; want %d (body %q)", what, res.status, http.StatusOK, res.body)
+        }
+        var body movieBody
+        res.decode(t, &body)
+        if body.Movie.Version != want {
+            t.Errorf("%s: version = %d; want %d", what, body.Movie.Version, want)
+        }
+    }
+
+    checkUnchanged := func(what string, res testResponse, want int32) {
+        t.Helper()
+
+        var body struct {
+            Changed *bool `json:"changed"`
+            Version int32 `json:"version"`
+        }
+        res.decode(t, &body)
+        if res.status != http.StatusOK || body.Changed == nil || *body.Changed || body.Version != want {
+            t.Errorf("%s: status %d, body %s; want changed false at version %d", what, res.status, res.body, want)
+        }
+    }
+
+    // An update wakes the waiting request, which gets the new version.
+    res := startGet(ts, path("wait_version_gt=1"))
+    waiting()
+    if r := ts.sendJSON(t, http.MethodPatch, fmt.Sprintf("/v1/movies/%d", id), map[string]string{"title": "Vaiana"}); r.status != http.StatusOK {
+        t.Fatalf("update: status = %d (body %q)", r.status, r.body)
+    }
+    checkVersion("update", receiveResponse(t, res), 2)
+
+    // Only one request may wait on the movie at a time.
+    res = startGet(ts, path("wait_version_gt=2"))
+    waiting()
+    if r := ts.get(t, path("wait_version_gt=2")); r.status != http.StatusTooManyRequests {
+        t.Errorf("second waiter: status = %d; want %d", r.status, http.StatusTooManyRequests)
+    }
+
+    // So does renaming one of its genres.
+    rename := ts.do(t, http.MethodPost, "/v1/genres/rename", strings.NewReader(`{"from": "animation", "to": "cartoon"}`), admin)
+    if rename.status != http.StatusOK {
+        t.Fatalf("rename: status = %d (body %q)", rename.status, rename.body)
+    }
+    checkVersion("rename", receiveResponse(t, res), 3)
+
+    // Without a change, the request is told so once its timeout passes.
+    res = startGet(ts, path("wait_version_gt=3&timeout=5"))
+    waiting()
+    var timedOut testResponse
+    for received := false; !received; {
+        select {
+        case timedOut = <-res:
+            received = true
+        case <-time.After(time.Millisecond):
+            fake.Advance(5 * time.Second)
+        }
+    }
+    checkUnchanged("timeout", timedOut, 3)
+
+    // A bulk delete of the movie gets a 404 to the waiting request.
+    res = startGet(ts, path("wait_version_gt=3"))
+    waiting()
+    preview := ts.do(t, http.MethodPost, "/v1/admin/movies/bulk-delete?title=Vaiana&dry_run=true", nil, admin)
+    var confirm struct {
+        Confirm string `json:"confirm"`
+    }
+    preview.decode(t, &confirm)
+    if r := ts.do(t, http.MethodPost, "/v1/admin/movies/bulk-delete?title=Vaiana&confirm="+confirm.Confirm, nil, admin); r.status != http.StatusOK {
+        t.Fatalf("bulk delete: status = %d (body %q)", r.status, r.body)
+    }
+    if r := receiveResponse(t, res); r.status != http.StatusNotFound {
+        t.Errorf("bulk delete: status = %d; want %d", r.status, http.StatusNotFound)
+    }
+
+    // The shutdown releases the waiting requests.
+    id = createTestMovie(t, ts, "Coco")
+    res = startGet(ts, path("wait_version_gt=1"))
+    waiting()
+    app.movieChanges.shutdown()
+    checkUnchanged("shutdown", receiveResponse(t, res), 1)
+}
+
+func TestReadLongPoll(t *testing.T) {
+    tests := []struct {
+        query string
+        wantVersion int32
+        wantTimeout time.Duration
+        wantWaiting bool
+        wantErrors []string
+    }{
+        {"", 0, 0, false, nil},
+        {"wait_version_gt=3", 3, maxLongPollTimeout, true, nil},
+        {"wait_version_gt=3&timeout=5", 3, 5 * time.Second, true, nil},
+        {"wait_version_gt=2147483647", math.MaxInt32, maxLongPollTimeout, true, nil},
+        {"wait_version_gt=4294967297", 0, 0, true, []string{"wait_version_gt"}},
+        {"wait_version_gt=0", 0, 0, true, []string{"wait_version_gt"}},
+        {"wait_version_gt=x", 0, 0, true, []string{"wait_version_gt"}},
+        {"wait_version_gt=3&timeout=26", 0, 0, true, []string{"timeout"}},
+        {"wait_version_gt=3&timeout=0", 0, 0, true, []string{"timeout"}},
+    }
+
+    app := newTestApplication(t, nil)
+
+    for _, tt := range tests {
+        v := validator.New()
+        r := httptest.NewRequest(http.MethodGet, "/v1/movies/1?"+tt.query, nil)
+
+        version, timeout, waiting := app.readLongPoll(r, v)
+
+        if len(tt.wantErrors) > 0 {
+            for _, key := range tt.wantErrors {
+                if _, ok := v.Errors[key]; !ok {
+                    t.Errorf("%q: errors = %v; want one for %q", tt.query, v.Errors, key)
+                }
+            }
+            continue
+        }
+
+        if !v.Valid() || version != tt.wantVersion || timeout != tt.wantTimeout || waiting != tt.wantWaiting {
+            t.Errorf("%q: readLongPoll() = %d, %v, %v (errors %v); want %d, %v, %v", tt.query, version, timeout, waiting, v.Errors, tt.wantVersion, tt.wantTimeout, tt.wantWaiting)
+        }
+    }
+}
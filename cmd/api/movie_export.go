@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// exportFlushEvery is the number of movies written between flushes of the export
+// response, so that the client receives it in steady chunks.
+const exportFlushEvery = 100
+
+// handleExportMovies streams every movie as newline-delimited JSON (NDJSON), one
+// movie per line in the same representation as the other movie endpoints, for
+// backups and migrations. Each movie is encoded straight to the response as it is
+// read from the database, so the catalog is never held in memory as a whole.
+//
+// Once the first movie has been written the status code can no longer be changed,
+// so an error after that point is logged and the stream is cut short. Clients
+// should treat a response which doesn't end with a newline as incomplete.
+func (app *application) handleExportMovies(w http.ResponseWriter, r *http.Request) {
+    enc := json.NewEncoder(w)
+    flusher, _ := w.(http.Flusher)
+
+    written := 0
+
+    err := app.models.Movies.Export(r.Context(), func(movie *data.Movie) error {
+        if written == 0 {
+            w.Header().Set("Content-Type", "application/x-ndjson")
+            w.WriteHeader(http.StatusOK)
+        }
+
+        err := enc.Encode(app.toMovieResponse(movie, data.RuntimeFormatMins))
+        if err != nil {
+            return err
+        }
+
+        written++
+        if flusher != nil && written%exportFlushEvery == 0 {
+            flusher.Flush()
+        }
+
+        return nil
+    })
+    if err != nil {
+        if written == 0 {
+            app.serverErrorResponse(w, r, err)
+            return
+        }
+        app.logError(r, err)
+        return
+    }
+
+    // An empty catalog is still a successful (empty) export.
+    if written == 0 {
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        w.WriteHeader(http.StatusOK)
+    }
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+const (
+    // importBatchSize is the number of movies inserted per transaction by the import
+    // endpoint. A progress line is written to the response after each batch.
+    importBatchSize = 500
+    // maxImportLineBytes is the longest line that the import endpoint accepts.
+    maxImportLineBytes = 1_048_576
+    // maxImportBytes is the largest body (after decompression) that the import
+    // endpoint accepts. The movies are all read and validated before any are
+    // inserted, so they are held in memory until then.
+    maxImportBytes = 64 << 20
+)
+
+// importMovieInput is the shape of each line of an import, which is the same as
+// the body of a create movie request. The fields which the export endpoint adds
+// (the ID, version and creation time) are accepted and ignored, so that an export
+// can be imported again as it is; the imported movies get new ones.
+type importMovieInput struct {
+    ID json.RawMessage `json:"id"`
+    Version json.RawMessage `json:"version"`
+    CreatedAt json.RawMessage `json:"created_at"`
+    Title string `json:"title"`
+    Year *int32 `json:"year"`
+    Runtime *data.Runtime `json:"runtime"`
+    Genres []string `json:"genres"`
+    Certifications data.Certifications `json:"certifications"`
+    TrailerURL *string `json:"trailer_url"`
+    HomepageURL *string `json:"homepage_url"`
+}
+
+// importProgress holds the running totals of an import.
+type importProgress struct {
+    Lines int `json:"lines"`
+    Imported int `json:"imported"`
+    Failed int `json:"failed"`
+}
+
+// importLineError describes a line of an import which couldn't be imported. Error
+// is either a message, or a map of validation messages keyed by field.
+type importLineError struct {
+    Line int `json:"line"`
+    Error interface{} `json:"error"`
+}
+
+// handleImportMovies reads movies as newline-delimited JSON (NDJSON), one movie per
+// line in the same shape as the create movie request, and inserts them in batches
+// of importBatchSize. This is the counterpart of the export endpoint, for restoring
+// a backup or loading a catalog. The body may be gzipped (with a
+// "Content-Encoding: gzip" header), which makes large imports far smaller.
+//
+// The whole body is read and validated before anything is written to the response
+// or the database, as net/http doesn't support writing a response while the request
+// body is still being read (without http.ResponseController.EnableFullDuplex, which
+// not every client copes with). A body which can't be read to the end, such as a
+// truncated gzip stream or one with an overlong line, gets a 400 response and
+// nothing is imported.
+//
+// Lines which can't be decoded or fail validation are reported and skipped, rather
+// than aborting the import. The response is itself NDJSON: a {"failed": ...} line
+// for each bad line, then a {"progress": ...} line after each batch is inserted, and
+// finally a {"result": ...} line with the totals. If the import has to stop part
+// way through (e.g. because the database can't be reached), the last line is an
+// {"error": ...} instead. As with other writes, a dry run checks everything without
+// saving.
+func (app *application) handleImportMovies(w http.ResponseWriter, r *http.Request) {
+    v := validator.New()
+    dryRun := app.readDryRun(r, v)
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    var body io.Reader = r.Body
+
+    switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+    case "", "identity":
+    case "gzip":
+        gz, err := gzip.NewReader(r.Body)
+        if err != nil {
+            app.badRequestResponse(w, r, fmt.Errorf("body is not valid gzip: %v", err))
+            return
+        }
+        defer gz.Close()
+        body = gz
+    default:
+        app.errorResponse(w, r, http.StatusUnsupportedMediaType, "the Content-Encoding header must be gzip or identity")
+        return
+    }
+
+    var progress importProgress
+    var movies []*data.Movie
+    var movieLines []int
+    var failures []importLineError
+
+    limited := &io.LimitedReader{R: body, N: maxImportBytes + 1}
+    scanner := bufio.NewScanner(limited)
+    scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+
+    for scanner.Scan() {
+        progress.Lines++
+
+        line := bytes.TrimSpace(scanner.Bytes())
+        if len(line) == 0 {
+            continue
+        }
+
+        movie, lineErr := app.readImportLine(r, line)
+        if lineErr != nil {
+            failures = append(failures, importLineError{Line: progress.Lines, Error: lineErr})
+            continue
+        }
+
+        movies = append(movies, movie)
+        movieLines = append(movieLines, progress.Lines)
+    }
+
+    // A body which can't be read to the end is the client's to fix, so the error is
+    // reported as it is.
+    err := scanner.Err()
+    switch {
+    case limited.N == 0:
+        app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxImportBytes))
+        return
+    case errors.Is(err, bufio.ErrTooLong):
+        app.badRequestResponse(w, r, fmt.Errorf("line %d is longer than %d bytes", progress.Lines+1, maxImportLineBytes))
+        return
+    case err != nil:
+        app.badRequestResponse(w, r, fmt.Errorf("reading body: %v", err))
+        return
+    }
+
+    if dryRun {
+        w.Header().Set("Preference-Applied", "dry-run")
+    }
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    w.WriteHeader(http.StatusOK)
+
+    enc := json.NewEncoder(w)
+    flusher, _ := w.(http.Flusher)
+
+    for _, failure := range failures {
+        progress.Failed++
+        enc.Encode(app.envelopeImportFailure(failure))
+    }
+
+    // The movies are inserted in batches, with the progress reported after each.
+    for start := 0; start < len(movies); start += importBatchSize {
+        end := start + importBatchSize
+        if end > len(movies) {
+            end = len(movies)
+        }
+
+        errs, err := app.movieModel(dryRun).InsertBatch(r.Context(), movies[start:end])
+        if err != nil {
+            app.stopImport(r, enc, err)
+            return
+        }
+
+        for i, err := range errs {
+            if err != nil {
+                app.logError(r, err)
+                progress.Failed++
+                enc.Encode(app.envelopeImportFailure(importLineError{Line: movieLines[start+i], Error: "the movie could not be inserted"}))
+                continue
+            }
+            progress.Imported++
+        }
+
+        enc.Encode(app.envelopeImportProgress(progress))
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+
+    enc.Encode(app.envelopeImportResult(progress))
+}
+
+// readImportLine decodes and validates a single line of an import. Problems are
+// returned as a value to report in the response: a message, or a map of
+// validation messages.
+func (app *application) readImportLine(r *http.Request, line []byte) (*data.Movie, interface{}) {
+    var input importMovieInput
+
+    dec := json.NewDecoder(bytes.NewReader(line))
+    dec.DisallowUnknownFields()
+
+    err := dec.Decode(&input)
+    if err != nil {
+        return nil, err.Error()
+    }
+    if dec.More() {
+        return nil, "line must only contain a single JSON value"
+    }
+
+    movie := &data.Movie{
+        Title: input.Title,
+        Year: input.Year,
+        Runtime: input.Runtime,
+        Genres: input.Genres,
+        Certifications: input.Certifications,
+        TrailerURL: nonEmpty(input.TrailerURL),
+        HomepageURL: nonEmpty(input.HomepageURL),
+    }
+
+    v := validator.New()
+    if data.ValidateMovie(v, movie, app.movieRules()); !v.Valid() {
+        return nil, app.validationMessages(r, v)
+    }
+
+    return movie, nil
+}
+
+// stopImport ends an import after a batch couldn't be inserted. The status code has
+// already been sent, so rather than a 500 response the error is logged and reported
+// as the last line of the response.
+func (app *application) stopImport(r *http.Request, enc *json.Encoder, err error) {
+    app.logError(r, err)
+
+    enc.Encode(app.envelopeError("the server encountered a problem and could not finish the import"))
+}
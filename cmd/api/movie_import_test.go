@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadImportLine(t *testing.T) {
+    app := newTestApplication(t, nil)
+    r := httptest.NewRequest(http.MethodPost, "/v1/admin/movies/import", nil)
+
+    tests := []struct {
+        name string
+        line string
+        wantErr bool
+    }{
+        {"create body", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, false},
+        {"exported movie", `{"id": 12, "created_at": "2023-01-02T15:04:05Z", "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"], "version": 3}`, false},
+        {"exported movie with a UUID", `{"id": "0e5cf3b1-8f5e-4b59-9a8e-1c6d2f1f4c1a", "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"], "version": 1}`, false},
+        {"unknown field", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"], "rating": "PG"}`, true},
+        {"invalid movie", `{"title": "", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, true},
+        {"two values", `{"title": "Moana"} {"title": "Moana"}`, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            movie, lineErr := app.readImportLine(r, []byte(tt.line))
+            if tt.wantErr {
+                if lineErr == nil {
+                    t.Fatalf("readImportLine() = %+v; want an error", movie)
+                }
+                return
+            }
+            if lineErr != nil {
+                t.Fatalf("readImportLine() error = %v", lineErr)
+            }
+            if movie.Title != "Moana" || movie.ID != 0 || movie.Version != 0 {
+                t.Errorf("movie = %+v; want a new movie titled Moana", movie)
+            }
+        })
+    }
+}
+
+// TestImportMoviesUnreadableBody checks that a body which can't be read to the end
+// is rejected with a 400 response before anything is imported. The application has
+// no database, so an attempt to insert a movie would fail the test.
+func TestImportMoviesUnreadableBody(t *testing.T) {
+    valid := `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}` + "\n"
+
+    var gzipped bytes.Buffer
+    gz := gzip.NewWriter(&gzipped)
+    gz.Write([]byte(strings.Repeat(valid, 10)))
+    gz.Close()
+    truncated := gzipped.Bytes()[:gzipped.Len()-10]
+
+    tests := []struct {
+        name string
+        body []byte
+        encoding string
+    }{
+        {"overlong line", []byte(valid + `{"title": "` + strings.Repeat("a", maxImportLineBytes) + `"}` + "\n"), ""},
+        {"truncated gzip", truncated, "gzip"},
+        {"not gzip", []byte(valid), "gzip"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            app := newTestApplication(t, nil)
+            app.config.admin.token = "secret"
+            ts := newTestServer(t, app.routes())
+
+            header := http.Header{
+                "Authorization": {"Bearer secret"},
+                "Content-Type": {"application/x-ndjson"},
+            }
+            if tt.encoding != "" {
+                header.Set("Content-Encoding", tt.encoding)
+            }
+
+            res := ts.do(t, http.MethodPost, "/v1/admin/movies/import", bytes.NewReader(tt.body), header)
+            if res.status != http.StatusBadRequest {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusBadRequest, res.body)
+            }
+            if got := res.header.Get("Content-Type"); got != "application/json" {
+                t.Errorf("Content-Type = %q; want application/json", got)
+            }
+        })
+    }
+}
+
+func TestImportMoviesExported(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    app.config.admin.token = "secret"
+    ts := newTestServer(t, app.routes())
+
+    createTestMovie(t, ts, "Moana")
+    createTestMovie(t, ts, "Vaiana")
+
+    export := ts.do(t, http.MethodGet, "/v1/admin/movies/export", nil, http.Header{"Authorization": {"Bearer secret"}})
+    if export.status != http.StatusOK {
+        t.Fatalf("export status = %d (body %q)", export.status, export.body)
+    }
+
+    res := ts.do(t, http.MethodPost, "/v1/admin/movies/import", bytes.NewReader(export.body), http.Header{
+        "Authorization": {"Bearer secret"},
+        "Content-Type": {"application/x-ndjson"},
+    })
+    if res.status != http.StatusOK {
+        t.Fatalf("import status = %d (body %q)", res.status, res.body)
+    }
+
+    lines := strings.Split(strings.TrimSpace(string(res.body)), "\n")
+    want := `{"result":{"lines":2,"imported":2,"failed":0}}`
+    if last := lines[len(lines)-1]; last != want {
+        t.Errorf("last line = %s; want %s", last, want)
+    }
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// listStreamBatchSize is the number of movies which streamMovies() localizes and
+// writes at a time. Localizing a batch takes a single query, and the response is
+// flushed after each batch so that the client receives it in steady chunks.
+const listStreamBatchSize = 100
+
+// acceptsNDJSON reports whether the Accept header of the request lists the
+// newline-delimited JSON media type (with a non-zero quality).
+func acceptsNDJSON(r *http.Request) bool {
+    for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+        mediaType, params, err := mime.ParseMediaType(part)
+        if err != nil || !strings.EqualFold(mediaType, "application/x-ndjson") {
+            continue
+        }
+
+        if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+            continue
+        }
+
+        return true
+    }
+
+    return false
+}
+
+// streamMovies writes a page of movies as newline-delimited JSON (NDJSON), for
+// clients which send "Accept: application/x-ndjson" to the list endpoint. Each movie
+// is written on its own line, in the same representation as in the "movies" array,
+// as it is read from the database, so the page is never built up as one big array.
+// The pagination metadata is only known once every movie has been read, so it comes
+// last, as a line of its own of the form {"metadata": {...}}. The response size
+// budget doesn't apply, as the point of streaming is that the size doesn't matter.
+//
+// As with the export endpoint, once the first line has been written the status
+// code can no longer be changed, so an error after that point is logged and the
+// stream is cut short. A response which doesn't end with the metadata line is
+// incomplete.
+func (app *application) streamMovies(w http.ResponseWriter, r *http.Request, search data.MovieSearch, filters data.Filters, runtimeFormat string) {
+    enc := json.NewEncoder(w)
+    flusher, _ := w.(http.Flusher)
+
+    written := false
+    batch := make([]*data.Movie, 0, listStreamBatchSize)
+
+    // writeBatch localizes and writes the movies read since the last batch.
+    writeBatch := func() error {
+        err := app.localizeMovies(r, batch...)
+        if err != nil {
+            return err
+        }
+
+        if !written {
+            w.Header().Set("Content-Type", "application/x-ndjson")
+            w.WriteHeader(http.StatusOK)
+            written = true
+        }
+
+        for _, movie := range batch {
+            err := enc.Encode(app.toMovieResponse(movie, runtimeFormat))
+            if err != nil {
+                return err
+            }
+        }
+
+        if flusher != nil {
+            flusher.Flush()
+        }
+
+        batch = batch[:0]
+        return nil
+    }
+
+    metadata, err := app.models.Movies.StreamAll(r.Context(), search, filters, func(movie *data.Movie) error {
+        batch = append(batch, movie)
+        if len(batch) < listStreamBatchSize {
+            return nil
+        }
+        return writeBatch()
+    })
+    if err == nil {
+        err = writeBatch()
+    }
+    if err == nil {
+        err = enc.Encode(app.envelopeListMetadata(metadata))
+    }
+    if err != nil {
+        if written {
+            app.logError(r, err)
+            return
+        }
+
+        switch {
+        case errors.Is(err, data.ErrInvalidSort):
+            v := validator.New()
+            v.AddError("sort", "invalid_sort")
+            app.failedValidationResponse(w, r, v)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+    }
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/i18n"
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// The revalidation worker checks a batch of movies at a time, and between sweeps
+// looks for running ones every minute, which picks up a sweep that was interrupted
+// by a restart (or left behind by another instance).
+const (
+    revalidationBatchSize = 100
+    revalidationPollInterval = time.Minute
+)
+
+// handleStartRevalidation starts a sweep which checks every movie against the
+// current validation rules, and with ?fix=true fixes the problems that are safe to
+// fix (see data.FixMovie). The sweep runs in the background: the response is a 202
+// Accepted with the new run, whose progress and report can be followed at the URL
+// in its Location header. Only one sweep runs at a time.
+func (app *application) handleStartRevalidation(w http.ResponseWriter, r *http.Request) {
+    v := validator.New()
+
+    fix := app.readBool(r.URL.Query(), "fix", false, v)
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    run, err := app.models.Revalidations.Start(r.Context(), fix)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRevalidationRunning):
+            app.revalidationRunningResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    // Wake the worker, unless it has already been woken and not got round to it.
+    select {
+    case app.revalidationStarted <- struct{}{}:
+    default:
+    }
+
+    headers := make(http.Header)
+    headers.Set("Location", app.apiPath(fmt.Sprintf("/v1/admin/movies/revalidate/%d", run.ID)))
+
+    err = app.writeJSON(w, http.StatusAccepted, app.envelopeRevalidation(run, nil), headers)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleShowRevalidation returns the progress of a sweep, along with the movies it
+// has found to be invalid so far.
+func (app *application) handleShowRevalidation(w http.ResponseWriter, r *http.Request) {
+    id, err := app.readIDParam(r)
+    if err != nil {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    run, err := app.models.Revalidations.Get(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    results, err := app.models.Revalidations.GetResults(r.Context(), id)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeRevalidation(run, results), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// revalidateMovies runs the revalidation worker until the context is cancelled. It
+// works through the running sweeps a batch at a time, and then waits until a sweep
+// is started or the poll interval passes.
+func (app *application) revalidateMovies(ctx context.Context) {
+    ticker := app.clock.NewTicker(revalidationPollInterval)
+    defer ticker.Stop()
+
+    for {
+        for ctx.Err() == nil {
+            found, err := app.models.Revalidations.ProcessNext(ctx, func(run *data.Revalidation) (*data.RevalidationBatch, error) {
+                return app.revalidateBatch(ctx, run)
+            })
+            if err != nil {
+                if ctx.Err() == nil {
+                    app.logger.PrintError(err, map[string]string{"task": "revalidate"})
+                }
+                break
+            }
+            if !found {
+                break
+            }
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-app.revalidationStarted:
+        case <-ticker.C():
+        }
+    }
+}
+
+// revalidateBatch checks the next batch of movies in a sweep. If the sweep is fixing
+// movies, a fix is only saved if it makes the movie valid; a partial fix would
+// leave the movie failing validation anyway. Fixes are saved with the usual
+// optimistic-locked Update(), so they create a new version of the movie, and a movie
+// which was edited in the meantime is reported as invalid rather than overwritten.
+func (app *application) revalidateBatch(ctx context.Context, run *data.Revalidation) (*data.RevalidationBatch, error) {
+    movies, err := app.models.Movies.GetAfter(ctx, run.LastMovieID, revalidationBatchSize)
+    if err != nil {
+        return nil, err
+    }
+
+    batch := &data.RevalidationBatch{
+        Checked: int64(len(movies)),
+        LastMovieID: run.LastMovieID,
+        Results: []data.RevalidationResult{},
+        Done: len(movies) < revalidationBatchSize,
+    }
+
+    rules := app.movieRules()
+
+    for _, movie := range movies {
+        batch.LastMovieID = movie.ID
+
+        v := validator.New()
+        if data.ValidateMovie(v, movie, rules); v.Valid() {
+            continue
+        }
+
+        result := data.RevalidationResult{MovieID: movie.ID, Errors: revalidationMessages(v)}
+
+        if run.Fix && data.FixMovie(movie) {
+            fixed := validator.New()
+            if data.ValidateMovie(fixed, movie, rules); fixed.Valid() {
+                err := app.models.Movies.Update(ctx, movie)
+                switch {
+                case err == nil:
+                    result.Fixed = true
+                    app.movieChanges.notify(movie.ID)
+                case errors.Is(err, data.ErrEditConflict):
+                    // Leave the movie to whoever edited it.
+                default:
+                    return nil, err
+                }
+            }
+        }
+
+        batch.Results = append(batch.Results, result)
+    }
+
+    app.logger.PrintInfo("revalidated movies", map[string]string{
+        "run_id": strconv.FormatInt(run.ID, 10),
+        "checked": strconv.FormatInt(run.Checked+batch.Checked, 10),
+        "total": strconv.FormatInt(run.Total, 10),
+    })
+
+    return batch, nil
+}
+
+// revalidationMessages translates the validation errors of a movie into the default
+// language, as the report isn't read in the context of any one request.
+func revalidationMessages(v *validator.Validator) map[string]string {
+    messages := make(map[string]string, len(v.Errors))
+    for field, messageKey := range v.Errors {
+        messages[field] = i18n.Translate(i18n.DefaultLanguage, messageKey, v.Params[field])
+    }
+
+    return messages
+}
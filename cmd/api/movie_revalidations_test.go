@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// A fixing sweep runs to completion on a pool with a single connection: the batch
+// is checked and fixed without the sweep's row held locked on another connection.
+func TestRevalidationSingleConnection(t *testing.T) {
+    db := newTestDB(t)
+    db.SetMaxOpenConns(1)
+
+    app := newTestApplication(t, db)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    year := int32(2016)
+    runtime := data.Runtime(107)
+    movie := &data.Movie{Title: "Moana", Year: &year, Runtime: &runtime, Genres: []string{"animation", " Animation"}}
+    err := app.models.Movies.Insert(ctx, movie)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    run, err := app.models.Revalidations.Start(ctx, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    found, err := app.models.Revalidations.ProcessNext(ctx, func(run *data.Revalidation) (*data.RevalidationBatch, error) {
+        return app.revalidateBatch(ctx, run)
+    })
+    if err != nil || !found {
+        t.Fatalf("ProcessNext() = %v, %v; want true, nil", found, err)
+    }
+
+    run, err = app.models.Revalidations.Get(ctx, run.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if run.Status != data.RevalidationCompleted || run.Checked != 1 || run.Invalid != 1 || run.Fixed != 1 {
+        t.Errorf("run = %+v; want completed with 1 checked, invalid and fixed", run)
+    }
+
+    fixed, err := app.models.Movies.Get(ctx, movie.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := []string{"animation"}; !reflect.DeepEqual(fixed.Genres, want) {
+        t.Errorf("genres = %q; want %q", fixed.Genres, want)
+    }
+}
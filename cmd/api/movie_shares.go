@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// A share link gives read access to a single movie to someone without an account,
+// such as an external partner, until it expires or is revoked. It has the form
+//
+//	/v1/shared/movies/:id?expires=<unix time>&nonce=<nonce>&sig=<signature>
+//
+// where the signature is an HMAC-SHA256 of the movie ID, expiry time and nonce,
+// keyed with the -share-key setting. The nonce identifies the share in the
+// movie_shares table, which is how a link is revoked before it expires.
+
+// The error codes sent with the 401 response for a share link which can't be used.
+const (
+    shareLinkInvalid = "share_link_invalid"
+    shareLinkExpired = "share_link_expired"
+    shareLinkRevoked = "share_link_revoked"
+)
+
+// signShareLink returns the signature of a share link for the movie with the given
+// ID (as it appears in URLs), expiry time and nonce.
+func (app *application) signShareLink(movieID string, expires int64, nonce string) string {
+    mac := hmac.New(sha256.New, []byte(app.config.share.key))
+    fmt.Fprintf(mac, "%s\n%d\n%s", movieID, expires, nonce)
+
+    return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// handleCreateMovieShare creates a share link for a movie, which expires after the
+// number of seconds given in the expires_in field (at most -share-max-ttl).
+func (app *application) handleCreateMovieShare(w http.ResponseWriter, r *http.Request) {
+    if app.config.share.key == "" {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    var input struct {
+        ExpiresIn *int64 `json:"expires_in"`
+    }
+
+    err := app.readJSON(w, r, &input)
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return
+    }
+
+    v := validator.New()
+
+    maxTTL := int64(app.config.share.maxTTL / time.Second)
+
+    if v.Check(input.ExpiresIn != nil, "expires_in", "required"); v.Valid() {
+        v.Check(*input.ExpiresIn > 0, "expires_in", "greater_than_zero")
+        v.CheckParams(*input.ExpiresIn <= maxTTL, "expires_in", "too_large", map[string]string{"max": strconv.FormatInt(maxTTL, 10)})
+    }
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    movie, err := app.models.Movies.GetSummary(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    nonce := make([]byte, 16)
+    _, err = rand.Read(nonce)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    share := &data.MovieShare{
+        MovieID: movie.ID,
+        Nonce: hex.EncodeToString(nonce),
+        ExpiresAt: app.clock.Now().Add(time.Duration(*input.ExpiresIn) * time.Second).Truncate(time.Second),
+    }
+
+    err = app.models.MovieShares.Insert(r.Context(), share)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    movieID := fmt.Sprint(app.movieID(movie))
+    expires := share.ExpiresAt.Unix()
+
+    qs := url.Values{}
+    qs.Set("expires", strconv.FormatInt(expires, 10))
+    qs.Set("nonce", share.Nonce)
+    qs.Set("sig", app.signShareLink(movieID, expires, share.Nonce))
+
+    link := app.apiPath("/v1/shared/movies/"+movieID) + "?" + qs.Encode()
+
+    app.logger.PrintInfo("movie share created", map[string]string{
+        "share_id": strconv.FormatInt(share.ID, 10),
+        "movie_id": movieID,
+        "expires_at": share.ExpiresAt.Format(time.RFC3339),
+    })
+
+    err = app.writeJSON(w, http.StatusCreated, app.envelopeNewMovieShare(share, link), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleShowSharedMovie serves a movie through a share link, without any other
+// authentication. It checks the signature before anything else, so that a link
+// which has been tampered with is reported as invalid rather than expired, and
+// only then the expiry time and whether the share has been revoked. The response
+// is the public movie document, and isn't cached, so that revoking a share takes
+// effect straight away.
+func (app *application) handleShowSharedMovie(w http.ResponseWriter, r *http.Request) {
+    if app.config.share.key == "" {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    qs := r.URL.Query()
+
+    movieID := httprouter.ParamsFromContext(r.Context()).ByName("id")
+    nonce := qs.Get("nonce")
+
+    expires, err := strconv.ParseInt(qs.Get("expires"), 10, 64)
+    if err != nil || nonce == "" {
+        app.invalidShareLinkResponse(w, r, shareLinkInvalid)
+        return
+    }
+
+    // hmac.Equal compares the signatures in constant time, so that the time taken
+    // doesn't give away how much of a forged signature is right.
+    sig := app.signShareLink(movieID, expires, nonce)
+    if !hmac.Equal([]byte(sig), []byte(qs.Get("sig"))) {
+        app.invalidShareLinkResponse(w, r, shareLinkInvalid)
+        return
+    }
+
+    if app.clock.Now().Unix() >= expires {
+        app.invalidShareLinkResponse(w, r, shareLinkExpired)
+        return
+    }
+
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    revoked, err := app.models.MovieShares.IsRevoked(r.Context(), id, nonce)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+    if revoked {
+        app.invalidShareLinkResponse(w, r, shareLinkRevoked)
+        return
+    }
+
+    v := validator.New()
+
+    runtimeFormat := app.readRuntimeFormat(r, v)
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    movie, err := app.models.Movies.Get(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    err = app.localizeMovies(r, movie)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    headers := make(http.Header)
+    headers.Set("Cache-Control", "no-store")
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovie(movie, runtimeFormat), headers)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleListMovieShares returns the shares which haven't expired yet, including
+// revoked ones, newest first.
+func (app *application) handleListMovieShares(w http.ResponseWriter, r *http.Request) {
+    shares, err := app.models.MovieShares.GetAll(r.Context())
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovieShares(shares), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// handleRevokeMovieShare revokes a share, so that its link stops working even
+// though it hasn't expired.
+func (app *application) handleRevokeMovieShare(w http.ResponseWriter, r *http.Request) {
+    id, err := app.readIDParam(r)
+    if err != nil {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    share, err := app.models.MovieShares.Revoke(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    app.logger.PrintInfo("movie share revoked", map[string]string{
+        "share_id": strconv.FormatInt(share.ID, 10),
+    })
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovieShare(share), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/clock"
+)
+
+// TestShareLinkExpiry checks the expiry of a share link at the boundary, by moving
+// a fake clock: the link works until the second before it expires, and not from
+// then on.
+func TestShareLinkExpiry(t *testing.T) {
+    fake := clock.NewFake(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+
+    app := newTestApplication(t, newTestDB(t))
+    app.clock = fake
+    app.config.admin.token = "secret"
+    app.config.share.key = "share-secret"
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+
+    res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/movies/%d/share", id), strings.NewReader(`{"expires_in": 60}`), http.Header{
+        "Authorization": {"Bearer secret"},
+        "Content-Type": {"application/json"},
+    })
+    if res.status != http.StatusCreated {
+        t.Fatalf("creating the share: status = %d (body %q)", res.status, res.body)
+    }
+
+    var body struct {
+        URL string `json:"url"`
+    }
+    res.decode(t, &body)
+
+    link, err := url.Parse(body.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        at time.Duration
+        wantStatus int
+    }{
+        {"just created", 0, http.StatusOK},
+        {"a second before expiry", 59 * time.Second, http.StatusOK},
+        {"at expiry", 60 * time.Second, http.StatusUnauthorized},
+        {"a second after expiry", 61 * time.Second, http.StatusUnauthorized},
+    }
+
+    start := fake.Now()
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake.Set(start.Add(tt.at))
+
+            res := ts.get(t, link.RequestURI())
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, tt.wantStatus, res.body)
+            }
+        })
+    }
+}
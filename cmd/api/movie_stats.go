@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+)
+
+// handleShowMovieStats returns aggregate statistics about the movie catalog, for
+// dashboards which would otherwise have to page through every movie.
+func (app *application) handleShowMovieStats(w http.ResponseWriter, r *http.Request) {
+    stats, err := app.models.Movies.Stats(r.Context())
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovieStats(stats), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/i18n"
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) handleListMovieTranslations(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    // Send a 404 rather than an empty list if the movie doesn't exist.
+    exists, err := app.models.Movies.Exists(r.Context(), id)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+    if !exists {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    translations, err := app.models.MovieTranslations.GetAll(r.Context(), id)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeTranslations(translations), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+func (app *application) handleCreateMovieTranslation(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    movie, err := app.models.Movies.GetSummary(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    var input struct {
+        Language string `json:"lang"`
+        Title string `json:"title"`
+        Overview string `json:"overview"`
+    }
+
+    err = app.readJSON(w, r, &input)
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return
+    }
+
+    translation := &data.MovieTranslation{
+        MovieID: id,
+        Language: data.CanonicalLanguageTag(input.Language),
+        Title: input.Title,
+        Overview: input.Overview,
+    }
+
+    v := validator.New()
+
+    if data.ValidateMovieTranslation(v, translation); !v.Valid() {
+        app.failedValidationEchoResponse(w, r, v, input)
+        return
+    }
+
+    // A movie can only have one translation per language, so a duplicate is reported
+    // as a validation failure on the lang field.
+    err = app.models.MovieTranslations.Insert(r.Context(), translation)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrDuplicateTranslation):
+            v.AddError("lang", "translation_exists")
+            app.failedValidationResponse(w, r, v)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    headers := make(http.Header)
+    headers.Set("Location", app.apiPath(fmt.Sprintf("/v1/movies/%s/translations/%s", app.movieIDString(movie), translation.Language)))
+
+    err = app.writeJSON(w, http.StatusCreated, app.envelopeTranslation(translation), headers)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+func (app *application) handleGetMovieTranslation(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    translation, err := app.models.MovieTranslations.Get(r.Context(), id, app.readLangParam(r))
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeTranslation(translation), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+func (app *application) handleUpdateMovieTranslation(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    translation, err := app.models.MovieTranslations.Get(r.Context(), id, app.readLangParam(r))
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    // As with movies, the fields are pointers so that we can tell which of them
+    // the client actually wants to change.
+    var input struct {
+        Title *string `json:"title"`
+        Overview *string `json:"overview"`
+    }
+
+    err = app.readJSON(w, r, &input)
+    if err != nil {
+        app.badRequestResponse(w, r, err)
+        return
+    }
+
+    if input.Title != nil {
+        translation.Title = *input.Title
+    }
+
+    if input.Overview != nil {
+        translation.Overview = *input.Overview
+    }
+
+    v := validator.New()
+
+    if data.ValidateMovieTranslation(v, translation); !v.Valid() {
+        app.failedValidationEchoResponse(w, r, v, input)
+        return
+    }
+
+    err = app.models.MovieTranslations.Update(r.Context(), translation)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrEditConflict):
+            app.editConflictResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeTranslation(translation), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+func (app *application) handleDeleteMovieTranslation(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    err := app.models.MovieTranslations.Delete(r.Context(), id, app.readLangParam(r))
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMessage("translation successfully deleted"), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// The readLangParam() helper returns the language tag from the URL, in the
+// canonical case that translations are stored under.
+func (app *application) readLangParam(r *http.Request) string {
+    params := httprouter.ParamsFromContext(r.Context())
+
+    return data.CanonicalLanguageTag(params.ByName("lang"))
+}
+
+// The requestedLanguages() helper returns the languages the client would like
+// localized content in, most preferred first. A ?lang= query string parameter takes
+// precedence over the Accept-Language header. Each tag with a region or script is
+// followed by its base language, so that a request for "fr-CH" can fall back to a
+// "fr" translation.
+func (app *application) requestedLanguages(r *http.Request) []string {
+    tags := i18n.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+    if lang := r.URL.Query().Get("lang"); lang != "" {
+        tags = []string{lang}
+    }
+
+    var langs []string
+    seen := make(map[string]bool)
+
+    for _, tag := range tags {
+        canonical := data.CanonicalLanguageTag(tag)
+        base, _, _ := strings.Cut(canonical, "-")
+
+        for _, lang := range []string{canonical, base} {
+            if !seen[lang] {
+                seen[lang] = true
+                langs = append(langs, lang)
+            }
+        }
+    }
+
+    return langs
+}
+
+// The localizeMovies() helper sets the localized title of each movie according to
+// the languages requested by the client. If the client didn't ask for a language
+// the movies are left as they are, and no localized_title is included in the
+// response.
+func (app *application) localizeMovies(r *http.Request, movies ...*data.Movie) error {
+    return app.models.MovieTranslations.Localize(r.Context(), movies, app.requestedLanguages(r))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+func (app *application) handleListMovieVersions(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    // Make sure the movie exists, so that we send a 404 rather than an empty
+    // history for a movie which was never created (or has been deleted).
+    exists, err := app.models.Movies.Exists(r.Context(), id)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+    if !exists {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    versions, err := app.models.Movies.GetVersions(r.Context(), id)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovieVersions(versions), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+func (app *application) handleGetMovieVersion(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    params := httprouter.ParamsFromContext(r.Context())
+
+    version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+    if err != nil || version < 1 {
+        app.notFoundResponse(w, r)
+        return
+    }
+
+    v := validator.New()
+
+    runtimeFormat := app.readRuntimeFormat(r, v)
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    movie, ok := app.readMovieVersion(w, r, id, int32(version))
+    if !ok {
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovie(movie, runtimeFormat), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+func (app *application) handleDiffMovieVersions(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    v := validator.New()
+
+    qs := r.URL.Query()
+    from := app.readInt(qs, "from", 0, v)
+    to := app.readInt(qs, "to", 0, v)
+
+    v.Check(from > 0, "from", "greater_than_zero")
+    v.Check(to > 0, "to", "greater_than_zero")
+
+    // Versions are int32s, so a larger value would wrap around to another version.
+    maxVersion := map[string]string{"max": strconv.Itoa(math.MaxInt32)}
+    v.CheckParams(from <= math.MaxInt32, "from", "too_large", maxVersion)
+    v.CheckParams(to <= math.MaxInt32, "to", "too_large", maxVersion)
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    fromMovie, ok := app.readMovieVersion(w, r, id, int32(from))
+    if !ok {
+        return
+    }
+
+    toMovie, ok := app.readMovieVersion(w, r, id, int32(to))
+    if !ok {
+        return
+    }
+
+    changes := data.DiffMovies(fromMovie, toMovie)
+
+    err := app.writeJSON(w, http.StatusOK, app.envelopeMovieDiff(int32(from), int32(to), changes), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
+
+// The readMovieVersion() helper fetches a movie as it was at a given version. If
+// the movie doesn't exist, or the version is newer than the current version or
+// older than the history we have retained, it sends a 404 response with a message
+// explaining which of those applies and returns false.
+func (app *application) readMovieVersion(w http.ResponseWriter, r *http.Request, id int64, version int32) (*data.Movie, bool) {
+    current, err := app.models.Movies.Get(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return nil, false
+    }
+
+    if version > current.Version {
+        message := fmt.Sprintf("version %d does not exist, the current version of this movie is %d", version, current.Version)
+        app.errorResponse(w, r, http.StatusNotFound, message)
+        return nil, false
+    }
+
+    movie, err := app.models.Movies.GetVersion(r.Context(), id, version)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            message := fmt.Sprintf("version %d of this movie is older than the retained history", version)
+            app.errorResponse(w, r, http.StatusNotFound, message)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return nil, false
+    }
+
+    return movie, true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Version numbers which don't fit in an int32 are rejected before the movie is
+// read, rather than wrapping around to another version.
+func TestDiffMovieVersionsRange(t *testing.T) {
+    app := newTestApplication(t, nil)
+    ts := newTestServer(t, app.routes())
+
+    tests := []struct {
+        query string
+        field string
+    }{
+        {"from=4294967297&to=1", "from"},
+        {"from=1&to=2147483648", "to"},
+        {"from=0&to=1", "from"},
+        {"from=1&to=x", "to"},
+    }
+
+    for _, tt := range tests {
+        res := ts.get(t, "/v1/movies/1/diff?"+tt.query)
+        if res.status != http.StatusUnprocessableEntity {
+            t.Errorf("%s: status = %d; want %d (body %q)", tt.query, res.status, http.StatusUnprocessableEntity, res.body)
+            continue
+        }
+
+        var body struct {
+            Error map[string]string `json:"error"`
+        }
+        res.decode(t, &body)
+        if _, ok := body.Error[tt.field]; !ok {
+            t.Errorf("%s: errors = %v; want one for %q", tt.query, body.Error, tt.field)
+        }
+    }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// movieViewsFlushInterval is how often the view counts are added to the database.
+const movieViewsFlushInterval = 30 * time.Second
+
+// viewCounter counts the views of each movie (successful GET /v1/movies/:id
+// requests) in memory, until they are flushed to the database. Writing a row for
+// every view would turn each read into a write, so instead the counts are batched.
+//
+// Every response with the movie in it counts as a view, and so does a 304 Not
+// Modified response to a conditional request with the movie's ETag: a client
+// revalidating its copy is still someone looking at the movie, and counting it
+// differently would make the counts depend on how well the client caches. Error
+// responses (including 404s) don't count.
+type viewCounter struct {
+    mu sync.Mutex
+    counts map[int64]int64
+}
+
+// add counts a view of the movie with the given ID.
+func (c *viewCounter) add(id int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.counts == nil {
+        c.counts = make(map[int64]int64)
+    }
+    c.counts[id]++
+}
+
+// take returns the counts so far and starts counting from zero again.
+func (c *viewCounter) take() map[int64]int64 {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    counts := c.counts
+    c.counts = nil
+
+    return counts
+}
+
+// restore adds counts which couldn't be flushed back into the counter, so that they
+// are tried again with the next flush rather than lost.
+func (c *viewCounter) restore(counts map[int64]int64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.counts == nil {
+        c.counts = make(map[int64]int64, len(counts))
+    }
+    for id, n := range counts {
+        c.counts[id] += n
+    }
+}
+
+// flushMovieViewsPeriodically flushes the view counts at movieViewsFlushInterval
+// until the context is cancelled. The final flush, of the views counted while the
+// server drains its last requests, is made by serve() once they have finished.
+func (app *application) flushMovieViewsPeriodically(ctx context.Context) {
+    ticker := app.clock.NewTicker(movieViewsFlushInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C():
+        }
+
+        app.flushMovieViews(ctx)
+    }
+}
+
+// flushMovieViews adds the views counted since the last flush to today's counts in
+// the database. If that fails, the counts are kept for the next flush.
+func (app *application) flushMovieViews(ctx context.Context) {
+    counts := app.movieViews.take()
+    if len(counts) == 0 {
+        return
+    }
+
+    err := app.models.Movies.AddViews(ctx, app.clock.Now().UTC(), counts)
+    if err != nil {
+        app.movieViews.restore(counts)
+        app.logger.PrintError(err, map[string]string{"task": "movie_views"})
+    }
+}
+
+// handleShowAdminMovieDetails returns a movie along with details which are only of
+// interest to admins, currently the number of times it was viewed in the last 30
+// days.
+func (app *application) handleShowAdminMovieDetails(w http.ResponseWriter, r *http.Request) {
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    movie, err := app.models.Movies.Get(r.Context(), id)
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    views, err := app.models.Movies.ViewsLast30Days(r.Context(), id)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeAdminMovieDetails(movie, views), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestViewCounterRestore(t *testing.T) {
+    var c viewCounter
+
+    c.add(1)
+    c.add(1)
+    c.add(2)
+
+    counts := c.take()
+    if counts[1] != 2 || counts[2] != 1 {
+        t.Fatalf("take() = %v; want map[1:2 2:1]", counts)
+    }
+    if again := c.take(); len(again) != 0 {
+        t.Fatalf("take() after take() = %v; want nothing", again)
+    }
+
+    // Views counted while a failed flush was running are added to what it restores.
+    c.add(1)
+    c.restore(counts)
+
+    if got := c.take(); got[1] != 3 || got[2] != 1 {
+        t.Errorf("take() after restore() = %v; want map[1:3 2:1]", got)
+    }
+}
+
+// TestViewCounterConcurrent adds views from many goroutines while others flush the
+// counts, some of them failing and restoring what they took. No view may be lost or
+// counted twice. Run with -race to check the locking.
+func TestViewCounterConcurrent(t *testing.T) {
+    const (
+        adders = 8
+        addsEach = 1000
+        movies = 5
+    )
+
+    var (
+        c viewCounter
+        wg sync.WaitGroup
+        mu sync.Mutex
+        flushed = make(map[int64]int64)
+    )
+
+    done := make(chan struct{})
+
+    for i := 0; i < adders; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < addsEach; j++ {
+                c.add(int64(j % movies))
+            }
+        }()
+    }
+
+    var flushers sync.WaitGroup
+    for i := 0; i < 2; i++ {
+        flushers.Add(1)
+        go func() {
+            defer flushers.Done()
+            for n := 0; ; n++ {
+                select {
+                case <-done:
+                    return
+                default:
+                }
+
+                counts := c.take()
+                // Every other flush fails, and puts its counts back.
+                if n%2 == 0 {
+                    c.restore(counts)
+                    continue
+                }
+
+                mu.Lock()
+                for id, views := range counts {
+                    flushed[id] += views
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+
+    wg.Wait()
+    close(done)
+    flushers.Wait()
+
+    for id, views := range c.take() {
+        flushed[id] += views
+    }
+
+    for id := int64(0); id < movies; id++ {
+        if want := int64(adders * addsEach / movies); flushed[id] != want {
+            t.Errorf("movie %d: %d views; want %d", id, flushed[id], want)
+        }
+    }
+}
+
+func TestEtagMatches(t *testing.T) {
+    tests := []struct {
+        header string
+        want bool
+    }{
+        {`"1-2"`, true},
+        {`W/"1-2"`, true},
+        {`"1-1", "1-2"`, true},
+        {`*`, true},
+        {`"1-1"`, false},
+        {`"1-2-abc"`, false},
+        {``, false},
+    }
+
+    for _, tt := range tests {
+        if got := etagMatches(tt.header, `"1-2"`); got != tt.want {
+            t.Errorf("etagMatches(%q) = %v; want %v", tt.header, got, tt.want)
+        }
+    }
+}
+
+// A 304 Not Modified response to a client with the current ETag counts as a view,
+// just as a full response does. Failed requests don't count.
+func TestShowMovieCountsViews(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+    path := fmt.Sprintf("/v1/movies/%d", id)
+
+    full := ts.get(t, path)
+    if full.status != http.StatusOK {
+        t.Fatalf("status = %d; want %d", full.status, http.StatusOK)
+    }
+    etag := full.header.Get("ETag")
+    if etag == "" {
+        t.Fatal("no ETag on the response")
+    }
+
+    notModified := ts.do(t, http.MethodGet, path, nil, http.Header{"If-None-Match": {etag}})
+    if notModified.status != http.StatusNotModified {
+        t.Fatalf("with If-None-Match: status = %d; want %d", notModified.status, http.StatusNotModified)
+    }
+    if len(notModified.body) != 0 {
+        t.Errorf("304 response has a body: %q", notModified.body)
+    }
+
+    stale := ts.do(t, http.MethodGet, path, nil, http.Header{"If-None-Match": {`"0-0"`}})
+    if stale.status != http.StatusOK {
+        t.Fatalf("with a stale If-None-Match: status = %d; want %d", stale.status, http.StatusOK)
+    }
+
+    // Neither a missing movie nor an invalid request is a view.
+    ts.get(t, fmt.Sprintf("/v1/movies/%d", id+1))
+    ts.get(t, path+"?runtime_format=nonsense")
+
+    counts := app.movieViews.take()
+    if counts[id] != 3 {
+        t.Errorf("views of movie %d = %d; want 3 (full, 304 and stale)", id, counts[id])
+    }
+    if len(counts) != 1 {
+        t.Errorf("counts = %v; want only movie %d", counts, id)
+    }
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/agpelkey/greenlight/internal/data"
 	"github.com/agpelkey/greenlight/internal/validator"
@@ -13,9 +14,12 @@ func (app *application) handleCreateMovie(w http.ResponseWriter, r *http.Request
 
     var input struct {
         Title string `json:"title"`
-        Year int32 `json:"year"`
-        Runtime data.Runtime`json:"runtime"`
+        Year *int32 `json:"year"`
+        Runtime *data.Runtime`json:"runtime"`
         Genres []string `json:"genres"`
+        Certifications data.Certifications `json:"certifications"`
+        TrailerURL *string `json:"trailer_url"`
+        HomepageURL *string `json:"homepage_url"`
     }
 
     // use readJSON() to decode the request body into the input struct.
@@ -33,21 +37,30 @@ func (app *application) handleCreateMovie(w http.ResponseWriter, r *http.Request
         Year: input.Year,
         Runtime: input.Runtime,
         Genres: input.Genres,
+        Certifications: input.Certifications,
+        TrailerURL: nonEmpty(input.TrailerURL),
+        HomepageURL: nonEmpty(input.HomepageURL),
     }
 
     v := validator.New()
 
+    // Clients can check a payload against our rules without saving it by making a
+    // dry run. This goes through exactly the same validation and database checks as
+    // a real request, but the insert is rolled back at the end.
+    dryRun := app.readDryRun(r, v)
+    runtimeFormat := app.readRuntimeFormat(r, v)
+
     // call the ValidateMovie() function and return a response containing the errors
     // if any checks fail
-    if data.ValidateMovie(v, movie); !v.Valid() {
-        app.failedValidationResponse(w, r, v.Errors)
+    if data.ValidateMovie(v, movie, app.movieRules()); !v.Valid() {
+        app.failedValidationEchoResponse(w, r, v, input)
         return
     }
 
     // Call the Insert() method on our movies model, passing in a pointer to the
     // validatd movie struct. This will create a record in the database and update 
     // the movie struct with the system-generated information
-    err = app.models.Movies.Insert(movie)
+    err = app.movieModel(dryRun).Insert(r.Context(), movie)
     if err != nil {
         app.serverErrorResponse(w, r, err)
         return
@@ -57,12 +70,25 @@ func (app *application) handleCreateMovie(w http.ResponseWriter, r *http.Request
     // to let the client know which URL they can find the newly created resource at.
     // We make an empty http.Header map and then use the Set() method to add a new
     // location header, interpolating the system-generated ID for our new movie in the URL.
+    // There is no such resource after a dry run, so we just say that one was made.
     headers := make(http.Header)
-    headers.Set("Location", fmt.Sprintf("v1/movies/%d", movie.ID))
+    if dryRun {
+        headers.Set("Preference-Applied", "dry-run")
+    } else {
+        headers.Set("Location", app.apiPath(fmt.Sprintf("/v%d/movies/%s", app.contextGetAPIVersion(r), app.movieIDString(movie))))
+        headers.Set("ETag", app.movieETag(movie))
+    }
+
+    // Clients which already have the movie data (such as bulk sync clients) can ask
+    // us to leave it out of the response with a "Prefer: return=minimal" header.
+    if app.hasPreference(r, "return=minimal") {
+        app.writeMinimal(w, http.StatusCreated, headers)
+        return
+    }
 
     // Write a JSON response with a 201 created status code, the movie data in the
     // response body, and the location header.
-    err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+    err = app.writeJSON(w, http.StatusCreated, app.envelopeMovie(movie, runtimeFormat), headers)
     if err != nil {
         app.serverErrorResponse(w, r, err)
     }
@@ -70,46 +96,134 @@ func (app *application) handleCreateMovie(w http.ResponseWriter, r *http.Request
 }
 func (app *application) handleGetMovieByID(w http.ResponseWriter, r *http.Request) {
 
-    id, err := app.readIDParam(r)
-    if err != nil {
-        app.notFoundResponse(w, r)
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
         return
     }
 
-    // Call the Get() method to fetch the data for a specific movie.
-    // We also need to use errors.Is() function to check if it returns 
-    // a data.ErrRecondNotFound error, in which case we send a 404
-    // Not Found response to the client
-    movie, err := app.models.Movies.Get(id)
-    if err != nil {
-        switch{
-        case errors.Is(err, data.ErrRecordNotFound):
-            app.notFoundResponse(w, r)
-        default:
-            app.serverErrorResponse(w, r, err)
+    // Read the runtime format that the client wants the movie in.
+    v := validator.New()
+
+    runtimeFormat := app.readRuntimeFormat(r, v)
+
+    // A client can long-poll for a change to the movie with ?wait_version_gt=N (see
+    // movie_changes.go), so that an editor finds out as soon as someone else saves.
+    waitVersion, timeout, waiting := app.readLongPoll(r, v)
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    // A long-polling request registers as a waiter before it reads the movie, so
+    // that a change committed between the read and the wait isn't missed.
+    var changed <-chan struct{}
+    if waiting {
+        ch, release, ok := app.movieChanges.wait(id, app.config.movies.maxWaiters)
+        if !ok {
+            app.tooManyWaitersResponse(w, r)
+            return
+        }
+        defer release()
+        changed = ch
+    }
+
+    // A long-polling request reads the movie with a query of its own, rather than
+    // joining one already in flight (see data.MovieModel.Get()), which may have
+    // started before the change that it is waiting for was committed.
+    get := app.models.Movies.Get
+    if waiting {
+        get = app.models.Movies.GetLatest
+    }
+
+    var movie *data.Movie
+    var err error
+
+    for {
+        // Call the Get() method to fetch the data for a specific movie.
+        // We also need to use errors.Is() function to check if it returns 
+        // a data.ErrRecondNotFound error, in which case we send a 404
+        // Not Found response to the client
+        movie, err = get(r.Context(), id)
+        if err != nil {
+            switch{
+            case errors.Is(err, data.ErrRecordNotFound):
+                app.notFoundResponse(w, r)
+            default:
+                app.serverErrorResponse(w, r, err)
+            }
+            return
+        }
+
+        if !waiting || movie.Version > waitVersion {
+            break
+        }
+
+        // The client already has the current version, so wait for the movie to
+        // change and then read it again. If it doesn't change in time, the client
+        // is told so instead. We only wait once, as the changed channel is closed.
+        if !app.awaitMovieChange(r.Context(), changed, timeout) {
+            unchanged := func() (interface{}, error) {
+                return movieUnchangedXMLResponse{Changed: false, Version: movie.Version}, nil
+            }
+            err = app.writeFormatted(w, r, http.StatusOK, app.envelopeMovieUnchanged(movie.Version), unchanged, nil)
+            if err != nil {
+                app.serverErrorResponse(w, r, err)
+            }
+            return
         }
+        waiting = false
+    }
+
+    err = app.localizeMovies(r, movie)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
         return
     }
 
+    // The movie is written as XML if the client asked for it with a .xml suffix on
+    // the ID (see formats.go), and as JSON otherwise.
+    xmlMovie := func() (interface{}, error) {
+        return toMovieXMLResponse(app.toMovieResponse(movie, runtimeFormat))
+    }
+
+    // The title depends on the client's Accept-Language, so caches must keep a copy
+    // of the response per language.
+    headers := make(http.Header)
+    headers.Set("ETag", app.localizedMovieETag(movie))
+    headers.Set("Vary", "Accept-Language")
+
+    // A client whose copy is current gets a 304 Not Modified response, which counts
+    // as a view just as a full response does (see viewCounter).
+    if etagMatches(r.Header.Get("If-None-Match"), headers.Get("ETag")) {
+        for key, value := range headers {
+            w.Header()[key] = value
+        }
+        w.WriteHeader(http.StatusNotModified)
+
+        app.movieViews.add(movie.ID)
+        return
+    }
 
-    err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+    err = app.writeFormatted(w, r, http.StatusOK, app.envelopeMovie(movie, runtimeFormat), xmlMovie, headers)
     if err != nil {
         app.serverErrorResponse(w, r, err)
+        return
     }
 
+    app.movieViews.add(movie.ID)
 }
 
 func (app *application) handleUpdateMovie(w http.ResponseWriter, r *http.Request) {
     // Extrace the movie ID from the URL
-    id, err := app.readIDParam(r)
-    if err != nil {
-        app.notFoundResponse(w, r)
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
         return
     }
 
     // Fetch the existing movie record from the database, sending a 404 Not Found
     // response to the client if we couldnt find a matching record
-    movie, err := app.models.Movies.Get(id)
+    movie, err := app.models.Movies.Get(r.Context(), id)
     if err != nil {
         switch {
         case errors.Is(err, data.ErrRecordNotFound):
@@ -123,9 +237,12 @@ func (app *application) handleUpdateMovie(w http.ResponseWriter, r *http.Request
     // declare an input struct to hold the expected data from the client
     var input struct {
         Title   *string `json:"title"`
-        Year    *int32 `json:"year"`
-        Runtime *data.Runtime `json:"runtime"`
+        Year    patchField[int32] `json:"year"`
+        Runtime patchField[data.Runtime] `json:"runtime"`
         Genres  []string `json:"genres"`
+        Certifications data.Certifications `json:"certifications"`
+        TrailerURL *string `json:"trailer_url"`
+        HomepageURL *string `json:"homepage_url"`
     }
 
     // Read the JSOn request body into the input struct
@@ -145,30 +262,51 @@ func (app *application) handleUpdateMovie(w http.ResponseWriter, r *http.Request
         movie.Title = *input.Title
     }
 
-    if input.Year != nil {
-        movie.Year = *input.Year
+    // The year and runtime are optional, so they can be cleared by sending null.
+    if input.Year.Set {
+        movie.Year = input.Year.Value
     }
 
-    if input.Runtime != nil {
-        movie.Runtime = *input.Runtime
+    if input.Runtime.Set {
+        movie.Runtime = input.Runtime.Value
     }
 
     if input.Genres != nil {
         movie.Genres = input.Genres // Note that we do not need to derefernce a slice
     }
 
+    // The certifications replace the existing ones as a whole, so sending an empty
+    // object clears them.
+    if input.Certifications != nil {
+        movie.Certifications = input.Certifications
+    }
+
+    // The links can be removed by sending an empty string.
+    if input.TrailerURL != nil {
+        movie.TrailerURL = nonEmpty(input.TrailerURL)
+    }
+
+    if input.HomepageURL != nil {
+        movie.HomepageURL = nonEmpty(input.HomepageURL)
+    }
+
     // Validate the updated movie record, sending the client a 422 Unprocessable Entity
     // response if any checks fail
     v := validator.New()
 
-    if data.ValidateMovie(v, movie); !v.Valid() {
-        app.failedValidationResponse(w, r, v.Errors)
+    // As with creation, a dry run checks the update (including the version check for
+    // edit conflicts) and returns the resulting movie, but doesn't save it.
+    dryRun := app.readDryRun(r, v)
+    runtimeFormat := app.readRuntimeFormat(r, v)
+
+    if data.ValidateMovie(v, movie, app.movieRules()); !v.Valid() {
+        app.failedValidationEchoResponse(w, r, v, input)
         return
     }
 
 
     // Pass the updated movie record to our new Update() method.
-    err = app.models.Movies.Update(movie)
+    err = app.movieModel(dryRun).Update(r.Context(), movie)
     if err != nil {
         switch{
         case errors.Is(err, data.ErrEditConflict):
@@ -179,8 +317,23 @@ func (app *application) handleUpdateMovie(w http.ResponseWriter, r *http.Request
         return
     }
 
+    headers := make(http.Header)
+    if dryRun {
+        headers.Set("Preference-Applied", "dry-run")
+    } else {
+        headers.Set("ETag", app.movieETag(movie))
+        app.movieChanges.notify(movie.ID)
+    }
+
+    // As with creation, honor a "Prefer: return=minimal" header by sending only the
+    // headers.
+    if app.hasPreference(r, "return=minimal") {
+        app.writeMinimal(w, http.StatusOK, headers)
+        return
+    }
+
     // Write the updated movie record in a JSON response
-    err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMovie(movie, runtimeFormat), headers)
     if err != nil {
         app.serverErrorResponse(w, r, err)
     }
@@ -188,15 +341,24 @@ func (app *application) handleUpdateMovie(w http.ResponseWriter, r *http.Request
 
 func (app *application) handleDeleteMovie(w http.ResponseWriter, r *http.Request) {
     // Extrace the movie ID from the URL
-    id, err := app.readIDParam(r)
-    if err != nil {
-        app.notFoundResponse(w, r)
+    id, ok := app.readMovieIDParam(w, r)
+    if !ok {
+        return
+    }
+
+    v := validator.New()
+
+    // A dry run tells the client whether the movie could be deleted, without
+    // deleting it.
+    dryRun := app.readDryRun(r, v)
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
         return
     }
 
     // Delete the movie from the database, sending a 404 Not Found response
     // to the client if there isnt a matching record
-    err = app.models.Movies.Delete(id)
+    err := app.movieModel(dryRun).Delete(r.Context(), id)
     if err != nil {
         switch {
         case errors.Is(err, data.ErrRecordNotFound):
@@ -207,8 +369,23 @@ func (app *application) handleDeleteMovie(w http.ResponseWriter, r *http.Request
         return
     }
 
+    if dryRun {
+        headers := make(http.Header)
+        headers.Set("Preference-Applied", "dry-run")
+
+        err = app.writeJSON(w, http.StatusOK, app.envelopeMessage("movie would be deleted"), headers)
+        if err != nil {
+            app.serverErrorResponse(w, r, err)
+        }
+        return
+    }
+
+    // Wake any requests waiting for the movie to change, so that they find out
+    // that it has gone.
+    app.movieChanges.notify(id)
+
     // Return a 200 OK status code along with a success message
-    err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+    err = app.writeJSON(w, http.StatusOK, app.envelopeMessage("movie successfully deleted"), nil)
     if err != nil {
         app.serverErrorResponse(w, r, err)
     }
@@ -218,8 +395,7 @@ func (app *application) handleListMovies(w http.ResponseWriter, r *http.Request)
     // To keep things consistent with our other handlers, we'll define an input
     // struct to hold the expected values from the request query string
     var input struct {
-        Title string
-        Genres []string
+        data.MovieSearch
         data.Filters
     }
 
@@ -229,47 +405,118 @@ func (app *application) handleListMovies(w http.ResponseWriter, r *http.Request)
     // Call r.URL.Query() to get the url.Values map containing the query string data.
     qs := r.URL.Query()
 
-    // Use our helpers to extract the title and genres query string values, falling back
-    // to defaults of an empty string and an empty slice respectively if they are not
-    // provided by the client
-    input.Title = app.readString(qs, "title", "")
-    input.Genres = app.readCSV(qs, "genres", []string{})
+    input.MovieSearch = app.readMovieSearch(qs, v)
+
+    // With ?fuzzy=true the title is matched by trigram similarity rather than
+    // full-text search, to find titles despite typos. Full-text search stays the
+    // default, as it is more precise. Fuzzy search is behind a feature flag, so that
+    // it can be turned off if its queries cause trouble, and asking for it while the
+    // flag is off gets a 404 Not Found. The flag is created turned on by migration
+    // 000019, as a flag which doesn't exist is off.
+    input.MovieSearch.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+    input.MovieSearch.FuzzyThreshold = app.config.movies.fuzzyThreshold
+
+    if input.MovieSearch.Fuzzy && !app.featureEnabled(r, featureFuzzySearch) {
+        app.notFoundResponse(w, r)
+        return
+    }
 
-    // Get the page and page_size query string values as integers. Notice that we set
-    // the default page value to 1 and default page_size to 20, and that we pass
-    // the validator instance as the final argument here
-    input.Filters.Page = app.readInt(qs, "page", 1, v)
-    input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+    runtimeFormat := app.readRuntimeFormat(r, v)
+
+    // Get the page and page_size query string values as integers, falling back to the
+    // configured defaults (1 and 20 unless overridden by the command-line flags). Notice
+    // that we pass the validator instance as the final argument here
+    input.Filters.Page = app.readInt(qs, "page", app.config.pagination.defaultPage, v)
+    input.Filters.PageSize = app.readInt(qs, "page_size", app.config.pagination.defaultPageSize, v)
+    input.Filters.MaxPageSize = app.config.pagination.maxPageSize
 
     // Extract the sort query string value, falling back to "id" if it is not provided
     // by the client (which will imply a ascending sort on movie ID).
     input.Filters.Sort = app.readString(qs, "sort", "id")
-    input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+    input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime", "most_viewed", data.SortRandom}
+
+    // With ?sort=random, an integer ?seed makes the shuffled order repeatable so that
+    // the client can page through it. The seed is ignored by the other sort orders.
+    if qs.Get("seed") != "" {
+        seed := int64(app.readInt(qs, "seed", 0, v))
+        input.Filters.Seed = &seed
+    }
 
     // Check the validator instance for any errors and use the failedValidationResponse()
     // helper to send the client a response if necessary
     if data.ValidateFilters(v, input.Filters); !v.Valid() {
-        app.failedValidationResponse(w, r, v.Errors)
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    // Streaming clients can ask for the page as newline-delimited JSON instead of a
+    // single envelope, with "Accept: application/x-ndjson". The Vary header is set
+    // for both representations, so that a cache doesn't serve one in place of the
+    // other, and as the titles are localized it includes Accept-Language too.
+    w.Header().Set("Vary", "Accept, Accept-Language")
+
+    if acceptsNDJSON(r) {
+        app.streamMovies(w, r, input.MovieSearch, input.Filters, runtimeFormat)
         return
     }
 
     // Call GetAll() method to retrieve the movies, passing in the various filter parameters.
-    movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+    movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.MovieSearch, input.Filters)
     if err != nil {
-        app.serverErrorResponse(w, r, err)
+        switch {
+        case errors.Is(err, data.ErrInvalidSort):
+            v.AddError("sort", "invalid_sort")
+            app.failedValidationResponse(w, r, v)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
         return
     }
 
-    err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+    err = app.localizeMovies(r, movies...)
     if err != nil {
         app.serverErrorResponse(w, r, err)
+        return
     }
-}
 
+    // Keep the response within the size budget, cutting the page short (and warning
+    // the client) if it would be larger.
+    js, served, err := app.fitSizeBudget(len(movies), func(n int) envelope {
+        return app.envelopeMovies(movies[:n], metadata.Truncate(n, len(movies)), runtimeFormat)
+    })
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
 
+    headers := make(http.Header)
+    if served < len(movies) {
+        truncationWarning(headers, served, len(movies), "movies")
+    }
 
+    app.writeEncodedJSON(w, http.StatusOK, js, headers)
+}
 
+// readMovieSearch reads the movie search criteria from the query string, for the
+// list endpoint and the bulk delete endpoint, and validates them.
+func (app *application) readMovieSearch(qs url.Values, v *validator.Validator) data.MovieSearch {
+    var search data.MovieSearch
 
+    // Use our helpers to extract the title and genres query string values, falling back
+    // to defaults of an empty string and an empty slice respectively if they are not
+    // provided by the client
+    search.Title = app.readString(qs, "title", "")
+    search.Genres = app.readCSV(qs, "genres", []string{})
+    search.SearchTranslations = app.readBool(qs, "search_translations", false, v)
+    search.Language = app.config.movies.ftsLanguage
 
+    // The certification parameter is a comma-separated list of "SYSTEM:RATING"
+    // values, e.g. ?certification=US:PG-13,GB:12A, and the unrated parameter controls
+    // whether movies without any certifications are included.
+    search.Certifications = data.ParseCertificationFilter(v, app.readCSV(qs, "certification", []string{}))
+    search.Unrated = app.readString(qs, "unrated", data.UnratedInclude)
 
+    data.ValidateMovieSearch(v, search)
 
+    return search
+}
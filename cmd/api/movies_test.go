@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// movieBody is the part of a movie response that the tests check.
+type movieBody struct {
+    Movie struct {
+        ID int64 `json:"id"`
+        Title string `json:"title"`
+        Year int32 `json:"year"`
+        Runtime string `json:"runtime"`
+        Genres []string `json:"genres"`
+        Version int32 `json:"version"`
+    } `json:"movie"`
+}
+
+// createTestMovie creates a movie through the API, failing the test if it can't,
+// and returns its ID.
+func createTestMovie(t *testing.T, ts *testServer, title string) int64 {
+    t.Helper()
+
+    res := ts.postJSON(t, "/v1/movies", map[string]interface{}{
+        "title": title,
+        "year": 2016,
+        "runtime": "107 mins",
+        "genres": []string{"animation", "adventure"},
+    })
+    if res.status != http.StatusCreated {
+        t.Fatalf("creating %q: status = %d (body %q)", title, res.status, res.body)
+    }
+
+    var body movieBody
+    res.decode(t, &body)
+
+    return body.Movie.ID
+}
+
+func TestCreateMovie(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    tests := []struct {
+        name string
+        body interface{}
+        wantStatus int
+    }{
+        {"valid", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusCreated},
+        {"runtime as a number", `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation"]}`, http.StatusCreated},
+        {"missing title", `{"year": 2016, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnprocessableEntity},
+        {"future year", `{"title": "Moana", "year": 3000, "runtime": "107 mins", "genres": ["animation"]}`, http.StatusUnprocessableEntity},
+        {"duplicate genres", `{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "animation"]}`, http.StatusUnprocessableEntity},
+        {"unknown field", `{"title": "Moana", "rating": "PG"}`, http.StatusBadRequest},
+        {"malformed", `{"title": "Moana"`, http.StatusBadRequest},
+        {"empty", ``, http.StatusBadRequest},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res := ts.postJSON(t, "/v1/movies", tt.body)
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, tt.wantStatus, res.body)
+            }
+            if tt.wantStatus != http.StatusCreated {
+                return
+            }
+
+            var body movieBody
+            res.decode(t, &body)
+
+            if want := fmt.Sprintf("/v1/movies/%d", body.Movie.ID); res.header.Get("Location") != want {
+                t.Errorf("Location = %q; want %q", res.header.Get("Location"), want)
+            }
+            if body.Movie.Title != "Moana" || body.Movie.Runtime != "107 mins" || body.Movie.Version != 1 {
+                t.Errorf("movie = %+v; want the created movie at version 1", body.Movie)
+            }
+        })
+    }
+}
+
+func TestShowMovie(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+
+    tests := []struct {
+        name string
+        path string
+        wantStatus int
+    }{
+        {"existing", fmt.Sprintf("/v1/movies/%d", id), http.StatusOK},
+        {"missing", fmt.Sprintf("/v1/movies/%d", id+1), http.StatusNotFound},
+        {"zero", "/v1/movies/0", http.StatusNotFound},
+        {"negative", "/v1/movies/-1", http.StatusNotFound},
+        {"not a number", "/v1/movies/moana", http.StatusNotFound},
+        {"leading zero", fmt.Sprintf("/v1/movies/0%d", id), http.StatusNotFound},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res := ts.get(t, tt.path)
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, tt.wantStatus, res.body)
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+
+            var body movieBody
+            res.decode(t, &body)
+            if body.Movie.ID != id || body.Movie.Title != "Moana" {
+                t.Errorf("movie = %+v; want movie %d", body.Movie, id)
+            }
+        })
+    }
+}
+
+func TestUpdateMovie(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+    path := fmt.Sprintf("/v1/movies/%d", id)
+
+    tests := []struct {
+        name string
+        path string
+        body string
+        wantStatus int
+        wantTitle string
+        wantVersion int32
+    }{
+        {"title", path, `{"title": "Vaiana"}`, http.StatusOK, "Vaiana", 2},
+        {"year only keeps the title", path, `{"year": 2017}`, http.StatusOK, "Vaiana", 3},
+        {"invalid", path, `{"title": ""}`, http.StatusUnprocessableEntity, "", 0},
+        {"unknown field", path, `{"rating": "PG"}`, http.StatusBadRequest, "", 0},
+        {"missing", fmt.Sprintf("/v1/movies/%d", id+1), `{"title": "Vaiana"}`, http.StatusNotFound, "", 0},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res := ts.sendJSON(t, http.MethodPatch, tt.path, tt.body)
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, tt.wantStatus, res.body)
+            }
+            if tt.wantStatus != http.StatusOK {
+                return
+            }
+
+            var body movieBody
+            res.decode(t, &body)
+            if body.Movie.Title != tt.wantTitle || body.Movie.Version != tt.wantVersion {
+                t.Errorf("movie = %+v; want title %q at version %d", body.Movie, tt.wantTitle, tt.wantVersion)
+            }
+        })
+    }
+}
+
+func TestPatchField(t *testing.T) {
+    year := int32(2016)
+
+    tests := []struct {
+        body string
+        wantSet bool
+        wantValue *int32
+        wantErr bool
+    }{
+        {`{}`, false, nil, false},
+        {`{"year": null}`, true, nil, false},
+        {`{"year": 2016}`, true, &year, false},
+        {`{"year": "2016"}`, true, nil, true},
+    }
+
+    for _, tt := range tests {
+        var input struct {
+            Year patchField[int32] `json:"year"`
+        }
+
+        err := json.Unmarshal([]byte(tt.body), &input)
+        if (err != nil) != tt.wantErr {
+            t.Errorf("%s: err = %v; want error %v", tt.body, err, tt.wantErr)
+            continue
+        }
+        if tt.wantErr {
+            continue
+        }
+
+        if input.Year.Set != tt.wantSet || !reflect.DeepEqual(input.Year.Value, tt.wantValue) {
+            t.Errorf("%s: got set %v, value %v; want set %v, value %v", tt.body, input.Year.Set, input.Year.Value, tt.wantSet, tt.wantValue)
+        }
+    }
+}
+
+// The year and runtime are optional, so a PATCH can clear them with null, while
+// leaving them out keeps them as they are.
+func TestUpdateMovieClearsOptionalFields(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+    path := fmt.Sprintf("/v1/movies/%d", id)
+
+    movieFields := func(res testResponse) map[string]interface{} {
+        t.Helper()
+
+        if res.status != http.StatusOK {
+            t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusOK, res.body)
+        }
+        var body struct {
+            Movie map[string]interface{} `json:"movie"`
+        }
+        res.decode(t, &body)
+        return body.Movie
+    }
+
+    movie := movieFields(ts.sendJSON(t, http.MethodPatch, path, `{"title": "Vaiana"}`))
+    if movie["year"] != 2016.0 || movie["runtime"] != "107 mins" {
+        t.Fatalf("after leaving them out: year = %v, runtime = %v; want them unchanged", movie["year"], movie["runtime"])
+    }
+
+    movie = movieFields(ts.sendJSON(t, http.MethodPatch, path, `{"year": null, "runtime": null}`))
+    if movie["year"] != nil || movie["runtime"] != nil {
+        t.Errorf("after sending null: year = %v, runtime = %v; want them cleared", movie["year"], movie["runtime"])
+    }
+
+    movie = movieFields(ts.get(t, path))
+    if movie["year"] != nil || movie["runtime"] != nil || movie["title"] != "Vaiana" {
+        t.Errorf("GET after clearing: movie = %v; want the title kept and no year or runtime", movie)
+    }
+}
+
+func TestDeleteMovie(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    id := createTestMovie(t, ts, "Moana")
+    path := fmt.Sprintf("/v1/movies/%d", id)
+
+    tests := []struct {
+        name string
+        path string
+        wantStatus int
+    }{
+        {"existing", path, http.StatusOK},
+        {"already deleted", path, http.StatusNotFound},
+        {"never existed", fmt.Sprintf("/v1/movies/%d", id+1), http.StatusNotFound},
+        {"not a number", "/v1/movies/moana", http.StatusNotFound},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            res := ts.do(t, http.MethodDelete, tt.path, nil, nil)
+            if res.status != tt.wantStatus {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, tt.wantStatus, res.body)
+            }
+        })
+    }
+
+    if res := ts.get(t, path); res.status != http.StatusNotFound {
+        t.Errorf("GET after DELETE: status = %d; want %d", res.status, http.StatusNotFound)
+    }
+}
+
+// A search which matches nothing still has every metadata field, all zero, and an
+// empty list of movies rather than null. So does a page past the last one.
+func TestListMoviesEmpty(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    createTestMovie(t, ts, "Moana")
+
+    for _, path := range []string{"/v1/movies?title=casablanca", "/v1/movies?page=2"} {
+        t.Run(path, func(t *testing.T) {
+            res := ts.get(t, path)
+            if res.status != http.StatusOK {
+                t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusOK, res.body)
+            }
+
+            var body struct {
+                Movies json.RawMessage `json:"movies"`
+                Metadata map[string]interface{} `json:"metadata"`
+            }
+            res.decode(t, &body)
+
+            if string(body.Movies) != "[]" {
+                t.Errorf("movies = %s; want []", body.Movies)
+            }
+
+            want := map[string]interface{}{
+                "current_page": 0.0,
+                "page_size": 0.0,
+                "first_page": 0.0,
+                "last_page": 0.0,
+                "total_records": 0.0,
+            }
+            if !reflect.DeepEqual(body.Metadata, want) {
+                t.Errorf("metadata = %v; want %v", body.Metadata, want)
+            }
+        })
+    }
+}
+
+// An invalid sort value is refused with a 422 response before the database is
+// touched, so the application here doesn't have one.
+func TestListMoviesInvalidSort(t *testing.T) {
+    app := newTestApplication(t, nil)
+    ts := newTestServer(t, app.routes())
+
+    for _, sort := range []string{"nonsense", "-nonsense", "title%3BDROP+TABLE+movies", "title%20DESC"} {
+        res := ts.get(t, "/v1/movies?sort="+sort)
+        if res.status != http.StatusUnprocessableEntity {
+            t.Errorf("sort=%s: status = %d; want %d (body %q)", sort, res.status, http.StatusUnprocessableEntity, res.body)
+        }
+    }
+}
+
+// Fuzzy search works on a freshly migrated database, whose feature flag for it is
+// created turned on, and gets a 404 once the flag is turned off.
+func TestListMoviesFuzzy(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    createTestMovie(t, ts, "Casablanca")
+
+    if err := app.models.Features.Refresh(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+
+    res := ts.get(t, "/v1/movies?title=casablanka&fuzzy=true")
+    if res.status != http.StatusOK {
+        t.Fatalf("status = %d; want %d (body %q)", res.status, http.StatusOK, res.body)
+    }
+
+    var body struct {
+        Movies []struct {
+            Title string `json:"title"`
+        } `json:"movies"`
+    }
+    res.decode(t, &body)
+    if len(body.Movies) != 1 || body.Movies[0].Title != "Casablanca" {
+        t.Errorf("movies = %+v; want Casablanca", body.Movies)
+    }
+
+    err := app.models.Features.Upsert(context.Background(), &data.FeatureFlag{Name: featureFuzzySearch, Enabled: false, RolloutPercent: 100})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if res := ts.get(t, "/v1/movies?title=casablanka&fuzzy=true"); res.status != http.StatusNotFound {
+        t.Errorf("with the flag off: status = %d; want %d", res.status, http.StatusNotFound)
+    }
+}
+
+// The list is served as a JSON envelope or as NDJSON depending on the Accept header,
+// so both representations say that they vary by it.
+func TestListMoviesVary(t *testing.T) {
+    app := newTestApplication(t, newTestDB(t))
+    ts := newTestServer(t, app.routes())
+
+    createTestMovie(t, ts, "Moana")
+
+    for _, accept := range []string{"application/json", "application/x-ndjson"} {
+        res := ts.do(t, http.MethodGet, "/v1/movies", nil, http.Header{"Accept": {accept}})
+        if res.status != http.StatusOK {
+            t.Fatalf("Accept %s: status = %d; want %d", accept, res.status, http.StatusOK)
+        }
+        if got := res.header.Get("Vary"); got != "Accept, Accept-Language" {
+            t.Errorf("Accept %s: Vary = %q; want %q", accept, got, "Accept, Accept-Language")
+        }
+    }
+}
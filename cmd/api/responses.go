@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data"
+)
+
+// movieResponse is the public JSON representation of a movie. Handlers never encode
+// a data.Movie directly; they map it to a movieResponse with toMovieResponse()
+// first. This keeps the wire format independent from the database struct, so
+// that we can add internal columns to the movies table without them leaking into
+// the API, and change the API without touching the storage layer.
+type movieResponse struct {
+    ID interface{} `json:"id"` // an int64 ID, or a UUID string when -id-type=uuid is set
+    CreatedAt *time.Time `json:"created_at,omitempty"`
+    Title string `json:"title"`
+    LocalizedTitle string `json:"localized_title,omitempty"`
+    Year *int32 `json:"year,omitempty"`
+    // Runtime holds a data.Runtime, data.RuntimeHM or data.RuntimeMinutes, depending
+    // on the API version and the format the client asked for, and is nil when the
+    // runtime isn't known.
+    Runtime json.Marshaler `json:"runtime,omitempty"`
+    Genres []string `json:"genres,omitempty"`
+    Certifications data.Certifications `json:"certifications,omitempty"`
+    TrailerURL *string `json:"trailer_url,omitempty"`
+    HomepageURL *string `json:"homepage_url,omitempty"`
+    Version int32 `json:"version"`
+}
+
+// The toMovieResponse() helper maps a movie to its public representation. The
+// created_at timestamp is useful when developing and debugging, but we don't want
+// to commit to it as part of the public API, so it is only included when we're
+// not running in production. The runtimeFormat is one of data.RuntimeFormatMins,
+// data.RuntimeFormatHM or data.RuntimeFormatInt, as returned by readRuntimeFormat().
+func (app *application) toMovieResponse(movie *data.Movie, runtimeFormat string) *movieResponse {
+    response := &movieResponse{
+        Title: movie.Title,
+        LocalizedTitle: movie.LocalizedTitle,
+        Year: movie.Year,
+        Genres: movie.Genres,
+        Certifications: movie.Certifications,
+        TrailerURL: movie.TrailerURL,
+        HomepageURL: movie.HomepageURL,
+        Version: movie.Version,
+    }
+
+    // Identify the movie by its UUID instead of its sequential ID if configured to,
+    // so that we don't reveal how many movies there are.
+    response.ID = app.movieID(movie)
+
+    if movie.Runtime != nil {
+        switch runtimeFormat {
+        case data.RuntimeFormatHM:
+            response.Runtime = data.RuntimeHM(*movie.Runtime)
+        case data.RuntimeFormatInt:
+            response.Runtime = data.RuntimeMinutes(*movie.Runtime)
+        default:
+            response.Runtime = *movie.Runtime
+        }
+    }
+
+    if app.config.env != "production" {
+        createdAt := movie.CreatedAt
+        response.CreatedAt = &createdAt
+    }
+
+    return response
+}
+
+// movieXMLResponse is the XML representation of a movie, for /v1/movies/:id.xml. It
+// has the same fields as movieResponse, but maps don't have an XML form, so the
+// certifications are listed as elements with the country as an attribute, e.g.
+// <certification country="US">PG-13</certification>.
+type movieXMLResponse struct {
+    XMLName xml.Name `xml:"movie"`
+    ID interface{} `xml:"id"`
+    CreatedAt *time.Time `xml:"created_at,omitempty"`
+    Title string `xml:"title"`
+    LocalizedTitle string `xml:"localized_title,omitempty"`
+    Year *int32 `xml:"year,omitempty"`
+    Runtime string `xml:"runtime,omitempty"`
+    Genres []string `xml:"genres>genre"`
+    Certifications []certificationXML `xml:"certifications>certification"`
+    TrailerURL *string `xml:"trailer_url,omitempty"`
+    HomepageURL *string `xml:"homepage_url,omitempty"`
+    Version int32 `xml:"version"`
+}
+
+type certificationXML struct {
+    Country string `xml:"country,attr"`
+    Rating string `xml:",chardata"`
+}
+
+// The toMovieXMLResponse() helper converts the public representation of a movie to
+// its XML form. The runtime is written as it would be in JSON (e.g. "102 mins"),
+// without the quotes.
+func toMovieXMLResponse(movie *movieResponse) (*movieXMLResponse, error) {
+    response := &movieXMLResponse{
+        ID: movie.ID,
+        CreatedAt: movie.CreatedAt,
+        Title: movie.Title,
+        LocalizedTitle: movie.LocalizedTitle,
+        Year: movie.Year,
+        Genres: movie.Genres,
+        TrailerURL: movie.TrailerURL,
+        HomepageURL: movie.HomepageURL,
+        Version: movie.Version,
+    }
+
+    if movie.Runtime != nil {
+        js, err := movie.Runtime.MarshalJSON()
+        if err != nil {
+            return nil, err
+        }
+        response.Runtime = strings.Trim(string(js), `"`)
+    }
+
+    // Map iteration order is random, so the countries are sorted to keep the output
+    // stable.
+    for country, rating := range movie.Certifications {
+        response.Certifications = append(response.Certifications, certificationXML{Country: country, Rating: rating})
+    }
+    sort.Slice(response.Certifications, func(i, j int) bool {
+        return response.Certifications[i].Country < response.Certifications[j].Country
+    })
+
+    return response, nil
+}
+
+// movieUnchangedXMLResponse is the XML form of the response to a long-poll for a
+// change to a movie which timed out.
+type movieUnchangedXMLResponse struct {
+    XMLName xml.Name `xml:"movie"`
+    Changed bool `xml:"changed"`
+    Version int32 `xml:"version"`
+}
+
+// movieLinksResponse describes the links of a movie and the result of their last
+// check, for the admin listing of broken links.
+type movieLinksResponse struct {
+    ID interface{} `json:"id"`
+    Title string `json:"title"`
+    TrailerURL *string `json:"trailer_url,omitempty"`
+    HomepageURL *string `json:"homepage_url,omitempty"`
+    LinkStatus string `json:"link_status"`
+    LinksCheckedAt *time.Time `json:"links_checked_at"`
+}
+
+func (app *application) toMovieLinksResponse(movie *data.Movie) *movieLinksResponse {
+    return &movieLinksResponse{
+        ID: app.movieID(movie),
+        Title: movie.Title,
+        TrailerURL: movie.TrailerURL,
+        HomepageURL: movie.HomepageURL,
+        LinkStatus: movie.LinkStatus,
+        LinksCheckedAt: movie.LinksCheckedAt,
+    }
+}
+
+// movieStatsResponse is the public representation of the catalog statistics.
+type movieStatsResponse struct {
+    TotalMovies int `json:"total_movies"`
+    ByDecade map[string]int `json:"by_decade"`
+    AverageRuntimeMins *float64 `json:"average_runtime_mins"`
+    TopGenres []data.GenreCount `json:"top_genres"`
+    ByCertification map[string]map[string]int `json:"by_certification"`
+    Newest *movieSummaryResponse `json:"newest"`
+    Oldest *movieSummaryResponse `json:"oldest"`
+}
+
+// movieSummaryResponse identifies a movie by its ID, title and year, for places
+// where the whole movie isn't needed.
+type movieSummaryResponse struct {
+    ID interface{} `json:"id"`
+    Title string `json:"title"`
+    Year *int32 `json:"year,omitempty"`
+}
+
+func (app *application) toMovieStatsResponse(stats *data.MovieStats) *movieStatsResponse {
+    return &movieStatsResponse{
+        TotalMovies: stats.TotalMovies,
+        ByDecade: stats.ByDecade,
+        AverageRuntimeMins: stats.AverageRuntime,
+        TopGenres: stats.TopGenres,
+        ByCertification: stats.ByCertification,
+        Newest: app.toMovieSummaryResponse(stats.Newest),
+        Oldest: app.toMovieSummaryResponse(stats.Oldest),
+    }
+}
+
+func (app *application) toMovieSummaryResponse(movie *data.Movie) *movieSummaryResponse {
+    if movie == nil {
+        return nil
+    }
+
+    return &movieSummaryResponse{
+        ID: app.movieID(movie),
+        Title: movie.Title,
+        Year: movie.Year,
+    }
+}
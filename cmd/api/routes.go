@@ -1,33 +1,220 @@
 package main
 
 import (
+	"expvar"
+	"fmt"
+	"io"
 	"net/http"
+	"text/tabwriter"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// routeAccess says who may call a route. Every route which changes data must
+// declare its access explicitly: routes() refuses to register a POST, PUT, PATCH or
+// DELETE route whose access is left as accessUnset, so that a write route can't
+// silently end up unprotected.
+type routeAccess int
+
+const (
+    accessUnset routeAccess = iota
+    accessPublic
+    accessAdmin
+)
+
+func (a routeAccess) String() string {
+    switch a {
+    case accessPublic:
+        return "public"
+    case accessAdmin:
+        return "admin"
+    default:
+        return "unset"
+    }
+}
+
+// route describes a single endpoint. The route table below is the one place where
+// the API's endpoints are listed: routes() registers them with the router, wrapping
+// each handler according to its access, and the "routes" command prints them, so
+// the two can never disagree. The table is made up of one list of routes per major
+// version of the API (see registerV1() and registerV2()).
+type route struct {
+    method string
+    path string
+    handler http.HandlerFunc
+    access routeAccess
+    summary string
+}
+
+func (app *application) routeTable() []route {
+    return append(app.registerV1(), app.registerV2()...)
+}
+
+// registerV1 returns the routes of version 1 of the API, which is stable: its
+// representations and validation must not change in ways that break clients.
+func (app *application) registerV1() []route {
+    routes := []route{
+        {http.MethodGet, "/v1/healthcheck", app.handleHealthCheck, accessPublic, "Show application status"},
+        {http.MethodHead, "/v1/healthcheck", app.handleHealthCheck, accessPublic, "Show application status (no body)"},
+        {http.MethodGet, "/v1/healthz/ready", app.handleReadinessCheck, accessPublic, "Show whether the instance is ready for traffic"},
+        {http.MethodGet, changelogPath, app.handleShowChangelog, accessPublic, "List API changes and deprecations"},
+
+        {http.MethodGet, "/v1/movies", app.handleListMovies, accessPublic, "List movies"},
+        {http.MethodPost, "/v1/movies", app.handleCreateMovie, accessPublic, "Create a movie"},
+        {http.MethodGet, "/v1/movies/:id", app.formatSuffix(app.handleGetMovieByID), accessPublic, "Show a movie (as JSON, or XML with a .xml suffix)"},
+        {http.MethodPatch, "/v1/movies/:id", app.handleUpdateMovie, accessPublic, "Update a movie"},
+        {http.MethodDelete, "/v1/movies/:id", app.handleDeleteMovie, accessPublic, "Delete a movie"},
+        {http.MethodGet, "/v1/movies/:id/versions", app.handleListMovieVersions, accessPublic, "List the versions of a movie"},
+        {http.MethodGet, "/v1/movies/:id/versions/:version", app.handleGetMovieVersion, accessPublic, "Show a version of a movie"},
+        {http.MethodGet, "/v1/movies/:id/diff", app.handleDiffMovieVersions, accessPublic, "Compare two versions of a movie"},
+        {http.MethodPost, "/v1/movies/:id/share", app.handleCreateMovieShare, accessAdmin, "Create a share link for a movie"},
+        {http.MethodGet, "/v1/shared/movies/:id", app.handleShowSharedMovie, accessPublic, "Show a movie through a share link"},
+        {http.MethodGet, "/v1/movies/:id/translations", app.handleListMovieTranslations, accessPublic, "List the translations of a movie"},
+        {http.MethodPost, "/v1/movies/:id/translations", app.handleCreateMovieTranslation, accessPublic, "Add a translation of a movie"},
+        {http.MethodGet, "/v1/movies/:id/translations/:lang", app.handleGetMovieTranslation, accessPublic, "Show a translation of a movie"},
+        {http.MethodPatch, "/v1/movies/:id/translations/:lang", app.handleUpdateMovieTranslation, accessPublic, "Update a translation of a movie"},
+        {http.MethodDelete, "/v1/movies/:id/translations/:lang", app.handleDeleteMovieTranslation, accessPublic, "Delete a translation of a movie"},
+
+        {http.MethodPost, "/v1/genres/rename", app.handleRenameGenre, accessAdmin, "Rename a genre across all movies"},
+        {http.MethodGet, "/v1/stats", app.handleShowMovieStats, accessPublic, "Show catalog statistics"},
+        {http.MethodGet, "/v1/search/suggest", app.handleSuggestMovies, accessPublic, "Suggest movie titles for a search prefix"},
+
+        {http.MethodPost, "/v1/users", app.handleRegistUser, accessPublic, "Register a user"},
+
+        {http.MethodGet, "/v1/admin/limiter", app.handleListLimiterClients, accessAdmin, "List rate limited clients"},
+        {http.MethodDelete, "/v1/admin/limiter/:key", app.handleResetLimiterClient, accessAdmin, "Reset a client's rate limiter"},
+        {http.MethodGet, "/v1/admin/summary", app.handleShowAdminSummary, accessAdmin, "Show operational counters"},
+        {http.MethodPut, "/v1/admin/read-only", app.handleSetReadOnly, accessAdmin, "Turn read-only mode on or off"},
+        {http.MethodPut, "/v1/admin/maintenance", app.handleSetMaintenance, accessAdmin, "Turn maintenance mode on or off"},
+        {http.MethodGet, "/v1/admin/movie-details/:id", app.handleShowAdminMovieDetails, accessAdmin, "Show a movie with its view count"},
+        {http.MethodGet, "/v1/admin/features", app.handleListFeatureFlags, accessAdmin, "List feature flags"},
+        {http.MethodPut, "/v1/admin/features/:name", app.handleUpdateFeatureFlag, accessAdmin, "Create or update a feature flag"},
+        {http.MethodDelete, "/v1/admin/features/:name", app.handleDeleteFeatureFlag, accessAdmin, "Delete a feature flag"},
+        {http.MethodGet, "/v1/admin/mail-templates", app.handleListMailTemplates, accessAdmin, "List email templates"},
+        {http.MethodGet, "/v1/admin/mail-templates/:name/preview", app.handlePreviewMailTemplate, accessAdmin, "Render an email template with fixture data"},
+        {http.MethodPost, "/v1/admin/mail-templates/:name/test-send", app.handleTestSendMailTemplate, accessAdmin, "Send a test email rendered from a template"},
+        {http.MethodGet, "/v1/admin/shares", app.handleListMovieShares, accessAdmin, "List movie share links"},
+        {http.MethodDelete, "/v1/admin/shares/:id", app.handleRevokeMovieShare, accessAdmin, "Revoke a movie share link"},
+        {http.MethodGet, "/v1/admin/movies/export", app.handleExportMovies, accessAdmin, "Export all movies as NDJSON"},
+        {http.MethodPost, "/v1/admin/movies/import", app.handleImportMovies, accessAdmin, "Import movies from NDJSON"},
+        {http.MethodPost, "/v1/admin/movies/bulk-delete", app.handleBulkDeleteMovies, accessAdmin, "Delete all movies matching a filter"},
+        {http.MethodGet, "/v1/admin/movies/broken-links", app.handleListBrokenLinks, accessAdmin, "List movies with broken links"},
+        {http.MethodPost, "/v1/admin/movies/revalidate", app.handleStartRevalidation, accessAdmin, "Check every movie against the validation rules"},
+        {http.MethodGet, "/v1/admin/movies/revalidate/:id", app.handleShowRevalidation, accessAdmin, "Show the progress and report of a revalidation"},
+
+        {http.MethodGet, debugVarsPath, expvar.Handler().ServeHTTP, accessAdmin, "Show runtime and application metrics"},
+    }
+
+    // The echo endpoint shows request headers (albeit with credentials redacted), so
+    // it is only available in development.
+    if app.config.env == "development" {
+        for _, method := range debugEchoMethods {
+            routes = append(routes, route{method, "/v1/debug/echo", app.handleDebugEcho, accessPublic, "Echo the request back (development only)"})
+        }
+    }
+
+    return app.versionRoutes(1, routes)
+}
+
+// registerV2 returns the routes of version 2 of the API. The handlers and models
+// are shared with version 1; they check contextGetAPIVersion() for the places where
+// the versions differ, such as readRuntimeFormat() writing runtimes as a plain
+// number of minutes. Only the endpoints whose representation has changed are
+// listed here, and everything else is served by version 1 alone.
+func (app *application) registerV2() []route {
+    routes := []route{
+        {http.MethodGet, "/v2/movies", app.handleListMovies, accessPublic, "List movies"},
+        {http.MethodPost, "/v2/movies", app.handleCreateMovie, accessPublic, "Create a movie"},
+        {http.MethodGet, "/v2/movies/:id", app.formatSuffix(app.handleGetMovieByID), accessPublic, "Show a movie (as JSON, or XML with a .xml suffix)"},
+        {http.MethodPatch, "/v2/movies/:id", app.handleUpdateMovie, accessPublic, "Update a movie"},
+        {http.MethodDelete, "/v2/movies/:id", app.handleDeleteMovie, accessPublic, "Delete a movie"},
+        {http.MethodGet, "/v2/movies/:id/versions/:version", app.handleGetMovieVersion, accessPublic, "Show a version of a movie"},
+    }
+
+    return app.versionRoutes(2, routes)
+}
+
+// versionRoutes wraps the handler of each route so that the API version is added
+// to the request context before it runs.
+func (app *application) versionRoutes(version int, routes []route) []route {
+    for i := range routes {
+        next := routes[i].handler
+        routes[i].handler = func(w http.ResponseWriter, r *http.Request) {
+            next(w, app.contextSetAPIVersion(r, version))
+        }
+    }
+
+    return routes
+}
+
 func (app *application) routes() http.Handler {
 
     router := httprouter.New()
 
     // http.handlerFunc acts as an adapter to convert notFoundResponse() to an http.Handler
     // This is then set as the custome error handler for 404 Not Found responses from the router
-    router.NotFound = http.HandlerFunc(app.notFoundResponse)
+    router.NotFound = http.HandlerFunc(app.routeNotFoundResponse)
 
     // Likewise, methodNotAllowedResponse is set as the custom error handler for 405 Method Not Allowed
     router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-    router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.handleHealthCheck)
+    // Register every route in the route table, wrapping its handler with the
+    // middleware its access requires. A write route without a declared access is a
+    // programming error, so we panic and the server fails to start.
+    for _, rt := range app.routeTable() {
+        handler := rt.handler
+
+        switch rt.access {
+        case accessAdmin:
+            handler = app.requireAdmin(handler)
+        case accessUnset:
+            switch rt.method {
+            case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+                panic(fmt.Sprintf("route %s %s must declare its access", rt.method, rt.path))
+            }
+        }
+
+        if d, ok := deprecations[rt.path]; ok {
+            handler = app.deprecated(rt.path, d, handler)
+        }
+
+        router.HandlerFunc(rt.method, app.apiPath(rt.path), handler)
+    }
 
+    // The middleware wraps the router as a whole, so it applies to every version of
+    // the API alike.
+    handler := app.rejectDuringShutdown(app.rejectDuringMaintenance(app.rejectWritesWhenReadOnly(app.rateLimit(app.limitConcurrency(app.requestTimeout(app.requireJSON(router)))))))
+
+    // In production we want recoverPanic() to catch any panic in a handler, so that
+    // the client gets a proper 500 response and the error is logged in our usual
+    // format. The catch is that it hides the crash: the process carries on, and the
+    // stack trace is lost unless someone reads the logs. When developing it is often
+    // more useful to run with -recover-panics=false, so that a panic propagates to
+    // net/http, which logs the full stack trace to stderr (and a test of the handler
+    // fails loudly at the panic rather than with an unexpected 500).
+    if app.config.recoverPanics {
+        handler = app.recoverPanic(handler)
+    }
+
+    // The metrics() middleware goes outside recoverPanic(), so that the 500 responses
+    // it sends after a panic are counted too.
+    return app.metrics(handler)
+}
 
-    router.HandlerFunc(http.MethodGet, "/v1/movies", app.handleListMovies)
-    router.HandlerFunc(http.MethodPost, "/v1/movies", app.handleCreateMovie)
-    router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.handleGetMovieByID)
-    router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.handleUpdateMovie)
-    router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.handleDeleteMovie)
+// printRoutes writes the route table as a table of method, path, access and
+// summary, for the "routes" command.
+func (app *application) printRoutes(w io.Writer) error {
+    tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
 
-    router.HandlerFunc(http.MethodPost, "/v1/users", app.handleRegistUser)
+    fmt.Fprintln(tw, "METHOD\tPATH\tACCESS\tSUMMARY")
+    for _, rt := range app.routeTable() {
+        summary := rt.summary
+        if d, ok := deprecations[rt.path]; ok {
+            summary += fmt.Sprintf(" (deprecated, sunset %s)", d.sunset.Format("2006-01-02"))
+        }
 
-    return app.recoverPanic(app.rateLimit(router)) 
+        fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", rt.method, app.apiPath(rt.path), rt.access, summary)
+    }
 
+    return tw.Flush()
 }
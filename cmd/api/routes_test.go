@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBasePath(t *testing.T) {
+    tests := []struct {
+        flag string
+        want string
+    }{
+        {"", ""},
+        {"/", ""},
+        {"catalog", "/catalog"},
+        {"/catalog", "/catalog"},
+        {"/catalog/", "/catalog"},
+        {"/api/v2/", "/api/v2"},
+    }
+
+    for _, tt := range tests {
+        t.Run(fmt.Sprintf("%q", tt.flag), func(t *testing.T) {
+            basePath := normalizeBasePath(tt.flag)
+            if basePath != tt.want {
+                t.Fatalf("normalizeBasePath(%q) = %q; want %q", tt.flag, basePath, tt.want)
+            }
+
+            app := newTestApplication(t, nil)
+            app.config.basePath = basePath
+            ts := newTestServer(t, app.routes())
+
+            if res := ts.get(t, basePath+"/v1/healthcheck"); res.status != http.StatusOK {
+                t.Errorf("GET %s/v1/healthcheck: status = %d; want %d", basePath, res.status, http.StatusOK)
+            }
+
+            // Without the prefix, the routes aren't there.
+            if basePath != "" {
+                if res := ts.get(t, "/v1/healthcheck"); res.status != http.StatusNotFound {
+                    t.Errorf("GET /v1/healthcheck: status = %d; want %d", res.status, http.StatusNotFound)
+                }
+            }
+
+            if got, want := app.apiPath("/v1/movies/1"), basePath+"/v1/movies/1"; got != want {
+                t.Errorf("apiPath() = %q; want %q", got, want)
+            }
+
+            var buf bytes.Buffer
+            if err := app.printRoutes(&buf); err != nil {
+                t.Fatal(err)
+            }
+            for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+                fields := strings.Fields(line)
+                if len(fields) < 2 || !strings.HasPrefix(fields[1], basePath+"/v") && fields[1] != basePath+debugVarsPath {
+                    t.Errorf("route %q isn't under %q", line, basePath)
+                }
+            }
+        })
+    }
+}
+
+// The expvar endpoint is only open to admins, and includes our own counters.
+func TestDebugVars(t *testing.T) {
+    app := newTestApplication(t, nil)
+    app.config.admin.token = "secret"
+    ts := newTestServer(t, app.routes())
+
+    if res := ts.get(t, debugVarsPath); res.status != http.StatusUnauthorized {
+        t.Errorf("without a token: status = %d; want %d", res.status, http.StatusUnauthorized)
+    }
+
+    res := ts.do(t, http.MethodGet, debugVarsPath, nil, http.Header{"Authorization": {"Bearer secret"}})
+    if res.status != http.StatusOK {
+        t.Fatalf("status = %d; want %d", res.status, http.StatusOK)
+    }
+
+    var vars map[string]json.RawMessage
+    res.decode(t, &vars)
+    for _, name := range []string{"memstats", "httpclient_breakers", "limiter", "concurrency"} {
+        if _, ok := vars[name]; !ok {
+            t.Errorf("%s isn't in the expvars", name)
+        }
+    }
+}
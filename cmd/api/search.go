@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// maxSuggestions is the number of suggestions returned by the search suggestions
+// endpoint.
+const maxSuggestions = 10
+
+// handleSuggestMovies returns up to 10 movies whose titles start with or are
+// similar to the q query string parameter, for a search box's typeahead. The
+// results aren't cached, as the trigram index keeps the query fast.
+func (app *application) handleSuggestMovies(w http.ResponseWriter, r *http.Request) {
+    v := validator.New()
+
+    q := strings.TrimSpace(app.readString(r.URL.Query(), "q", ""))
+
+    v.Check(q != "", "q", "required")
+    v.CheckParams(len(q) <= 100, "q", "query_too_long", map[string]string{"max": "100"})
+
+    if !v.Valid() {
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    movies, err := app.models.Movies.Suggest(r.Context(), q, maxSuggestions)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+        return
+    }
+
+    err = app.writeJSON(w, http.StatusOK, app.envelopeSuggestions(movies), nil)
+    if err != nil {
+        app.serverErrorResponse(w, r, err)
+    }
+}
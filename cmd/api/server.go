@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,8 +12,17 @@ import (
 	"time"
 )
 
+// serve starts the server, then runs the rest of the startup work and marks the
+// instance as ready (see start()), and serves requests until the process is
+// signalled to shut down.
+func (app *application) serve(startup func() error) error {
+    // Every request context is derived from baseCtx. If the graceful shutdown doesn't
+    // complete before its deadline we cancel it, so that requests which are still
+    // running (and the database queries they are waiting on) are aborted rather than
+    // outliving the server.
+    baseCtx, cancelBase := context.WithCancel(context.Background())
+    defer cancelBase()
 
-func (app *application) serve() error {
     // Declare a HTTP server using the same settings as in our main() function.
     srv := &http.Server{
         Addr: fmt.Sprintf(":%d", app.config.port),
@@ -20,12 +30,42 @@ func (app *application) serve() error {
         IdleTimeout: time.Minute,
         ReadTimeout: 10 * time.Second,
         WriteTimeout: 30 * time.Second,
+        BaseContext: func(net.Listener) context.Context {
+            return baseCtx
+        },
     }
 
     // Create a shutdownError channel. We will use this to receive any errors 
     // returned by the graceful Shutdown() function.
     shutdownError := make(chan error)
 
+    // Start the background tasks, which run until the shutdown begins: logging the
+    // connection pool statistics, checking the movies' links, reloading the feature
+    // flags, flushing the movie view counts, revalidating movies, and warning about
+    // default credentials.
+    backgroundCtx, stopBackground := context.WithCancel(context.Background())
+    defer stopBackground()
+
+    if app.config.db.statsInterval > 0 {
+        go app.logDBStats(backgroundCtx, app.config.db.statsInterval)
+    }
+
+    if app.config.movies.checkLinks {
+        go app.checkLinks(backgroundCtx)
+    }
+
+    if app.config.features.refreshInterval > 0 {
+        go app.refreshFeatureFlags(backgroundCtx, app.config.features.refreshInterval)
+    }
+
+    go app.flushMovieViewsPeriodically(backgroundCtx)
+
+    go app.revalidateMovies(backgroundCtx)
+
+    if len(app.insecureDefaults) > 0 {
+        go app.warnInsecureDefaults(backgroundCtx, app.insecureDefaults)
+    }
+
     // Start background go routine
     go func() {
         // Create a quit channel which carries os.Signal values
@@ -47,16 +87,10 @@ func (app *application) serve() error {
             "signal": s.String(),
         })
 
-        // Create a context with a 5 second timeout.
-        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-        defer cancel()
+        stopBackground()
 
-        // Call Shutdown() on our server, passing in the context we just made.
-        // Shutdown() will return nil if the graceful shutdown was successful, or an error
-        // (which may happen because of a problem clsoing the listeners, or because
-        // the shutdown didn't complete before the 5-second context deadline is hit).
-        // We relay this return value to the shutdownError channel.
-        shutdownError <- srv.Shutdown(ctx)
+        // We relay the return value of the shutdown to the shutdownError channel.
+        shutdownError <- app.shutdown(srv, 5*time.Second, cancelBase)
     }()
 
     // likewise log a starting server message
@@ -65,12 +99,22 @@ func (app *application) serve() error {
         "env": app.config.env,
     })
 
-    // Calling Shutdown() on our server will cause ListenAndServe() to immediately 
-    // return a http.ErrServerClosed error. So if we see this error, it is actually
-    // a good thing and an indication that the graceful shutdown has started.
-    // So we check specifically for this, only returning the error if it is NOT
-    // htt.ErrServerClosed.
-    err := srv.ListenAndServe()
+    ln, err := net.Listen("tcp", srv.Addr)
+    if err != nil {
+        return err
+    }
+
+    served, err := app.start(srv, ln, startup, cancelBase)
+    if err != nil {
+        return err
+    }
+
+    // Calling Shutdown() on our server will cause Serve() to immediately return a
+    // http.ErrServerClosed error. So if we see this error, it is actually a good
+    // thing and an indication that the graceful shutdown has started. So we check
+    // specifically for this, only returning the error if it is NOT
+    // http.ErrServerClosed.
+    err = <-served
     if !errors.Is(err, http.ErrServerClosed){
         return err
     }
@@ -79,6 +123,14 @@ func (app *application) serve() error {
     // the shutdownError channel. If return value is an error, we know that
     // there was a problem with the graceful shutdown and we return the error.
     err = <-shutdownError
+
+    // Now that no more requests are being served, flush the views counted since the
+    // last periodic flush, so that they aren't lost. This is done even if the
+    // shutdown timed out, as the counts are still good.
+    flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+    app.flushMovieViews(flushCtx)
+    cancelFlush()
+
     if err != nil {
         return err
     }
@@ -92,15 +144,51 @@ func (app *application) serve() error {
     return nil
 }
 
+// start serves requests on the listener in the background, and then runs startup.
+// While it runs, the readiness endpoint reports that the instance is not ready, so
+// that it isn't sent traffic until the startup work is done; once it returns the
+// instance is marked as ready. If startup fails, the server is shut down and its
+// error returned. Otherwise start returns a channel which receives the error from
+// Serve() once the server stops.
+func (app *application) start(srv *http.Server, ln net.Listener, startup func() error, cancelBase context.CancelFunc) (<-chan error, error) {
+    served := make(chan error, 1)
+    go func() {
+        served <- srv.Serve(ln)
+    }()
 
+    err := startup()
+    if err != nil {
+        app.shutdown(srv, 5*time.Second, cancelBase)
+        return nil, err
+    }
 
+    app.ready.Store(true)
 
+    return served, nil
+}
 
+// shutdown gracefully shuts down the server, waiting up to timeout for the requests
+// in flight to finish. From the start the rejectDuringShutdown() middleware turns
+// new requests away, and the requests long-polling for changes to a movie are
+// released, as they could otherwise hold up the shutdown until its deadline.
+//
+// Shutdown() returns nil if the graceful shutdown was successful, or an error
+// (which may happen because of a problem closing the listeners, or because the
+// shutdown didn't complete before the deadline). If the deadline was hit we call
+// cancelBase, which cancels the base context of the server's requests, so that
+// those still in flight (and the database queries they are waiting on) are aborted
+// rather than outliving the server.
+func (app *application) shutdown(srv *http.Server, timeout time.Duration, cancelBase context.CancelFunc) error {
+    app.shuttingDown.Store(true)
+    app.movieChanges.shutdown()
+
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    err := srv.Shutdown(ctx)
+    if err != nil {
+        cancelBase()
+    }
 
-
-
-
-
-
-
-
+    return err
+}
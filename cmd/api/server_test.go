@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startSlowServer serves the routes of app through the rejectDuringShutdown()
+// middleware, with /slow registered as a handler which signals started when it
+// begins and then waits for release (or for its context to be cancelled), and
+// reports the error of its context on done. Everything else gets a 200 response.
+func startSlowServer(t *testing.T, app *application) (srv *http.Server, addr string, started, release chan struct{}, done chan error, cancelBase context.CancelFunc) {
+    t.Helper()
+
+    started = make(chan struct{})
+    release = make(chan struct{})
+    done = make(chan error, 1)
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+        close(started)
+        select {
+        case <-release:
+        case <-r.Context().Done():
+        }
+        done <- r.Context().Err()
+    })
+    mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+    baseCtx, cancelBase := context.WithCancel(context.Background())
+    t.Cleanup(cancelBase)
+
+    srv = &http.Server{
+        Handler: app.rejectDuringShutdown(mux),
+        BaseContext: func(net.Listener) context.Context {
+            return baseCtx
+        },
+    }
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    go srv.Serve(ln)
+    t.Cleanup(func() { srv.Close() })
+
+    return srv, ln.Addr().String(), started, release, done, cancelBase
+}
+
+func TestShutdownRejectsNewRequests(t *testing.T) {
+    app := newTestApplication(t, nil)
+    _, addr, started, release, done, _ := startSlowServer(t, app)
+
+    slow := make(chan int, 1)
+    go func() {
+        res, err := http.Get("http://" + addr + "/slow")
+        if err != nil {
+            slow <- 0
+            return
+        }
+        res.Body.Close()
+        slow <- res.StatusCode
+    }()
+    <-started
+
+    app.shuttingDown.Store(true)
+
+    // A new request is turned away, and told to retry on another connection...
+    req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/v1/movies", nil)
+    res, err := (&http.Client{Transport: &http.Transport{}}).Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    res.Body.Close()
+
+    if res.StatusCode != http.StatusServiceUnavailable {
+        t.Errorf("status = %d; want %d", res.StatusCode, http.StatusServiceUnavailable)
+    }
+    if !res.Close {
+        t.Error("response doesn't close the connection")
+    }
+    if res.Header.Get("Retry-After") == "" {
+        t.Error("no Retry-After header")
+    }
+
+    // ...while the request already in flight carries on as normal.
+    close(release)
+    if err := <-done; err != nil {
+        t.Errorf("in-flight request's context: %v; want it not to be cancelled", err)
+    }
+    if status := <-slow; status != http.StatusOK {
+        t.Errorf("in-flight request: status = %d; want %d", status, http.StatusOK)
+    }
+}
+
+func TestShutdownCancelsRequestsAfterDeadline(t *testing.T) {
+    app := newTestApplication(t, nil)
+    srv, addr, started, _, done, cancelBase := startSlowServer(t, app)
+
+    go func() {
+        res, err := http.Get("http://" + addr + "/slow")
+        if err == nil {
+            res.Body.Close()
+        }
+    }()
+    <-started
+
+    // The slow request never finishes by itself, so the shutdown hits its deadline
+    // and the request's context is cancelled.
+    err := app.shutdown(srv, 50*time.Millisecond, cancelBase)
+    if !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("shutdown() = %v; want context.DeadlineExceeded", err)
+    }
+    if !app.shuttingDown.Load() {
+        t.Error("shuttingDown isn't set")
+    }
+
+    select {
+    case err := <-done:
+        if !errors.Is(err, context.Canceled) {
+            t.Errorf("request's context error = %v; want context.Canceled", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("the request's context wasn't cancelled after the shutdown deadline")
+    }
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+    app := newTestApplication(t, nil)
+    srv, addr, started, release, done, cancelBase := startSlowServer(t, app)
+
+    go func() {
+        res, err := http.Get("http://" + addr + "/slow")
+        if err == nil {
+            res.Body.Close()
+        }
+    }()
+    <-started
+
+    // A request which finishes within the deadline isn't cancelled.
+    time.AfterFunc(20*time.Millisecond, func() { close(release) })
+
+    err := app.shutdown(srv, 5*time.Second, cancelBase)
+    if err != nil {
+        t.Errorf("shutdown() = %v; want nil", err)
+    }
+    if err := <-done; err != nil {
+        t.Errorf("request's context: %v; want it not to be cancelled", err)
+    }
+}
+
+// listenForStart returns a server for app's routes, and a listener on a free port
+// for it, to pass to start(). The instance isn't ready yet, and its readiness checks
+// pass.
+func listenForStart(t *testing.T, app *application) (*http.Server, net.Listener) {
+    t.Helper()
+
+    app.ready.Store(false)
+    app.readinessChecks = &checkCache{ttl: time.Second}
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    srv := &http.Server{Handler: app.routes()}
+    t.Cleanup(func() { srv.Close() })
+
+    return srv, ln
+}
+
+// getStatus makes a GET request to url and returns the status code of the response.
+func getStatus(t *testing.T, url string) int {
+    t.Helper()
+
+    res, err := http.Get(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    res.Body.Close()
+
+    return res.StatusCode
+}
+
+// The server listens while the startup work runs, and the readiness endpoint
+// reports that the instance isn't ready until the work is done.
+func TestStartReadiness(t *testing.T) {
+    app := newTestApplication(t, nil)
+    srv, ln := listenForStart(t, app)
+    ready := "http://" + ln.Addr().String() + "/v1/healthz/ready"
+
+    var duringStartup int
+    served, err := app.start(srv, ln, func() error {
+        duringStartup = getStatus(t, ready)
+        return nil
+    }, func() {})
+    if err != nil {
+        t.Fatalf("start() = %v", err)
+    }
+
+    if duringStartup != http.StatusServiceUnavailable {
+        t.Errorf("during startup: status = %d; want %d", duringStartup, http.StatusServiceUnavailable)
+    }
+    if got := getStatus(t, ready); got != http.StatusOK {
+        t.Errorf("after startup: status = %d; want %d", got, http.StatusOK)
+    }
+
+    app.shutdown(srv, time.Second, func() {})
+    if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+        t.Errorf("Serve() = %v; want http.ErrServerClosed", err)
+    }
+}
+
+// If the startup work fails, the server is shut down and the instance never
+// becomes ready.
+func TestStartFailure(t *testing.T) {
+    app := newTestApplication(t, nil)
+    srv, ln := listenForStart(t, app)
+
+    failed := errors.New("dependency checks failed")
+    _, err := app.start(srv, ln, func() error { return failed }, func() {})
+    if !errors.Is(err, failed) {
+        t.Fatalf("start() = %v; want %v", err, failed)
+    }
+
+    if app.ready.Load() {
+        t.Error("the instance is ready after its startup work failed")
+    }
+    if _, err := http.Get("http://" + ln.Addr().String() + "/v1/healthcheck"); err == nil {
+        t.Error("the server is still serving after its startup work failed")
+    }
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/clock"
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/data/datatest"
+	"github.com/agpelkey/greenlight/internal/jsonlog"
+	"github.com/agpelkey/greenlight/internal/mailer"
+)
+
+func TestMain(m *testing.M) {
+    datatest.Main(m)
+}
+
+// newTestConfig returns the config that the server gets from its flag defaults,
+// except that the rate limiter is off, so that tests can make as many requests as
+// they like.
+func newTestConfig() config {
+    var cfg config
+
+    cfg.env = "development"
+    cfg.db.maxOpenConns = 25
+    cfg.db.maxIdleConns = 25
+    cfg.db.maxIdleTime = "15m"
+    cfg.db.acquireTimeout = time.Second
+    cfg.concurrency.max = 200
+    cfg.concurrency.export = 4
+    cfg.concurrency.longPoll = 1000
+    cfg.concurrency.wait = 500 * time.Millisecond
+    cfg.recoverPanics = true
+    cfg.smtp.sender = "Greenlight <no-reply@greenlight.alexedwards.net>"
+    cfg.smtp.encryption = string(mailer.EncryptionSTARTTLS)
+    cfg.smtp.timeout = 5 * time.Second
+    cfg.pagination.defaultPage = 1
+    cfg.pagination.defaultPageSize = 20
+    cfg.pagination.maxPageSize = 100
+    cfg.movies.maxGenres = data.DefaultMaxGenres
+    cfg.movies.maxTitleBytes = data.DefaultMaxTitleBytes
+    cfg.movies.maxWaiters = 50
+    cfg.movies.fuzzyThreshold = 0.3
+    cfg.movies.ftsLanguage = "simple"
+    cfg.share.maxTTL = 30 * 24 * time.Hour
+    cfg.idType = idTypeBigserial
+    cfg.responseSizeBudget = 1 << 20
+    cfg.maxRequestTimeout = 30 * time.Second
+    cfg.features.refreshInterval = 30 * time.Second
+    cfg.maintenance.retryAfter = 5 * time.Minute
+
+    return cfg
+}
+
+// newTestApplication returns an application with the test config, which logs to
+// nowhere. Its models use db, which may be nil for tests of handlers that don't
+// touch the database; tests which do should pass newTestDB(t).
+func newTestApplication(t *testing.T, db *sql.DB) *application {
+    t.Helper()
+
+    cfg := newTestConfig()
+
+    app := &application{
+        config: cfg,
+        logger: jsonlog.New(io.Discard, jsonlog.LevelInfo),
+        db: db,
+        mailer: newMailer(cfg),
+        clock: clock.Real{},
+        limiters: newClientLimiters(),
+        concurrency: newConcurrencyLimiter(cfg.concurrency.max, map[string]int{
+            concurrencyClassExport: cfg.concurrency.export,
+            concurrencyClassLongPoll: cfg.concurrency.longPoll,
+        }, concurrencyClassLongPoll),
+        revalidationStarted: make(chan struct{}, 1),
+    }
+
+    if db != nil {
+        app.models = data.NewModels(db)
+        app.models.SetAcquireTimeout(cfg.db.acquireTimeout)
+        app.readinessChecks = &checkCache{checks: databaseChecks(db)[:2], ttl: 10 * time.Second}
+    }
+
+    app.ready.Store(true)
+
+    return app
+}
+
+// newTestDB returns a migrated database for the test alone (see datatest.NewDB),
+// skipping the test if there is no database to test against.
+func newTestDB(t *testing.T) *sql.DB {
+    t.Helper()
+
+    return datatest.NewDB(t)
+}
+
+// testServer is an HTTP server for the application's routes, with helpers for
+// making requests to it.
+type testServer struct {
+    *httptest.Server
+}
+
+// newTestServer starts a server for the handler, which is closed when the test
+// finishes.
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+    t.Helper()
+
+    ts := httptest.NewServer(h)
+    t.Cleanup(ts.Close)
+
+    return &testServer{ts}
+}
+
+// testResponse is the part of a response that tests check.
+type testResponse struct {
+    status int
+    header http.Header
+    body []byte
+}
+
+// decode unmarshals the response body into dst, failing the test if it isn't valid
+// JSON.
+func (res testResponse) decode(t *testing.T, dst interface{}) {
+    t.Helper()
+
+    err := json.Unmarshal(res.body, dst)
+    if err != nil {
+        t.Fatalf("decoding response body %q: %v", res.body, err)
+    }
+}
+
+// do sends a request to the server with the given body (which may be nil) and
+// headers, and reads the whole response.
+func (ts *testServer) do(t *testing.T, method, path string, body io.Reader, header http.Header) testResponse {
+    t.Helper()
+
+    req, err := http.NewRequest(method, ts.URL+path, body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+
+    res, err := ts.Client().Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer res.Body.Close()
+
+    resBody, err := io.ReadAll(res.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return testResponse{status: res.StatusCode, header: res.Header, body: resBody}
+}
+
+// debugVar reads the named expvar from the /debug/vars endpoint into dst, with the
+// admin token that the tests set ("secret").
+func (ts *testServer) debugVar(t *testing.T, name string, dst interface{}) {
+    t.Helper()
+
+    res := ts.do(t, http.MethodGet, debugVarsPath, nil, http.Header{"Authorization": {"Bearer secret"}})
+    if res.status != http.StatusOK {
+        t.Fatalf("GET %s: status = %d; want %d", debugVarsPath, res.status, http.StatusOK)
+    }
+
+    var vars map[string]json.RawMessage
+    res.decode(t, &vars)
+
+    value, ok := vars[name]
+    if !ok {
+        t.Fatalf("%s isn't in the expvars", name)
+    }
+    if err := json.Unmarshal(value, dst); err != nil {
+        t.Fatalf("decoding the %s expvar %s: %v", name, value, err)
+    }
+}
+
+// get sends a GET request to the server.
+func (ts *testServer) get(t *testing.T, path string) testResponse {
+    t.Helper()
+
+    return ts.do(t, http.MethodGet, path, nil, nil)
+}
+
+// sendJSON sends a request to the server with the value encoded as a JSON body.
+// A string or []byte value is sent as it is, for tests of malformed bodies.
+func (ts *testServer) sendJSON(t *testing.T, method, path string, value interface{}) testResponse {
+    t.Helper()
+
+    var body []byte
+    switch v := value.(type) {
+    case string:
+        body = []byte(v)
+    case []byte:
+        body = v
+    default:
+        var err error
+        body, err = json.Marshal(value)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    return ts.do(t, method, path, bytes.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+}
+
+// postJSON sends a POST request to the server with a JSON body.
+func (ts *testServer) postJSON(t *testing.T, path string, value interface{}) testResponse {
+    t.Helper()
+
+    return ts.sendJSON(t, http.MethodPost, path, value)
+}
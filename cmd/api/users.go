@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/mailer"
 	"github.com/agpelkey/greenlight/internal/validator"
 )
 
@@ -24,13 +25,15 @@ func (app *application) handleRegistUser(w http.ResponseWriter, r *http.Request)
         return
     }
 
-    // Copy the data from the request body into a new user struct. Notice also that we
-    // set the Activated field to false, which isnt strictly necessary because the
-    // Activated field will have the zero-value of false by default. But setting this
-    // explicitly helps to make our intentions clear to anyone
+    // Copy the data from the request body into a new user struct, normalizing the
+    // email address so that it is validated and stored in its canonical form.
+    // Notice also that we set the Activated field to false, which isnt strictly
+    // necessary because the Activated field will have the zero-value of false by
+    // default. But setting this explicitly helps to make our intentions clear to
+    // anyone
     user := &data.User{
         Name: input.Name,
-        Email: input.Email,
+        Email: data.NormalizeEmail(input.Email),
         Activated: false,
     }
 
@@ -47,20 +50,35 @@ func (app *application) handleRegistUser(w http.ResponseWriter, r *http.Request)
     // Validate the user struct and return the error messages to the client if any
     // of the checks fail.
     if data.ValidateUser(v, user); !v.Valid() {
-        app.failedValidationResponse(w, r, v.Errors)
+        app.failedValidationResponse(w, r, v)
+        return
+    }
+
+    // Check that we'll be able to send the welcome email before creating the user.
+    // This is stricter than the check in ValidateUser(), as it parses the address
+    // fully and (with the -smtp-check-mx flag) checks that its domain exists.
+    err = mailer.ValidateRecipient(r.Context(), user.Email, app.config.smtp.checkMX)
+    if err != nil {
+        switch {
+        case errors.Is(err, mailer.ErrInvalidRecipient):
+            v.AddError("email", "invalid_email")
+            app.failedValidationResponse(w, r, v)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
         return
     }
 
     // Insert the user data into the database
-    err = app.models.Users.Insert(user)
+    err = app.models.Users.Insert(r.Context(), user)
     if err != nil {
         switch {
         // If we get a ErrDuplicateEmail error, use the v.AddError() method 
         // to manually add a message to the validator instance, and then call our
         // failedValidationResponse() helper.
         case errors.Is(err, data.ErrDuplicateEmail):
-            v.AddError("email", "a user with this email address already exists")
-            app.failedValidationResponse(w, r, v.Errors)
+            v.AddError("email", "email_taken")
+            app.failedValidationResponse(w, r, v)
         default:
             app.serverErrorResponse(w, r, err)
         }
@@ -69,7 +87,9 @@ func (app *application) handleRegistUser(w http.ResponseWriter, r *http.Request)
     }
 
     // Call the Send() method to our Mailer, passing in the user's email address,
-    // name of the template file, and the User struct containing the new user's data
+    // name of the template file, and the User struct containing the new user's data.
+    // The address was checked by ValidateRecipient() above and the user now exists,
+    // so a failure here is ours rather than the client's, whatever the error.
     err = app.mailer.Send(user.Email, "user_welcome.tmpl", user)
     if err != nil {
         app.serverErrorResponse(w, r, err)
@@ -78,7 +98,7 @@ func (app *application) handleRegistUser(w http.ResponseWriter, r *http.Request)
 
     // Write a JSON response containing the user data along with a 201 Created
     // status code.
-    err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+    err = app.writeJSON(w, http.StatusCreated, app.envelopeUser(user), nil)
     if err != nil {
         app.serverErrorResponse(w, r, err)
     }
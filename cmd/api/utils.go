@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/agpelkey/greenlight/internal/data"
+	"github.com/agpelkey/greenlight/internal/httpclient"
 	"github.com/agpelkey/greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -60,7 +64,7 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
     // validator instance and return the default defaultValue.
     i, err := strconv.Atoi(s)
     if err != nil {
-        v.AddError(key, "must be an integer value")
+        v.AddError(key, "integer")
         return defaultValue
     }
 
@@ -68,6 +72,25 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
     return i
 }
 
+// The readBool() helper reads a boolean value ("true", "false", "1", "0" etc.) from the
+// query string. If no matching key could be found it returns the provided default value,
+// and if the value couldn't be parsed it records an error in the Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+    s := qs.Get(key)
+
+    if s == "" {
+        return defaultValue
+    }
+
+    b, err := strconv.ParseBool(s)
+    if err != nil {
+        v.AddError(key, "boolean")
+        return defaultValue
+    }
+
+    return b
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 
     // use http.MaxBytesReader to limit the size of the request body to 1MB
@@ -142,15 +165,41 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 }
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, header http.Header) error {
-    // Encode the data to JSON, returning the error if there was one
-    js, err := json.MarshalIndent(data, "", "\t")
+    js, err := app.marshalJSON(data)
     if err != nil {
         return err
     }
 
+    app.writeEncodedJSON(w, status, js, header)
+
+    return nil
+}
+
+// The marshalJSON() helper encodes a response body as writeJSON() sends it. It is
+// separate so that the size of a response can be measured before it is sent (see
+// fitSizeBudget()). The body is indented unless -compact-json is set.
+func (app *application) marshalJSON(data envelope) ([]byte, error) {
+    // Encode the data to JSON, returning the error if there was one
+    var js []byte
+    var err error
+    if app.config.compactJSON {
+        js, err = json.Marshal(data)
+    } else {
+        js, err = json.MarshalIndent(data, "", "\t")
+    }
+    if err != nil {
+        return nil, err
+    }
+
     // append a new line to make it easier to view in terminal applications
     js = append(js, '\n')
 
+    return js, nil
+}
+
+// The writeEncodedJSON() helper sends a response body which has already been
+// encoded by marshalJSON().
+func (app *application) writeEncodedJSON(w http.ResponseWriter, status int, js []byte, header http.Header) {
     for key, value := range header {
         w.Header()[key] = value
     }
@@ -158,18 +207,290 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(status)
     w.Write(js)
+}
 
-    return nil 
+// The movieRules() helper returns the validation policy for movies, as set by the
+// command-line flags.
+func (app *application) movieRules() data.MovieRules {
+    return data.MovieRules{
+        GenresAllowlist: app.config.movies.genresAllowlist,
+        MaxGenres: app.config.movies.maxGenres,
+        MaxTitleBytes: app.config.movies.maxTitleBytes,
+    }
 }
 
+// The readIDParam() helper returns the "id" URL parameter as an int64. Only the
+// canonical form of an ID is accepted: ParseInt() on its own would also accept
+// values such as "01" or "+5", which would give the same record several URLs (and
+// so mismatched cache keys and ETags). We check for this by formatting the parsed
+// ID back into a string and comparing it to the original.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
     params := httprouter.ParamsFromContext(r.Context())
 
     id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
-    if err != nil || id < 1{
+    if err != nil || id < 1 || strconv.FormatInt(id, 10) != params.ByName("id") {
         return 0, errors.New("invalid id parameter")
 
     }
 
     return id, nil
 }
+
+// The readMovieIDParam() helper reads the movie identified by the "id" URL parameter
+// and returns its ID. With the default -id-type=bigserial the parameter is the ID
+// itself; with -id-type=uuid it is the movie's UUID, which we look up. If the
+// parameter is malformed or there is no such movie, a 404 Not Found response is
+// sent and ok is false.
+func (app *application) readMovieIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+    if app.config.idType != idTypeUUID {
+        id, err := app.readIDParam(r)
+        if err != nil {
+            app.notFoundResponse(w, r)
+            return 0, false
+        }
+        return id, true
+    }
+
+    // Check that the parameter is a well-formed UUID before going to the database,
+    // in place of the id < 1 shortcut used for sequential IDs.
+    uuid := httprouter.ParamsFromContext(r.Context()).ByName("id")
+    if !validator.Matches(uuid, *validator.UUIDRX) {
+        app.notFoundResponse(w, r)
+        return 0, false
+    }
+
+    id, err := app.models.Movies.GetIDByUUID(r.Context(), strings.ToLower(uuid))
+    if err != nil {
+        switch {
+        case errors.Is(err, data.ErrRecordNotFound):
+            app.notFoundResponse(w, r)
+        default:
+            app.serverErrorResponse(w, r, err)
+        }
+        return 0, false
+    }
+
+    return id, true
+}
+
+// The movieID() helper returns the identifier of a movie as it appears in JSON
+// responses: its int64 ID, or its UUID string when -id-type=uuid is set.
+func (app *application) movieID(movie *data.Movie) interface{} {
+    if app.config.idType == idTypeUUID {
+        return movie.UUID
+    }
+
+    return movie.ID
+}
+
+// The movieIDString() helper returns the identifier of a movie as it appears in the
+// API: its ID, or its UUID when -id-type=uuid is set.
+func (app *application) movieIDString(movie *data.Movie) string {
+    if app.config.idType == idTypeUUID {
+        return movie.UUID
+    }
+
+    return strconv.FormatInt(movie.ID, 10)
+}
+
+// The hasPreference() helper reports whether the client sent the given preference
+// (e.g. "return=minimal") in a Prefer request header, as described in RFC 7240.
+// The header can be repeated and each one can hold a comma-separated list of
+// preferences, any of which may have parameters after a semicolon that we ignore.
+func (app *application) hasPreference(r *http.Request, preference string) bool {
+    for _, header := range r.Header.Values("Prefer") {
+        for _, value := range strings.Split(header, ",") {
+            value, _, _ = strings.Cut(value, ";")
+            if strings.EqualFold(strings.TrimSpace(value), preference) {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+// The writeMinimal() helper sends a response with the given status and headers and
+// an empty body, for clients which asked for one with "Prefer: return=minimal". The
+// Preference-Applied header tells them that their preference was honored.
+func (app *application) writeMinimal(w http.ResponseWriter, status int, header http.Header) {
+    for key, value := range header {
+        w.Header()[key] = value
+    }
+
+    w.Header().Add("Preference-Applied", "return=minimal")
+    w.WriteHeader(status)
+}
+
+// The movieETag() helper returns the entity tag for the current version of a movie.
+// Because the version number is incremented on every change, it identifies the
+// representation just as well as a hash of the body would.
+func (app *application) movieETag(movie *data.Movie) string {
+    return fmt.Sprintf(`"%s-%d"`, app.movieIDString(movie), movie.Version)
+}
+
+// The localizedMovieETag() helper returns the entity tag for a movie as it is shown
+// to a client, whose title may have been translated into the client's language. A
+// translation can change without the movie's version changing, so a translated
+// title adds a checksum of itself to the tag. Untranslated movies have the same tag
+// as movieETag().
+func (app *application) localizedMovieETag(movie *data.Movie) string {
+    if movie.LocalizedTitle == "" || movie.LocalizedTitle == movie.Title {
+        return app.movieETag(movie)
+    }
+
+    return fmt.Sprintf(`"%s-%d-%08x"`, app.movieIDString(movie), movie.Version, crc32.ChecksumIEEE([]byte(movie.LocalizedTitle)))
+}
+
+// The etagMatches() helper reports whether the value of an If-None-Match header
+// matches the entity tag, using the weak comparison that If-None-Match calls for:
+// a "W/" prefix on either side is ignored. The header may list several tags, or be
+// "*" to match any.
+func etagMatches(ifNoneMatch, etag string) bool {
+    for _, tag := range strings.Split(ifNoneMatch, ",") {
+        tag = strings.TrimSpace(tag)
+        if tag == "*" || (tag != "" && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/")) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// The readDryRun() helper reports whether the client asked for a mutation to be
+// checked without being saved, with either a dry_run=true query string parameter or
+// a "Prefer: dry-run" header. An invalid dry_run value is recorded in the validator.
+func (app *application) readDryRun(r *http.Request, v *validator.Validator) bool {
+    return app.readBool(r.URL.Query(), "dry_run", false, v) || app.hasPreference(r, "dry-run")
+}
+
+// The movieModel() helper returns the movie model to make changes with, which rolls
+// back instead of saving them if this is a dry run.
+func (app *application) movieModel(dryRun bool) data.MovieModel {
+    if dryRun {
+        return app.models.Movies.DryRun()
+    }
+
+    return app.models.Movies
+}
+
+// The readRuntimeFormat() helper returns the runtime_format query string value,
+// which selects how movie runtimes are written in the response: "mins" (the
+// default, e.g. "107 mins") or "hm" (e.g. "1h 47m"). Any other value is recorded
+// in the validator. Version 2 of the API always writes runtimes as a plain number
+// of minutes (e.g. 107), so the parameter is ignored there.
+func (app *application) readRuntimeFormat(r *http.Request, v *validator.Validator) string {
+    if app.contextGetAPIVersion(r) >= 2 {
+        return data.RuntimeFormatInt
+    }
+
+    format := app.readString(r.URL.Query(), "runtime_format", data.RuntimeFormatMins)
+
+    v.Check(validator.In(format, data.RuntimeFormatMins, data.RuntimeFormatHM), "runtime_format", "invalid_runtime_format")
+
+    return format
+}
+
+// The normalizeBasePath() helper gives the -base-path a leading slash and no trailing
+// slash, so that "api", "/api" and "/api/" all mean the same thing, and "/" means no
+// prefix at all.
+func normalizeBasePath(basePath string) string {
+    if basePath = strings.Trim(basePath, "/"); basePath != "" {
+        basePath = "/" + basePath
+    }
+
+    return basePath
+}
+
+// The apiPath() helper prefixes a path such as "/v1/movies" with the -base-path, for
+// deployments behind a gateway which routes to us under a prefix such as "/api".
+// All of the routes are registered under it, and any URLs that we send to clients
+// (such as in Location headers) must be built with it too.
+func (app *application) apiPath(path string) string {
+    return app.config.basePath + path
+}
+
+// The newOutboundClient() helper returns a client for calls to third-party services,
+// which identifies us with a User-Agent of "greenlight/<version>", followed by the
+// -outbound-contact-url if one is set, and makes its connections from the
+// -outbound-bind-ip if one is set.
+func newOutboundClient(cfg config, c httpclient.Config) *httpclient.Client {
+    c.UserAgent = "greenlight/" + version
+    if cfg.outbound.contactURL != "" {
+        c.UserAgent += fmt.Sprintf(" (+%s)", cfg.outbound.contactURL)
+    }
+    c.LocalAddr = cfg.outbound.bindIP
+
+    return httpclient.New(c)
+}
+
+// The nonEmpty() helper returns nil if the string is nil or empty, and otherwise
+// the string itself. It is used for optional fields, such as the movie links, for
+// which an empty string in the request means "no value".
+func nonEmpty(s *string) *string {
+    if s == nil || *s == "" {
+        return nil
+    }
+
+    return s
+}
+
+// patchField holds an optional field of a PATCH request body, which can be absent,
+// null or a value. With a plain pointer field, absent and null both decode to nil,
+// so a client couldn't clear the field by sending null. Set is true if the field
+// was in the body, and Value is nil if it was null.
+type patchField[T any] struct {
+    Set bool
+    Value *T
+}
+
+func (f *patchField[T]) UnmarshalJSON(b []byte) error {
+    f.Set = true
+
+    if string(b) == "null" {
+        f.Value = nil
+        return nil
+    }
+
+    var value T
+    err := json.Unmarshal(b, &value)
+    if err != nil {
+        // The decoder doesn't add the field name or position to errors from inside
+        // an UnmarshalJSON() method, so say what was expected instead.
+        var unmarshalTypeError *json.UnmarshalTypeError
+        if errors.As(err, &unmarshalTypeError) {
+            return fmt.Errorf("body contains incorrect JSON type (%s where %s was expected)", unmarshalTypeError.Value, unmarshalTypeError.Type)
+        }
+        return err
+    }
+
+    f.Value = &value
+    return nil
+}
+
+// MarshalJSON writes the value, or null, so that the input echoed back in a
+// validation error response looks like what the client sent.
+func (f patchField[T]) MarshalJSON() ([]byte, error) {
+    return json.Marshal(f.Value)
+}
+
+// The parseIPNet() helper parses either a CIDR range such as "10.0.0.0/8" or a
+// single IP address, which is treated as a range containing only that address.
+func parseIPNet(s string) (*net.IPNet, error) {
+    if strings.Contains(s, "/") {
+        _, network, err := net.ParseCIDR(s)
+        return network, err
+    }
+
+    ip := net.ParseIP(s)
+    if ip == nil {
+        return nil, fmt.Errorf("invalid IP address %q", s)
+    }
+
+    bits := 8 * net.IPv6len
+    if ip4 := ip.To4(); ip4 != nil {
+        ip, bits = ip4, 8*net.IPv4len
+    }
+
+    return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
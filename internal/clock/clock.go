@@ -0,0 +1,164 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used by the application. Code that makes decisions
+// based on the current time (limiter eviction, expiry checks and so on) should
+// ask a Clock rather than calling time.Now() directly, so that tests can swap in
+// a Fake and move time forward without sleeping.
+type Clock interface {
+    Now() time.Time
+    Since(t time.Time) time.Duration
+    NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of *time.Ticker behavior that we rely on.
+type Ticker interface {
+    C() <-chan time.Time
+    Stop()
+}
+
+// Real is a Clock backed by the time package.
+type Real struct{}
+
+func (Real) Now() time.Time {
+    return time.Now()
+}
+
+func (Real) Since(t time.Time) time.Duration {
+    return time.Since(t)
+}
+
+func (Real) NewTicker(d time.Duration) Ticker {
+    return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+    ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+    return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+    t.ticker.Stop()
+}
+
+// Fake is a Clock whose time only changes when Advance() or Set() is called. Any
+// tickers created from it fire (at most once per call, just like a real ticker
+// drops ticks for slow receivers) when the clock is moved past their next tick.
+type Fake struct {
+    mu sync.Mutex
+    now time.Time
+    tickers []*fakeTicker
+}
+
+// NewFake returns a Fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+    return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+    return f.Now().Sub(t)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+    if d <= 0 {
+        panic("non-positive interval for clock.Fake.NewTicker")
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    t := &fakeTicker{
+        clock: f,
+        c: make(chan time.Time, 1),
+        interval: d,
+        next: f.now.Add(d),
+    }
+    f.tickers = append(f.tickers, t)
+
+    return t
+}
+
+// Tickers returns the number of tickers created from the clock which haven't been
+// stopped. A test can wait for it to go up before moving the clock, to be sure that
+// a goroutine has created its ticker and so won't miss the tick.
+func (f *Fake) Tickers() int {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    return len(f.tickers)
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.set(f.now.Add(d))
+}
+
+// Set moves the clock to the given time.
+func (f *Fake) Set(now time.Time) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.set(now)
+}
+
+// set must be called with the mutex held.
+func (f *Fake) set(now time.Time) {
+    f.now = now
+
+    for _, t := range f.tickers {
+        if t.next.After(now) {
+            continue
+        }
+
+        // Send without blocking, so that a ticker nobody is reading from can't
+        // deadlock the test.
+        select {
+        case t.c <- now:
+        default:
+        }
+
+        for !t.next.After(now) {
+            t.next = t.next.Add(t.interval)
+        }
+    }
+}
+
+type fakeTicker struct {
+    clock *Fake
+    c chan time.Time
+    interval time.Duration
+    next time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+    return t.c
+}
+
+func (t *fakeTicker) Stop() {
+    t.clock.mu.Lock()
+    defer t.clock.mu.Unlock()
+
+    for i, ticker := range t.clock.tickers {
+        if ticker == t {
+            t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+            break
+        }
+    }
+}
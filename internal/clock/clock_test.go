@@ -0,0 +1,77 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeNowAndSince(t *testing.T) {
+    start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+    c := NewFake(start)
+
+    if got := c.Now(); !got.Equal(start) {
+        t.Fatalf("Now() = %v; want %v", got, start)
+    }
+
+    c.Advance(90 * time.Second)
+    if got := c.Since(start); got != 90*time.Second {
+        t.Errorf("Since() = %v; want 1m30s", got)
+    }
+
+    later := start.Add(time.Hour)
+    c.Set(later)
+    if got := c.Now(); !got.Equal(later) {
+        t.Errorf("Now() after Set() = %v; want %v", got, later)
+    }
+}
+
+func TestFakeTicker(t *testing.T) {
+    start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+    c := NewFake(start)
+
+    ticker := c.NewTicker(time.Minute)
+    if c.Tickers() != 1 {
+        t.Fatalf("Tickers() = %d; want 1", c.Tickers())
+    }
+
+    // Just short of the interval, the ticker doesn't fire.
+    c.Advance(time.Minute - time.Second)
+    select {
+    case tick := <-ticker.C():
+        t.Fatalf("ticked at %v before the interval had passed", tick)
+    default:
+    }
+
+    // At the interval it fires once, with the clock's time.
+    c.Advance(time.Second)
+    select {
+    case tick := <-ticker.C():
+        if !tick.Equal(start.Add(time.Minute)) {
+            t.Errorf("tick = %v; want %v", tick, start.Add(time.Minute))
+        }
+    default:
+        t.Fatal("didn't tick once the interval had passed")
+    }
+
+    // Jumping several intervals at once gives a single tick, as a real ticker
+    // drops the ticks that a slow receiver misses.
+    c.Advance(5 * time.Minute)
+    <-ticker.C()
+    select {
+    case tick := <-ticker.C():
+        t.Fatalf("ticked twice for one Advance(), the second time at %v", tick)
+    default:
+    }
+
+    ticker.Stop()
+    if c.Tickers() != 0 {
+        t.Fatalf("Tickers() after Stop() = %d; want 0", c.Tickers())
+    }
+
+    c.Advance(time.Hour)
+    select {
+    case tick := <-ticker.C():
+        t.Fatalf("stopped ticker ticked at %v", tick)
+    default:
+    }
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// ratingSystems maps the country codes that we accept certifications for to the
+// ratings of their classification system, ordered from least to most restrictive.
+// The order is what lets us find movies rated "at or below" a given rating.
+var ratingSystems = map[string][]string{
+    // Motion Picture Association (United States)
+    "US": {"G", "PG", "PG-13", "R", "NC-17"},
+    // British Board of Film Classification (United Kingdom)
+    "GB": {"U", "PG", "12A", "12", "15", "18", "R18"},
+    // Freiwillige Selbstkontrolle der Filmwirtschaft (Germany)
+    "DE": {"0", "6", "12", "16", "18"},
+}
+
+// Certifications maps a country code (e.g. "US") to the age rating given to a movie
+// in that country (e.g. "PG-13"). It is stored in a JSONB column, and implements
+// the driver.Valuer and sql.Scanner interfaces to convert to and from it.
+type Certifications map[string]string
+
+func (c Certifications) Value() (driver.Value, error) {
+    if c == nil {
+        return "{}", nil
+    }
+
+    js, err := json.Marshal(c)
+    if err != nil {
+        return nil, err
+    }
+
+    return string(js), nil
+}
+
+func (c *Certifications) Scan(src interface{}) error {
+    var js []byte
+
+    switch src := src.(type) {
+    case []byte:
+        js = src
+    case string:
+        js = []byte(src)
+    case nil:
+        *c = nil
+        return nil
+    default:
+        return errors.New("unsupported type for certifications")
+    }
+
+    return json.Unmarshal(js, c)
+}
+
+// ratingsUpTo returns the ratings of a system which are at or below the given
+// rating, or nil if the system or rating is unknown.
+func ratingsUpTo(system, rating string) []string {
+    ratings := ratingSystems[system]
+
+    for i, r := range ratings {
+        if r == rating {
+            return ratings[:i+1]
+        }
+    }
+
+    return nil
+}
+
+func ratingSystemNames() string {
+    names := make([]string, 0, len(ratingSystems))
+    for name := range ratingSystems {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    return strings.Join(names, ", ")
+}
+
+// validateRating records an error under the given key if the system or rating is
+// unknown, and returns whether they were valid.
+func validateRating(v *validator.Validator, key, system, rating string) bool {
+    ratings, ok := ratingSystems[system]
+    if !ok {
+        v.AddErrorParams(key, "unknown_rating_system", map[string]string{
+            "system": system,
+            "systems": ratingSystemNames(),
+        })
+        return false
+    }
+
+    if !validator.In(rating, ratings...) {
+        v.AddErrorParams(key, "invalid_rating", map[string]string{
+            "rating": rating,
+            "system": system,
+            "ratings": strings.Join(ratings, ", "),
+        })
+        return false
+    }
+
+    return true
+}
+
+func ValidateCertifications(v *validator.Validator, certifications Certifications) {
+    // Check the systems in a fixed order, so that the same error is reported each
+    // time for a given invalid input.
+    systems := make([]string, 0, len(certifications))
+    for system := range certifications {
+        systems = append(systems, system)
+    }
+    sort.Strings(systems)
+
+    for _, system := range systems {
+        if !validateRating(v, "certifications", system, certifications[system]) {
+            return
+        }
+    }
+}
+
+// Values for the MovieSearch.Unrated field, controlling whether movies without any
+// certifications are included in the results.
+const (
+    UnratedInclude = "include"
+    UnratedExclude = "exclude"
+    UnratedOnly = "only"
+)
+
+// ParseCertificationFilter parses the values of the certification query string
+// parameter, each in the form "SYSTEM:RATING" (e.g. "US:PG-13"), into a map of
+// system to maximum rating. Any problems are recorded in the validator.
+func ParseCertificationFilter(v *validator.Validator, values []string) map[string]string {
+    filter := make(map[string]string)
+
+    for _, value := range values {
+        system, rating, ok := strings.Cut(value, ":")
+        if !ok || system == "" || rating == "" {
+            v.AddError("certification", "invalid_certification_filter")
+            return nil
+        }
+
+        if !validateRating(v, "certification", system, rating) {
+            return nil
+        }
+
+        filter[system] = rating
+    }
+
+    return filter
+}
+
+func ValidateMovieSearch(v *validator.Validator, search MovieSearch) {
+    v.Check(validator.In(search.Unrated, UnratedInclude, UnratedExclude, UnratedOnly), "unrated", "invalid_unrated")
+}
+
+// certificationFilterJSON returns the certification filter as a JSON object mapping
+// each system to the list of ratings which satisfy it, for use in the GetAll()
+// query.
+func certificationFilterJSON(filter map[string]string) (string, error) {
+    allowed := make(map[string][]string, len(filter))
+    for system, rating := range filter {
+        allowed[system] = ratingsUpTo(system, rating)
+    }
+
+    js, err := json.Marshal(allowed)
+    if err != nil {
+        return "", err
+    }
+
+    return string(js), nil
+}
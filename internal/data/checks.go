@@ -0,0 +1,65 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// requiredTables lists the tables that the application expects to exist. Add new
+// tables here as migrations create them.
+var requiredTables = []string{"movies", "movie_versions", "movie_translations", "users", "feature_flags", "movie_views", "movie_shares", "movie_revalidations", "movie_revalidation_results", "movie_deletions"}
+
+// CheckSchema verifies that the migrations have been applied cleanly and that all
+// of the tables the application uses exist. The migration state is read from the
+// schema_migrations table maintained by the migrate tool, which marks it dirty if a
+// migration failed part way through.
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+    var version int64
+    var dirty bool
+
+    err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+    if err != nil {
+        return fmt.Errorf("reading migration version: %w", err)
+    }
+    if dirty {
+        return fmt.Errorf("migration %d is dirty", version)
+    }
+
+    for _, table := range requiredTables {
+        var exists bool
+
+        err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+        if err != nil {
+            return err
+        }
+        if !exists {
+            return fmt.Errorf("table %q does not exist (migration version %d)", table, version)
+        }
+    }
+
+    return nil
+}
+
+// CheckPrivileges verifies that the database user can read from and write to the
+// movies table, by running a SELECT and an INSERT in a transaction that is always
+// rolled back, so nothing is left behind.
+func CheckPrivileges(ctx context.Context, db *sql.DB) error {
+    tx, err := db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.ExecContext(ctx, `SELECT id FROM movies LIMIT 1`)
+    if err != nil {
+        return fmt.Errorf("select: %w", err)
+    }
+
+    _, err = tx.ExecContext(ctx, `INSERT INTO movies (title, genres) VALUES ('greenlight check', '{check}')`)
+    if err != nil {
+        return fmt.Errorf("insert: %w", err)
+    }
+
+    return nil
+}
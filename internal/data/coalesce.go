@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"expvar"
+	"sync"
+)
+
+// When a popular record is requested by many clients at once, there's no point in
+// running the same query for each of them. A coalescer lets concurrent callers
+// asking for the same key share a single call: the first caller runs it, and the
+// others wait for its result. The number of callers which were served by somebody
+// else's call is published under the "coalesced_requests" expvar, keyed by the
+// name of the coalescer, so that it shows up at /debug/vars.
+var coalescedRequests = expvar.NewMap("coalesced_requests")
+
+type coalescedCall struct {
+    done chan struct{}
+    val interface{}
+    err error
+}
+
+type coalescer struct {
+    name string
+    mu sync.Mutex
+    calls map[string]*coalescedCall
+}
+
+func newCoalescer(name string) *coalescer {
+    return &coalescer{name: name, calls: make(map[string]*coalescedCall)}
+}
+
+// do runs fn for the key, unless a call for the same key is already in flight, in
+// which case it waits for and returns that call's result (including its error).
+//
+// The call runs with its own background context rather than the caller's, so fn
+// must apply its own timeout. This means that a caller giving up (because its
+// context is cancelled) doesn't cancel the call for everybody else waiting on it;
+// the caller just stops waiting and gets its context's error back.
+//
+// The same value is returned to every caller, so it must not be modified. Callers
+// which need to modify it should make a copy.
+func (c *coalescer) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+    c.mu.Lock()
+
+    call, found := c.calls[key]
+    if found {
+        c.mu.Unlock()
+        coalescedRequests.Add(c.name, 1)
+    } else {
+        call = &coalescedCall{done: make(chan struct{})}
+        c.calls[key] = call
+        c.mu.Unlock()
+
+        go func() {
+            call.val, call.err = fn(context.Background())
+
+            c.mu.Lock()
+            delete(c.calls, key)
+            c.mu.Unlock()
+
+            close(call.done)
+        }()
+    }
+
+    select {
+    case <-call.done:
+        return call.val, call.err
+    case <-ctx.Done():
+        return nil, ctx.Err()
+    }
+}
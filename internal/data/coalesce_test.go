@@ -0,0 +1,91 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// coalescedCount returns the coalesced_requests counter of the named coalescer.
+func coalescedCount(name string) int64 {
+    if v, ok := coalescedRequests.Get(name).(*expvar.Int); ok {
+        return v.Value()
+    }
+    return 0
+}
+
+// Concurrent callers share a single call, whose error reaches every one of them
+// that is still waiting, and a caller giving up doesn't cancel the call for the
+// others.
+func TestCoalescerDo(t *testing.T) {
+    c := newCoalescer("test_do")
+    base := coalescedCount("test_do")
+    errQuery := errors.New("query failed")
+
+    var calls atomic.Int32
+    started := make(chan struct{})
+    release := make(chan struct{})
+    fn := func(ctx context.Context) (interface{}, error) {
+        if calls.Add(1) == 1 {
+            close(started)
+        }
+        select {
+        case <-release:
+            return nil, errQuery
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+    }
+
+    results := make(chan error, 3)
+    do := func(ctx context.Context) {
+        _, err := c.do(ctx, "movie:1", fn)
+        results <- err
+    }
+
+    // The first caller starts the call, and the others join it, as the counter
+    // shows.
+    go do(context.Background())
+    <-started
+
+    cancelled, cancel := context.WithCancel(context.Background())
+    go do(cancelled)
+    go do(context.Background())
+
+    deadline := time.Now().Add(time.Second)
+    for coalescedCount("test_do")-base < 2 {
+        if time.Now().After(deadline) {
+            t.Fatalf("coalesced_requests went up by %d; want 2", coalescedCount("test_do")-base)
+        }
+        time.Sleep(time.Millisecond)
+    }
+
+    // The caller which gives up gets its context's error straight away...
+    cancel()
+    if err := <-results; !errors.Is(err, context.Canceled) {
+        t.Fatalf("cancelled caller: err = %v; want context.Canceled", err)
+    }
+
+    // ...while the call carries on, and its error goes to both remaining callers.
+    close(release)
+    for i := 0; i < 2; i++ {
+        if err := <-results; !errors.Is(err, errQuery) {
+            t.Errorf("caller %d: err = %v; want the query's error", i+1, err)
+        }
+    }
+
+    if n := calls.Load(); n != 1 {
+        t.Errorf("fn was called %d times; want 1", n)
+    }
+
+    // Once the call has finished, the next caller starts a new one.
+    if _, err := c.do(context.Background(), "movie:1", fn); !errors.Is(err, errQuery) {
+        t.Errorf("later caller: err = %v; want the query's error", err)
+    }
+    if n := calls.Load(); n != 2 {
+        t.Errorf("fn was called %d times; want 2", n)
+    }
+}
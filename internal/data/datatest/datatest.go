@@ -0,0 +1,296 @@
+// Package datatest provides throwaway PostgreSQL databases for tests which need a
+// real database, such as the integration tests of the models and the handler tests
+// of the API.
+//
+// The first call to NewDB starts a PostgreSQL container with Docker (through
+// ory/dockertest) and applies the migrations to a template database. Every test
+// then gets a database of its own, copied from the template, so that tests can't
+// see each other's rows and can run in parallel. If the GREENLIGHT_TEST_DB_DSN
+// environment variable is set, the PostgreSQL server it points to is used instead
+// of a container; its user needs permission to create databases. When neither is
+// available the tests which need a database are skipped.
+//
+// Packages which use NewDB should call Main from their TestMain, so that the
+// container is removed when their tests finish.
+package datatest
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/migrations"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+
+	_ "github.com/lib/pq"
+)
+
+// templateDB is the name of the database which the migrations are applied to, and
+// which every test database is copied from.
+const templateDB = "greenlight_template"
+
+// server is the PostgreSQL server shared by the tests of a package.
+var server struct {
+    once sync.Once
+    // dsn connects to the server's maintenance database, for creating and dropping
+    // the test databases.
+    dsn string
+    // skip is set, instead of dsn, when there is no server to test against.
+    skip string
+    err error
+
+    pool *dockertest.Pool
+    resource *dockertest.Resource
+}
+
+// databases counts the test databases created, to give each a unique name.
+var databases atomic.Int64
+
+// Main runs the tests and then removes the PostgreSQL container, if one was
+// started. Call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//	    datatest.Main(m)
+//	}
+func Main(m *testing.M) {
+    code := m.Run()
+
+    if server.resource != nil {
+        if err := server.pool.Purge(server.resource); err != nil {
+            fmt.Fprintf(os.Stderr, "datatest: removing the postgres container: %v\n", err)
+        }
+    }
+
+    os.Exit(code)
+}
+
+// NewDB returns a connection pool for a new, fully migrated database which only
+// the calling test uses. The database is dropped when the test finishes. If there
+// is no PostgreSQL server to test against, the test is skipped.
+func NewDB(t testing.TB) *sql.DB {
+    t.Helper()
+
+    server.once.Do(start)
+    if server.skip != "" {
+        t.Skip(server.skip)
+    }
+    if server.err != nil {
+        t.Fatal(server.err)
+    }
+
+    admin, err := sql.Open("postgres", server.dsn)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer admin.Close()
+
+    name := fmt.Sprintf("greenlight_test_%d_%d", os.Getpid(), databases.Add(1))
+
+    _, err = admin.Exec(fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", name, templateDB))
+    if err != nil {
+        t.Fatalf("creating test database: %v", err)
+    }
+
+    db, err := sql.Open("postgres", withDatabase(server.dsn, name))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    t.Cleanup(func() {
+        db.Close()
+
+        admin, err := sql.Open("postgres", server.dsn)
+        if err != nil {
+            t.Errorf("dropping test database: %v", err)
+            return
+        }
+        defer admin.Close()
+
+        _, err = admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", name))
+        if err != nil {
+            t.Errorf("dropping test database: %v", err)
+        }
+    })
+
+    return db
+}
+
+// Truncate empties every table in the database (other than the migration state),
+// and restarts the ID sequences, for tests which run several cases against the same
+// database.
+func Truncate(t testing.TB, db *sql.DB) {
+    t.Helper()
+
+    rows, err := db.Query(`
+        SELECT quote_ident(tablename)
+        FROM pg_tables
+        WHERE schemaname = 'public' AND tablename <> 'schema_migrations'`)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer rows.Close()
+
+    var tables []string
+    for rows.Next() {
+        var table string
+        if err := rows.Scan(&table); err != nil {
+            t.Fatal(err)
+        }
+        tables = append(tables, table)
+    }
+    if err := rows.Err(); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(tables) == 0 {
+        return
+    }
+
+    _, err = db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE")
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+// start finds or starts the PostgreSQL server, and creates the template database.
+func start() {
+    server.dsn = os.Getenv("GREENLIGHT_TEST_DB_DSN")
+
+    if server.dsn == "" {
+        pool, err := dockertest.NewPool("")
+        if err == nil {
+            err = pool.Client.Ping()
+        }
+        if err != nil {
+            server.skip = fmt.Sprintf("no database to test against: Docker isn't available (%v) and GREENLIGHT_TEST_DB_DSN isn't set", err)
+            return
+        }
+
+        server.pool = pool
+        server.dsn, server.err = startContainer(pool)
+        if server.err != nil {
+            return
+        }
+    }
+
+    server.err = createTemplate(server.dsn)
+}
+
+// startContainer starts a PostgreSQL container and waits for it to accept
+// connections. The container is set to be removed after ten minutes in any case, in
+// case the tests are killed before Main can remove it.
+func startContainer(pool *dockertest.Pool) (string, error) {
+    resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+        Repository: "postgres",
+        Tag: "15-alpine",
+        Env: []string{"POSTGRES_PASSWORD=greenlight", "POSTGRES_DB=postgres"},
+    }, func(config *docker.HostConfig) {
+        config.AutoRemove = true
+        config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+    })
+    if err != nil {
+        return "", fmt.Errorf("starting postgres container: %w", err)
+    }
+    server.resource = resource
+
+    resource.Expire(600)
+
+    dsn := fmt.Sprintf("postgres://postgres:greenlight@%s/postgres?sslmode=disable", resource.GetHostPort("5432/tcp"))
+
+    pool.MaxWait = time.Minute
+    err = pool.Retry(func() error {
+        db, err := sql.Open("postgres", dsn)
+        if err != nil {
+            return err
+        }
+        defer db.Close()
+
+        return db.Ping()
+    })
+    if err != nil {
+        return "", fmt.Errorf("waiting for postgres: %w", err)
+    }
+
+    return dsn, nil
+}
+
+// createTemplate (re)creates the template database, applies the up migrations to it
+// in order, and records the final version in schema_migrations as the migrate tool
+// would, so that data.CheckSchema() passes.
+func createTemplate(dsn string) error {
+    admin, err := sql.Open("postgres", dsn)
+    if err != nil {
+        return err
+    }
+    defer admin.Close()
+
+    _, err = admin.Exec("DROP DATABASE IF EXISTS " + templateDB + " WITH (FORCE)")
+    if err == nil {
+        _, err = admin.Exec("CREATE DATABASE " + templateDB)
+    }
+    if err != nil {
+        return fmt.Errorf("creating template database: %w", err)
+    }
+
+    db, err := sql.Open("postgres", withDatabase(dsn, templateDB))
+    if err != nil {
+        return err
+    }
+    // The template can't be copied while anyone is connected to it.
+    defer db.Close()
+
+    files, err := fs.Glob(migrations.FS, "*.up.sql")
+    if err != nil {
+        return err
+    }
+    sort.Strings(files)
+
+    var version int64
+    for _, file := range files {
+        script, err := fs.ReadFile(migrations.FS, file)
+        if err != nil {
+            return err
+        }
+
+        _, err = db.Exec(string(script))
+        if err != nil {
+            return fmt.Errorf("applying %s: %w", file, err)
+        }
+
+        version, err = strconv.ParseInt(file[:strings.Index(file, "_")], 10, 64)
+        if err != nil {
+            return fmt.Errorf("migration %s: %w", file, err)
+        }
+    }
+
+    _, err = db.Exec(`
+        CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL);
+        INSERT INTO schema_migrations (version, dirty) VALUES (` + strconv.FormatInt(version, 10) + `, false)`)
+    if err != nil {
+        return fmt.Errorf("recording migration version: %w", err)
+    }
+
+    return nil
+}
+
+// withDatabase returns the DSN with its database name replaced.
+func withDatabase(dsn, name string) string {
+    u, err := url.Parse(dsn)
+    if err != nil || u.Scheme == "" {
+        // A key=value DSN; a later dbname setting overrides an earlier one.
+        return dsn + " dbname=" + name
+    }
+
+    u.Path = "/" + name
+    return u.String()
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DB wraps the connection pool so that waiting for a connection has its own time
+// limit, separate from the timeout of the query which runs on it. Each of the query
+// methods below first takes a connection from the pool with Conn(), giving up after
+// AcquireTimeout, and only then runs the query on it. A query which can't get a
+// connection in time therefore fails with ErrPoolExhausted, and one which times out
+// afterwards fails with the usual context error, so the two can't be mistaken for
+// each other.
+//
+// The other methods of *sql.DB, such as Stats() and PingContext(), are used as they
+// are.
+type DB struct {
+    *sql.DB
+    // AcquireTimeout is how long a query waits for a connection from the pool. Zero
+    // means no limit other than the query's own context.
+    AcquireTimeout time.Duration
+}
+
+// conn takes a connection from the pool, waiting no longer than AcquireTimeout.
+// The caller must close the connection to return it to the pool.
+func (db *DB) conn(ctx context.Context) (*sql.Conn, error) {
+    acquireCtx := ctx
+    if db.AcquireTimeout > 0 {
+        var cancel context.CancelFunc
+        acquireCtx, cancel = context.WithTimeout(ctx, db.AcquireTimeout)
+        defer cancel()
+    }
+
+    conn, err := db.DB.Conn(acquireCtx)
+    if err != nil {
+        // Only our own deadline means that the pool ran out; if the caller's context
+        // is done, its error is returned as is.
+        if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+            return nil, fmt.Errorf("%w: no connection available after %s", ErrPoolExhausted, db.AcquireTimeout)
+        }
+        return nil, err
+    }
+
+    return conn, nil
+}
+
+// ExecContext runs a query which doesn't return rows on a connection taken from the
+// pool with conn().
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+    conn, err := db.conn(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    return conn.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs a query on a connection taken from the pool with conn(). The
+// connection is needed until the rows are closed, which is up to the caller. Close()
+// on a *sql.Conn waits for any rows still open on it before returning it to the
+// pool, so it is called in the background rather than here.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+    conn, err := db.conn(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    rows, err := conn.QueryContext(ctx, query, args...)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    go conn.Close()
+
+    return rows, nil
+}
+
+// BeginTx starts a transaction on a connection taken from the pool with conn(). As
+// with QueryContext(), the connection is returned to the pool in the background,
+// once the transaction is committed or rolled back.
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+    conn, err := db.conn(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    tx, err := conn.BeginTx(ctx, opts)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    go conn.Close()
+
+    return tx, nil
+}
+
+// Row is the result of DB.QueryRowContext(). It is used like a *sql.Row, but can
+// also hold the error from taking a connection, which a *sql.Row can't.
+type Row struct {
+    row *sql.Row
+    conn *sql.Conn
+    err error
+}
+
+// QueryRowContext runs a query which returns at most one row on a connection taken
+// from the pool with conn(). Any error is deferred until Scan() is called, which
+// also returns the connection to the pool.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *Row {
+    conn, err := db.conn(ctx)
+    if err != nil {
+        return &Row{err: err}
+    }
+
+    return &Row{row: conn.QueryRowContext(ctx, query, args...), conn: conn}
+}
+
+// Scan copies the columns of the row into dest, as with (*sql.Row).Scan(), and
+// returns the connection to the pool. If the query returned no rows, it returns
+// sql.ErrNoRows.
+func (r *Row) Scan(dest ...interface{}) error {
+    if r.err != nil {
+        return r.err
+    }
+    defer r.conn.Close()
+
+    return r.row.Scan(dest...)
+}
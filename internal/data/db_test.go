@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data/datatest"
+)
+
+// newSingleConnDB returns a pool for a test database which has a single connection,
+// so that a test can exhaust it.
+func newSingleConnDB(t *testing.T, acquireTimeout time.Duration) *DB {
+    t.Helper()
+
+    db := datatest.NewDB(t)
+    db.SetMaxOpenConns(1)
+
+    return &DB{DB: db, AcquireTimeout: acquireTimeout}
+}
+
+func TestDBAcquireTimeout(t *testing.T) {
+    db := newSingleConnDB(t, 50*time.Millisecond)
+    ctx := context.Background()
+
+    held, err := db.DB.Conn(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Every kind of query gives up once the acquire timeout passes, even though its
+    // own context has plenty of time left.
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+
+    var one int
+    checks := map[string]func() error{
+        "QueryRowContext": func() error { return db.QueryRowContext(ctx, `SELECT 1`).Scan(&one) },
+        "ExecContext": func() error { _, err := db.ExecContext(ctx, `SELECT 1`); return err },
+        "QueryContext": func() error { _, err := db.QueryContext(ctx, `SELECT 1`); return err },
+        "BeginTx": func() error { _, err := db.BeginTx(ctx, nil); return err },
+    }
+    for name, check := range checks {
+        start := time.Now()
+        err := check()
+        if !errors.Is(err, ErrPoolExhausted) {
+            t.Errorf("%s: err = %v; want ErrPoolExhausted", name, err)
+        }
+        if elapsed := time.Since(start); elapsed > time.Second {
+            t.Errorf("%s took %s to give up; want about the acquire timeout", name, elapsed)
+        }
+    }
+
+    // The caller's own deadline passing first isn't reported as an exhausted pool.
+    shortCtx, shortCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer shortCancel()
+    err = db.QueryRowContext(shortCtx, `SELECT 1`).Scan(&one)
+    if errors.Is(err, ErrPoolExhausted) || !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("caller's deadline: err = %v; want context.DeadlineExceeded", err)
+    }
+
+    held.Close()
+
+    err = db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+    if err != nil {
+        t.Fatalf("after the connection was released: %v", err)
+    }
+}
+
+// A slow query isn't mistaken for an exhausted pool: once it has a connection, it
+// fails with its own context's error.
+func TestDBQueryTimeout(t *testing.T) {
+    db := newSingleConnDB(t, time.Second)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+
+    _, err := db.ExecContext(ctx, `SELECT pg_sleep(1)`)
+    if err == nil || errors.Is(err, ErrPoolExhausted) {
+        t.Errorf("err = %v; want the query to be cancelled", err)
+    }
+}
+
+// TestDBSingleConnLoad runs many queries at once against a pool with a single
+// connection. Each either runs or fails with ErrPoolExhausted, and the connection
+// is back in the pool afterwards however the query ended.
+func TestDBSingleConnLoad(t *testing.T) {
+    db := newSingleConnDB(t, 100*time.Millisecond)
+
+    const workers = 20
+
+    var (
+        wg sync.WaitGroup
+        mu sync.Mutex
+        ran, exhausted int
+    )
+
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+
+            ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+            defer cancel()
+
+            var err error
+            switch i % 3 {
+            case 0:
+                _, err = db.ExecContext(ctx, `SELECT pg_sleep(0.05)`)
+            case 1:
+                var rows *sql.Rows
+                rows, err = db.QueryContext(ctx, `SELECT pg_sleep(0.05)`)
+                if err == nil {
+                    rows.Close()
+                }
+            default:
+                var s string
+                err = db.QueryRowContext(ctx, `SELECT pg_sleep(0.05)::text`).Scan(&s)
+            }
+
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                ran++
+            case errors.Is(err, ErrPoolExhausted):
+                exhausted++
+            default:
+                t.Errorf("worker %d: %v", i, err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    if ran == 0 || exhausted == 0 {
+        t.Errorf("%d queries ran and %d found the pool exhausted; want some of each", ran, exhausted)
+    }
+
+    // The connections of QueryContext() are returned to the pool in the background.
+    deadline := time.Now().Add(time.Second)
+    for db.Stats().InUse != 0 {
+        if time.Now().After(deadline) {
+            t.Fatalf("%d connections still in use after the queries finished", db.Stats().InUse)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    var one int
+    err := db.QueryRowContext(context.Background(), `SELECT 1`).Scan(&one)
+    if err != nil {
+        t.Fatalf("after the load: %v", err)
+    }
+}
@@ -0,0 +1,302 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// FeatureNameRX matches feature flag names, which are lowercase words separated by
+// underscores, dots or hyphens (e.g. "movies.expand").
+var FeatureNameRX = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+// FeatureFlagsChannel is the channel that a notification is sent on (with the name
+// of the flag as the payload) whenever a feature flag is changed, so that other
+// instances can reload their cache straight away rather than at their next refresh.
+const FeatureFlagsChannel = "feature_flags"
+
+// FeatureFlag turns a piece of new behavior on or off. An enabled flag with a
+// RolloutPercent below 100 is only on for that percentage of users, picked by a
+// hash of the flag name and user ID, so that a given user gets the same answer
+// every time (and different flags roll out to different users).
+type FeatureFlag struct {
+    Name string `json:"name"`
+    Enabled bool `json:"enabled"`
+    RolloutPercent int `json:"rollout_percent"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+func ValidateFeatureFlag(v *validator.Validator, flag *FeatureFlag) {
+    v.Check(FeatureNameRX.MatchString(flag.Name), "name", "invalid_feature_name")
+    v.CheckParams(len(flag.Name) <= 100, "name", "name_too_long", map[string]string{"max": "100"})
+    v.Check(flag.RolloutPercent >= 0 && flag.RolloutPercent <= 100, "rollout_percent", "invalid_percent")
+}
+
+// featureCache holds the feature flags in memory, so that checking a flag doesn't
+// need a query. It is filled by Refresh(), and updated straight away by Upsert()
+// and Delete().
+//
+// A refresh can race with those changes: its query may have read a flag before
+// a change was committed, and finish after the change was made to the cache. So
+// that the refresh doesn't undo the change, gen counts the changes made to the
+// cache, and changed records the gen of the latest change to each flag.
+type featureCache struct {
+    mu sync.RWMutex
+    flags map[string]FeatureFlag
+    gen uint64
+    changed map[string]uint64
+}
+
+// set records a change to a flag made on this instance: the flag is cached, or
+// removed from the cache if it is nil.
+func (c *featureCache) set(name string, flag *FeatureFlag) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.flags == nil {
+        c.flags = make(map[string]FeatureFlag)
+    }
+    if c.changed == nil {
+        c.changed = make(map[string]uint64)
+    }
+
+    if flag != nil {
+        c.flags[name] = *flag
+    } else {
+        delete(c.flags, name)
+    }
+
+    c.gen++
+    c.changed[name] = c.gen
+}
+
+// replace fills the cache with flags read from the database by a refresh which
+// started when the cache was at generation start. Flags changed on this instance
+// since then are kept as they are in the cache, as the refresh may have read them
+// before the change. Otherwise the flag read by the refresh is used, unless the
+// cached one was updated later, which happens when two refreshes overlap and the
+// older one finishes last.
+func (c *featureCache) replace(flags []*FeatureFlag, start uint64) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    cached := make(map[string]FeatureFlag, len(flags))
+    for _, flag := range flags {
+        if old, ok := c.flags[flag.Name]; ok && old.UpdatedAt.After(flag.UpdatedAt) {
+            cached[flag.Name] = old
+            continue
+        }
+        cached[flag.Name] = *flag
+    }
+
+    for name, gen := range c.changed {
+        if gen <= start {
+            // The change was committed before the refresh began, so the refresh saw it.
+            delete(c.changed, name)
+            continue
+        }
+
+        if flag, ok := c.flags[name]; ok {
+            cached[name] = flag
+        } else {
+            delete(cached, name)
+        }
+    }
+
+    c.flags = cached
+}
+
+// FeatureModel wraps the connection pool and the cache of feature flags. The cache
+// is shared by copies of the model.
+type FeatureModel struct {
+    DB *DB
+    cache *featureCache
+}
+
+// IsEnabled reports whether the named flag is on for the user. Flags which don't
+// exist (or haven't been loaded yet) are off, so new behavior stays off until it
+// is turned on explicitly. Requests without a user can pass a userID of 0, which
+// puts them all in the same bucket.
+func (m FeatureModel) IsEnabled(name string, userID int64) bool {
+    if m.cache == nil {
+        return false
+    }
+
+    m.cache.mu.RLock()
+    flag, ok := m.cache.flags[name]
+    m.cache.mu.RUnlock()
+
+    if !ok || !flag.Enabled {
+        return false
+    }
+
+    return rolloutBucket(name, userID) < flag.RolloutPercent
+}
+
+// rolloutBucket returns the bucket (0 to 99) that the user falls into for a flag.
+func rolloutBucket(name string, userID int64) int {
+    h := fnv.New32a()
+    h.Write([]byte(name + ":" + strconv.FormatInt(userID, 10)))
+
+    return int(h.Sum32() % 100)
+}
+
+// Refresh reloads the cache from the database, without undoing any changes made
+// through Upsert() or Delete() while it runs (see featureCache). A model without a
+// cache has nothing to refresh.
+func (m FeatureModel) Refresh(ctx context.Context) error {
+    if m.cache == nil {
+        return nil
+    }
+
+    m.cache.mu.RLock()
+    start := m.cache.gen
+    m.cache.mu.RUnlock()
+
+    flags, err := m.GetAll(ctx)
+    if err != nil {
+        return err
+    }
+
+    m.cache.replace(flags, start)
+
+    return nil
+}
+
+// GetAll returns every feature flag from the database, ordered by name.
+func (m FeatureModel) GetAll(ctx context.Context) ([]*FeatureFlag, error) {
+    query := `
+        SELECT name, enabled, rollout_percent, updated_at
+        FROM feature_flags
+        ORDER BY name`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    rows, err := m.DB.QueryContext(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    flags := []*FeatureFlag{}
+
+    for rows.Next() {
+        var flag FeatureFlag
+
+        err := rows.Scan(&flag.Name, &flag.Enabled, &flag.RolloutPercent, &flag.UpdatedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        flags = append(flags, &flag)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return flags, nil
+}
+
+// Upsert creates the feature flag, or updates it if it already exists, and updates
+// the cache of this instance. Other instances are notified on FeatureFlagsChannel,
+// and otherwise pick the change up at their next Refresh().
+func (m FeatureModel) Upsert(ctx context.Context, flag *FeatureFlag) error {
+    query := `
+        INSERT INTO feature_flags (name, enabled, rollout_percent)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (name) DO UPDATE
+        SET enabled = EXCLUDED.enabled, rollout_percent = EXCLUDED.rollout_percent, updated_at = NOW()
+        RETURNING updated_at`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    // The notification is sent in the same transaction as the change, so that it is
+    // only delivered (and the other instances only reload) once the change is
+    // committed.
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    err = tx.QueryRowContext(ctx, query, flag.Name, flag.Enabled, flag.RolloutPercent).Scan(&flag.UpdatedAt)
+    if err != nil {
+        return err
+    }
+
+    err = notifyFeatureFlag(ctx, tx, flag.Name)
+    if err != nil {
+        return err
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return err
+    }
+
+    if m.cache != nil {
+        m.cache.set(flag.Name, flag)
+    }
+
+    return nil
+}
+
+// Delete removes the named feature flag, which turns it off, and removes it from
+// the cache of this instance. As with Upsert(), the other instances are notified.
+// If there is no such flag an ErrRecordNotFound error is returned.
+func (m FeatureModel) Delete(ctx context.Context, name string) error {
+    query := `
+        DELETE FROM feature_flags
+        WHERE name = $1`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    result, err := tx.ExecContext(ctx, query, name)
+    if err != nil {
+        return err
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if rowsAffected == 0 {
+        return ErrRecordNotFound
+    }
+
+    err = notifyFeatureFlag(ctx, tx, name)
+    if err != nil {
+        return err
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return err
+    }
+
+    if m.cache != nil {
+        m.cache.set(name, nil)
+    }
+
+    return nil
+}
+
+func notifyFeatureFlag(ctx context.Context, tx *sql.Tx, name string) error {
+    _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, FeatureFlagsChannel, name)
+    return err
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/data/datatest"
+)
+
+// A refresh which read the flags before a change made on this instance doesn't
+// undo the change when it finishes afterwards.
+func TestFeatureCacheReplace(t *testing.T) {
+    t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    flag := func(name string, enabled bool, updatedAt time.Time) *FeatureFlag {
+        return &FeatureFlag{Name: name, Enabled: enabled, RolloutPercent: 100, UpdatedAt: updatedAt}
+    }
+
+    var c featureCache
+    c.set("kept", flag("kept", false, t0))
+    c.set("deleted", flag("deleted", true, t0))
+    c.set("gone", flag("gone", true, t0))
+
+    // A refresh starts, and reads the flags as they were.
+    start := c.gen
+    read := []*FeatureFlag{
+        flag("kept", false, t0),
+        flag("deleted", true, t0),
+        flag("updated", false, t0),
+        flag("remote", true, t0),
+    }
+
+    // Meanwhile, flags are changed on this instance.
+    c.set("updated", flag("updated", true, t0.Add(time.Minute)))
+    c.set("deleted", nil)
+    c.set("created", flag("created", true, t0.Add(time.Minute)))
+
+    c.replace(read, start)
+
+    want := map[string]bool{"kept": false, "updated": true, "remote": true, "created": true}
+    if len(c.flags) != len(want) {
+        t.Errorf("cached flags = %v; want %v", c.flags, want)
+    }
+    for name, enabled := range want {
+        got, ok := c.flags[name]
+        if !ok || got.Enabled != enabled {
+            t.Errorf("flag %q = %+v (cached %v); want enabled %v", name, got, ok, enabled)
+        }
+    }
+
+    // Once a refresh has started after the changes, it is trusted for them too.
+    c.replace([]*FeatureFlag{flag("updated", false, t0.Add(2 * time.Minute))}, c.gen)
+    if len(c.flags) != 1 || c.flags["updated"].Enabled {
+        t.Errorf("after a later refresh: cached flags = %v; want only \"updated\", disabled", c.flags)
+    }
+    if len(c.changed) != 0 {
+        t.Errorf("changed = %v; want the changes seen by the refresh forgotten", c.changed)
+    }
+
+    // A refresh which read an older version of a flag than the cache has, because
+    // it overlapped with a later one, keeps the newer version.
+    c.replace([]*FeatureFlag{flag("updated", true, t0)}, c.gen)
+    if c.flags["updated"].Enabled {
+        t.Errorf("an older refresh replaced a newer version of the flag: %+v", c.flags["updated"])
+    }
+}
+
+// A model without a cache (such as the zero value) reports every flag as off, and
+// has nothing to refresh.
+func TestFeatureModelWithoutCache(t *testing.T) {
+    var m FeatureModel
+
+    if m.IsEnabled("movies.fuzzy_search", 1) {
+        t.Error("IsEnabled() = true; want false")
+    }
+    if err := m.Refresh(context.Background()); err != nil {
+        t.Errorf("Refresh() = %v; want nil", err)
+    }
+}
+
+// Upsert() and Delete() work on a model without a cache, leaving the cache alone.
+func TestFeatureModelWithoutCacheWrites(t *testing.T) {
+    m := FeatureModel{DB: &DB{DB: datatest.NewDB(t)}}
+    ctx := context.Background()
+
+    err := m.Upsert(ctx, &FeatureFlag{Name: "movies.test", Enabled: true, RolloutPercent: 100})
+    if err != nil {
+        t.Fatalf("Upsert() = %v", err)
+    }
+    if err := m.Delete(ctx, "movies.test"); err != nil {
+        t.Fatalf("Delete() = %v", err)
+    }
+}
@@ -1,18 +1,43 @@
 package data
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/agpelkey/greenlight/internal/validator"
 )
 
+// Metadata holds the pagination details of a page of results. Every pagination
+// field is always included in the JSON, so that a search which matches nothing has
+// the well-defined metadata described by calculateMetadata() rather than an empty
+// object.
+//
+// Truncated is set when the page was cut short to keep the response within its
+// size budget, and Served is then the number of records that it holds. They are
+// only included when the page was truncated.
 type Metadata struct {
-    CurrentPage int `json:"current_page,omitempty"`
-    PageSize int `json:"page_size,omitempty"`
-    FirstPage int `json:"first_page,omitempty"`
-    LastPage int `json:"last_page,omitempty"`
-    TotalRecords int `json:"total_records,omitempty"`
+    CurrentPage int `json:"current_page"`
+    PageSize int `json:"page_size"`
+    FirstPage int `json:"first_page"`
+    LastPage int `json:"last_page"`
+    TotalRecords int `json:"total_records"`
+    Truncated bool `json:"truncated,omitempty"`
+    Served int `json:"served,omitempty"`
+}
+
+// Truncate returns a copy of the metadata for a page which holds only the first
+// served of its count records, or the metadata unchanged if it holds them all.
+func (m Metadata) Truncate(served, count int) Metadata {
+    if served < count {
+        m.Truncated = true
+        m.Served = served
+    }
+
+    return m
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata
@@ -20,6 +45,11 @@ type Metadata struct {
 // that the last page value is calculated using the math.Ceil() function, which rounds
 // up a float to the nearest integer. So, for example, if there were 12 records in total and a
 // page size of 5, the last page value would be math.Ceil(12/5) = 3.
+//
+// If there are no records, every field is zero (including current_page, as there
+// are no pages at all), which clients can check for with total_records == 0. The
+// same is true of a page past the last one, as the total is counted from the rows
+// returned by the query.
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
     if totalRecords == 0 {
         return Metadata{}
@@ -37,8 +67,13 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 type Filters struct {
     Page    int
     PageSize int
+    // MaxPageSize is the largest page size that a client is allowed to request.
+    MaxPageSize int
     Sort string
     SortSafelist []string
+    // Seed makes the order of a "random" sort repeatable, so that a shuffled list can
+    // be paged through. It is nil if the client didn't give one.
+    Seed *int64
 }
 
 func (f Filters) limit() int {
@@ -49,21 +84,65 @@ func (f Filters) offset() int {
     return (f.Page - 1) * f.PageSize
 }
 
+// sortExpressions maps the sort values which don't name a column to the SQL
+// expression that they sort by. These always sort in descending order, as their
+// names say (e.g. "most_viewed").
+var sortExpressions = map[string]string{
+    "most_viewed": movieViewsLast30Days,
+}
+
+// SortRandom is the sort value which shuffles the results. With a Seed the order is
+// that of an MD5 hash of each ID and the seed, which is the same on every request
+// with that seed (and mixes the IDs well enough for browsing); without one the
+// results are shuffled afresh each time, so paging through them isn't consistent.
+const SortRandom = "random"
+
+// ErrInvalidSort is returned by queries whose Filters have a Sort value that isn't
+// in the safelist. ValidateFilters() should always catch this first; the check in
+// sortColumn() is a second line of defence, so that a handler which forgets to
+// validate gets an error rather than building SQL from the client's input.
+var ErrInvalidSort = errors.New("invalid sort parameter")
+
+// sortColumnRX matches the plain column names that a sort value can refer to.
+var sortColumnRX = regexp.MustCompile(`^[a-z_]+$`)
+
 // Check that the client-provided Sort field matches one of the entries in our safelist
 // and if it does, extract the column name from the Sort field by stripping the leading 
-// hyphen character (if one exists)
-func (f Filters) sortColumn() string {
+// hyphen character (if one exists). The column name is also checked to be a plain
+// identifier, in case an unsafe value ever makes it into a safelist. If either
+// check fails, ErrInvalidSort is returned and nothing is interpolated into the query.
+func (f Filters) sortColumn() (string, error) {
     for _, safeValue := range f.SortSafelist {
         if f.Sort == safeValue {
-            return strings.TrimPrefix(f.Sort, "-")
+            if expression, ok := sortExpressions[f.Sort]; ok {
+                return expression, nil
+            }
+
+            // The seed is an integer, so it is safe to format into the query.
+            if f.Sort == SortRandom {
+                if f.Seed == nil {
+                    return "random()", nil
+                }
+                return fmt.Sprintf("md5(id::text || ':%d')", *f.Seed), nil
+            }
+
+            column := strings.TrimPrefix(f.Sort, "-")
+            if !sortColumnRX.MatchString(column) {
+                break
+            }
+            return column, nil
         }
     }
 
-    panic("unsafe sort paramter: " + f.Sort)
+    return "", fmt.Errorf("%w: %q", ErrInvalidSort, f.Sort)
 }
 
 // Return the sort direction ("ASC" or "DESC") depending on the prefix character of the Sort field
 func (f Filters) sortDirection() string {
+    if _, ok := sortExpressions[f.Sort]; ok {
+        return "DESC"
+    }
+
     if strings.HasPrefix(f.Sort, "-"){
         return "DESC"
     }
@@ -73,11 +152,13 @@ func (f Filters) sortDirection() string {
 
 func ValidateFilters (v *validator.Validator, f Filters) {
     // Check that the page and page_size parameters contain sensible values
-    v.Check(f.Page > 0, "page", "must be greater than zero")
-    v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-    v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-    v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+    v.Check(f.Page > 0, "page", "greater_than_zero")
+    v.Check(f.Page <= 10_000_000, "page", "page_too_large")
+    v.Check(f.PageSize > 0, "page_size", "greater_than_zero")
+    v.CheckParams(f.PageSize <= f.MaxPageSize, "page_size", "page_size_too_large", map[string]string{
+        "max": strconv.Itoa(f.MaxPageSize),
+    })
 
     // Check that the sort parameter matches a value in the safelist
-    v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+    v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid_sort")
 }
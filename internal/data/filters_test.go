@@ -0,0 +1,131 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestCalculateMetadata(t *testing.T) {
+    tests := []struct {
+        name string
+        totalRecords, page, pageSize int
+        want Metadata
+    }{
+        {"no records", 0, 1, 20, Metadata{}},
+        {"no records past the first page", 0, 3, 20, Metadata{}},
+        {"one page", 3, 1, 20, Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 3}},
+        {"partial last page", 12, 2, 5, Metadata{CurrentPage: 2, PageSize: 5, FirstPage: 1, LastPage: 3, TotalRecords: 12}},
+        {"full last page", 10, 2, 5, Metadata{CurrentPage: 2, PageSize: 5, FirstPage: 1, LastPage: 2, TotalRecords: 10}},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := calculateMetadata(tt.totalRecords, tt.page, tt.pageSize)
+            if got != tt.want {
+                t.Errorf("calculateMetadata(%d, %d, %d) = %+v; want %+v", tt.totalRecords, tt.page, tt.pageSize, got, tt.want)
+            }
+        })
+    }
+}
+
+// The metadata of an empty result has every pagination field, set to zero, rather
+// than being an empty object.
+func TestMetadataJSONEmpty(t *testing.T) {
+    js, err := json.Marshal(calculateMetadata(0, 1, 20))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    want := `{"current_page":0,"page_size":0,"first_page":0,"last_page":0,"total_records":0}`
+    if string(js) != want {
+        t.Errorf("metadata = %s; want %s", js, want)
+    }
+}
+
+func TestSortColumn(t *testing.T) {
+    seed := int64(42)
+    safelist := []string{"id", "title", "-title", "most_viewed", SortRandom}
+
+    tests := []struct {
+        name string
+        filters Filters
+        want string
+        wantDirection string
+        wantErr bool
+    }{
+        {name: "column", filters: Filters{Sort: "title"}, want: "title", wantDirection: "ASC"},
+        {name: "descending column", filters: Filters{Sort: "-title"}, want: "title", wantDirection: "DESC"},
+        {name: "expression", filters: Filters{Sort: "most_viewed"}, want: movieViewsLast30Days, wantDirection: "DESC"},
+        {name: "random", filters: Filters{Sort: SortRandom}, want: "random()", wantDirection: "ASC"},
+        {name: "seeded random", filters: Filters{Sort: SortRandom, Seed: &seed}, want: "md5(id::text || ':42')", wantDirection: "ASC"},
+        {name: "not in safelist", filters: Filters{Sort: "year"}, wantErr: true},
+        {name: "descending not in safelist", filters: Filters{Sort: "-id"}, wantErr: true},
+        {name: "injection", filters: Filters{Sort: "title; DROP TABLE movies"}, wantErr: true},
+        {name: "empty", filters: Filters{Sort: ""}, wantErr: true},
+        // An unsafe value which somehow made it into a safelist is still refused.
+        {
+            name: "unsafe value in safelist",
+            filters: Filters{Sort: "title; DROP TABLE movies", SortSafelist: []string{"title; DROP TABLE movies"}},
+            wantErr: true,
+        },
+        {
+            name: "quoted value in safelist",
+            filters: Filters{Sort: `-"title"`, SortSafelist: []string{`-"title"`}},
+            wantErr: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if tt.filters.SortSafelist == nil {
+                tt.filters.SortSafelist = safelist
+            }
+
+            got, err := tt.filters.sortColumn()
+            if tt.wantErr {
+                if !errors.Is(err, ErrInvalidSort) || got != "" {
+                    t.Errorf("sortColumn() = %q, %v; want ErrInvalidSort", got, err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("sortColumn(): %v", err)
+            }
+            if got != tt.want {
+                t.Errorf("sortColumn() = %q; want %q", got, tt.want)
+            }
+            if direction := tt.filters.sortDirection(); direction != tt.wantDirection {
+                t.Errorf("sortDirection() = %q; want %q", direction, tt.wantDirection)
+            }
+        })
+    }
+}
+
+// An unsafe sort value never reaches the query: GetAll() and StreamAll() return
+// ErrInvalidSort before touching the database. The model has no connection pool, so
+// any query would panic.
+func TestGetAllInvalidSort(t *testing.T) {
+    var m MovieModel
+    filters := Filters{
+        Page: 1,
+        PageSize: 20,
+        MaxPageSize: 100,
+        Sort: "title; DROP TABLE movies",
+        SortSafelist: []string{"id", "title"},
+    }
+
+    _, _, err := m.GetAll(context.Background(), MovieSearch{}, filters)
+    if !errors.Is(err, ErrInvalidSort) {
+        t.Errorf("GetAll(): err = %v; want ErrInvalidSort", err)
+    }
+
+    _, err = m.StreamAll(context.Background(), MovieSearch{}, filters, func(*Movie) error {
+        t.Error("StreamAll() returned a movie")
+        return nil
+    })
+    if !errors.Is(err, ErrInvalidSort) {
+        t.Errorf("StreamAll(): err = %v; want ErrInvalidSort", err)
+    }
+}
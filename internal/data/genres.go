@@ -0,0 +1,92 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+)
+
+// RenameGenre replaces the genre from with to in every movie which has it, and
+// returns the IDs of the movies changed. Each changed movie gets a new version (with
+// a snapshot, as for any other update), so that clients holding the old version
+// get an edit conflict rather than silently overwriting the new genre. A movie
+// which already had both genres would end up with to twice, so duplicates (ignoring
+// case, as in ValidateMovie()) are removed, keeping the first occurrence.
+func (m MovieModel) RenameGenre(ctx context.Context, from, to string) ([]int64, error) {
+    query := `
+        UPDATE movies
+        SET genres = ARRAY(
+                SELECT g FROM (
+                    SELECT DISTINCT ON (lower(g)) g, n
+                    FROM unnest(array_replace(genres, $1, $2)) WITH ORDINALITY AS t(g, n)
+                    ORDER BY lower(g), n
+                ) d
+                ORDER BY n),
+            version = version + 1
+        WHERE $1 = ANY(genres) AND deleted_at IS NULL
+        RETURNING id`
+
+    ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+    defer cancel()
+
+    // The rename and the snapshots are written in a single transaction, so either
+    // every movie is renamed or none are.
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    start := time.Now()
+    rows, err := tx.QueryContext(ctx, query, from, to)
+    m.logSlowQuery("movies.rename_genre", start)
+    if err != nil {
+        return nil, err
+    }
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    for _, id := range ids {
+        err = insertMovieSnapshot(ctx, tx, id)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    err = m.commit(tx)
+    if err != nil {
+        return nil, err
+    }
+
+    return ids, nil
+}
+
+// ValidateGenreRename checks a request to rename the genre from to the genre to. If
+// an allowlist of genres is configured, the new name must be on it, as otherwise
+// the renamed movies would no longer be valid.
+func ValidateGenreRename(v *validator.Validator, from, to string, rules MovieRules) {
+    v.Check(from != "", "from", "required")
+    v.Check(to != "", "to", "required")
+    v.Check(from != to, "to", "same_as_from")
+
+    if len(rules.GenresAllowlist) > 0 && to != "" {
+        v.CheckParams(validator.In(to, rules.GenresAllowlist...), "to", "genre_not_allowed", map[string]string{
+            "genre": to,
+            "allowed": strings.Join(rules.GenresAllowlist, ", "),
+        })
+    }
+}
@@ -0,0 +1,46 @@
+package data
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Each renamed movie gets a snapshot of its new version, and movies without the
+// genre are left alone.
+func TestMovieModelRenameGenre(t *testing.T) {
+    models := newTestModels(t)
+    ctx := context.Background()
+
+    moana := insertTestMovie(t, models.Movies, "Moana", 2016, "animation", "Cartoon")
+    coco := insertTestMovie(t, models.Movies, "Coco", 2017, "animation")
+    heat := insertTestMovie(t, models.Movies, "Heat", 1995, "crime")
+
+    ids, err := models.Movies.RenameGenre(ctx, "animation", "cartoon")
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    if want := []int64{moana.ID, coco.ID}; !reflect.DeepEqual(ids, want) {
+        t.Errorf("RenameGenre() = %v; want %v", ids, want)
+    }
+
+    for _, movie := range []*Movie{moana, coco} {
+        version, err := models.Movies.GetVersion(ctx, movie.ID, 2)
+        if err != nil {
+            t.Fatalf("%s: GetVersion() = %v", movie.Title, err)
+        }
+        if want := []string{"cartoon"}; !reflect.DeepEqual(version.Genres, want) {
+            t.Errorf("%s: version 2 genres = %q; want %q", movie.Title, version.Genres, want)
+        }
+    }
+
+    versions, err := models.Movies.GetVersions(ctx, heat.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(versions) != 1 {
+        t.Errorf("Heat has %d versions; want 1", len(versions))
+    }
+}
@@ -0,0 +1,19 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/agpelkey/greenlight/internal/data/datatest"
+)
+
+func TestMain(m *testing.M) {
+    datatest.Main(m)
+}
+
+// newTestModels returns the models for a new, empty database of the test's own. The
+// test is skipped when there is no database to test against.
+func newTestModels(t *testing.T) Models {
+    t.Helper()
+
+    return NewModels(datatest.NewDB(t))
+}
@@ -1,8 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 // define a custom ErrRecordNotFound error. Return this
@@ -11,20 +17,123 @@ import (
 var (
     ErrRecordNotFound = errors.New("record not found")
     ErrEditConflict = errors.New("edit conflict")
+    // ErrPoolExhausted means that a query gave up waiting for a database connection
+    // because every connection in the pool was in use. See DB.AcquireTimeout.
+    ErrPoolExhausted = errors.New("database connection pool exhausted")
+    // ErrTooManyConnections means that the database server refused a connection
+    // because it already has as many as its max_connections setting allows. See
+    // CheckTooManyConnections().
+    ErrTooManyConnections = errors.New("database server has too many connections")
 )
 
+// rowScanner is satisfied by both *sql.Row and *Row, so that the helpers below can
+// be used inside or outside of a transaction.
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// queryer is satisfied by both *DB and *sql.Tx, for queries which return any number
+// of rows.
+type queryer interface {
+    QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// likeEscaper escapes the characters which are special in a LIKE pattern, including
+// the backslash escape character itself. The replacer works in a single pass, so the
+// backslashes it adds aren't escaped again.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike escapes user input for use in a LIKE or ILIKE pattern, so that it only
+// matches itself: without it, a search for "100%" would match every title starting
+// with "100", and one for "%" the whole table. Any wildcards must be added after
+// escaping, and the query should say ESCAPE '\' so that the escape character is
+// explicit rather than left to PostgreSQL's default.
+func escapeLike(s string) string {
+    return likeEscaper.Replace(s)
+}
+
+// Versioned is implemented by the records which are updated with optimistic locking
+// (see optimisticUpdate()): each has a version number, which every update checks
+// and increments.
+type Versioned interface {
+    // VersionDest returns a pointer to the record's version number, which the new
+    // version is scanned into after an update.
+    VersionDest() interface{}
+}
+
+// optimisticUpdate scans the row returned by an UPDATE query which uses optimistic
+// locking on the record into dest, followed by the record's version. The query must
+// only match the row if its version is unchanged (e.g. "WHERE id = $n AND version
+// = $m") and end with a RETURNING clause listing the columns of dest and then the
+// new version, such as "RETURNING email, version". If no row matched, the record
+// has been changed or deleted since it was read, and ErrEditConflict is returned.
+// Any other error is returned as is, so that callers can check for constraint
+// violations.
+func optimisticUpdate(row rowScanner, record Versioned, dest ...interface{}) error {
+    dest = append(dest, record.VersionDest())
+
+    err := row.Scan(dest...)
+    if errors.Is(err, sql.ErrNoRows) {
+        return ErrEditConflict
+    }
+
+    return err
+}
+
 // Create a models struct which wraps the MovieModel.
 // Add other models to this, like a UserModel and PermissionModel
 type Models struct {
     Movies MovieModel
+    MovieTranslations MovieTranslationModel
     Users UserModel
+    Features FeatureModel
+    MovieShares MovieShareModel
+    Revalidations RevalidationModel
+    // db is the connection pool shared by the models, kept for DBStats() and
+    // SetAcquireTimeout().
+    db *DB
 }
 
 // for ease of use, we also add a New() method which returns a Models
 // struct containing the initialized MovieModel.
-func NewModels(db *sql.DB) Models {
+func NewModels(sqlDB *sql.DB) Models {
+    db := &DB{DB: sqlDB}
+
     return Models{
-        Movies: MovieModel{DB: db},
+        Movies: MovieModel{DB: db, gets: newCoalescer("movies.get"), stats: newCoalescer("movies.stats")},
+        MovieTranslations: MovieTranslationModel{DB: db},
         Users: UserModel{DB: db},
+        Features: FeatureModel{DB: db, cache: &featureCache{}},
+        MovieShares: MovieShareModel{DB: db},
+        Revalidations: RevalidationModel{DB: db},
+        db: db,
+    }
+}
+
+// SetAcquireTimeout sets how long the queries of every model wait for a connection
+// from the pool before failing with ErrPoolExhausted. It must be called before the
+// models are used.
+func (m Models) SetAcquireTimeout(d time.Duration) {
+    m.db.AcquireTimeout = d
+}
+
+// CheckTooManyConnections checks whether the error is the database server refusing
+// a new connection because it is at its max_connections limit (SQLSTATE 53300). If
+// it is, it returns an error wrapping ErrTooManyConnections; otherwise it returns
+// the error unchanged. Unlike an exhausted pool, this means that the server as a
+// whole is full, which our own pool settings alone can't prevent when several
+// instances (or other applications) share it.
+func CheckTooManyConnections(err error) error {
+    var pqErr *pq.Error
+    if !errors.As(err, &pqErr) || pqErr.Code.Name() != "too_many_connections" {
+        return err
     }
+
+    return fmt.Errorf("%w: %v", ErrTooManyConnections, err)
+}
+
+// DBStats returns the statistics of the connection pool behind the models, such as
+// the number of open, idle and waiting connections.
+func (m Models) DBStats() sql.DBStats {
+    return m.db.Stats()
 }
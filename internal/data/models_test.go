@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestOptimisticUpdateConcurrent runs two updates of the same version of a record at
+// once, for each of the models which use optimisticUpdate(). Exactly one of them
+// must win, and the other must get an ErrEditConflict.
+func TestOptimisticUpdateConcurrent(t *testing.T) {
+    models := newTestModels(t)
+
+    movie := insertTestMovie(t, models.Movies, "Moana", 2016, "animation")
+
+    user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+    if err := user.Password.Set("pa55word1234"); err != nil {
+        t.Fatal(err)
+    }
+    if err := models.Users.Insert(context.Background(), user); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        update func(i int) error
+    }{
+        {"movie", func(i int) error {
+            m := movie.clone()
+            m.Title = []string{"Vaiana", "Oceania"}[i]
+            return models.Movies.Update(context.Background(), m)
+        }},
+        {"user", func(i int) error {
+            u := *user
+            u.Name = []string{"Alice Smith", "Alice Jones"}[i]
+            return models.Users.Update(context.Background(), &u)
+        }},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var wg sync.WaitGroup
+            errs := make([]error, 2)
+
+            for i := range errs {
+                wg.Add(1)
+                go func(i int) {
+                    defer wg.Done()
+                    errs[i] = tt.update(i)
+                }(i)
+            }
+            wg.Wait()
+
+            wins, conflicts := 0, 0
+            for _, err := range errs {
+                switch {
+                case err == nil:
+                    wins++
+                case errors.Is(err, ErrEditConflict):
+                    conflicts++
+                default:
+                    t.Errorf("unexpected error: %v", err)
+                }
+            }
+            if wins != 1 || conflicts != 1 {
+                t.Errorf("%d updates won and %d conflicted; want one of each", wins, conflicts)
+            }
+        })
+    }
+}
+
+func TestOptimisticUpdateSetsVersion(t *testing.T) {
+    models := newTestModels(t)
+
+    user := &User{Name: "Alice", Email: "Alice@Example.com", Activated: true}
+    if err := user.Password.Set("pa55word1234"); err != nil {
+        t.Fatal(err)
+    }
+    if err := models.Users.Insert(context.Background(), user); err != nil {
+        t.Fatal(err)
+    }
+    version := user.Version
+
+    user.Name = "Alice Smith"
+    if err := models.Users.Update(context.Background(), user); err != nil {
+        t.Fatal(err)
+    }
+    if user.Version != version+1 {
+        t.Errorf("Version = %d; want %d", user.Version, version+1)
+    }
+    if user.Email != "alice@example.com" {
+        t.Errorf("Email = %q; want the normalized address returned by the update", user.Email)
+    }
+
+    // The version that was read has now been superseded.
+    stale := *user
+    stale.Version = version
+    err := models.Users.Update(context.Background(), &stale)
+    if !errors.Is(err, ErrEditConflict) {
+        t.Errorf("Update() of a stale version: err = %v; want ErrEditConflict", err)
+    }
+}
+
+func TestEscapeLike(t *testing.T) {
+    tests := []struct {
+        in string
+        want string
+    }{
+        {"casablanca", "casablanca"},
+        {"100%", `100\%`},
+        {"a_b", `a\_b`},
+        {"%", `\%`},
+        {`C:\films`, `C:\\films`},
+        {`50\%_`, `50\\\%\_`},
+        {"", ""},
+    }
+
+    for _, tt := range tests {
+        if got := escapeLike(tt.in); got != tt.want {
+            t.Errorf("escapeLike(%q) = %q; want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+// Wildcards typed into the search box only match themselves in the prefix match of
+// Suggest().
+func TestSuggestEscapesWildcards(t *testing.T) {
+    models := newTestModels(t)
+
+    for _, title := range []string{"a_b Story", "aXb Story", "Zorro", `C:\ Drive`} {
+        insertTestMovie(t, models.Movies, title, 2000)
+    }
+
+    tests := []struct {
+        q string
+        want []string
+    }{
+        {"a_b", []string{"a_b Story"}},
+        {"%", nil},
+        {"_", nil},
+        {`C:\`, []string{`C:\ Drive`}},
+    }
+
+    for _, tt := range tests {
+        movies, err := models.Movies.Suggest(context.Background(), tt.q, 10)
+        if err != nil {
+            t.Fatalf("Suggest(%q): %v", tt.q, err)
+        }
+
+        var got []string
+        for _, movie := range movies {
+            got = append(got, movie.Title)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("Suggest(%q) = %q; want %q", tt.q, got, tt.want)
+        }
+    }
+}
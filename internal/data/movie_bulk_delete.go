@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// bulkDeleteBatchSize is the number of movies deleted per transaction by
+// DeleteWhere(), which keeps each transaction (and the locks it holds) short.
+const bulkDeleteBatchSize = 1000
+
+// MovieDeletion is the audit record of a bulk delete: who made it (as far as we can
+// tell, since every admin shares the admin token), the filter it was made with, and
+// the IDs of the movies it deleted.
+type MovieDeletion struct {
+    ID int64 `json:"id"`
+    CreatedAt time.Time `json:"created_at"`
+    ClientIP string `json:"client_ip"`
+    UserAgent string `json:"user_agent"`
+    Filter json.RawMessage `json:"filter"`
+    MovieIDs []int64 `json:"movie_ids"`
+}
+
+// DeleteWhere deletes every movie matching the search, in batches of
+// bulkDeleteBatchSize with a transaction each, and returns the number deleted. A
+// failure part way through leaves the batches already committed deleted; the count
+// returned alongside the error says how many. Callers must refuse an empty search,
+// which would delete every movie.
+//
+// The delete is a soft one: the movies are only given a deleted_at time, which
+// every other query filters out, so that a mistaken bulk delete can be undone in
+// the database. The deletion is recorded in the movie_deletions table first, and
+// each batch adds the IDs it deleted to that record in its own transaction, so the
+// audit trail always matches what was deleted. The ID and creation time of the
+// record are set on the deletion passed in, and the IDs of the movies deleted are
+// added to its MovieIDs as each batch is committed, so that the caller knows which
+// movies went even if a later batch fails.
+//
+// As the batches would never finish if they were rolled back, a dry-run model
+// deletes nothing (and records nothing), and returns the number of movies which
+// would be deleted.
+func (m MovieModel) DeleteWhere(ctx context.Context, search MovieSearch, deletion *MovieDeletion) (int64, error) {
+    if m.dryRun {
+        count, _, err := m.PreviewDeleteWhere(ctx, search, 0)
+        return count, err
+    }
+
+    query := fmt.Sprintf(`
+        UPDATE movies
+        SET deleted_at = now()
+        WHERE id IN (
+            SELECT id FROM movies
+            WHERE %s
+            ORDER BY id
+            LIMIT $6
+            FOR UPDATE)
+        RETURNING id`, search.condition())
+
+    args, err := search.args()
+    if err != nil {
+        return 0, err
+    }
+    args = append(args, bulkDeleteBatchSize)
+
+    err = m.insertDeletion(ctx, deletion)
+    if err != nil {
+        return 0, err
+    }
+
+    var deleted int64
+
+    for {
+        n, err := m.deleteBatch(ctx, query, args, deletion)
+        deleted += n
+        if err != nil {
+            return deleted, err
+        }
+
+        if n < bulkDeleteBatchSize {
+            return deleted, nil
+        }
+    }
+}
+
+// insertDeletion records the start of a bulk delete, and updates the deletion with
+// its system-generated ID and creation time.
+func (m MovieModel) insertDeletion(ctx context.Context, deletion *MovieDeletion) error {
+    query := `
+        INSERT INTO movie_deletions (client_ip, user_agent, filter)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    return m.DB.QueryRowContext(ctx, query, deletion.ClientIP, deletion.UserAgent, []byte(deletion.Filter)).Scan(&deletion.ID, &deletion.CreatedAt)
+}
+
+// deleteBatch soft-deletes a batch of movies with the given query, and adds their
+// IDs to the audit record of the deletion in the same transaction.
+func (m MovieModel) deleteBatch(ctx context.Context, query string, args []interface{}, deletion *MovieDeletion) (int64, error) {
+    ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+    defer cancel()
+
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    start := time.Now()
+    rows, err := tx.QueryContext(ctx, query, args...)
+    m.logSlowQuery("movies.delete_where", start)
+    if err != nil {
+        return 0, err
+    }
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return 0, err
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return 0, err
+    }
+
+    if len(ids) > 0 {
+        _, err = tx.ExecContext(ctx, `UPDATE movie_deletions SET movie_ids = movie_ids || $1::bigint[] WHERE id = $2`, pq.Array(ids), deletion.ID)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    err = tx.Commit()
+    if err != nil {
+        return 0, err
+    }
+    deletion.MovieIDs = append(deletion.MovieIDs, ids...)
+
+    return int64(len(ids)), nil
+}
+
+// GetDeletion returns the audit record of the bulk delete with the given ID.
+func (m MovieModel) GetDeletion(ctx context.Context, id int64) (*MovieDeletion, error) {
+    query := `
+        SELECT id, created_at, client_ip, user_agent, filter, movie_ids
+        FROM movie_deletions
+        WHERE id = $1`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var deletion MovieDeletion
+    var filter []byte
+
+    err := m.DB.QueryRowContext(ctx, query, id).Scan(&deletion.ID, &deletion.CreatedAt, &deletion.ClientIP, &deletion.UserAgent, &filter, pq.Array(&deletion.MovieIDs))
+    if err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, ErrRecordNotFound
+        }
+        return nil, err
+    }
+    deletion.Filter = filter
+
+    return &deletion, nil
+}
+
+// PreviewDeleteWhere returns the number of movies matching the search, and the
+// titles of up to sampleSize of them (in order of ID), without deleting anything.
+func (m MovieModel) PreviewDeleteWhere(ctx context.Context, search MovieSearch, sampleSize int) (int64, []string, error) {
+    query := fmt.Sprintf(`
+        SELECT count(*) OVER(), title
+        FROM movies
+        WHERE %s
+        ORDER BY id
+        LIMIT greatest($6, 1)`, search.condition())
+
+    args, err := search.args()
+    if err != nil {
+        return 0, nil, err
+    }
+    args = append(args, sampleSize)
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    start := time.Now()
+    rows, err := m.DB.QueryContext(ctx, query, args...)
+    m.logSlowQuery("movies.preview_delete_where", start)
+    if err != nil {
+        return 0, nil, err
+    }
+    defer rows.Close()
+
+    var count int64
+    titles := []string{}
+
+    for rows.Next() {
+        var title string
+
+        err := rows.Scan(&count, &title)
+        if err != nil {
+            return 0, nil, err
+        }
+
+        if len(titles) < sampleSize {
+            titles = append(titles, title)
+        }
+    }
+    if err = rows.Err(); err != nil {
+        return 0, nil, err
+    }
+
+    return count, titles, nil
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMovieModelDeleteWhere(t *testing.T) {
+    models := newTestModels(t)
+
+    keep := insertTestMovie(t, models.Movies, "Moana", 2016, "animation")
+    drama := insertTestMovie(t, models.Movies, "Casablanca", 1942, "drama")
+    romance := insertTestMovie(t, models.Movies, "Brief Encounter", 1945, "drama", "romance")
+
+    search := MovieSearch{Genres: []string{"drama"}}
+    deletion := &MovieDeletion{ClientIP: "192.0.2.1", UserAgent: "test", Filter: []byte(`{"Genres":["drama"]}`)}
+
+    // A dry run only counts the movies.
+    n, err := models.Movies.DryRun().DeleteWhere(context.Background(), search, deletion)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 2 || deletion.ID != 0 {
+        t.Fatalf("dry run: deleted %d with deletion ID %d; want 2 and no deletion", n, deletion.ID)
+    }
+
+    n, err = models.Movies.DeleteWhere(context.Background(), search, deletion)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != 2 {
+        t.Fatalf("DeleteWhere() = %d; want 2", n)
+    }
+    sort.Slice(deletion.MovieIDs, func(i, j int) bool { return deletion.MovieIDs[i] < deletion.MovieIDs[j] })
+    if want := []int64{drama.ID, romance.ID}; !reflect.DeepEqual(deletion.MovieIDs, want) {
+        t.Errorf("deletion.MovieIDs = %v; want %v", deletion.MovieIDs, want)
+    }
+
+    // The deleted movies are gone from every query, but their rows are kept.
+    for _, movie := range []*Movie{drama, romance} {
+        _, err := models.Movies.Get(context.Background(), movie.ID)
+        if !errors.Is(err, ErrRecordNotFound) {
+            t.Errorf("Get(%d) after the delete: err = %v; want ErrRecordNotFound", movie.ID, err)
+        }
+
+        exists, err := models.Movies.Exists(context.Background(), movie.ID)
+        if err != nil || exists {
+            t.Errorf("Exists(%d) = %v, %v; want false", movie.ID, exists, err)
+        }
+
+        var deleted bool
+        err = models.Movies.DB.QueryRow(`SELECT deleted_at IS NOT NULL FROM movies WHERE id = $1`, movie.ID).Scan(&deleted)
+        if err != nil || !deleted {
+            t.Errorf("movie %d: deleted_at set = %v, %v; want the row kept with deleted_at set", movie.ID, deleted, err)
+        }
+    }
+
+    movies, metadata, err := models.Movies.GetAll(context.Background(), MovieSearch{}, Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(movies) != 1 || movies[0].ID != keep.ID || metadata.TotalRecords != 1 {
+        t.Errorf("GetAll() = %d movies (%d in total); want only movie %d", len(movies), metadata.TotalRecords, keep.ID)
+    }
+
+    // Deleting again finds nothing more to delete.
+    n, err = models.Movies.DeleteWhere(context.Background(), search, &MovieDeletion{ClientIP: "192.0.2.1", Filter: []byte(`{}`)})
+    if err != nil || n != 0 {
+        t.Errorf("second DeleteWhere() = %d, %v; want 0", n, err)
+    }
+
+    // The deletion is audited with who made it, its filter and what it deleted.
+    got, err := models.Movies.GetDeletion(context.Background(), deletion.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.ClientIP != "192.0.2.1" || got.UserAgent != "test" || string(got.Filter) != `{"Genres": ["drama"]}` {
+        t.Errorf("deletion = %+v; want the client and filter of the delete", got)
+    }
+    if want := []int64{drama.ID, romance.ID}; !reflect.DeepEqual(got.MovieIDs, want) {
+        t.Errorf("MovieIDs = %v; want %v", got.MovieIDs, want)
+    }
+}
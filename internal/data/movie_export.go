@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Export calls fn with every movie, in order of ID, stopping at the first error. The
+// rows are read from the database one at a time as fn consumes them, rather than
+// being loaded into memory first, so that the whole catalog can be exported. There
+// is no timeout other than the context's, as a large export can take a while.
+func (m MovieModel) Export(ctx context.Context, fn func(*Movie) error) error {
+    query := `
+        SELECT id, uuid, created_at, title, year, runtime, genres, certifications, trailer_url, homepage_url, version
+        FROM movies
+        WHERE deleted_at IS NULL
+        ORDER BY id ASC`
+
+    start := time.Now()
+    rows, err := m.DB.QueryContext(ctx, query)
+    m.logSlowQuery("movies.export", start)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var movie Movie
+
+        err := rows.Scan(
+            &movie.ID,
+            &movie.UUID,
+            &movie.CreatedAt,
+            &movie.Title,
+            &movie.Year,
+            &movie.Runtime,
+            pq.Array(&movie.Genres),
+            &movie.Certifications,
+            &movie.TrailerURL,
+            &movie.HomepageURL,
+            &movie.Version,
+        )
+        if err != nil {
+            return err
+        }
+
+        err = fn(&movie)
+        if err != nil {
+            return err
+        }
+    }
+
+    return rows.Err()
+}
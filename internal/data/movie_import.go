@@ -0,0 +1,49 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// InsertBatch inserts the movies in a single transaction, which is much faster than
+// a transaction per movie when importing a large number of them. Each insert runs
+// under its own savepoint, so that a movie which the database rejects doesn't take
+// the rest of the batch down with it. The returned slice holds the error (or nil)
+// for each movie, in order; the error return is for failures of the batch as a
+// whole, in which case none of the movies have been inserted.
+func (m MovieModel) InsertBatch(ctx context.Context, movies []*Movie) ([]error, error) {
+    ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+    defer cancel()
+
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    errs := make([]error, len(movies))
+
+    for i, movie := range movies {
+        _, err := tx.ExecContext(ctx, "SAVEPOINT import_movie")
+        if err != nil {
+            return nil, err
+        }
+
+        errs[i] = m.insert(ctx, tx, movie)
+        if errs[i] != nil {
+            _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_movie")
+        } else {
+            _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT import_movie")
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    err = m.commit(tx)
+    if err != nil {
+        return nil, err
+    }
+
+    return errs, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TrailerHosts lists the video sites that trailers may be hosted on, as these are
+// the ones whose players we can embed. Subdomains (such as www.youtube.com) are
+// allowed too.
+var TrailerHosts = []string{"youtube.com", "youtu.be", "vimeo.com"}
+
+// The values of the link_status column. New and edited links are unchecked until
+// the link checker has requested them.
+const (
+    LinkStatusUnchecked = "unchecked"
+    LinkStatusOK = "ok"
+    LinkStatusBroken = "broken"
+)
+
+// GetLinksToCheck returns up to limit movies which have a trailer or homepage URL
+// that hasn't been checked since the given time, least recently checked first.
+func (m MovieModel) GetLinksToCheck(ctx context.Context, before time.Time, limit int) ([]*Movie, error) {
+    query := `
+        SELECT id, uuid, title, trailer_url, homepage_url, link_status, links_checked_at
+        FROM movies
+        WHERE (trailer_url IS NOT NULL OR homepage_url IS NOT NULL)
+        AND (links_checked_at IS NULL OR links_checked_at < $1)
+        AND deleted_at IS NULL
+        ORDER BY links_checked_at ASC NULLS FIRST, id ASC
+        LIMIT $2`
+
+    return m.queryLinks(ctx, "movies.get_links_to_check", query, before, limit)
+}
+
+// GetBrokenLinks returns the movies whose links were found to be broken the last
+// time that they were checked.
+func (m MovieModel) GetBrokenLinks(ctx context.Context) ([]*Movie, error) {
+    query := `
+        SELECT id, uuid, title, trailer_url, homepage_url, link_status, links_checked_at
+        FROM movies
+        WHERE link_status = $1 AND deleted_at IS NULL
+        ORDER BY links_checked_at DESC, id ASC`
+
+    return m.queryLinks(ctx, "movies.get_broken_links", query, LinkStatusBroken)
+}
+
+func (m MovieModel) queryLinks(ctx context.Context, label, query string, args ...interface{}) ([]*Movie, error) {
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    start := time.Now()
+    rows, err := m.DB.QueryContext(ctx, query, args...)
+    m.logSlowQuery(label, start)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    movies := []*Movie{}
+
+    for rows.Next() {
+        var movie Movie
+
+        err := rows.Scan(&movie.ID, &movie.UUID, &movie.Title, &movie.TrailerURL, &movie.HomepageURL, &movie.LinkStatus, &movie.LinksCheckedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        movies = append(movies, &movie)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return movies, nil
+}
+
+// SetLinkStatus records the result of checking the links of a movie, and when they
+// were checked. The links that were checked are passed in, and the status is only
+// recorded if they are still the movie's links, so that a result for links which
+// have since been edited is discarded. This isn't an edit of the movie, so its
+// version is unchanged.
+//
+// It must only be called with a conclusive result (LinkStatusOK or
+// LinkStatusBroken). A check which didn't give a clear answer isn't recorded at
+// all, so that the movie is checked again at the next run rather than a week later.
+func (m MovieModel) SetLinkStatus(ctx context.Context, movie *Movie, status string) error {
+    if status != LinkStatusOK && status != LinkStatusBroken {
+        return fmt.Errorf("%q is not a conclusive link status", status)
+    }
+
+    query := `
+        UPDATE movies
+        SET link_status = $1, links_checked_at = now()
+        WHERE id = $2
+        AND trailer_url IS NOT DISTINCT FROM $3
+        AND homepage_url IS NOT DISTINCT FROM $4`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    start := time.Now()
+    _, err := m.DB.ExecContext(ctx, query, status, movie.ID, movie.TrailerURL, movie.HomepageURL)
+    m.logSlowQuery("movies.set_link_status", start)
+
+    return err
+}
@@ -0,0 +1,18 @@
+package data
+
+import (
+	"context"
+	"testing"
+)
+
+// An inconclusive check can't be recorded, as that would also record the links as
+// checked, and put off checking them again for a week.
+func TestSetLinkStatusInconclusive(t *testing.T) {
+    var m MovieModel
+
+    for _, status := range []string{LinkStatusUnchecked, ""} {
+        if err := m.SetLinkStatus(context.Background(), &Movie{ID: 1}, status); err == nil {
+            t.Errorf("SetLinkStatus(%q) = nil; want an error", status)
+        }
+    }
+}
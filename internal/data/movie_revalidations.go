@@ -0,0 +1,369 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrRevalidationRunning is returned by RevalidationModel.Start() when a sweep is
+// already in progress.
+var ErrRevalidationRunning = errors.New("a revalidation is already running")
+
+// The values of the movie_revalidations status column.
+const (
+    RevalidationRunning = "running"
+    RevalidationCompleted = "completed"
+)
+
+// Revalidation is a sweep which runs the current validation rules against every
+// existing movie, so that rows which were valid under older rules can be found (and
+// the safe problems fixed) before they trip up an unrelated edit. The sweep works
+// through the movies in ID order, and LastMovieID records how far it has got, so
+// that it picks up where it left off after a restart.
+type Revalidation struct {
+    ID int64 `json:"id"`
+    Fix bool `json:"fix"`
+    Status string `json:"status"`
+    // Total is the number of movies when the sweep started, for reporting progress.
+    // Movies added during the sweep are checked too, so Checked can end up above it.
+    Total int64 `json:"total"`
+    Checked int64 `json:"checked"`
+    Invalid int64 `json:"invalid"`
+    Fixed int64 `json:"fixed"`
+    LastMovieID int64 `json:"-"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    FinishedAt *time.Time `json:"finished_at"`
+}
+
+// RevalidationResult records a movie which failed validation during a sweep, with
+// the messages for each invalid field. Fixed is true if the sweep fixed the movie,
+// in which case the errors are those it had before the fix.
+type RevalidationResult struct {
+    MovieID int64 `json:"movie_id"`
+    Errors map[string]string `json:"errors"`
+    Fixed bool `json:"fixed"`
+}
+
+// RevalidationBatch is the outcome of checking one batch of movies. LastMovieID is
+// the ID of the last movie in the batch, and Done is set once there are no more
+// movies to check.
+type RevalidationBatch struct {
+    Checked int64
+    LastMovieID int64
+    Results []RevalidationResult
+    Done bool
+}
+
+// RevalidationModel wraps the connection pool for the movie_revalidations and
+// movie_revalidation_results tables.
+type RevalidationModel struct {
+    DB *DB
+}
+
+// Start records a new sweep, unless one is already running, in which case an
+// ErrRevalidationRunning error is returned.
+func (m RevalidationModel) Start(ctx context.Context, fix bool) (*Revalidation, error) {
+    query := `
+        INSERT INTO movie_revalidations (fix, total)
+        SELECT $1, (SELECT count(*) FROM movies WHERE deleted_at IS NULL)
+        WHERE NOT EXISTS (SELECT 1 FROM movie_revalidations WHERE status = $2)
+        RETURNING id, fix, status, total, checked, invalid, fixed, last_movie_id, created_at, updated_at, finished_at`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    run, err := scanRevalidation(m.DB.QueryRowContext(ctx, query, fix, RevalidationRunning))
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return nil, ErrRevalidationRunning
+        default:
+            return nil, err
+        }
+    }
+
+    return run, nil
+}
+
+// Get returns the sweep with the given ID, or an ErrRecordNotFound error if there
+// isn't one.
+func (m RevalidationModel) Get(ctx context.Context, id int64) (*Revalidation, error) {
+    query := `
+        SELECT id, fix, status, total, checked, invalid, fixed, last_movie_id, created_at, updated_at, finished_at
+        FROM movie_revalidations
+        WHERE id = $1`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    run, err := scanRevalidation(m.DB.QueryRowContext(ctx, query, id))
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return nil, ErrRecordNotFound
+        default:
+            return nil, err
+        }
+    }
+
+    return run, nil
+}
+
+// GetResults returns the movies which failed validation during a sweep, in ID
+// order.
+func (m RevalidationModel) GetResults(ctx context.Context, id int64) ([]*RevalidationResult, error) {
+    query := `
+        SELECT movie_id, errors, fixed
+        FROM movie_revalidation_results
+        WHERE run_id = $1
+        ORDER BY movie_id ASC`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    rows, err := m.DB.QueryContext(ctx, query, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    results := []*RevalidationResult{}
+
+    for rows.Next() {
+        var result RevalidationResult
+        var js []byte
+
+        err := rows.Scan(&result.MovieID, &js, &result.Fixed)
+        if err != nil {
+            return nil, err
+        }
+
+        err = json.Unmarshal(js, &result.Errors)
+        if err != nil {
+            return nil, err
+        }
+
+        results = append(results, &result)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return results, nil
+}
+
+// ProcessNext takes the oldest running sweep and calls fn to check its next batch of
+// movies, then records the results and progress. fn is called without holding any
+// lock or transaction, as it reads (and may fix) movies on connections of its own;
+// holding the sweep's row locked across it would tie up two connections at once,
+// and deadlock a pool with only one. Instead, the progress is recorded only if the
+// sweep hasn't moved on since it was read, so that if two instances check the same
+// batch at once, only the first to finish records it. If fn returns an error
+// nothing is recorded and the batch will be checked again next time. ProcessNext
+// reports whether there was a sweep to work on.
+func (m RevalidationModel) ProcessNext(ctx context.Context, fn func(*Revalidation) (*RevalidationBatch, error)) (bool, error) {
+    query := `
+        SELECT id, fix, status, total, checked, invalid, fixed, last_movie_id, created_at, updated_at, finished_at
+        FROM movie_revalidations
+        WHERE status = $1
+        ORDER BY id ASC
+        LIMIT 1`
+
+    run, err := scanRevalidation(m.DB.QueryRowContext(ctx, query, RevalidationRunning))
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return false, nil
+        default:
+            return false, err
+        }
+    }
+
+    batch, err := fn(run)
+    if err != nil {
+        return true, err
+    }
+
+    var fixed int64
+    for _, movieResult := range batch.Results {
+        if movieResult.Fixed {
+            fixed++
+        }
+    }
+
+    status := RevalidationRunning
+    if batch.Done {
+        status = RevalidationCompleted
+    }
+
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return true, err
+    }
+    defer tx.Rollback()
+
+    // The progress is updated first, so that the row stays locked until the results
+    // are written too. An instance which checked the same batch and finishes second
+    // waits for the lock, and then finds that last_movie_id has moved on.
+    result, err := tx.ExecContext(ctx, `
+        UPDATE movie_revalidations
+        SET checked = checked + $1, invalid = invalid + $2, fixed = fixed + $3,
+            last_movie_id = $4, status = $5, updated_at = NOW(),
+            finished_at = CASE WHEN $6 THEN NOW() END
+        WHERE id = $7 AND status = $8 AND last_movie_id = $9`,
+        batch.Checked, len(batch.Results), fixed, batch.LastMovieID, status, batch.Done,
+        run.ID, RevalidationRunning, run.LastMovieID)
+    if err != nil {
+        return true, err
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return true, err
+    }
+    if rowsAffected == 0 {
+        // Another instance has recorded this batch already.
+        return true, nil
+    }
+
+    for _, movieResult := range batch.Results {
+        js, err := json.Marshal(movieResult.Errors)
+        if err != nil {
+            return true, err
+        }
+
+        _, err = tx.ExecContext(ctx, `
+            INSERT INTO movie_revalidation_results (run_id, movie_id, errors, fixed)
+            VALUES ($1, $2, $3, $4)`,
+            run.ID, movieResult.MovieID, js, movieResult.Fixed)
+        if err != nil {
+            return true, err
+        }
+    }
+
+    return true, tx.Commit()
+}
+
+func scanRevalidation(row rowScanner) (*Revalidation, error) {
+    var run Revalidation
+
+    err := row.Scan(
+        &run.ID,
+        &run.Fix,
+        &run.Status,
+        &run.Total,
+        &run.Checked,
+        &run.Invalid,
+        &run.Fixed,
+        &run.LastMovieID,
+        &run.CreatedAt,
+        &run.UpdatedAt,
+        &run.FinishedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    return &run, nil
+}
+
+// GetAfter returns up to limit movies with an ID greater than the given one, in ID
+// order, for working through every movie a batch at a time.
+func (m MovieModel) GetAfter(ctx context.Context, afterID int64, limit int) ([]*Movie, error) {
+    query := `
+        SELECT id, uuid, created_at, title, year, runtime, genres, certifications, trailer_url, homepage_url, link_status, version
+        FROM movies
+        WHERE id > $1 AND deleted_at IS NULL
+        ORDER BY id ASC
+        LIMIT $2`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    start := time.Now()
+    rows, err := m.DB.QueryContext(ctx, query, afterID, limit)
+    m.logSlowQuery("movies.get_after", start)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    movies := []*Movie{}
+
+    for rows.Next() {
+        var movie Movie
+
+        err := rows.Scan(
+            &movie.ID,
+            &movie.UUID,
+            &movie.CreatedAt,
+            &movie.Title,
+            &movie.Year,
+            &movie.Runtime,
+            pq.Array(&movie.Genres),
+            &movie.Certifications,
+            &movie.TrailerURL,
+            &movie.HomepageURL,
+            &movie.LinkStatus,
+            &movie.Version,
+        )
+        if err != nil {
+            return nil, err
+        }
+
+        movies = append(movies, &movie)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return movies, nil
+}
+
+// FixMovie makes the fixes to a movie which can't change its meaning: trimming the
+// whitespace around its title and genres, and removing genres which are repeated
+// (ignoring case, and keeping the first). It reports whether anything changed.
+func FixMovie(movie *Movie) bool {
+    changed := false
+
+    if title := strings.TrimSpace(movie.Title); title != movie.Title {
+        movie.Title = title
+        changed = true
+    }
+
+    if movie.Genres == nil {
+        return changed
+    }
+
+    genres := make([]string, 0, len(movie.Genres))
+    for _, genre := range movie.Genres {
+        trimmed := strings.TrimSpace(genre)
+        if trimmed != genre {
+            changed = true
+        }
+
+        duplicate := false
+        for _, g := range genres {
+            if strings.EqualFold(g, trimmed) {
+                duplicate = true
+                break
+            }
+        }
+        if duplicate {
+            changed = true
+            continue
+        }
+
+        genres = append(genres, trimmed)
+    }
+    movie.Genres = genres
+
+    return changed
+}
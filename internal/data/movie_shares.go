@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MovieShare records a signed link which gives read access to a movie without an
+// account. The link itself isn't stored, only the nonce which is signed into it, so
+// that a share can be revoked before it expires.
+type MovieShare struct {
+    ID int64 `json:"id"`
+    MovieID int64 `json:"movie_id"`
+    Nonce string `json:"-"`
+    ExpiresAt time.Time `json:"expires_at"`
+    CreatedAt time.Time `json:"created_at"`
+    RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// MovieShareModel wraps the connection pool for the movie_shares table.
+type MovieShareModel struct {
+    DB *DB
+}
+
+// Insert records a new share, filling in its ID and CreatedAt fields.
+func (m MovieShareModel) Insert(ctx context.Context, share *MovieShare) error {
+    query := `
+        INSERT INTO movie_shares (movie_id, nonce, expires_at)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    return m.DB.QueryRowContext(ctx, query, share.MovieID, share.Nonce, share.ExpiresAt).Scan(&share.ID, &share.CreatedAt)
+}
+
+// GetAll returns the shares which haven't expired yet (including revoked ones),
+// newest first.
+func (m MovieShareModel) GetAll(ctx context.Context) ([]*MovieShare, error) {
+    query := `
+        SELECT id, movie_id, expires_at, created_at, revoked_at
+        FROM movie_shares
+        WHERE expires_at > NOW()
+        ORDER BY id DESC`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    rows, err := m.DB.QueryContext(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    shares := []*MovieShare{}
+
+    for rows.Next() {
+        var share MovieShare
+
+        err := rows.Scan(&share.ID, &share.MovieID, &share.ExpiresAt, &share.CreatedAt, &share.RevokedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        shares = append(shares, &share)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return shares, nil
+}
+
+// Revoke marks a share as revoked, so that its link stops working. Revoking a share
+// which is already revoked leaves its RevokedAt time as it was. If there is no
+// share with the ID an ErrRecordNotFound error is returned.
+func (m MovieShareModel) Revoke(ctx context.Context, id int64) (*MovieShare, error) {
+    query := `
+        UPDATE movie_shares
+        SET revoked_at = COALESCE(revoked_at, NOW())
+        WHERE id = $1
+        RETURNING id, movie_id, expires_at, created_at, revoked_at`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var share MovieShare
+
+    err := m.DB.QueryRowContext(ctx, query, id).Scan(&share.ID, &share.MovieID, &share.ExpiresAt, &share.CreatedAt, &share.RevokedAt)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return nil, ErrRecordNotFound
+        default:
+            return nil, err
+        }
+    }
+
+    return &share, nil
+}
+
+// IsRevoked reports whether the share with the given nonce has been revoked. A
+// nonce without a share (for example, one whose movie has since been deleted) is
+// treated as revoked.
+func (m MovieShareModel) IsRevoked(ctx context.Context, movieID int64, nonce string) (bool, error) {
+    query := `
+        SELECT revoked_at IS NOT NULL
+        FROM movie_shares
+        WHERE movie_id = $1 AND nonce = $2`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var revoked bool
+
+    err := m.DB.QueryRowContext(ctx, query, movieID, nonce).Scan(&revoked)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return true, nil
+        default:
+            return false, err
+        }
+    }
+
+    return revoked, nil
+}
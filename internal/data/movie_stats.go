@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// MovieStats holds aggregate statistics about the movie catalog.
+type MovieStats struct {
+    TotalMovies int
+    // ByDecade maps a decade (e.g. "1990s") to the number of movies released in it.
+    // Movies without a release year aren't counted.
+    ByDecade map[string]int
+    // AverageRuntime is the mean runtime in minutes, rounded to one decimal place,
+    // of the movies whose runtime is known. It is nil if there are none.
+    AverageRuntime *float64
+    // TopGenres lists the most common genres, most common first.
+    TopGenres []GenreCount
+    // ByCertification maps a rating system (e.g. "US") to the number of movies with
+    // each of its ratings (e.g. "PG-13"). Movies aren't counted for the systems they
+    // have no rating in.
+    ByCertification map[string]map[string]int
+    // Newest and Oldest are the movies with the latest and earliest release years,
+    // or nil if no movie has a release year. Only their ID, UUID, title and year
+    // are set.
+    Newest *Movie
+    Oldest *Movie
+}
+
+// GenreCount is the number of movies with a given genre.
+type GenreCount struct {
+    Genre string `json:"genre"`
+    Count int `json:"count"`
+}
+
+// statsTopGenres is the number of genres listed in MovieStats.TopGenres.
+const statsTopGenres = 5
+
+// Stats returns aggregate statistics about the movie catalog. Concurrent calls share
+// a single query (see coalescer), as a dashboard polling the stats endpoint from
+// many browsers would otherwise run the same scan of the table for each of them.
+// The same MovieStats is returned to every caller, so it must not be modified.
+func (m MovieModel) Stats(ctx context.Context) (*MovieStats, error) {
+    if m.stats == nil {
+        return m.queryStats(ctx)
+    }
+
+    val, err := m.stats.do(ctx, "all", func(ctx context.Context) (interface{}, error) {
+        return m.queryStats(ctx)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return val.(*MovieStats), nil
+}
+
+// queryStats computes the statistics for Stats() in a single query, with a
+// subquery for each statistic, so that they all come from the same snapshot of the
+// table. The grouped statistics are built as JSON in the query, to avoid a round
+// trip per statistic.
+func (m MovieModel) queryStats(ctx context.Context) (*MovieStats, error) {
+    query := `
+        WITH live AS (SELECT * FROM movies WHERE deleted_at IS NULL)
+        SELECT
+            (SELECT count(*) FROM live),
+            (SELECT coalesce(jsonb_object_agg(decade, n), '{}')
+                FROM (SELECT (year / 10 * 10)::text || 's' AS decade, count(*) AS n
+                    FROM live WHERE year IS NOT NULL GROUP BY 1) d),
+            (SELECT round(avg(runtime), 1)::float8 FROM live WHERE runtime IS NOT NULL),
+            (SELECT coalesce(jsonb_agg(jsonb_build_object('genre', genre, 'count', n) ORDER BY n DESC, genre), '[]')
+                FROM (SELECT genre, count(*) AS n FROM live, unnest(genres) AS genre
+                    GROUP BY genre ORDER BY n DESC, genre LIMIT $1) g),
+            (SELECT coalesce(jsonb_object_agg(system, ratings), '{}')
+                FROM (SELECT system, jsonb_object_agg(rating, n) AS ratings
+                    FROM (SELECT c.key AS system, c.value AS rating, count(*) AS n
+                        FROM live, jsonb_each_text(certifications) AS c GROUP BY 1, 2) r
+                    GROUP BY system) s),
+            (SELECT jsonb_build_object('id', id, 'uuid', uuid, 'title', title, 'year', year)
+                FROM live WHERE year IS NOT NULL ORDER BY year DESC, id DESC LIMIT 1),
+            (SELECT jsonb_build_object('id', id, 'uuid', uuid, 'title', title, 'year', year)
+                FROM live WHERE year IS NOT NULL ORDER BY year ASC, id ASC LIMIT 1)`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var stats MovieStats
+    var byDecade, topGenres, byCertification []byte
+    var newest, oldest []byte
+
+    start := time.Now()
+    err := m.DB.QueryRowContext(ctx, query, statsTopGenres).Scan(
+        &stats.TotalMovies,
+        &byDecade,
+        &stats.AverageRuntime,
+        &topGenres,
+        &byCertification,
+        &newest,
+        &oldest,
+    )
+    m.logSlowQuery("movies.stats", start)
+    if err != nil {
+        return nil, err
+    }
+
+    err = json.Unmarshal(byDecade, &stats.ByDecade)
+    if err != nil {
+        return nil, err
+    }
+
+    err = json.Unmarshal(topGenres, &stats.TopGenres)
+    if err != nil {
+        return nil, err
+    }
+
+    err = json.Unmarshal(byCertification, &stats.ByCertification)
+    if err != nil {
+        return nil, err
+    }
+
+    stats.Newest, err = unmarshalStatsMovie(newest)
+    if err != nil {
+        return nil, err
+    }
+
+    stats.Oldest, err = unmarshalStatsMovie(oldest)
+    if err != nil {
+        return nil, err
+    }
+
+    return &stats, nil
+}
+
+// unmarshalStatsMovie decodes a movie built by jsonb_build_object() in the Stats()
+// query, which is NULL if there was no matching movie.
+func unmarshalStatsMovie(js []byte) (*Movie, error) {
+    if js == nil {
+        return nil, nil
+    }
+
+    var row struct {
+        ID int64 `json:"id"`
+        UUID string `json:"uuid"`
+        Title string `json:"title"`
+        Year *int32 `json:"year"`
+    }
+
+    err := json.Unmarshal(js, &row)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Movie{ID: row.ID, UUID: row.UUID, Title: row.Title, Year: row.Year}, nil
+}
+
+// CountCreatedSince returns the number of movies added to the catalog at or after
+// the given time.
+func (m MovieModel) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+    query := `SELECT count(*) FROM movies WHERE created_at >= $1 AND deleted_at IS NULL`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var count int
+
+    start := time.Now()
+    err := m.DB.QueryRowContext(ctx, query, since).Scan(&count)
+    m.logSlowQuery("movies.count_created_since", start)
+
+    return count, err
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMovieModelStats(t *testing.T) {
+    models := newTestModels(t)
+    ctx := context.Background()
+
+    // An empty catalog has no averages or extremes.
+    stats, err := models.Movies.Stats(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if stats.TotalMovies != 0 || len(stats.ByDecade) != 0 || stats.AverageRuntime != nil ||
+        len(stats.TopGenres) != 0 || len(stats.ByCertification) != 0 || stats.Newest != nil || stats.Oldest != nil {
+        t.Errorf("stats of an empty catalog = %+v", stats)
+    }
+
+    certify := func(movie *Movie, certifications Certifications) {
+        t.Helper()
+
+        movie.Certifications = certifications
+        if err := models.Movies.Update(ctx, movie); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    moana := insertTestMovie(t, models.Movies, "Moana", 2016, "animation")
+    certify(moana, Certifications{"US": "PG", "GB": "PG"})
+    coco := insertTestMovie(t, models.Movies, "Coco", 2017, "animation", "music")
+    certify(coco, Certifications{"US": "PG"})
+    heat := insertTestMovie(t, models.Movies, "Heat", 1995, "crime")
+    certify(heat, Certifications{"US": "R"})
+
+    // Deleted movies aren't counted.
+    gone := insertTestMovie(t, models.Movies, "Gone", 1980, "crime")
+    certify(gone, Certifications{"US": "R"})
+    if err := models.Movies.Delete(ctx, gone.ID); err != nil {
+        t.Fatal(err)
+    }
+
+    stats, err = models.Movies.Stats(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if stats.TotalMovies != 3 {
+        t.Errorf("TotalMovies = %d; want 3", stats.TotalMovies)
+    }
+    if want := map[string]int{"2010s": 2, "1990s": 1}; !reflect.DeepEqual(stats.ByDecade, want) {
+        t.Errorf("ByDecade = %v; want %v", stats.ByDecade, want)
+    }
+    if stats.AverageRuntime == nil || *stats.AverageRuntime != 100 {
+        t.Errorf("AverageRuntime = %v; want 100", stats.AverageRuntime)
+    }
+    if want := []GenreCount{{"animation", 2}, {"crime", 1}, {"music", 1}}; !reflect.DeepEqual(stats.TopGenres, want) {
+        t.Errorf("TopGenres = %v; want %v", stats.TopGenres, want)
+    }
+    if want := map[string]map[string]int{"US": {"PG": 2, "R": 1}, "GB": {"PG": 1}}; !reflect.DeepEqual(stats.ByCertification, want) {
+        t.Errorf("ByCertification = %v; want %v", stats.ByCertification, want)
+    }
+    if stats.Newest == nil || stats.Newest.ID != coco.ID {
+        t.Errorf("Newest = %+v; want Coco", stats.Newest)
+    }
+    if stats.Oldest == nil || stats.Oldest.ID != heat.ID {
+        t.Errorf("Oldest = %+v; want Heat", stats.Oldest)
+    }
+}
@@ -0,0 +1,50 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// Suggest returns up to limit movies whose titles start with q or are similar to
+// it, for search-as-you-type. The prefix match catches the first few letters of a
+// title, which are usually too short to pass the pg_trgm similarity threshold, and
+// the similarity match catches typos such as "casablanka". The most similar titles
+// come first. Both conditions can use the trigram index on the title column.
+// Only the ID, UUID, title and year of the movies are filled in.
+func (m MovieModel) Suggest(ctx context.Context, q string, limit int) ([]*Movie, error) {
+    query := `
+        SELECT id, uuid, title, year
+        FROM movies
+        WHERE (title ILIKE $2 ESCAPE '\' OR title % $1) AND deleted_at IS NULL
+        ORDER BY similarity(title, $1) DESC, title ASC, id ASC
+        LIMIT $3`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    start := time.Now()
+    rows, err := m.DB.QueryContext(ctx, query, q, escapeLike(q)+"%", limit)
+    m.logSlowQuery("movies.suggest", start)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    movies := []*Movie{}
+
+    for rows.Next() {
+        var movie Movie
+
+        err := rows.Scan(&movie.ID, &movie.UUID, &movie.Title, &movie.Year)
+        if err != nil {
+            return nil, err
+        }
+
+        movies = append(movies, &movie)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return movies, nil
+}
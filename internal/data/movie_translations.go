@@ -0,0 +1,281 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// custom ErrDuplicateTranslation error, returned when a movie already has a
+// translation for the given language.
+var (
+    ErrDuplicateTranslation = errors.New("duplicate translation")
+)
+
+// MovieTranslation holds the title (and optionally an overview) of a movie in a
+// given language. The language is a BCP 47 tag, stored in its canonical case.
+type MovieTranslation struct {
+    MovieID int64 `json:"movie_id,omitempty"` // left out of responses when movies are identified by UUID
+    Language string `json:"lang"`
+    Title string `json:"title"`
+    Overview string `json:"overview,omitempty"`
+    CreatedAt time.Time `json:"-"`
+    Version int32 `json:"version"`
+}
+
+// Create a MovieTranslationModel struct which wraps the connection pool
+type MovieTranslationModel struct {
+    DB *DB
+}
+
+// CanonicalLanguageTag returns a BCP 47 language tag in its conventional case: the
+// language in lowercase, a script in title case, and a region in uppercase (for
+// example "zh-hant-tw" becomes "zh-Hant-TW"). Translations are stored under the
+// canonical tag so that "en-gb" and "en-GB" can't both be created.
+func CanonicalLanguageTag(tag string) string {
+    subtags := strings.Split(tag, "-")
+
+    for i, subtag := range subtags {
+        switch {
+        case i == 0:
+            subtags[i] = strings.ToLower(subtag)
+        case len(subtag) == 4 && i == 1:
+            subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+        case len(subtag) == 2:
+            subtags[i] = strings.ToUpper(subtag)
+        default:
+            subtags[i] = strings.ToLower(subtag)
+        }
+    }
+
+    return strings.Join(subtags, "-")
+}
+
+func ValidateMovieTranslation(v *validator.Validator, translation *MovieTranslation) {
+    v.Check(translation.Language != "", "lang", "required")
+    v.Check(validator.Matches(translation.Language, *validator.LanguageTagRX), "lang", "invalid_language_tag")
+    v.Check(translation.Title != "", "title", "required")
+    v.Check(len(translation.Title) <= 500, "title", "too_long")
+    v.Check(len(translation.Overview) <= 5000, "overview", "overview_too_long")
+}
+
+// Insert a new translation. If the movie already has a translation for the language
+// the primary key is violated, and we return ErrDuplicateTranslation instead.
+func (m MovieTranslationModel) Insert(ctx context.Context, translation *MovieTranslation) error {
+    query := `
+        INSERT INTO movie_translations (movie_id, lang, title, overview)
+        VALUES ($1, $2, $3, $4)
+        RETURNING created_at, version`
+
+    args := []interface{}{translation.MovieID, translation.Language, translation.Title, translation.Overview}
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    err := m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.CreatedAt, &translation.Version)
+    if err != nil {
+        switch {
+        case err.Error() == `pq: duplicate key value violates unique constraint "movie_translations_pkey"`:
+            return ErrDuplicateTranslation
+        default:
+            return err
+        }
+    }
+
+    return nil
+}
+
+// GetAll returns all the translations of a movie, ordered by language.
+func (m MovieTranslationModel) GetAll(ctx context.Context, movieID int64) ([]*MovieTranslation, error) {
+    query := `
+        SELECT movie_id, lang, title, overview, created_at, version
+        FROM movie_translations
+        WHERE movie_id = $1
+        ORDER BY lang ASC`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    rows, err := m.DB.QueryContext(ctx, query, movieID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    translations := []*MovieTranslation{}
+
+    for rows.Next() {
+        var translation MovieTranslation
+
+        err := rows.Scan(
+            &translation.MovieID,
+            &translation.Language,
+            &translation.Title,
+            &translation.Overview,
+            &translation.CreatedAt,
+            &translation.Version,
+        )
+        if err != nil {
+            return nil, err
+        }
+
+        translations = append(translations, &translation)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return translations, nil
+}
+
+func (m MovieTranslationModel) Get(ctx context.Context, movieID int64, lang string) (*MovieTranslation, error) {
+    query := `
+        SELECT movie_id, lang, title, overview, created_at, version
+        FROM movie_translations
+        WHERE movie_id = $1 AND lang = $2`
+
+    var translation MovieTranslation
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    err := m.DB.QueryRowContext(ctx, query, movieID, lang).Scan(
+        &translation.MovieID,
+        &translation.Language,
+        &translation.Title,
+        &translation.Overview,
+        &translation.CreatedAt,
+        &translation.Version,
+    )
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return nil, ErrRecordNotFound
+        default:
+            return nil, err
+        }
+    }
+
+    return &translation, nil
+}
+
+// Update a translation, using the version number to guard against concurrent
+// edits in the same way as MovieModel.Update().
+func (m MovieTranslationModel) Update(ctx context.Context, translation *MovieTranslation) error {
+    query := `
+        UPDATE movie_translations
+        SET title = $1, overview = $2, version = version + 1
+        WHERE movie_id = $3 AND lang = $4 AND version = $5
+        RETURNING version`
+
+    args := []interface{}{
+        translation.Title,
+        translation.Overview,
+        translation.MovieID,
+        translation.Language,
+        translation.Version,
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    err := m.DB.QueryRowContext(ctx, query, args...).Scan(&translation.Version)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return ErrEditConflict
+        default:
+            return err
+        }
+    }
+
+    return nil
+}
+
+func (m MovieTranslationModel) Delete(ctx context.Context, movieID int64, lang string) error {
+    query := `
+        DELETE FROM movie_translations
+        WHERE movie_id = $1 AND lang = $2`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    result, err := m.DB.ExecContext(ctx, query, movieID, lang)
+    if err != nil {
+        return err
+    }
+
+    rowsAffected, err := result.RowsAffected()
+    if err != nil {
+        return err
+    }
+
+    if rowsAffected == 0 {
+        return ErrRecordNotFound
+    }
+
+    return nil
+}
+
+// Localize sets the LocalizedTitle field of each movie to its title in the most
+// preferred of the given languages (canonical BCP 47 tags, most preferred first),
+// falling back to the canonical title when none of them has a translation. If no
+// languages are given the movies are left untouched.
+func (m MovieTranslationModel) Localize(ctx context.Context, movies []*Movie, langs []string) error {
+    if len(movies) == 0 || len(langs) == 0 {
+        return nil
+    }
+
+    // For each movie, pick the translation whose language comes first in the list
+    // of preferences.
+    query := `
+        SELECT DISTINCT ON (movie_id) movie_id, title
+        FROM movie_translations
+        WHERE movie_id = ANY($1) AND lang = ANY($2)
+        ORDER BY movie_id, array_position($2, lang)`
+
+    ids := make([]int64, len(movies))
+    for i, movie := range movies {
+        ids[i] = movie.ID
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), pq.Array(langs))
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    titles := make(map[int64]string)
+
+    for rows.Next() {
+        var id int64
+        var title string
+
+        err := rows.Scan(&id, &title)
+        if err != nil {
+            return err
+        }
+
+        titles[id] = title
+    }
+    if err = rows.Err(); err != nil {
+        return err
+    }
+
+    for _, movie := range movies {
+        movie.LocalizedTitle = movie.Title
+        if title, ok := titles[movie.ID]; ok {
+            movie.LocalizedTitle = title
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// Every time a movie is inserted or updated we store a full snapshot of the row
+// (as JSONB) in the movie_versions table, keyed by the movie ID and version number.
+// We store snapshots rather than diffs because a movie record is small, so the
+// extra storage is negligible, and it means any historical version can be read
+// back with a single row lookup instead of replaying a chain of diffs. Diffs
+// between two versions are cheap to compute on demand from their snapshots.
+
+// MovieVersion describes a single entry in the version history of a movie. The
+// ChangedBy field is nil when the change wasn't made by a known user.
+type MovieVersion struct {
+    Version int32 `json:"version"`
+    ChangedBy *int64 `json:"changed_by"`
+    ChangedAt time.Time `json:"changed_at"`
+}
+
+// FieldChange holds the old and new values of a field which differs between two
+// versions of a movie.
+type FieldChange struct {
+    From interface{} `json:"from"`
+    To interface{} `json:"to"`
+}
+
+// movieSnapshot is the shape of the JSONB snapshot stored for each version. Only
+// the fields which make up the public movie document are read back out of it.
+// Note that the runtime is stored as a plain integer (as it is in the movies table)
+// rather than in the "<n> mins" format of our JSON responses.
+type movieSnapshot struct {
+    Title string `json:"title"`
+    Year *int32 `json:"year"`
+    Runtime *int32 `json:"runtime"`
+    Genres []string `json:"genres"`
+    Certifications Certifications `json:"certifications"`
+    TrailerURL *string `json:"trailer_url"`
+    HomepageURL *string `json:"homepage_url"`
+}
+
+// insertMovieSnapshot copies the current state of the movie row into the
+// movie_versions table. It must be called in the same transaction as the
+// statement which created the new version.
+func insertMovieSnapshot(ctx context.Context, tx *sql.Tx, id int64) error {
+    query := `
+        INSERT INTO movie_versions (movie_id, version, snapshot)
+        SELECT id, version, to_jsonb(movies)
+        FROM movies
+        WHERE id = $1`
+
+    _, err := tx.ExecContext(ctx, query, id)
+    return err
+}
+
+// GetVersions returns the version history of a movie, oldest first.
+func (m MovieModel) GetVersions(ctx context.Context, id int64) ([]*MovieVersion, error) {
+    query := `
+        SELECT version, changed_by, changed_at
+        FROM movie_versions
+        WHERE movie_id = $1
+        ORDER BY version ASC`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    rows, err := m.DB.QueryContext(ctx, query, id)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    versions := []*MovieVersion{}
+
+    for rows.Next() {
+        var version MovieVersion
+
+        err := rows.Scan(&version.Version, &version.ChangedBy, &version.ChangedAt)
+        if err != nil {
+            return nil, err
+        }
+
+        versions = append(versions, &version)
+    }
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return versions, nil
+}
+
+// GetVersion reconstructs a movie as it was at the given version. If there is no
+// snapshot for that version an ErrRecordNotFound error is returned.
+func (m MovieModel) GetVersion(ctx context.Context, id int64, version int32) (*Movie, error) {
+    query := `
+        SELECT m.uuid, m.created_at, v.snapshot
+        FROM movie_versions v
+        INNER JOIN movies m ON m.id = v.movie_id
+        WHERE v.movie_id = $1 AND v.version = $2 AND m.deleted_at IS NULL`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var uuid string
+    var createdAt time.Time
+    var js []byte
+
+    err := m.DB.QueryRowContext(ctx, query, id, version).Scan(&uuid, &createdAt, &js)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return nil, ErrRecordNotFound
+        default:
+            return nil, err
+        }
+    }
+
+    var snapshot movieSnapshot
+
+    err = json.Unmarshal(js, &snapshot)
+    if err != nil {
+        return nil, err
+    }
+
+    movie := &Movie{
+        ID: id,
+        UUID: uuid,
+        CreatedAt: createdAt,
+        Title: snapshot.Title,
+        Year: snapshot.Year,
+        Genres: snapshot.Genres,
+        Certifications: snapshot.Certifications,
+        TrailerURL: snapshot.TrailerURL,
+        HomepageURL: snapshot.HomepageURL,
+        Version: version,
+    }
+
+    if snapshot.Runtime != nil {
+        runtime := Runtime(*snapshot.Runtime)
+        movie.Runtime = &runtime
+    }
+
+    return movie, nil
+}
+
+// DiffMovies returns the fields of the public movie document which differ between
+// two versions of a movie, keyed by their JSON name.
+func DiffMovies(from, to *Movie) map[string]FieldChange {
+    changes := make(map[string]FieldChange)
+
+    if from.Title != to.Title {
+        changes["title"] = FieldChange{From: from.Title, To: to.Title}
+    }
+
+    if !reflect.DeepEqual(from.Year, to.Year) {
+        changes["year"] = FieldChange{From: from.Year, To: to.Year}
+    }
+
+    if !reflect.DeepEqual(from.Runtime, to.Runtime) {
+        changes["runtime"] = FieldChange{From: from.Runtime, To: to.Runtime}
+    }
+
+    if !reflect.DeepEqual(from.Genres, to.Genres) {
+        changes["genres"] = FieldChange{From: from.Genres, To: to.Genres}
+    }
+
+    // Snapshots taken before certifications were added don't have the field, so
+    // treat a missing map the same as an empty one.
+    if len(from.Certifications) != 0 || len(to.Certifications) != 0 {
+        if !reflect.DeepEqual(from.Certifications, to.Certifications) {
+            changes["certifications"] = FieldChange{From: from.Certifications, To: to.Certifications}
+        }
+    }
+
+    if !reflect.DeepEqual(from.TrailerURL, to.TrailerURL) {
+        changes["trailer_url"] = FieldChange{From: from.TrailerURL, To: to.TrailerURL}
+    }
+
+    if !reflect.DeepEqual(from.HomepageURL, to.HomepageURL) {
+        changes["homepage_url"] = FieldChange{From: from.HomepageURL, To: to.HomepageURL}
+    }
+
+    return changes
+}
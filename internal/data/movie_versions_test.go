@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// DiffMovies compares every field stored in a snapshot, so a field added to the
+// snapshot has to be added to the diff (and to this test) too.
+func TestDiffMoviesCoversSnapshot(t *testing.T) {
+    year, runtime := int32(2016), Runtime(107)
+    trailer, homepage := "https://www.youtube.com/watch?v=LKFuXETZUsI", "https://movies.disney.com/moana"
+
+    from := &Movie{Title: "Moana"}
+    to := &Movie{
+        Title: "Vaiana",
+        Year: &year,
+        Runtime: &runtime,
+        Genres: []string{"animation"},
+        Certifications: Certifications{"US": "PG"},
+        TrailerURL: &trailer,
+        HomepageURL: &homepage,
+    }
+
+    changes := DiffMovies(from, to)
+
+    fields := reflect.TypeOf(movieSnapshot{})
+    for i := 0; i < fields.NumField(); i++ {
+        name, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+        if _, ok := changes[name]; !ok {
+            t.Errorf("DiffMovies() doesn't report a change to %q", name)
+        }
+    }
+    if len(changes) != fields.NumField() {
+        t.Errorf("DiffMovies() = %v; want a change for each of the %d snapshot fields", changes, fields.NumField())
+    }
+
+    if same := DiffMovies(to, to); len(same) != 0 {
+        t.Errorf("DiffMovies() of a movie with itself = %v; want no changes", same)
+    }
+
+    // A snapshot from before certifications existed has none, which isn't a change
+    // from an empty set.
+    if changes := DiffMovies(from, &Movie{Title: "Moana", Certifications: Certifications{}}); len(changes) != 0 {
+        t.Errorf("DiffMovies() with no certifications either side = %v; want no changes", changes)
+    }
+}
+
+// GetVersion reads back every field of the snapshot, including those added after
+// the version history.
+func TestMovieModelGetVersion(t *testing.T) {
+    models := newTestModels(t)
+    ctx := context.Background()
+
+    movie := insertTestMovie(t, models.Movies, "Moana", 2016, "animation")
+
+    trailer := "https://www.youtube.com/watch?v=LKFuXETZUsI"
+    movie.Certifications = Certifications{"US": "PG"}
+    movie.TrailerURL = &trailer
+    err := models.Movies.Update(ctx, movie)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    first, err := models.Movies.GetVersion(ctx, movie.ID, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := models.Movies.GetVersion(ctx, movie.ID, 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !reflect.DeepEqual(second.Certifications, movie.Certifications) || second.TrailerURL == nil || *second.TrailerURL != trailer {
+        t.Errorf("version 2 = %+v; want the certifications and trailer", second)
+    }
+
+    changes := DiffMovies(first, second)
+    for _, field := range []string{"certifications", "trailer_url"} {
+        if _, ok := changes[field]; !ok {
+            t.Errorf("diff of versions 1 and 2 = %v; want a change to %q", changes, field)
+        }
+    }
+    if len(changes) != 2 {
+        t.Errorf("diff of versions 1 and 2 = %v; want only certifications and trailer_url", changes)
+    }
+}
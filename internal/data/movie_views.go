@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Views of each movie are counted in memory by the API and added to the
+// movie_views table in batches, as a count per movie per day. Keeping a row per
+// day (rather than a running total) lets us report on a sliding window, such as
+// the last 30 days, and drop old rows if the table grows too large.
+
+// movieViewsLast30Days is an SQL expression for the number of views of the movie in
+// the current row in the last 30 days. It is used both to report the views and to
+// sort by them.
+const movieViewsLast30Days = `(SELECT coalesce(sum(v.views), 0) FROM movie_views v
+    WHERE v.movie_id = movies.id AND v.day > current_date - 30)`
+
+// AddViews adds the given number of views for each movie (keyed by ID) to their
+// counts for the given day. Views of movies which have since been deleted are
+// dropped.
+func (m MovieModel) AddViews(ctx context.Context, day time.Time, counts map[int64]int64) error {
+    if len(counts) == 0 {
+        return nil
+    }
+
+    query := `
+        INSERT INTO movie_views (movie_id, day, views)
+        SELECT c.movie_id, $3, c.views
+        FROM unnest($1::bigint[], $2::bigint[]) AS c(movie_id, views)
+        INNER JOIN movies ON movies.id = c.movie_id AND movies.deleted_at IS NULL
+        ON CONFLICT (movie_id, day) DO UPDATE
+        SET views = movie_views.views + EXCLUDED.views`
+
+    ids := make([]int64, 0, len(counts))
+    views := make([]int64, 0, len(counts))
+    for id, n := range counts {
+        ids = append(ids, id)
+        views = append(views, n)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    start := time.Now()
+    _, err := m.DB.ExecContext(ctx, query, pq.Array(ids), pq.Array(views), day.Format("2006-01-02"))
+    m.logSlowQuery("movies.add_views", start)
+
+    return err
+}
+
+// ViewsLast30Days returns the number of views of the movie in the last 30 days.
+func (m MovieModel) ViewsLast30Days(ctx context.Context, id int64) (int64, error) {
+    query := `SELECT ` + movieViewsLast30Days + ` FROM movies WHERE id = $1 AND deleted_at IS NULL`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var views int64
+
+    start := time.Now()
+    err := m.DB.QueryRowContext(ctx, query, id).Scan(&views)
+    m.logSlowQuery("movies.views_last_30_days", start)
+
+    return views, err
+}
@@ -5,48 +5,261 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/agpelkey/greenlight/internal/jsonlog"
 	"github.com/agpelkey/greenlight/internal/validator"
 	"github.com/lib/pq"
 )
 
 type MovieModel struct {
-    DB *sql.DB
+    DB *DB
+    // gets coalesces concurrent Get() calls for the same movie into a single query.
+    // If it is nil (e.g. for a MovieModel{} literal), every call queries the database.
+    gets *coalescer
+    // stats does the same for Stats() calls.
+    stats *coalescer
+    // dryRun makes Insert(), Update() and Delete() roll back their transaction
+    // instead of committing it. See DryRun().
+    dryRun bool
+    // If Logger is set, any query which takes longer than SlowQueryThreshold is
+    // logged along with its duration. A zero threshold disables the logging.
+    Logger *jsonlog.Logger
+    SlowQueryThreshold time.Duration
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// logSlowQuery logs the query with the given label if more than the slow query
+// threshold has passed since it started. It is called straight after each query,
+// so that the time taken to process the results isn't counted.
+func (m MovieModel) logSlowQuery(label string, start time.Time) {
+    if m.Logger == nil || m.SlowQueryThreshold <= 0 {
+        return
+    }
+
+    duration := time.Since(start)
+    if duration < m.SlowQueryThreshold {
+        return
+    }
+
+    m.Logger.PrintInfo("slow query", map[string]string{
+        "query": label,
+        "duration": duration.String(),
+    })
+}
+
+// DryRun returns a copy of the model whose Insert(), Update() and Delete() methods
+// run exactly as normal, including any database constraint checks, but then roll
+// back their changes instead of committing them. The movie struct is still updated
+// with the values it would have had (such as the new version number), so handlers
+// can show the client what would have happened.
+func (m MovieModel) DryRun() MovieModel {
+    m.dryRun = true
+    return m
+}
+
+// commit commits the transaction, or rolls it back if the model is in dry-run mode.
+func (m MovieModel) commit(tx *sql.Tx) error {
+    if m.dryRun {
+        return tx.Rollback()
+    }
+
+    return tx.Commit()
+}
+
+// MovieSearch holds the criteria used by GetAll() to select movies. The zero value
+// matches every movie.
+type MovieSearch struct {
+    // Title is matched against the movie title using full-text search.
+    Title string
+    // Genres lists genres which the movie must have all of.
+    Genres []string
+    // SearchTranslations extends the title search to the translated titles of the
+    // movie, as well as its canonical title.
+    SearchTranslations bool
+    // Certifications maps a rating system to the most restrictive rating allowed in
+    // it, e.g. {"US": "PG-13"} matches movies rated G, PG or PG-13 in the US.
+    Certifications map[string]string
+    // Unrated controls whether movies without any certifications are included in
+    // the results (UnratedInclude, UnratedExclude or UnratedOnly). The empty string
+    // is treated as UnratedInclude.
+    Unrated string
+    // Fuzzy matches the title by trigram similarity instead of full-text search, so
+    // that titles with typos (e.g. "Casablaca") are still found. Titles need a
+    // similarity of at least FuzzyThreshold (between 0 and 1) to match. Only
+    // GetAll() supports fuzzy searches.
+    Fuzzy bool
+    FuzzyThreshold float64
+    // Language is the text search configuration used to match the title in a
+    // full-text search, which decides how words are stemmed (with "english",
+    // "running" matches "run"). It must be one of TextSearchLanguages; the empty
+    // string is treated as "simple", which doesn't stem words at all.
+    Language string
+}
+
+// TextSearchLanguages lists the text search configurations built into PostgreSQL
+// (as of version 12), which MovieSearch.Language may be set to.
+var TextSearchLanguages = []string{
+    "simple", "arabic", "danish", "dutch", "english", "finnish", "french", "german",
+    "greek", "hungarian", "indonesian", "irish", "italian", "lithuanian", "nepali",
+    "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "tamil",
+    "turkish",
+}
+
+// movieSearchCondition is the WHERE condition which selects the movies matching a
+// MovieSearch. It takes the values returned by MovieSearch.args() as the
+// placeholders $1 to $5, so any other placeholders in the query must follow them.
+// The %[1]s and %[2]s verbs are replaced by condition() with the title matches for
+// the movies and movie_translations tables. Movies removed by a bulk delete are
+// never matched.
+const movieSearchCondition = `(%[1]s OR $1 = ''
+        OR ($3 AND EXISTS (
+            SELECT 1 FROM movie_translations t
+            WHERE t.movie_id = movies.id AND %[2]s)))
+    AND (genres @> $2 OR $2 = '{}') 
+    AND (certifications = '{}' OR NOT EXISTS (
+        SELECT 1 FROM jsonb_each($4::jsonb) f(system, allowed)
+        WHERE NOT (f.allowed ? coalesce(certifications ->> f.system, ''))))
+    AND CASE $5
+        WHEN 'exclude' THEN certifications <> '{}'
+        WHEN 'only' THEN certifications = '{}'
+        ELSE true
+    END
+    AND deleted_at IS NULL`
+
+// condition returns movieSearchCondition with the title match for the search: the
+// pg_trgm similarity operator for a fuzzy search, whose threshold is set by
+// GetAll(), and full-text search otherwise.
+//
+// The language is written into the query rather than passed as a placeholder, as
+// the planner only uses the expression indexes on to_tsvector() when the
+// configuration is a constant. It is safe to do so because it is checked against
+// TextSearchLanguages. Translated titles are always matched with "simple", as they
+// are in many languages, so stemming them as the main language would do more harm
+// than good.
+func (s MovieSearch) condition() string {
+    if s.Fuzzy {
+        return fmt.Sprintf(movieSearchCondition, `title % $1`, `t.title % $1`)
+    }
+
+    language := "simple"
+    if validator.In(s.Language, TextSearchLanguages...) {
+        language = s.Language
+    }
+
+    return fmt.Sprintf(movieSearchCondition,
+        fmt.Sprintf(`to_tsvector('%[1]s', title) @@ plainto_tsquery('%[1]s', $1)`, language),
+        `to_tsvector('simple', t.title) @@ plainto_tsquery('simple', $1)`)
+}
+
+// args returns the values for the placeholders in movieSearchCondition. The
+// certification filter is passed to the query as a JSON object mapping each rating
+// system to the list of ratings which satisfy it.
+func (s MovieSearch) args() ([]interface{}, error) {
+    certifications, err := certificationFilterJSON(s.Certifications)
+    if err != nil {
+        return nil, err
+    }
+
+    return []interface{}{s.Title, pq.Array(s.Genres), s.SearchTranslations, certifications, s.Unrated}, nil
+}
+
+// IsEmpty reports whether the search matches every movie. SearchTranslations on
+// its own doesn't narrow the search, as it only applies to a title search.
+func (s MovieSearch) IsEmpty() bool {
+    return s.Title == "" && len(s.Genres) == 0 && len(s.Certifications) == 0 &&
+        (s.Unrated == "" || s.Unrated == UnratedInclude)
+}
+
+func (m MovieModel) GetAll(ctx context.Context, search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+    // Initialize an empty slice to hold the movie data. It must not be nil, so that
+    // a search which matches nothing is returned as "movies": [] rather than null.
+    movies := []*Movie{}
+
+    metadata, err := m.StreamAll(ctx, search, filters, func(movie *Movie) error {
+        movies = append(movies, movie)
+        return nil
+    })
+    if err != nil {
+        return nil, Metadata{}, err
+    }
+
+    return movies, metadata, nil
+}
+
+// StreamAll runs the same query as GetAll, but calls fn with each movie as it is
+// read from the database rather than collecting the page into a slice, stopping at
+// the first error. The pagination metadata is only known once every row has been
+// read, so it is returned at the end.
+func (m MovieModel) StreamAll(ctx context.Context, search MovieSearch, filters Filters, fn func(*Movie) error) (Metadata, error) {
+    // The results of a fuzzy title search are ranked by how similar their titles
+    // are, with the requested sort order breaking ties.
+    column, err := filters.sortColumn()
+    if err != nil {
+        return Metadata{}, err
+    }
+
+    order := fmt.Sprintf("%s %s, id ASC", column, filters.sortDirection())
+    if search.Fuzzy && search.Title != "" {
+        order = "similarity(title, $1) DESC, " + order
+    }
+
     // Construct the SQL query to retreive all movie records
     query := fmt.Sprintf(`
-    SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version 
+    SELECT count(*) OVER(), id, uuid, created_at, title, year, runtime, genres, certifications, trailer_url, homepage_url, link_status, links_checked_at, version 
     FROM movies 
-    WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-    AND (genres @> $2 OR $2 = '{}') 
-    ORDER BY %s %s, id ASC
-    LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-        
+    WHERE %s
+    ORDER BY %s
+    LIMIT $6 OFFSET $7`, search.condition(), order)
+
+    searchArgs, err := search.args()
+    if err != nil {
+        return Metadata{}, err
+    }
+
     // Create context with 3 second timeout
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
     defer cancel()
 
     // Our SQL query now has quite a few placeholder parameters, lets collect the
     // values for the placeholders in a slice. Notice here how we call the limit()
     // and offset() methods on the Filters struct to get the appropriate values for the
     // LIMIT and OFFSET clauses.
-    args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+    args := append(searchArgs, filters.limit(), filters.offset())
+
+    // The threshold of the pg_trgm similarity operator is a setting rather than an
+    // operand, so a fuzzy search runs in a transaction which sets it for just this
+    // query. Using the operator (rather than comparing similarity() with the
+    // threshold) lets the query use the trigram index on the title.
+    var db queryer = m.DB
+    if search.Fuzzy {
+        tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+        if err != nil {
+            return Metadata{}, err
+        }
+        defer tx.Rollback()
+
+        _, err = tx.ExecContext(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`, strconv.FormatFloat(search.FuzzyThreshold, 'f', -1, 64))
+        if err != nil {
+            return Metadata{}, err
+        }
+
+        db = tx
+    }
 
     // Use QueryContext() to execute the query. This returns a sql.Rows resultset
     // containing the result
-    rows, err := m.DB.QueryContext(ctx, query, args...)
+    start := time.Now()
+    rows, err := db.QueryContext(ctx, query, args...)
+    m.logSlowQuery("movies.get_all", start)
     if err != nil {
-        return nil, Metadata{}, err
+        return Metadata{}, err
     }
 
     defer rows.Close()
 
-    // Initialize an empty slice to hold the movie data
     totalRecords := 0
-    movies := []*Movie{}
 
     // Use rows.Next to iterate through the rows in the resultset
     for rows.Next() {
@@ -55,49 +268,185 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
         err := rows.Scan(
             &totalRecords,
             &movie.ID,
+            &movie.UUID,
             &movie.CreatedAt,
             &movie.Title,
             &movie.Year,
             &movie.Runtime,
             pq.Array(&movie.Genres),
+            &movie.Certifications,
+            &movie.TrailerURL,
+            &movie.HomepageURL,
+            &movie.LinkStatus,
+            &movie.LinksCheckedAt,
             &movie.Version,
         )
         if err != nil {
-            return nil, Metadata{}, err
+            return Metadata{}, err
         }
 
-        movies = append(movies, &movie)
+        err = fn(&movie)
+        if err != nil {
+            return Metadata{}, err
+        }
     }
     if err = rows.Err(); err != nil {
-       return nil, Metadata{}, err 
+       return Metadata{}, err 
     }
 
-    metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+    return calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
 
-    return movies, metadata, nil
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    // Insert the movie and the snapshot of its first version in a single transaction,
+    // so that the version history can never get out of step with the movies table.
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    err = m.insert(ctx, tx, movie)
+    if err != nil {
+        return err
+    }
+
+    return m.commit(tx)
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// insert inserts the movie and the snapshot of its first version in the given
+// transaction, and updates the movie struct with the system-generated data.
+func (m MovieModel) insert(ctx context.Context, tx *sql.Tx, movie *Movie) error {
     // define the sql query for inserting a new record in the movies table 
     // and returning the system-generated data.
-    query := `INSERT INTO movies (title, year, runtime, genres) VALUES
-    ($1, $2, $3, $4) RETURNING id, created_at, version`
+    query := `INSERT INTO movies (title, year, runtime, genres, certifications, trailer_url, homepage_url) VALUES
+    ($1, $2, $3, $4, $5, $6, $7) RETURNING id, uuid, created_at, link_status, version`
 
     // create an args slice containing the values for the placeholder parameters
     // from thje movie struct. Declaring this slice immediately next to our SQL query
     // helps to make it nice and clear *what values are being used where* in the query
-    args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
-
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
+    args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres), movie.Certifications, movie.TrailerURL, movie.HomepageURL}
 
-    // use the QueryRow() method to execute the SQL query on our connection pool,
+    // use the QueryRow() method to execute the SQL query in the transaction,
     // passing in the args slice as a variadic parameter and scanning the system-
     // generated id, created_at, and version values into the movie struct
-    return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+    start := time.Now()
+    err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.UUID, &movie.CreatedAt, &movie.LinkStatus, &movie.Version)
+    m.logSlowQuery("movies.insert", start)
+    if err != nil {
+        return err
+    }
+
+    return insertMovieSnapshot(ctx, tx, movie.ID)
+}
+
+// Get returns the movie with the given ID. Concurrent calls for the same movie share
+// a single query (see coalescer), which protects the database when a popular movie
+// is requested by many clients at once. Each caller gets its own copy of the movie,
+// so that it is free to modify it.
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+    if m.gets == nil {
+        return m.get(ctx, id)
+    }
+
+    val, err := m.gets.do(ctx, strconv.FormatInt(id, 10), func(ctx context.Context) (interface{}, error) {
+        return m.get(ctx, id)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return val.(*Movie).clone(), nil
+}
+
+// GetLatest returns the movie with the given ID, like Get(), but always with a query
+// of its own. A query shared with other callers may have started before a change
+// that the caller knows about was committed, and so return the movie as it was.
+func (m MovieModel) GetLatest(ctx context.Context, id int64) (*Movie, error) {
+    return m.get(ctx, id)
+}
+
+// GetIDByUUID returns the ID of the movie with the given UUID, which must be well
+// formed. If there is no such movie an ErrRecordNotFound error is returned.
+func (m MovieModel) GetIDByUUID(ctx context.Context, uuid string) (int64, error) {
+    query := `SELECT id FROM movies WHERE uuid = $1 AND deleted_at IS NULL`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var id int64
+
+    start := time.Now()
+    err := m.DB.QueryRowContext(ctx, query, uuid).Scan(&id)
+    m.logSlowQuery("movies.get_id_by_uuid", start)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return 0, ErrRecordNotFound
+        default:
+            return 0, err
+        }
+    }
+
+    return id, nil
+}
+
+// Exists reports whether there is a movie with the given ID. It is for handlers of
+// the resources nested under a movie, such as its translations, which only need to
+// check that the movie exists and so shouldn't pay for reading the whole row.
+func (m MovieModel) Exists(ctx context.Context, id int64) (bool, error) {
+    if id < 1 {
+        return false, nil
+    }
+
+    query := `SELECT EXISTS(SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var exists bool
+
+    start := time.Now()
+    err := m.DB.QueryRowContext(ctx, query, id).Scan(&exists)
+    m.logSlowQuery("movies.exists", start)
+
+    return exists, err
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+// GetSummary returns the movie with the given ID with only its ID, UUID, title and
+// version filled in, for handlers which need to identify the movie but not the rest
+// of it. If there is no such movie an ErrRecordNotFound error is returned.
+func (m MovieModel) GetSummary(ctx context.Context, id int64) (*Movie, error) {
+    if id < 1 {
+        return nil, ErrRecordNotFound
+    }
+
+    query := `SELECT id, uuid, title, version FROM movies WHERE id = $1 AND deleted_at IS NULL`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var movie Movie
+
+    start := time.Now()
+    err := m.DB.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.UUID, &movie.Title, &movie.Version)
+    m.logSlowQuery("movies.get_summary", start)
+    if err != nil {
+        switch {
+        case errors.Is(err, sql.ErrNoRows):
+            return nil, ErrRecordNotFound
+        default:
+            return nil, err
+        }
+    }
+
+    return &movie, nil
+}
+
+func (m MovieModel) get(ctx context.Context, id int64) (*Movie, error) {
     // The PostgreSQL bigseriral type that we're using for the movie id
     // starts auto-incrementin at 1 by default, so we know that no movies will have
     // ID values less than that. To avoid making an unnecessary databse call, we take
@@ -107,17 +456,17 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
     }
 
     // Define the SQL query for retrieving the movie data.
-    query := `SELECT id, created_at, title, year, runtime, genres, version 
+    query := `SELECT id, uuid, created_at, title, year, runtime, genres, certifications, trailer_url, homepage_url, link_status, links_checked_at, version 
     FROM movies
-    WHERE id = $1`
+    WHERE id = $1 AND deleted_at IS NULL`
 
     // Declare a movie struct to hold the data returned by the query
     var movie Movie
 
     // Use the context.WithTimeout() function to create a context.Context which
-    // carries a 3-second timeout deadline. Note that we're using the empty context.Background()
-    // as the 'parent' context
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    // carries a 3-second timeout deadline. When the query is shared by Get() the
+    // parent context is a background one, so this timeout is what bounds it.
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 
     // importantly, user defer to make sure we cancel the context before the Get() method returns
     defer cancel()
@@ -126,15 +475,23 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
     // as a placeholder parameter, and scan the response data into the fields of the
     // Movie struct. Importantly, notice that we need to convert the scan target for the
     // genres column using the pq.Arrary() adpater function again.
+    start := time.Now()
     err := m.DB.QueryRowContext(ctx, query, id).Scan(
         &movie.ID,
+        &movie.UUID,
         &movie.CreatedAt,
         &movie.Title,
         &movie.Year,
         &movie.Runtime,
         pq.Array(&movie.Genres),
+        &movie.Certifications,
+        &movie.TrailerURL,
+        &movie.HomepageURL,
+        &movie.LinkStatus,
+        &movie.LinksCheckedAt,
         &movie.Version,
     )
+    m.logSlowQuery("movies.get", start)
 
     // Handler any errors. If there was no matching movie found, Scan() will return
     // a sql.ErrNoRows error. We check for this and return our custom ErrRecordNotFound
@@ -153,13 +510,19 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
     // Declare the SQL query for updating the record and returning the new version number
     query := `
         UPDATE movies
-        SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-        WHERE id = $5 AND version = $6
-        RETURNING version`
+        SET title = $1, year = $2, runtime = $3, genres = $4, certifications = $5,
+            trailer_url = $6, homepage_url = $7,
+            link_status = CASE
+                WHEN trailer_url IS DISTINCT FROM $6 OR homepage_url IS DISTINCT FROM $7 THEN 'unchecked'
+                ELSE link_status
+            END,
+            version = version + 1
+        WHERE id = $8 AND version = $9 AND deleted_at IS NULL
+        RETURNING link_status, version`
 
     // Create an args slice containing the values for the placeholder parameters
     args := []interface{}{
@@ -167,29 +530,42 @@ func (m MovieModel) Update(movie *Movie) error {
         movie.Year,
         movie.Runtime,
         pq.Array(movie.Genres),
+        movie.Certifications,
+        movie.TrailerURL,
+        movie.HomepageURL,
         movie.ID,
         movie.Version,
     }
 
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
     defer cancel()
 
+    // As with Insert(), the update and the snapshot of the new version are written in
+    // a single transaction.
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
     // Execute the SQL query. If no matching row could be found, we know the movie version has changed (or the record has been deleted)
-    // and we return our custom ErrEditConflict error.
-    err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+    // and optimisticUpdate() returns our custom ErrEditConflict error.
+    start := time.Now()
+    err = optimisticUpdate(tx.QueryRowContext(ctx, query, args...), movie, &movie.LinkStatus)
+    m.logSlowQuery("movies.update", start)
     if err != nil {
-        switch {
-        case errors.Is(err, sql.ErrNoRows):
-            return ErrEditConflict
-        default:
-            return err
-        }
+        return err
     }
 
-    return nil 
+    err = insertMovieSnapshot(ctx, tx, movie.ID)
+    if err != nil {
+        return err
+    }
+
+    return m.commit(tx)
 }
 
-func (m MovieModel) Delete(id int64) error {
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
     // Return an ErrRecordNotFound error if the movie ID is less than 1
     if id < 1 {
         return ErrRecordNotFound
@@ -198,15 +574,24 @@ func (m MovieModel) Delete(id int64) error {
     // Construct the SQL query to delete the record
     query := `
         DELETE FROM movies
-        WHERE id = $1`
+        WHERE id = $1 AND deleted_at IS NULL`
 
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
     defer cancel()
 
+    // The delete is run in a transaction so that it can be rolled back in dry-run mode.
+    tx, err := m.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
     // Execute the SQL query using the Exec() method, passing in the id variable as
     // the value for the placeholder parameter. The Exec() method returns a sql.Result
     // object.
-    result, err := m.DB.ExecContext(ctx, query, id)
+    start := time.Now()
+    result, err := tx.ExecContext(ctx, query, id)
+    m.logSlowQuery("movies.delete", start)
     if err != nil {
         return err
     }
@@ -225,29 +610,168 @@ func (m MovieModel) Delete(id int64) error {
         return ErrRecordNotFound
     }
 
-    return nil 
+    return m.commit(tx)
 }
 
 type Movie struct {
     ID int64 `json:"id"` 
+    // UUID is an alternative, non-sequential identifier for the movie, which is
+    // used in the API instead of the ID when the -id-type=uuid flag is set.
+    UUID string `json:"-"`
     CreatedAt time.Time `json:"-"`
     Title string `json:"title"`
-    Year int32 `json:"year,omitempty"`
-    Runtime Runtime `json:"runtime,omitempty,string"`
+    Year *int32 `json:"year,omitempty"` // nil when the release year is not known yet
+    Runtime *Runtime `json:"runtime,omitempty,string"` // nil when the runtime is not known yet
     Genres []string `json:"genres,omitempty"`
+    Certifications Certifications `json:"certifications,omitempty"`
+    TrailerURL *string `json:"trailer_url,omitempty"` // nil when there is no trailer
+    HomepageURL *string `json:"homepage_url,omitempty"` // nil when there is no homepage
+    // LinkStatus is the result of the last check of the links above by the link
+    // checker (LinkStatusUnchecked, LinkStatusOK or LinkStatusBroken), and
+    // LinksCheckedAt is when that was. They are only shown to admins.
+    LinkStatus string `json:"-"`
+    LinksCheckedAt *time.Time `json:"-"`
     Version int32  `json:"version"`
+    // LocalizedTitle is the title in the language requested by the client, set by
+    // MovieTranslationModel.Localize(). It is not stored in the movies table.
+    LocalizedTitle string `json:"-"`
+}
+
+// The default limits applied by ValidateMovie() when MovieRules doesn't set them.
+const (
+    DefaultMaxGenres = 5
+    DefaultMaxTitleBytes = 500
+)
+
+// VersionDest returns a pointer to the movie's version, for optimisticUpdate().
+func (movie *Movie) VersionDest() interface{} {
+    return &movie.Version
+}
+
+// clone returns a deep copy of the movie.
+func (movie *Movie) clone() *Movie {
+    c := *movie
+
+    if movie.Year != nil {
+        year := *movie.Year
+        c.Year = &year
+    }
+    if movie.Runtime != nil {
+        runtime := *movie.Runtime
+        c.Runtime = &runtime
+    }
+    if movie.Genres != nil {
+        c.Genres = append([]string(nil), movie.Genres...)
+    }
+    if movie.Certifications != nil {
+        c.Certifications = make(Certifications, len(movie.Certifications))
+        for system, rating := range movie.Certifications {
+            c.Certifications[system] = rating
+        }
+    }
+    if movie.TrailerURL != nil {
+        trailerURL := *movie.TrailerURL
+        c.TrailerURL = &trailerURL
+    }
+    if movie.HomepageURL != nil {
+        homepageURL := *movie.HomepageURL
+        c.HomepageURL = &homepageURL
+    }
+    if movie.LinksCheckedAt != nil {
+        checkedAt := *movie.LinksCheckedAt
+        c.LinksCheckedAt = &checkedAt
+    }
+
+    return &c
+}
+
+// MovieRules holds the deployment-specific validation policy for movies. The zero
+// value applies the default limits and no extra restrictions.
+type MovieRules struct {
+    // GenresAllowlist is the controlled vocabulary of approved genres. If it is
+    // empty, genres are free-text.
+    GenresAllowlist []string
+    // MaxGenres and MaxTitleBytes limit the number of genres a movie may have and the
+    // length of its title. If they are zero, DefaultMaxGenres and
+    // DefaultMaxTitleBytes are used.
+    MaxGenres int
+    MaxTitleBytes int
+}
+
+func ValidateMovie(v *validator.Validator, movie *Movie, rules MovieRules) {
+maxGenres := rules.MaxGenres
+if maxGenres == 0 {
+    maxGenres = DefaultMaxGenres
+}
+maxTitleBytes := rules.MaxTitleBytes
+if maxTitleBytes == 0 {
+    maxTitleBytes = DefaultMaxTitleBytes
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
-v.Check(movie.Title != "", "title", "must be provided")
-v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-v.Check(movie.Year != 0, "year", "must be provided")
-v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-v.Check(movie.Runtime != 0, "runtime", "must be provided")
-v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-v.Check(movie.Genres != nil, "genres", "must be provided")
-v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+v.Check(movie.Title != "", "title", "required")
+v.CheckParams(len(movie.Title) <= maxTitleBytes, "title", "title_too_long", map[string]string{
+    "max": strconv.Itoa(maxTitleBytes),
+})
+
+// The year and runtime are optional (e.g. for announced but unreleased movies),
+// but if they are provided they must be valid.
+if movie.Year != nil {
+    v.Check(*movie.Year >= 1888, "year", "year_too_early")
+    v.Check(*movie.Year <= int32(time.Now().Year()), "year", "year_in_future")
+}
+if movie.Runtime != nil {
+    v.Check(*movie.Runtime > 0, "runtime", "positive_integer")
+}
+
+v.Check(movie.Genres != nil, "genres", "required")
+v.Check(len(movie.Genres) >= 1, "genres", "genres_too_few")
+v.CheckParams(len(movie.Genres) <= maxGenres, "genres", "genres_too_many", map[string]string{
+    "max": strconv.Itoa(maxGenres),
+})
+v.Check(validator.UniqueFold(movie.Genres), "genres", "duplicate_values")
+
+// If an allowlist of genres has been configured, reject the first genre which
+// isn't on it, telling the client which values are accepted. If the genre is close
+// to an allowed one (a typo, or just different casing such as "drama"), the error
+// suggests it in its canonical form. The distance allowed grows with the length of
+// the genre, so that short genres aren't matched to unrelated ones.
+if len(rules.GenresAllowlist) > 0 {
+    for _, genre := range movie.Genres {
+        if validator.In(genre, rules.GenresAllowlist...) {
+            continue
+        }
+
+        params := map[string]string{
+            "genre": genre,
+            "allowed": strings.Join(rules.GenresAllowlist, ", "),
+        }
+
+        maxDistance := len(genre) / 3
+        if maxDistance < 1 {
+            maxDistance = 1
+        }
+
+        if suggestion, ok := validator.Closest(genre, maxDistance, rules.GenresAllowlist...); ok {
+            params["suggestion"] = suggestion
+            v.AddErrorParams("genres", "genre_not_allowed_suggestion", params)
+        } else {
+            v.AddErrorParams("genres", "genre_not_allowed", params)
+        }
+        break
+    }
+}
+
+ValidateCertifications(v, movie.Certifications)
+
+// The links are optional, but must be https URLs, and trailers must be hosted on
+// one of the video sites that we can embed.
+if movie.TrailerURL != nil {
+    v.Check(validator.HTTPSURL(*movie.TrailerURL), "trailer_url", "invalid_https_url")
+    v.CheckParams(validator.URLHostIn(*movie.TrailerURL, TrailerHosts...), "trailer_url", "trailer_host_not_allowed", map[string]string{
+        "hosts": strings.Join(TrailerHosts, ", "),
+    })
+}
+if movie.HomepageURL != nil {
+    v.Check(validator.HTTPSURL(*movie.HomepageURL), "homepage_url", "invalid_https_url")
+}
 }
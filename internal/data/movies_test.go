@@ -0,0 +1,221 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// insertTestMovie inserts a movie with the given title, year and genres, failing the
+// test if it can't.
+func insertTestMovie(t *testing.T, m MovieModel, title string, year int32, genres ...string) *Movie {
+    t.Helper()
+
+    runtime := Runtime(100)
+    movie := &Movie{Title: title, Year: &year, Runtime: &runtime, Genres: genres}
+
+    err := m.Insert(context.Background(), movie)
+    if err != nil {
+        t.Fatalf("inserting %q: %v", title, err)
+    }
+
+    return movie
+}
+
+func TestMovieModelInsertGet(t *testing.T) {
+    models := newTestModels(t)
+
+    movie := insertTestMovie(t, models.Movies, "Casablanca", 1942, "drama", "romance")
+    if movie.ID < 1 || movie.UUID == "" || movie.CreatedAt.IsZero() {
+        t.Fatalf("Insert() didn't set the generated fields: %+v", movie)
+    }
+    if movie.Version != 1 {
+        t.Errorf("Version = %d; want 1", movie.Version)
+    }
+    if movie.LinkStatus != LinkStatusUnchecked {
+        t.Errorf("LinkStatus = %q; want %q", movie.LinkStatus, LinkStatusUnchecked)
+    }
+
+    got, err := models.Movies.Get(context.Background(), movie.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Title != "Casablanca" || *got.Year != 1942 || *got.Runtime != 100 {
+        t.Errorf("Get() = %+v; want the inserted movie", got)
+    }
+    if !reflect.DeepEqual(got.Genres, []string{"drama", "romance"}) {
+        t.Errorf("Genres = %q; want [drama romance]", got.Genres)
+    }
+    if got.Version != movie.Version {
+        t.Errorf("Version = %d; want %d", got.Version, movie.Version)
+    }
+
+    _, err = models.Movies.Get(context.Background(), movie.ID+1)
+    if !errors.Is(err, ErrRecordNotFound) {
+        t.Errorf("Get() of a missing movie: err = %v; want ErrRecordNotFound", err)
+    }
+}
+
+func TestMovieModelGetAll(t *testing.T) {
+    models := newTestModels(t)
+
+    insertTestMovie(t, models.Movies, "The Breakfast Club", 1985, "comedy", "drama")
+    insertTestMovie(t, models.Movies, "Black Panther", 2018, "action", "adventure")
+    insertTestMovie(t, models.Movies, "Moana", 2016, "animation", "adventure")
+    insertTestMovie(t, models.Movies, "Deadpool", 2016, "action", "comedy")
+
+    safelist := []string{"id", "title", "year", "-id", "-title", "-year"}
+
+    tests := []struct {
+        name string
+        search MovieSearch
+        sort string
+        page int
+        pageSize int
+        want []string
+        wantTotal int
+    }{
+        {
+            name: "everything by id",
+            sort: "id",
+            want: []string{"The Breakfast Club", "Black Panther", "Moana", "Deadpool"},
+            wantTotal: 4,
+        },
+        {
+            name: "by title",
+            sort: "title",
+            want: []string{"Black Panther", "Deadpool", "Moana", "The Breakfast Club"},
+            wantTotal: 4,
+        },
+        {
+            name: "by year descending, ties broken by id",
+            sort: "-year",
+            want: []string{"Black Panther", "Moana", "Deadpool", "The Breakfast Club"},
+            wantTotal: 4,
+        },
+        {
+            name: "title search",
+            search: MovieSearch{Title: "panther"},
+            sort: "id",
+            want: []string{"Black Panther"},
+            wantTotal: 1,
+        },
+        {
+            name: "one genre",
+            search: MovieSearch{Genres: []string{"adventure"}},
+            sort: "id",
+            want: []string{"Black Panther", "Moana"},
+            wantTotal: 2,
+        },
+        {
+            name: "all of several genres",
+            search: MovieSearch{Genres: []string{"action", "comedy"}},
+            sort: "id",
+            want: []string{"Deadpool"},
+            wantTotal: 1,
+        },
+        {
+            name: "no matches",
+            search: MovieSearch{Title: "casablanca"},
+            sort: "id",
+            want: []string{},
+            wantTotal: 0,
+        },
+        {
+            name: "second page",
+            sort: "id",
+            page: 2,
+            pageSize: 3,
+            want: []string{"Deadpool"},
+            wantTotal: 4,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            filters := Filters{Page: 1, PageSize: 20, MaxPageSize: 100, Sort: tt.sort, SortSafelist: safelist}
+            if tt.page != 0 {
+                filters.Page, filters.PageSize = tt.page, tt.pageSize
+            }
+
+            movies, metadata, err := models.Movies.GetAll(context.Background(), tt.search, filters)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            titles := []string{}
+            for _, movie := range movies {
+                titles = append(titles, movie.Title)
+            }
+            if !reflect.DeepEqual(titles, tt.want) {
+                t.Errorf("titles = %q; want %q", titles, tt.want)
+            }
+            if metadata.TotalRecords != tt.wantTotal {
+                t.Errorf("TotalRecords = %d; want %d", metadata.TotalRecords, tt.wantTotal)
+            }
+        })
+    }
+}
+
+func TestMovieModelUpdateConflict(t *testing.T) {
+    models := newTestModels(t)
+
+    movie := insertTestMovie(t, models.Movies, "Moana", 2016, "animation")
+
+    // Two clients read the same version of the movie, and both try to update it.
+    first, err := models.Movies.Get(context.Background(), movie.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := models.Movies.Get(context.Background(), movie.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    first.Title = "Moana (2016)"
+    err = models.Movies.Update(context.Background(), first)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.Version != 2 {
+        t.Errorf("Version after update = %d; want 2", first.Version)
+    }
+
+    second.Title = "Vaiana"
+    err = models.Movies.Update(context.Background(), second)
+    if !errors.Is(err, ErrEditConflict) {
+        t.Fatalf("Update() of a stale version: err = %v; want ErrEditConflict", err)
+    }
+
+    got, err := models.Movies.Get(context.Background(), movie.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Title != "Moana (2016)" || got.Version != 2 {
+        t.Errorf("after the conflict: title = %q, version = %d; want the first update", got.Title, got.Version)
+    }
+}
+
+func TestMovieModelDelete(t *testing.T) {
+    models := newTestModels(t)
+
+    movie := insertTestMovie(t, models.Movies, "Deadpool", 2016, "action")
+
+    err := models.Movies.Delete(context.Background(), movie.ID)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    _, err = models.Movies.Get(context.Background(), movie.ID)
+    if !errors.Is(err, ErrRecordNotFound) {
+        t.Errorf("Get() after Delete(): err = %v; want ErrRecordNotFound", err)
+    }
+
+    for _, id := range []int64{movie.ID, movie.ID + 1, 0, -1} {
+        err = models.Movies.Delete(context.Background(), id)
+        if !errors.Is(err, ErrRecordNotFound) {
+            t.Errorf("Delete(%d): err = %v; want ErrRecordNotFound", id, err)
+        }
+    }
+}
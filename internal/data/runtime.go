@@ -12,7 +12,22 @@ var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 type Runtime int32
 
 func (r *Runtime) UnmarshalJSON(jsonvalue []byte) error {
-    
+
+    // Some clients send the runtime as a bare JSON integer, such as 107, instead of
+    // the "107 mins" string that we write. We accept that too, treating the number as
+    // minutes. Anything other than a whole number (e.g. 107.5 or 1e2) is still
+    // rejected.
+    if len(jsonvalue) > 0 && jsonvalue[0] != '"' {
+        i, err := strconv.ParseInt(string(jsonvalue), 10, 32)
+        if err != nil {
+            return ErrInvalidRuntimeFormat
+        }
+
+        *r = Runtime(i)
+
+        return nil
+    }
+
     unquotedJSONvalue, err := strconv.Unquote(string(jsonvalue))
     if err != nil {
         return ErrInvalidRuntimeFormat
@@ -42,3 +57,37 @@ func(r Runtime) MarshalJSON() ([]byte, error) {
 
     return []byte(quotedJSONValue), nil
 }
+
+// The formats in which a runtime can be written in our JSON responses. The default,
+// RuntimeFormatMins, is the "<n> mins" format of Runtime itself. RuntimeFormatHM
+// writes it as hours and minutes instead (see RuntimeHM).
+const (
+    RuntimeFormatMins = "mins"
+    RuntimeFormatHM = "hm"
+    // RuntimeFormatInt writes the runtime as a plain number of minutes (see
+    // RuntimeMinutes). It is the only format in version 2 of the API, and can't
+    // be asked for in version 1.
+    RuntimeFormatInt = "int"
+)
+
+// RuntimeHM is a Runtime which is encoded in JSON as hours and minutes, such as
+// "1h 47m" (or just "47m" for runtimes under an hour), for clients which want to
+// display it without any parsing. It is only used for output.
+type RuntimeHM Runtime
+
+func (r RuntimeHM) MarshalJSON() ([]byte, error) {
+    jsonValue := fmt.Sprintf("%dm", r%60)
+    if r >= 60 {
+        jsonValue = fmt.Sprintf("%dh %s", r/60, jsonValue)
+    }
+
+    return []byte(strconv.Quote(jsonValue)), nil
+}
+
+// RuntimeMinutes is a Runtime which is encoded in JSON as a bare integer number of
+// minutes, such as 107. It is only used for output.
+type RuntimeMinutes Runtime
+
+func (r RuntimeMinutes) MarshalJSON() ([]byte, error) {
+    return []byte(strconv.FormatInt(int64(r), 10)), nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/agpelkey/greenlight/internal/validator"
@@ -17,7 +18,7 @@ var (
 
 // Create a UserModel struct which wraps the connection pool
 type UserModel struct {
-    DB *sql.DB
+    DB *DB
 }
 
 // Define a user struct to represent an individual user. Importantly,
@@ -34,6 +35,11 @@ type User struct {
     Version int `json:"-"`
 }
 
+// VersionDest returns a pointer to the user's version, for optimisticUpdate().
+func (user *User) VersionDest() interface{} {
+    return &user.Version
+}
+
 // Create a custom password type which is a struct containing the 
 // plaintext and hashed versions of the password for a user.
 // The plaintext field is a *pointer* to a string, so that
@@ -49,21 +55,21 @@ type password struct {
 // and version fields are all automatically generated by our database. so we use
 // the RETURNING clause to read them into the User struct after the insert, in the same
 // way that we did when creating a movie
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
     query := `INSERT INTO users (name, email, password_hash, activated)
-            VALUES ($1, $2, $3, $4)
-            RETURNING id, created_at, version`
+            VALUES ($1, normalize($2, NFC), $3, $4)
+            RETURNING id, created_at, email, version`
             
-    args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+    args := []interface{}{user.Name, NormalizeEmail(user.Email), user.Password.hash, user.Activated}
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
     defer cancel()
 
     // If the table already contains a record with this email address, then when we try
     // to perform the insert there will be a violation of the UNIQUE "users_email_key"
     // constraint that we set up previously. We check for this error specifically, and return 
     // custom ErrDuplicateEmail error instead.
-    err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+    err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Email, &user.Version)
     if err != nil {
         switch {
         case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -79,18 +85,20 @@ func (m UserModel) Insert(user *User) error {
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (m UserModel) GetByEmail(email string) (*User, error) {
+// The address is normalized in the same way as when it was stored, so it matches
+// whatever casing or Unicode form the user typed it in.
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
     query := `
-        SELEC id, created_at, name, email, password_hash, activated, version
+        SELECT id, created_at, name, email, password_hash, activated, version
         FROM users
-        WHERE email = $1`
+        WHERE email = normalize($1, NFC)::citext`
 
     var user User
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
     defer cancel()
 
-    err := m.DB.QueryRowContext(ctx, query, email).Scan(
+    err := m.DB.QueryRowContext(ctx, query, NormalizeEmail(email)).Scan(
         &user.ID,
         &user.CreatedAt,
         &user.Name,
@@ -118,32 +126,30 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 // when updating a movie. We also check for a violation of the "users_email_key"
 // constraint when performing the update, just like we did when inserting the user
 // record originally.
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
     query := `
         UPDATE users
-        Set name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+        Set name = $1, email = normalize($2, NFC), password_hash = $3, activated = $4, version = version + 1
         WHERE id = $5 AND version = $6
-        RETURNING version`
+        RETURNING email, version`
 
     args := []interface{}{
         user.Name,
-        user.Email,
+        NormalizeEmail(user.Email),
         user.Password.hash,
         user.Activated,
         user.ID,
         user.Version,
     }
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
     defer cancel()
 
-    err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+    err := optimisticUpdate(m.DB.QueryRowContext(ctx, query, args...), user, &user.Email)
     if err != nil {
         switch {
-        case err.Error() == `pq: duplicate key value violates unqiue constraint "user_email_key"`:
+        case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
             return ErrDuplicateEmail
-        case errors.Is(err, sql.ErrNoRows):
-            return ErrEditConflict
         default:
             return err
         }
@@ -152,22 +158,50 @@ func (m UserModel) Update(user *User) error {
     return nil
 }
 
+// CountCreatedSince returns the number of users who registered at or after the given
+// time.
+func (m UserModel) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+    query := `SELECT count(*) FROM users WHERE created_at >= $1`
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    var count int
+
+    err := m.DB.QueryRowContext(ctx, query, since).Scan(&count)
+    return count, err
+}
+
+
+// NormalizeEmail returns the canonical form of an email address, which is how it is
+// stored and looked up: lowercased, so that "User@Example.com" and
+// "user@example.com" are the same user. It should be applied to every address
+// that a user gives us, before it is validated. The queries which store and look
+// up addresses also convert them to Unicode normalization form C (NFC), which is
+// done in PostgreSQL as the standard library has no Unicode normalization.
+//
+// Note that lowercasing is applied to the whole address, including the local part.
+// Strictly the local part is case-sensitive, but no mainstream mail provider treats
+// it that way, and users expect their address to match however they type it.
+func NormalizeEmail(email string) string {
+    return strings.ToLower(email)
+}
 
 func ValidateEmail(v *validator.Validator, email string) {
-    v.Check(email != "", "email", "must be provided")
-    v.Check(validator.Matches(email, *validator.EmailRX), "email", "must be a valid email address")
+    v.Check(email != "", "email", "required")
+    v.Check(validator.Matches(email, *validator.EmailRX), "email", "invalid_email")
 
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-    v.Check(password != "", "password", "must be provided")
-    v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-    v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+    v.Check(password != "", "password", "required")
+    v.Check(len(password) >= 8, "password", "password_too_short")
+    v.Check(len(password) <= 72, "password", "password_too_long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-    v.Check(user.Name != "", "name", "must be provided")
-    v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+    v.Check(user.Name != "", "name", "required")
+    v.Check(len(user.Name) <= 500, "name", "too_long")
 
     // Call the standalone ValidateEmail() helper
     ValidateEmail(v, user.Email)
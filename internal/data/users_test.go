@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+    tests := []struct {
+        name string
+        email string
+        want string
+    }{
+        {"ascii", "User@Example.COM", "user@example.com"},
+        {"already normalized", "user@example.com", "user@example.com"},
+        {"composed umlaut", "JÖRG@example.de", "jörg@example.de"},
+        // The NFC normalization is done by PostgreSQL, so a decomposed character is
+        // only lowercased here.
+        {"decomposed umlaut", "JO\u0308RG@example.de", "jo\u0308rg@example.de"},
+        {"capital sharp s", "STRAẞE@example.de", "straße@example.de"},
+        // Lowercasing doesn't give a word-final sigma its final form, so an address
+        // typed in capitals matches one typed with σ throughout, but not one with ς.
+        {"greek capitals", "ΟΔΥΣΣΕΥΣ@example.gr", "οδυσσευσ@example.gr"},
+        {"dotted capital i", "İSTANBUL@example.tr", "istanbul@example.tr"},
+        {"titlecase digraph", "ǅ@example.com", "ǆ@example.com"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := NormalizeEmail(tt.email); got != tt.want {
+                t.Errorf("NormalizeEmail(%q) = %q; want %q", tt.email, got, tt.want)
+            }
+        })
+    }
+}
+
+// insertTestUser registers a user with the given email, returning the error from
+// Insert().
+func insertTestUser(t *testing.T, m UserModel, email string) (*User, error) {
+    t.Helper()
+
+    user := &User{Name: "Test", Email: email, Activated: true}
+    if err := user.Password.Set("pa55word1234"); err != nil {
+        t.Fatal(err)
+    }
+
+    return user, m.Insert(context.Background(), user)
+}
+
+func TestUserModelEmailMatching(t *testing.T) {
+    models := newTestModels(t)
+
+    user, err := insertTestUser(t, models.Users, "JÖRG@Example.de")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if user.Email != "jörg@example.de" {
+        t.Errorf("stored email = %q; want it lowercased", user.Email)
+    }
+
+    // The address matches however it is cased, and whether the umlaut is composed
+    // or decomposed.
+    forms := []string{"jörg@example.de", "JÖRG@EXAMPLE.DE", "jo\u0308rg@example.de", "JO\u0308RG@Example.DE"}
+    for _, email := range forms {
+        got, err := models.Users.GetByEmail(context.Background(), email)
+        if err != nil {
+            t.Errorf("GetByEmail(%q): %v", email, err)
+            continue
+        }
+        if got.ID != user.ID {
+            t.Errorf("GetByEmail(%q) = user %d; want %d", email, got.ID, user.ID)
+        }
+    }
+
+    _, err = models.Users.GetByEmail(context.Background(), "jorg@example.de")
+    if !errors.Is(err, ErrRecordNotFound) {
+        t.Errorf("GetByEmail() without the umlaut: err = %v; want ErrRecordNotFound", err)
+    }
+
+    // Nobody else can register the same address in another form.
+    for _, email := range []string{"jörg@EXAMPLE.de", "JO\u0308RG@example.de"} {
+        _, err := insertTestUser(t, models.Users, email)
+        if !errors.Is(err, ErrDuplicateEmail) {
+            t.Errorf("Insert(%q): err = %v; want ErrDuplicateEmail", email, err)
+        }
+    }
+}
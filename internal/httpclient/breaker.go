@@ -0,0 +1,131 @@
+package httpclient
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned (without a request being made) when the circuit
+// breaker for the target host is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// The state of every breaker is published under the "httpclient_breakers" expvar,
+// keyed by "<client name>/<host>", so that it shows up at /debug/vars.
+var breakerStates = expvar.NewMap("httpclient_breakers")
+
+type breakerState int
+
+const (
+    stateClosed breakerState = iota
+    stateOpen
+    stateHalfOpen
+)
+
+func (s breakerState) String() string {
+    switch s {
+    case stateClosed:
+        return "closed"
+    case stateOpen:
+        return "open"
+    case stateHalfOpen:
+        return "half-open"
+    default:
+        return ""
+    }
+}
+
+// breaker is a circuit breaker for a single host. It opens after threshold
+// consecutive failures, rejecting requests until the cooldown has passed. It then
+// lets a single trial request through (half-open): if that succeeds the breaker
+// closes again, otherwise it reopens for another cooldown period.
+type breaker struct {
+    mu sync.Mutex
+    name string
+    threshold int
+    cooldown time.Duration
+    state breakerState
+    failures int
+    openedAt time.Time
+    published *expvar.String
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *breaker {
+    b := &breaker{
+        name: name,
+        threshold: threshold,
+        cooldown: cooldown,
+        published: new(expvar.String),
+    }
+    b.published.Set(stateClosed.String())
+    breakerStates.Set(name, b.published)
+
+    return b
+}
+
+// allow reports whether a request may be made.
+func (b *breaker) allow() bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    switch b.state {
+    case stateOpen:
+        if time.Since(b.openedAt) < b.cooldown {
+            return false
+        }
+        b.setState(stateHalfOpen)
+        return true
+    case stateHalfOpen:
+        // A trial request is already in flight.
+        return false
+    default:
+        return true
+    }
+}
+
+// record updates the breaker with the outcome of a request.
+func (b *breaker) record(success bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if success {
+        b.failures = 0
+        b.setState(stateClosed)
+        return
+    }
+
+    b.failures++
+    if b.state == stateHalfOpen || b.failures >= b.threshold {
+        b.openedAt = time.Now()
+        b.setState(stateOpen)
+    }
+}
+
+// setState must be called with the mutex held.
+func (b *breaker) setState(state breakerState) {
+    b.state = state
+    b.published.Set(state.String())
+}
+
+// breakerSet holds one breaker per host, created on first use.
+type breakerSet struct {
+    mu sync.Mutex
+    name string
+    threshold int
+    cooldown time.Duration
+    breakers map[string]*breaker
+}
+
+func (s *breakerSet) get(host string) *breaker {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    b, ok := s.breakers[host]
+    if !ok {
+        b = newBreaker(s.name+"/"+host, s.threshold, s.cooldown)
+        s.breakers[host] = b
+    }
+
+    return b
+}
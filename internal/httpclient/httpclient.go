@@ -0,0 +1,401 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/agpelkey/greenlight/internal/jsonlog"
+)
+
+// Config holds the settings for a Client. Any zero values are replaced with the
+// defaults noted below.
+type Config struct {
+    // Name identifies the client in log entries and in the breaker expvar keys,
+    // e.g. "tmdb" or "webhooks".
+    Name string
+    // Timeout bounds each individual attempt, including reading the response body
+    // (default 10 seconds).
+    Timeout time.Duration
+    // MaxRetries is the number of times a request is retried after a retryable
+    // response or network error (default 3). Use a negative value to disable
+    // retries.
+    MaxRetries int
+    // BaseBackoff and MaxBackoff bound the exponential backoff between retries
+    // (defaults 200 milliseconds and 10 seconds). MaxBackoff is also the longest
+    // Retry-After that we wait for.
+    BaseBackoff time.Duration
+    MaxBackoff time.Duration
+    // BreakerThreshold is the number of consecutive failures after which the
+    // circuit breaker for a host opens (default 5), and BreakerCooldown is how long
+    // it stays open before a trial request is allowed through (default 30 seconds).
+    BreakerThreshold int
+    BreakerCooldown time.Duration
+    // UserAgent is sent with every request which doesn't set its own User-Agent
+    // header, so that the services we call can tell who we are (default
+    // "greenlight").
+    UserAgent string
+    // LocalAddr, if set, is the local IP address that connections are made from, for
+    // deployments with a dedicated egress IP. It is ignored if Transport is set.
+    LocalAddr net.IP
+    // PublicOnly refuses connections to addresses which aren't on the public
+    // internet, such as loopback, private and link-local ones, for clients which
+    // request URLs supplied by users, so that the URLs can't be used to reach our
+    // own network. The address is checked when the connection is made, after the
+    // host name has been resolved, so a host name which resolves to a private
+    // address is refused too. Proxies from the environment aren't used, as the
+    // proxy would be dialed in place of the host. It is ignored if Transport is set.
+    PublicOnly bool
+    // Transport is used to make the requests (default http.DefaultTransport). Tests
+    // can inject their own RoundTripper here.
+    Transport http.RoundTripper
+    // Logger, if set, receives a DEBUG entry for every attempt, with the request
+    // and response bodies truncated to MaxLoggedBody bytes (default 1024).
+    Logger *jsonlog.Logger
+    MaxLoggedBody int
+}
+
+// Client is a wrapper around http.Client for talking to third-party services. It
+// adds per-attempt timeouts, retries with exponential backoff (honoring any
+// Retry-After header) for 429, 502, 503 and 504 responses and network errors, and
+// a circuit breaker per host. A response whose Retry-After asks for a longer wait
+// than MaxBackoff isn't retried but returned to the caller, so that a host can't
+// hold the caller up for as long as it likes.
+type Client struct {
+    cfg Config
+    client *http.Client
+    breakers *breakerSet
+}
+
+// New returns a new Client with the given configuration.
+func New(cfg Config) *Client {
+    if cfg.Name == "" {
+        cfg.Name = "default"
+    }
+    if cfg.Timeout == 0 {
+        cfg.Timeout = 10 * time.Second
+    }
+    if cfg.MaxRetries == 0 {
+        cfg.MaxRetries = 3
+    }
+    if cfg.BaseBackoff == 0 {
+        cfg.BaseBackoff = 200 * time.Millisecond
+    }
+    if cfg.MaxBackoff == 0 {
+        cfg.MaxBackoff = 10 * time.Second
+    }
+    if cfg.BreakerThreshold == 0 {
+        cfg.BreakerThreshold = 5
+    }
+    if cfg.BreakerCooldown == 0 {
+        cfg.BreakerCooldown = 30 * time.Second
+    }
+    if cfg.UserAgent == "" {
+        cfg.UserAgent = "greenlight"
+    }
+    if cfg.Transport == nil {
+        cfg.Transport = http.DefaultTransport
+
+        // Binding to a local address, or checking the addresses we connect to, needs a
+        // transport of our own, with the same settings as the default one but a
+        // dialer of our own.
+        if cfg.LocalAddr != nil || cfg.PublicOnly {
+            dialer := &net.Dialer{
+                Timeout: 30 * time.Second,
+                KeepAlive: 30 * time.Second,
+            }
+            if cfg.LocalAddr != nil {
+                dialer.LocalAddr = &net.TCPAddr{IP: cfg.LocalAddr}
+            }
+
+            transport := http.DefaultTransport.(*http.Transport).Clone()
+            if cfg.PublicOnly {
+                dialer.Control = refuseNonPublic
+                transport.Proxy = nil
+            }
+            transport.DialContext = dialer.DialContext
+            cfg.Transport = transport
+        }
+    }
+    if cfg.MaxLoggedBody == 0 {
+        cfg.MaxLoggedBody = 1024
+    }
+
+    return &Client{
+        cfg: cfg,
+        client: &http.Client{Transport: cfg.Transport},
+        breakers: &breakerSet{
+            name: cfg.Name,
+            threshold: cfg.BreakerThreshold,
+            cooldown: cfg.BreakerCooldown,
+            breakers: make(map[string]*breaker),
+        },
+    }
+}
+
+// Do sends the request, retrying it if necessary. A request with a body can only
+// be retried if req.GetBody is set, which http.NewRequest does automatically for
+// *bytes.Buffer, *bytes.Reader and *strings.Reader bodies. The caller must close
+// the response body, as with http.Client.Do.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+    breaker := c.breakers.get(req.URL.Host)
+
+    // The request is cloned before the header is added, as the caller's request
+    // mustn't be modified.
+    if req.Header.Get("User-Agent") == "" {
+        req = req.Clone(req.Context())
+        req.Header.Set("User-Agent", c.cfg.UserAgent)
+    }
+
+    maxRetries := c.cfg.MaxRetries
+    if maxRetries < 0 || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+        maxRetries = 0
+    }
+
+    for attempt := 0; ; attempt++ {
+        if !breaker.allow() {
+            return nil, ErrCircuitOpen
+        }
+
+        resp, err := c.attempt(req, attempt)
+
+        // Network errors and 5xx responses count as failures for the breaker. A 429
+        // only tells us that we are sending too much, not that the host is unhealthy.
+        breaker.record(err == nil && resp.StatusCode < 500)
+
+        if attempt >= maxRetries || !retryable(resp, err) {
+            return resp, err
+        }
+
+        // Work out how long to wait before retrying, preferring the server's
+        // Retry-After header if it sent one. Drain and close the body of the
+        // response we're discarding so the connection can be reused.
+        wait := c.backoff(attempt)
+        if resp != nil {
+            if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+                if retryAfter > c.cfg.MaxBackoff {
+                    return resp, nil
+                }
+                wait = retryAfter
+            }
+            io.Copy(io.Discard, resp.Body)
+            resp.Body.Close()
+        }
+
+        timer := time.NewTimer(wait)
+        select {
+        case <-req.Context().Done():
+            timer.Stop()
+            return nil, req.Context().Err()
+        case <-timer.C:
+        }
+
+        if req.GetBody != nil {
+            body, err := req.GetBody()
+            if err != nil {
+                return nil, err
+            }
+            req.Body = body
+        }
+    }
+}
+
+// attempt makes a single request with its own timeout.
+func (c *Client) attempt(req *http.Request, attempt int) (*http.Response, error) {
+    ctx, cancel := context.WithTimeout(req.Context(), c.cfg.Timeout)
+
+    start := time.Now()
+
+    resp, err := c.client.Do(req.WithContext(ctx))
+    if err != nil {
+        cancel()
+        c.log(req, nil, err, attempt, time.Since(start))
+        return nil, err
+    }
+
+    // The timeout must keep applying while the caller reads the body, so rather than
+    // cancelling the context here we cancel it when the body is closed.
+    resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+
+    c.log(req, resp, nil, attempt, time.Since(start))
+
+    return resp, nil
+}
+
+// log writes a DEBUG entry describing an attempt. To include the start of the
+// response body we read it and then stitch the bytes we read back onto the front of
+// the body, so that the caller still sees the full response.
+func (c *Client) log(req *http.Request, resp *http.Response, err error, attempt int, duration time.Duration) {
+    if c.cfg.Logger == nil {
+        return
+    }
+
+    properties := map[string]string{
+        "client": c.cfg.Name,
+        "method": req.Method,
+        "url": req.URL.String(),
+        "attempt": strconv.Itoa(attempt + 1),
+        "duration": duration.String(),
+    }
+
+    if req.GetBody != nil {
+        if body, err := req.GetBody(); err == nil {
+            properties["request_body"] = c.readTruncated(body)
+            body.Close()
+        }
+    }
+
+    if err != nil {
+        properties["error"] = err.Error()
+    }
+
+    if resp != nil {
+        properties["status"] = strconv.Itoa(resp.StatusCode)
+
+        prefix := make([]byte, c.cfg.MaxLoggedBody)
+        n, _ := io.ReadFull(resp.Body, prefix)
+        properties["response_body"] = string(prefix[:n])
+
+        resp.Body = struct {
+            io.Reader
+            io.Closer
+        }{io.MultiReader(bytes.NewReader(prefix[:n]), resp.Body), resp.Body}
+    }
+
+    c.cfg.Logger.PrintDebug("outbound http request", properties)
+}
+
+func (c *Client) readTruncated(r io.Reader) string {
+    b, _ := io.ReadAll(io.LimitReader(r, int64(c.cfg.MaxLoggedBody)))
+    return string(b)
+}
+
+// backoff returns the wait before the given retry: BaseBackoff doubled for each
+// previous attempt, capped at MaxBackoff, with "full jitter" so that many clients
+// failing at once don't all retry in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+    wait := c.cfg.BaseBackoff << attempt
+    if wait <= 0 || wait > c.cfg.MaxBackoff {
+        wait = c.cfg.MaxBackoff
+    }
+
+    return time.Duration(rand.Int63n(int64(wait)) + 1)
+}
+
+func retryable(resp *http.Response, err error) bool {
+    if errors.Is(err, ErrNonPublicAddress) {
+        return false
+    }
+    if err != nil {
+        return true
+    }
+
+    switch resp.StatusCode {
+    case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+        return true
+    default:
+        return false
+    }
+}
+
+// parseRetryAfter parses a Retry-After header, which may be either a number of
+// seconds or an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+    if value == "" {
+        return 0, false
+    }
+
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second, true
+    }
+
+    if t, err := http.ParseTime(value); err == nil {
+        wait := time.Until(t)
+        if wait < 0 {
+            wait = 0
+        }
+        return wait, true
+    }
+
+    return 0, false
+}
+
+type cancelOnClose struct {
+    io.ReadCloser
+    cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+    err := c.ReadCloser.Close()
+    c.cancel()
+    return err
+}
+
+// ErrNonPublicAddress is returned by a client with PublicOnly set when a request
+// would connect to an address which isn't on the public internet.
+var ErrNonPublicAddress = errors.New("httpclient: refusing to connect to a non-public address")
+
+// nonPublicPrefixes are the special-purpose ranges which isPublic refuses on top of
+// those that the netip.Addr methods know about: shared address space for carrier
+// NAT, the IETF protocol assignments, the documentation and benchmarking ranges,
+// the reserved class E range, and NAT64, through which an IPv6 address can reach
+// any IPv4 one.
+var nonPublicPrefixes = []netip.Prefix{
+    netip.MustParsePrefix("0.0.0.0/8"),
+    netip.MustParsePrefix("100.64.0.0/10"),
+    netip.MustParsePrefix("192.0.0.0/24"),
+    netip.MustParsePrefix("192.0.2.0/24"),
+    netip.MustParsePrefix("198.18.0.0/15"),
+    netip.MustParsePrefix("198.51.100.0/24"),
+    netip.MustParsePrefix("203.0.113.0/24"),
+    netip.MustParsePrefix("240.0.0.0/4"),
+    netip.MustParsePrefix("64:ff9b::/96"),
+    netip.MustParsePrefix("64:ff9b:1::/48"),
+    netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// isPublic reports whether the address is on the public internet: not loopback,
+// private, link-local, multicast, unspecified or otherwise reserved. IPv4 addresses
+// mapped into IPv6 are judged as IPv4 ones.
+func isPublic(addr netip.Addr) bool {
+    addr = addr.Unmap()
+
+    if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+        addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+        addr.IsMulticast() {
+        return false
+    }
+
+    for _, prefix := range nonPublicPrefixes {
+        if prefix.Contains(addr) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// refuseNonPublic is the Control function of the dialer of a PublicOnly client. It
+// is called with the resolved address just before each connection is made.
+func refuseNonPublic(network, address string, c syscall.RawConn) error {
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return err
+    }
+
+    addr, err := netip.ParseAddr(host)
+    if err != nil || !isPublic(addr) {
+        return fmt.Errorf("%w: %s", ErrNonPublicAddress, host)
+    }
+
+    return nil
+}
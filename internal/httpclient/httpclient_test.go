@@ -0,0 +1,295 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc lets a function be used as the Transport of a client.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+    return f(r)
+}
+
+// statusTransport answers each request with the next status in statuses, and the
+// last one once they run out, counting the requests it gets. Each response has the
+// given header.
+func statusTransport(requests *int, header http.Header, statuses ...int) http.RoundTripper {
+    return roundTripFunc(func(r *http.Request) (*http.Response, error) {
+        status := statuses[len(statuses)-1]
+        if *requests < len(statuses) {
+            status = statuses[*requests]
+        }
+        *requests++
+
+        return &http.Response{
+            StatusCode: status,
+            Header: header.Clone(),
+            Body: io.NopCloser(strings.NewReader("")),
+            Request: r,
+        }, nil
+    })
+}
+
+func doGet(t *testing.T, client *Client) (*http.Response, error) {
+    t.Helper()
+
+    req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    resp, err := client.Do(req)
+    if err == nil {
+        resp.Body.Close()
+    }
+
+    return resp, err
+}
+
+func TestIsPublic(t *testing.T) {
+    tests := []struct {
+        addr string
+        want bool
+    }{
+        {"93.184.216.34", true},
+        {"2606:2800:220:1:248:1893:25c8:1946", true},
+        {"127.0.0.1", false},
+        {"::1", false},
+        {"10.1.2.3", false},
+        {"172.16.0.1", false},
+        {"192.168.1.1", false},
+        {"169.254.169.254", false},
+        {"100.64.0.1", false},
+        {"0.0.0.0", false},
+        {"::", false},
+        {"fc00::1", false},
+        {"fe80::1", false},
+        {"::ffff:127.0.0.1", false},
+        {"::ffff:10.0.0.1", false},
+        {"64:ff9b::a00:1", false},
+        {"224.0.0.1", false},
+        {"255.255.255.255", false},
+    }
+
+    for _, tt := range tests {
+        if got := isPublic(netip.MustParseAddr(tt.addr)); got != tt.want {
+            t.Errorf("isPublic(%s) = %v; want %v", tt.addr, got, tt.want)
+        }
+    }
+}
+
+// A PublicOnly client refuses to connect to a local server, whether it is given
+// the address or a host name which resolves to it, and doesn't retry.
+func TestPublicOnly(t *testing.T) {
+    requests := 0
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests++
+    }))
+    defer ts.Close()
+
+    client := New(Config{PublicOnly: true})
+
+    for _, url := range []string{ts.URL, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)} {
+        req, err := http.NewRequest(http.MethodGet, url, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        resp, err := client.Do(req)
+        if err == nil {
+            resp.Body.Close()
+        }
+        if !errors.Is(err, ErrNonPublicAddress) {
+            t.Errorf("GET %s: err = %v; want ErrNonPublicAddress", url, err)
+        }
+    }
+
+    if requests != 0 {
+        t.Errorf("the server got %d requests; want none", requests)
+    }
+
+    // Without PublicOnly, the same request goes through.
+    req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+    resp, err := New(Config{}).Do(req)
+    if err != nil {
+        t.Fatalf("without PublicOnly: %v", err)
+    }
+    resp.Body.Close()
+}
+
+func TestRetries(t *testing.T) {
+    tests := []struct {
+        status int
+        wantRequests int
+        wantStatus int
+    }{
+        {http.StatusTooManyRequests, 2, http.StatusOK},
+        {http.StatusBadGateway, 2, http.StatusOK},
+        {http.StatusServiceUnavailable, 2, http.StatusOK},
+        {http.StatusGatewayTimeout, 2, http.StatusOK},
+        {http.StatusInternalServerError, 1, http.StatusInternalServerError},
+        {http.StatusNotFound, 1, http.StatusNotFound},
+    }
+
+    for _, tt := range tests {
+        requests := 0
+        client := New(Config{
+            Name: "test-retries",
+            BaseBackoff: time.Millisecond,
+            MaxBackoff: time.Millisecond,
+            Transport: statusTransport(&requests, nil, tt.status, http.StatusOK),
+        })
+
+        resp, err := doGet(t, client)
+        if err != nil {
+            t.Fatalf("%d: %v", tt.status, err)
+        }
+        if resp.StatusCode != tt.wantStatus || requests != tt.wantRequests {
+            t.Errorf("%d: got %d after %d requests; want %d after %d", tt.status, resp.StatusCode, requests, tt.wantStatus, tt.wantRequests)
+        }
+    }
+
+    // Retries stop after MaxRetries, returning the last response.
+    requests := 0
+    client := New(Config{
+        Name: "test-retries",
+        MaxRetries: 2,
+        BaseBackoff: time.Millisecond,
+        MaxBackoff: time.Millisecond,
+        Transport: statusTransport(&requests, nil, http.StatusServiceUnavailable),
+    })
+
+    resp, err := doGet(t, client)
+    if err != nil || resp.StatusCode != http.StatusServiceUnavailable || requests != 3 {
+        t.Errorf("with MaxRetries 2: got %v, %v after %d requests; want a 503 after 3", resp, err, requests)
+    }
+}
+
+func TestRetryAfter(t *testing.T) {
+    // A Retry-After of zero replaces a backoff which would otherwise take an hour.
+    requests := 0
+    client := New(Config{
+        Name: "test-retry-after",
+        BaseBackoff: time.Hour,
+        MaxBackoff: time.Hour,
+        Transport: statusTransport(&requests, http.Header{"Retry-After": {"0"}}, http.StatusServiceUnavailable, http.StatusOK),
+    })
+
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        resp, err := doGet(t, client)
+        if err != nil || resp.StatusCode != http.StatusOK || requests != 2 {
+            t.Errorf("got %v, %v after %d requests; want a 200 after 2", resp, err, requests)
+        }
+    }()
+
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("the retry waited for the backoff instead of Retry-After")
+    }
+
+    // A Retry-After beyond MaxBackoff isn't waited for: the response is returned.
+    for _, retryAfter := range []string{"999999999", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)} {
+        requests := 0
+        client := New(Config{
+            Name: "test-retry-after",
+            MaxBackoff: time.Second,
+            Transport: statusTransport(&requests, http.Header{"Retry-After": {retryAfter}}, http.StatusServiceUnavailable, http.StatusOK),
+        })
+
+        start := time.Now()
+        resp, err := doGet(t, client)
+        if err != nil || resp.StatusCode != http.StatusServiceUnavailable || requests != 1 {
+            t.Errorf("Retry-After %s: got %v, %v after %d requests; want the 503 after 1", retryAfter, resp, err, requests)
+        }
+        if elapsed := time.Since(start); elapsed > time.Second {
+            t.Errorf("Retry-After %s: took %v", retryAfter, elapsed)
+        }
+    }
+}
+
+func TestParseRetryAfter(t *testing.T) {
+    tests := []struct {
+        value string
+        want time.Duration
+        wantOK bool
+    }{
+        {"", 0, false},
+        {"120", 2 * time.Minute, true},
+        {"0", 0, true},
+        {"-1", 0, false},
+        {"soon", 0, false},
+        {"Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+    }
+
+    for _, tt := range tests {
+        got, ok := parseRetryAfter(tt.value)
+        if got != tt.want || ok != tt.wantOK {
+            t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+        }
+    }
+}
+
+// The breaker opens after BreakerThreshold consecutive failures, after which
+// requests fail without reaching the host, and closes again after a successful
+// trial once the cooldown has passed.
+func TestBreaker(t *testing.T) {
+    requests := 0
+    client := New(Config{
+        Name: "test-breaker",
+        MaxRetries: -1,
+        BreakerThreshold: 2,
+        BreakerCooldown: 50 * time.Millisecond,
+        Transport: statusTransport(&requests, nil, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK),
+    })
+
+    for i := 0; i < 2; i++ {
+        if _, err := doGet(t, client); err != nil {
+            t.Fatalf("request %d: %v", i+1, err)
+        }
+    }
+
+    if _, err := doGet(t, client); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("after 2 failures: err = %v; want ErrCircuitOpen", err)
+    }
+    if requests != 2 {
+        t.Errorf("the host got %d requests; want 2", requests)
+    }
+    if got := breakerStates.Get("test-breaker/example.com").String(); got != `"open"` {
+        t.Errorf("published state = %s; want \"open\"", got)
+    }
+
+    time.Sleep(60 * time.Millisecond)
+
+    resp, err := doGet(t, client)
+    if err != nil || resp.StatusCode != http.StatusOK {
+        t.Fatalf("after the cooldown: got %v, %v; want a 200", resp, err)
+    }
+    if got := breakerStates.Get("test-breaker/example.com").String(); got != `"closed"` {
+        t.Errorf("published state = %s; want \"closed\"", got)
+    }
+
+    // A 429 doesn't count as a failure.
+    requests = 0
+    client = New(Config{
+        Name: "test-breaker-429",
+        MaxRetries: -1,
+        BreakerThreshold: 1,
+        Transport: statusTransport(&requests, nil, http.StatusTooManyRequests),
+    })
+    for i := 0; i < 3; i++ {
+        if _, err := doGet(t, client); err != nil {
+            t.Fatalf("429 %d: %v", i+1, err)
+        }
+    }
+}
@@ -0,0 +1,133 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The translation tables are embedded in the binary, one JSON file per language,
+// each mapping a message key to its translated text.
+//
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// DefaultLanguage is used whenever the client doesn't ask for a language that we
+// have a translation table for.
+const DefaultLanguage = "en"
+
+// catalogs maps a language code (e.g. "fr") to its translation table.
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+    files, err := localeFS.ReadDir("locales")
+    if err != nil {
+        panic(err)
+    }
+
+    catalogs := make(map[string]map[string]string)
+
+    for _, file := range files {
+        js, err := localeFS.ReadFile("locales/" + file.Name())
+        if err != nil {
+            panic(err)
+        }
+
+        var messages map[string]string
+        err = json.Unmarshal(js, &messages)
+        if err != nil {
+            panic("invalid translation table " + file.Name() + ": " + err.Error())
+        }
+
+        lang := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+        catalogs[lang] = messages
+    }
+
+    return catalogs
+}
+
+// Translate returns the message for the given key in the given language, with any
+// {name} placeholders replaced by the corresponding value in params. If the
+// language doesn't have a translation for the key we fall back to English, and if
+// there is no English message either we return the key itself so that nothing is
+// silently dropped from the response.
+func Translate(lang, key string, params map[string]string) string {
+    message, ok := catalogs[lang][key]
+    if !ok {
+        message, ok = catalogs[DefaultLanguage][key]
+        if !ok {
+            return key
+        }
+    }
+
+    for name, value := range params {
+        message = strings.ReplaceAll(message, "{"+name+"}", value)
+    }
+
+    return message
+}
+
+// Match parses the value of an Accept-Language header (e.g. "fr-CH, fr;q=0.9,
+// en;q=0.8") and returns the supported language with the highest quality value.
+// Region subtags are ignored, so "fr-CH" selects the "fr" table. If none of the
+// requested languages are supported, DefaultLanguage is returned.
+func Match(acceptLanguage string) string {
+    for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+        lang, _, _ := strings.Cut(tag, "-")
+        if _, ok := catalogs[lang]; ok {
+            return lang
+        }
+    }
+
+    return DefaultLanguage
+}
+
+// ParseAcceptLanguage parses the value of an Accept-Language header and returns the
+// language tags it contains, lowercased and ordered from most to least preferred.
+// Tags with a quality value of zero and the "*" wildcard are left out.
+func ParseAcceptLanguage(acceptLanguage string) []string {
+    type candidate struct {
+        tag string
+        q float64
+    }
+
+    var candidates []candidate
+
+    for _, part := range strings.Split(acceptLanguage, ",") {
+        tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        if tag == "" || tag == "*" {
+            continue
+        }
+
+        q := 1.0
+        if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+            parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+            if err != nil {
+                continue
+            }
+            q = parsed
+        }
+
+        if q <= 0 {
+            continue
+        }
+
+        candidates = append(candidates, candidate{tag: strings.ToLower(tag), q: q})
+    }
+
+    // Use a stable sort so that languages with the same quality value keep the
+    // order in which the client listed them.
+    sort.SliceStable(candidates, func(i, j int) bool {
+        return candidates[i].q > candidates[j].q
+    })
+
+    tags := make([]string, len(candidates))
+    for i, c := range candidates {
+        tags[i] = c.tag
+    }
+
+    return tags
+}
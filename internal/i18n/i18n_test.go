@@ -0,0 +1,199 @@
+package i18n
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// messageKeyArgs maps the validator methods which take a message key to the
+// position of the key among their arguments.
+var messageKeyArgs = map[string]int{
+    "Check": 2,
+    "CheckParams": 2,
+    "AddError": 1,
+    "AddErrorParams": 1,
+}
+
+// usedMessageKeys returns the message keys passed as string literals to the
+// validator methods anywhere in the module, mapped to where each is first used.
+// Keys passed in variables (such as inside the validator itself) can't be known
+// without running the code, so they are left out.
+func usedMessageKeys(t *testing.T) map[string]string {
+    t.Helper()
+
+    root, err := filepath.Abs(filepath.Join("..", ".."))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(filepath.Join(root, "go.mod")); err != nil {
+        t.Fatalf("module root not found: %v", err)
+    }
+
+    keys := make(map[string]string)
+    fset := token.NewFileSet()
+
+    err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
+            return filepath.SkipDir
+        }
+        if d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+            return nil
+        }
+
+        file, err := parser.ParseFile(fset, path, nil, 0)
+        if err != nil {
+            return err
+        }
+
+        ast.Inspect(file, func(n ast.Node) bool {
+            call, ok := n.(*ast.CallExpr)
+            if !ok {
+                return true
+            }
+            sel, ok := call.Fun.(*ast.SelectorExpr)
+            if !ok {
+                return true
+            }
+            i, ok := messageKeyArgs[sel.Sel.Name]
+            if !ok || len(call.Args) <= i {
+                return true
+            }
+            lit, ok := call.Args[i].(*ast.BasicLit)
+            if !ok || lit.Kind != token.STRING {
+                return true
+            }
+
+            key, err := strconv.Unquote(lit.Value)
+            if err != nil {
+                t.Errorf("%s: %v", fset.Position(lit.Pos()), err)
+                return true
+            }
+            if _, seen := keys[key]; !seen {
+                pos := fset.Position(lit.Pos())
+                rel, _ := filepath.Rel(root, pos.Filename)
+                keys[key] = fmt.Sprintf("%s:%d", rel, pos.Line)
+            }
+            return true
+        })
+
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return keys
+}
+
+// TestCatalogsComplete checks that every message key used in the code has a
+// message in every catalog, so that no language silently falls back to English.
+func TestCatalogsComplete(t *testing.T) {
+    keys := usedMessageKeys(t)
+    if len(keys) < 10 {
+        t.Fatalf("found only %d message keys in the code; is the search broken?", len(keys))
+    }
+
+    for lang, catalog := range catalogs {
+        for key, pos := range keys {
+            if _, ok := catalog[key]; !ok {
+                t.Errorf("%s.json has no message for %q (used at %s)", lang, key, pos)
+            }
+        }
+    }
+}
+
+// placeholderRX matches the {name} placeholders in a message.
+var placeholderRX = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// TestCatalogsConsistent checks that every catalog has the same keys as the
+// English one, and that each translation uses the same placeholders as the English
+// message, so that none of the values are lost.
+func TestCatalogsConsistent(t *testing.T) {
+    english := catalogs[DefaultLanguage]
+    if len(english) == 0 {
+        t.Fatalf("no %s catalog", DefaultLanguage)
+    }
+
+    placeholders := func(message string) []string {
+        found := placeholderRX.FindAllString(message, -1)
+        sort.Strings(found)
+        return found
+    }
+
+    for lang, catalog := range catalogs {
+        for key, message := range catalog {
+            want, ok := english[key]
+            if !ok {
+                t.Errorf("%s.json has %q, which isn't in %s.json", lang, key, DefaultLanguage)
+                continue
+            }
+            if got, want := placeholders(message), placeholders(want); !reflect.DeepEqual(got, want) {
+                t.Errorf("%s.json message for %q has placeholders %v; want %v", lang, key, got, want)
+            }
+        }
+        for key := range english {
+            if _, ok := catalog[key]; !ok {
+                t.Errorf("%s.json has no message for %q", lang, key)
+            }
+        }
+    }
+}
+
+func TestTranslate(t *testing.T) {
+    tests := []struct {
+        lang, key string
+        params map[string]string
+        want string
+    }{
+        {"en", "required", nil, "must be provided"},
+        {"de", "required", nil, "must be provided"},
+        {"en", "page_size_too_large", map[string]string{"max": "100"}, "must be a maximum of 100"},
+        {"en", "no_such_key", nil, "no_such_key"},
+    }
+
+    for _, tt := range tests {
+        if got := Translate(tt.lang, tt.key, tt.params); got != tt.want {
+            t.Errorf("Translate(%q, %q, %v) = %q; want %q", tt.lang, tt.key, tt.params, got, tt.want)
+        }
+    }
+
+    if got := Translate("fr", "required", nil); got == Translate("en", "required", nil) {
+        t.Errorf("Translate(\"fr\", \"required\") = %q, the English message", got)
+    }
+}
+
+func TestMatch(t *testing.T) {
+    tests := []struct {
+        header string
+        want string
+    }{
+        {"", "en"},
+        {"fr", "fr"},
+        {"fr-CH, fr;q=0.9, en;q=0.8", "fr"},
+        {"de, fr;q=0.5", "fr"},
+        {"en;q=0.5, fr;q=0.9", "fr"},
+        {"fr;q=0, en", "en"},
+        {"*", "en"},
+        {"de", "en"},
+    }
+
+    for _, tt := range tests {
+        if got := Match(tt.header); got != tt.want {
+            t.Errorf("Match(%q) = %q; want %q", tt.header, got, tt.want)
+        }
+    }
+}
@@ -17,7 +17,9 @@ type Level int8
 // to the constants
 
 const (
-    LevelInfo Level = iota   // Has the value of 0
+    LevelDebug Level = iota  // Has the value of 0
+    LevelInfo
+    LevelWarn
     LevelError
     LevelFatal 
     LevelOff
@@ -27,8 +29,12 @@ const (
 // Return a human-friendly string for the severity level
 func (l Level) String() string {
     switch l {
+    case LevelDebug:
+        return "DEBUG"
     case LevelInfo:
         return "INFO"
+    case LevelWarn:
+        return "WARN"
     case LevelError:
         return "ERROR"
     case LevelFatal:
@@ -60,10 +66,20 @@ func New(out io.Writer, minLevel Level) *Logger {
 // Declare some helper methods for writing log entries at the different level.
 // Notice that these all accept a map as the second parameter which
 // can contain any arbitrary 'properties' that you want to appear in the log entry
+func (l *Logger) PrintDebug(message string, properties map[string]string) {
+    l.print(LevelDebug, message, properties)
+}
+
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
     l.print(LevelInfo, message, properties)
 }
 
+// PrintWarn is for things which aren't errors, but which someone should look into,
+// such as a client still using a deprecated endpoint.
+func (l *Logger) PrintWarn(message string, properties map[string]string) {
+    l.print(LevelWarn, message, properties)
+}
+
 func (l *Logger) PrintError(err error, properties map[string]string) {
     l.print(LevelError, err.Error(), properties)
 }
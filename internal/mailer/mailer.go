@@ -2,47 +2,78 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
 	"html/template"
+	"net"
+	netmail "net/mail"
+	"strings"
 	"time"
 
 	"github.com/go-mail/mail"
 )
 
+// The email templates, and the fixture data used to preview them, are embedded in
+// the binary from the templates directory.
+//
+//go:embed "templates"
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mailer.Dialer instance
-// (used to connect to a SMTP server) and the sender information
-// for your emails (the name and address you want the email to be
-// from, such as "Alice Smith <alice@example.com>").
+// ErrInvalidRecipient is returned when a recipient address can't be parsed, or (if
+// requested) its domain can't receive email. Callers can check for it with
+// errors.Is() and report the problem to the user rather than as a server error.
+var ErrInvalidRecipient = errors.New("invalid recipient address")
+
+// Define a Mailer struct which contains the settings used to connect to the SMTP
+// server (see Config in smtp.go) and the sender information for your emails (the
+// name and address you want the email to be from, such as
+// "Alice Smith <alice@example.com>").
 type Mailer struct {
-    dialer *mail.Dialer
-    sender string
+    cfg Config
 }
 
-
-func New(host string, port int, username, password, sender string) Mailer {
-    // Initialize a new mail.Dialer instance with the given SMTP server settings.
-    // We also configure this to use a 5-second timeout whenever we send an email.
-    dialer := mail.NewDialer(host, port, username, password)
-    dialer.Timeout = 5 * time.Second
-
-    // Return a Mailer instance containing the dialer and sender information
-    return Mailer{
-        dialer: dialer,
-        sender: sender,
+func New(cfg Config) Mailer {
+    // Use a 5-second timeout for each stage of sending an email unless told
+    // otherwise, and never send without encryption unless explicitly asked to.
+    if cfg.Timeout == 0 {
+        cfg.Timeout = 5 * time.Second
     }
+    if cfg.Encryption == "" {
+        cfg.Encryption = EncryptionSTARTTLS
+    }
+
+    return Mailer{cfg: cfg}
 }
 
 // Define a Send() method on the Mailer type. This takes the recipient email address
 // as the first parameter, the name of the file containing the templates, and any
 // dynamic data for the templates as an interface{} parameter.
 func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+    // Check the recipient address before doing any other work, so that a malformed
+    // address is reported as such rather than as an error from the SMTP server.
+    _, err := ParseAddress(recipient)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+    }
+
+    message, err := Render(templateFile, data)
+    if err != nil {
+        return err
+    }
+
+    return m.SendMessage(recipient, message)
+}
+
+// Render executes the "subject", "plainBody" and "htmlBody" templates in the given
+// template file with the dynamic data, and returns the resulting message.
+func Render(templateFile string, data interface{}) (*Message, error) {
     // Use the ParseFS() method to parse the required template file from the embedded
     // file system.
     tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
     if err != nil {
-        return err
+        return nil, err
     }
 
     // Execute the named template "subject", passing in the dynamic data and storing
@@ -50,7 +81,7 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
     subject := new(bytes.Buffer)
     err = tmpl.ExecuteTemplate(subject, "subject", data)
     if err != nil {
-        return err
+        return nil, err
     }
 
     // Follow the same pattern to execute the "plainBody" template and store the result
@@ -58,14 +89,28 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
     plainBody := new(bytes.Buffer)
     err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
     if err != nil {
-        return err
+        return nil, err
     }
 
     // and Likewise with the "htmlBody" templateFile
     htmlBody := new(bytes.Buffer)
     err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
     if err != nil {
-        return err
+        return nil, err
+    }
+
+    return &Message{
+        Subject: subject.String(),
+        PlainBody: plainBody.String(),
+        HTMLBody: htmlBody.String(),
+    }, nil
+}
+
+// SendMessage sends an already rendered message to the recipient.
+func (m Mailer) SendMessage(recipient string, message *Message) error {
+    _, err := ParseAddress(recipient)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
     }
 
     // Use the mail.NewMessage() function to initialize a new mail.Message instance.
@@ -75,19 +120,81 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
     // always be called *after* SetBody()
     msg := mail.NewMessage()
     msg.SetHeader("To", recipient)
-    msg.SetHeader("From", m.sender)
-    msg.SetHeader("Subject", subject.String())
-    msg.SetBody("text/plain", plainBody.String())
-    msg.AddAlternative("text/html", htmlBody.String())
-
-    // Call the DialAndSend() method on the dialer, passing in the message to send.
-    // This opens a connection to the SMTP server, sends the message, then closes the
-    // connection. If there is a timeout, it will return a "dial tcp: i/o timeout" error
-    err = m.dialer.DialAndSend(msg)
+    msg.SetHeader("From", m.cfg.Sender)
+    msg.SetHeader("Subject", message.Subject)
+    msg.SetBody("text/plain", message.PlainBody)
+    msg.AddAlternative("text/html", message.HTMLBody)
+
+    // Open a connection to the SMTP server, send the message, then close the
+    // connection. If there is a timeout, it will return an "i/o timeout" error.
+    conn, err := m.dial()
     if err != nil {
         return err
     }
 
-    return nil
+    return conn.send(m.cfg.Timeout, msg)
+}
+
+// Check connects to the SMTP server and authenticates, then closes the connection
+// without sending anything. It is used to catch a wrong host or password, or a
+// server which can't provide the configured encryption, at deployment time rather
+// than when the first email fails to send.
+func (m Mailer) Check() error {
+    conn, err := m.dial()
+    if err != nil {
+        return err
+    }
+    defer conn.client.Close()
+
+    return conn.client.Quit()
+}
+
+// ParseAddress parses an email address in any of the RFC 5322 forms accepted by
+// net/mail, such as "alice@example.com", "Alice Smith <alice@example.com>" or
+// "alice@example.com (Alice)". It is used both for the sender address, which is
+// checked at startup, and for recipient addresses before an email is sent.
+func ParseAddress(address string) (*netmail.Address, error) {
+    return netmail.ParseAddress(address)
+}
+
+// ValidateRecipient checks that an email address can be parsed and, if checkMX is
+// true, that its domain has a mail server, which catches typos in the domain such
+// as "gmial.com". If the address is invalid the returned error wraps
+// ErrInvalidRecipient. A failed DNS lookup (as opposed to a domain which doesn't
+// exist) isn't treated as an invalid address, so that a DNS outage doesn't stop
+// users from registering.
+func ValidateRecipient(ctx context.Context, recipient string, checkMX bool) error {
+    addr, err := ParseAddress(recipient)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+    }
+
+    if !checkMX {
+        return nil
+    }
+
+    domain := addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+
+    ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+    defer cancel()
+
+    _, err = net.DefaultResolver.LookupMX(ctx, domain)
+    if err == nil {
+        return nil
+    }
+
+    // A domain without any MX records can still receive email at the address of
+    // its A or AAAA record (RFC 5321 section 5.1), so only reject the address if
+    // the domain doesn't resolve at all.
+    var dnsErr *net.DNSError
+    if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+        return nil
+    }
 
+    _, err = net.DefaultResolver.LookupHost(ctx, domain)
+    if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+        return fmt.Errorf("%w: no mail server for domain %q", ErrInvalidRecipient, domain)
+    }
+
+    return nil
 }
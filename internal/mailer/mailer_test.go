@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+    tests := []struct {
+        name string
+        address string
+        wantName string
+        wantAddress string
+        wantErr bool
+    }{
+        {"plain", "alice@example.com", "", "alice@example.com", false},
+        {"display name", "Alice Smith <alice@example.com>", "Alice Smith", "alice@example.com", false},
+        {"quoted display name", `"Smith, Alice" <alice@example.com>`, "Smith, Alice", "alice@example.com", false},
+        {"unicode display name", "José <jose@example.com>", "José", "jose@example.com", false},
+        {"unicode local part", "jörg@example.com", "", "jörg@example.com", false},
+        {"unicode local part and domain", "用户@例子.广告", "", "用户@例子.广告", false},
+        {"trailing comment", "alice@example.com (Alice)", "Alice", "alice@example.com", false},
+        {"comment in display name", "Alice (work) <alice@example.com>", "Alice", "alice@example.com", false},
+        {"display name without address", "Greenlight no-reply@", "", "", true},
+        {"empty domain label", "user@gmial..com", "", "", true},
+        {"missing domain", "user@", "", "", true},
+        {"missing local part", "@example.com", "", "", true},
+        {"two addresses", "alice@example.com, bob@example.com", "", "", true},
+        {"empty", "", "", "", true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            addr, err := ParseAddress(tt.address)
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatalf("ParseAddress(%q) = %v; want an error", tt.address, addr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ParseAddress(%q) returned error: %v", tt.address, err)
+            }
+
+            if addr.Name != tt.wantName || addr.Address != tt.wantAddress {
+                t.Errorf("ParseAddress(%q) = (%q, %q); want (%q, %q)", tt.address, addr.Name, addr.Address, tt.wantName, tt.wantAddress)
+            }
+        })
+    }
+}
+
+func TestValidateRecipient(t *testing.T) {
+    for _, recipient := range []string{"alice@example.com", "Alice <alice@example.com>", "jörg@example.com"} {
+        err := ValidateRecipient(context.Background(), recipient, false)
+        if err != nil {
+            t.Errorf("ValidateRecipient(%q) returned error: %v", recipient, err)
+        }
+    }
+
+    for _, recipient := range []string{"user@gmial..com", "not an address"} {
+        err := ValidateRecipient(context.Background(), recipient, false)
+        if !errors.Is(err, ErrInvalidRecipient) {
+            t.Errorf("ValidateRecipient(%q) = %v; want ErrInvalidRecipient", recipient, err)
+        }
+    }
+}
+
+// Send must reject a malformed recipient before rendering the templates or dialing
+// the SMTP server, neither of which is set up here.
+func TestSendInvalidRecipient(t *testing.T) {
+    m := New(Config{Host: "smtp.invalid"})
+
+    err := m.Send("Greenlight no-reply@", "user_welcome.tmpl", nil)
+    if !errors.Is(err, ErrInvalidRecipient) {
+        t.Fatalf("Send() = %v; want ErrInvalidRecipient", err)
+    }
+}
@@ -0,0 +1,230 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/go-mail/mail"
+)
+
+// Encryption is the security mode used for the connection to the SMTP server.
+type Encryption string
+
+const (
+    // EncryptionNone sends everything, including the credentials, in the clear. It
+    // is only meant for local test servers.
+    EncryptionNone Encryption = "none"
+    // EncryptionSTARTTLS connects in the clear (usually to port 587 or 25) and then
+    // upgrades the connection with the STARTTLS command. The upgrade is mandatory:
+    // if the server doesn't offer it, no email is sent.
+    EncryptionSTARTTLS Encryption = "starttls"
+    // EncryptionTLS uses TLS from the start of the connection ("implicit TLS"),
+    // usually on port 465.
+    EncryptionTLS Encryption = "tls"
+)
+
+// ErrInsecureConnection is returned when the security of the connection to the
+// SMTP server doesn't match the configured Encryption mode.
+var ErrInsecureConnection = errors.New("smtp connection security doesn't match the configured mode")
+
+// ParseEncryption parses the value of the -smtp-encryption flag.
+func ParseEncryption(s string) (Encryption, error) {
+    switch e := Encryption(strings.ToLower(s)); e {
+    case EncryptionNone, EncryptionSTARTTLS, EncryptionTLS:
+        return e, nil
+    default:
+        return "", fmt.Errorf("unknown smtp encryption %q: must be %s, %s or %s", s, EncryptionNone, EncryptionSTARTTLS, EncryptionTLS)
+    }
+}
+
+// Config holds the settings for a Mailer.
+type Config struct {
+    Host string
+    Port int
+    Username string
+    Password string
+    // Sender is the address that emails are sent from, such as
+    // "Alice Smith <alice@example.com>".
+    Sender string
+    Encryption Encryption
+    // Timeout bounds each stage of sending an email separately: dialing the
+    // server, the TLS and SMTP handshake (including authentication), and sending
+    // the message itself. This way a hung server can hold up a send for at most a
+    // few multiples of the timeout, rather than forever.
+    Timeout time.Duration
+    // SkipVerify turns off verification of the server's TLS certificate. It must
+    // only be used against test servers with self-signed certificates.
+    SkipVerify bool
+}
+
+// smtpConn is an open connection to the SMTP server, ready to send on.
+type smtpConn struct {
+    conn net.Conn
+    client *smtp.Client
+}
+
+// dial connects and authenticates to the SMTP server, with the Encryption mode
+// and timeouts from the configuration. If the connection ends up less secure than
+// configured, it is closed and ErrInsecureConnection is returned.
+//
+// We talk to the server with net/smtp here, rather than with the mail.Dialer,
+// because the dialer applies a single deadline to the whole conversation and
+// only starts it once the TLS handshake is already under way.
+func (m Mailer) dial() (*smtpConn, error) {
+    addr := net.JoinHostPort(m.cfg.Host, fmt.Sprint(m.cfg.Port))
+
+    conn, err := net.DialTimeout("tcp", addr, m.cfg.Timeout)
+    if err != nil {
+        return nil, err
+    }
+
+    // Everything up to and including authentication falls under the handshake
+    // deadline.
+    conn.SetDeadline(time.Now().Add(m.cfg.Timeout))
+
+    tlsConfig := &tls.Config{
+        ServerName: m.cfg.Host,
+        InsecureSkipVerify: m.cfg.SkipVerify,
+        MinVersion: tls.VersionTLS12,
+    }
+
+    if m.cfg.Encryption == EncryptionTLS {
+        tlsConn := tls.Client(conn, tlsConfig)
+        err = tlsConn.Handshake()
+        if err != nil {
+            conn.Close()
+            return nil, err
+        }
+        conn = tlsConn
+    }
+
+    client, err := smtp.NewClient(conn, m.cfg.Host)
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+
+    c := &smtpConn{conn: conn, client: client}
+
+    err = c.handshake(m.cfg, tlsConfig)
+    if err != nil {
+        client.Close()
+        return nil, err
+    }
+
+    return c, nil
+}
+
+// handshake upgrades the connection with STARTTLS if configured to, checks that
+// the connection is as secure as configured, and then authenticates.
+func (c *smtpConn) handshake(cfg Config, tlsConfig *tls.Config) error {
+    if cfg.Encryption == EncryptionSTARTTLS {
+        if ok, _ := c.client.Extension("STARTTLS"); !ok {
+            return fmt.Errorf("%w: server doesn't support STARTTLS", ErrInsecureConnection)
+        }
+
+        err := c.client.StartTLS(tlsConfig)
+        if err != nil {
+            return err
+        }
+    }
+
+    err := c.checkSecurity(cfg.Encryption)
+    if err != nil {
+        return err
+    }
+
+    if cfg.Username == "" {
+        return nil
+    }
+
+    ok, mechanisms := c.client.Extension("AUTH")
+    if !ok {
+        return errors.New("smtp server doesn't support authentication")
+    }
+
+    // Prefer CRAM-MD5 if the server offers it, as it doesn't send the password
+    // itself. Note that smtp.PlainAuth refuses to send the credentials over an
+    // unencrypted connection unless the server is on localhost.
+    var auth smtp.Auth
+    if strings.Contains(mechanisms, "CRAM-MD5") {
+        auth = smtp.CRAMMD5Auth(cfg.Username, cfg.Password)
+    } else {
+        auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+    }
+
+    return c.client.Auth(auth)
+}
+
+// checkSecurity returns an error wrapping ErrInsecureConnection if the connection
+// isn't encrypted when the configured mode says that it should be.
+func (c *smtpConn) checkSecurity(encryption Encryption) error {
+    if encryption == EncryptionNone {
+        return nil
+    }
+
+    // With implicit TLS the connection itself is the *tls.Conn, whereas after
+    // STARTTLS the smtp.Client knows about the upgrade.
+    var state tls.ConnectionState
+    var encrypted bool
+
+    if tlsConn, ok := c.conn.(*tls.Conn); ok {
+        state, encrypted = tlsConn.ConnectionState(), true
+    } else {
+        state, encrypted = c.client.TLSConnectionState()
+    }
+
+    if !encrypted || !state.HandshakeComplete {
+        return fmt.Errorf("%w: expected %s but the connection is not encrypted", ErrInsecureConnection, encryption)
+    }
+
+    return nil
+}
+
+// send sends the messages over the connection, under a fresh deadline, and then
+// closes it.
+func (c *smtpConn) send(timeout time.Duration, msgs ...*mail.Message) error {
+    defer c.client.Close()
+
+    c.conn.SetDeadline(time.Now().Add(timeout))
+
+    sender := mail.SendFunc(func(from string, to []string, msg io.WriterTo) error {
+        err := c.client.Mail(from)
+        if err != nil {
+            return err
+        }
+
+        for _, addr := range to {
+            err = c.client.Rcpt(addr)
+            if err != nil {
+                return err
+            }
+        }
+
+        w, err := c.client.Data()
+        if err != nil {
+            return err
+        }
+
+        _, err = msg.WriteTo(w)
+        if err != nil {
+            w.Close()
+            return err
+        }
+
+        return w.Close()
+    })
+
+    err := mail.Send(sender, msgs...)
+    if err != nil {
+        return err
+    }
+
+    return c.client.Quit()
+}
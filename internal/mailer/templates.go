@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownTemplate is returned by Preview() when there is no template with the
+// given name.
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Message is an email rendered from a template, ready to be sent.
+type Message struct {
+    Subject string
+    PlainBody string
+    HTMLBody string
+}
+
+// Templates returns the names of the email templates, which are their file names
+// without the .tmpl extension (e.g. "user_welcome"), in alphabetical order.
+func Templates() ([]string, error) {
+    files, err := fs.Glob(templateFS, "templates/*.tmpl")
+    if err != nil {
+        return nil, err
+    }
+
+    names := make([]string, len(files))
+    for i, file := range files {
+        names[i] = strings.TrimSuffix(path.Base(file), ".tmpl")
+    }
+    sort.Strings(names)
+
+    return names, nil
+}
+
+// Preview renders the named template with representative data, so that changes to
+// a template can be checked before they reach real users. The data is read from
+// the {name}.fixture.json file alongside the template, and is decoded as generic
+// JSON, so its keys must match the field names that the template uses (e.g. "ID"
+// for {{.ID}}).
+func Preview(name string) (*Message, error) {
+    names, err := Templates()
+    if err != nil {
+        return nil, err
+    }
+
+    i := sort.SearchStrings(names, name)
+    if i == len(names) || names[i] != name {
+        return nil, ErrUnknownTemplate
+    }
+
+    js, err := fs.ReadFile(templateFS, "templates/"+name+".fixture.json")
+    if err != nil {
+        return nil, fmt.Errorf("reading fixture for template %q: %w", name, err)
+    }
+
+    var data interface{}
+
+    err = json.Unmarshal(js, &data)
+    if err != nil {
+        return nil, fmt.Errorf("decoding fixture for template %q: %w", name, err)
+    }
+
+    return Render(name+".tmpl", data)
+}
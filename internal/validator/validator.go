@@ -1,22 +1,36 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
-
+	"strings"
 )
 
 var (
+    // LanguageTagRX matches well-formed BCP 47 language tags such as "fr", "en-GB"
+    // or "zh-Hant-TW". It checks the shape of the tag, not that the subtags exist.
+    LanguageTagRX = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-([a-zA-Z]{2}|[0-9]{3}))?(-([a-zA-Z0-9]{5,8}|[0-9][a-zA-Z0-9]{3}))*$`)
+    // UUIDRX matches UUIDs in their canonical hyphenated form, in either case.
+    UUIDRX = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
     EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
-// define a new validator type which contains a map of validation errors
+// define a new validator type which contains a map of validation errors. The map
+// values are message keys (e.g. "required") rather than literal English text, and
+// are translated into the client's language when the response is written.
 type Validator struct {
     Errors map[string]string
+    // Params holds the values to interpolate into the message for a given key,
+    // for messages which contain placeholders like {allowed}.
+    Params map[string]map[string]string
 }
 
 // New is a helper which creates a new validator instance with an empty error map
 func New() *Validator {
-    return &Validator{Errors: make(map[string]string)}
+    return &Validator{
+        Errors: make(map[string]string),
+        Params: make(map[string]map[string]string),
+    }
 }
 
 // Valid returns true if the errors map doesnt contain any entries
@@ -24,17 +38,33 @@ func (v *Validator) Valid() bool {
     return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the map (so long as no entry already exists for the given key)
-func (v *Validator) AddError(key, message string) {
+// AddError adds an error message key to the map (so long as no entry already exists for the given key)
+func (v *Validator) AddError(key, messageKey string) {
     if _, exists := v.Errors[key]; !exists {
-        v.Errors[key] = message
+        v.Errors[key] = messageKey
     }
 }
 
-// Check adds an error message to the map only if a validation check is not 'ok'
-func (v *Validator) Check(ok bool, key, message string) {
+// AddErrorParams is like AddError, but also records the values to interpolate into
+// the message (so long as no entry already exists for the given key)
+func (v *Validator) AddErrorParams(key, messageKey string, params map[string]string) {
+    if _, exists := v.Errors[key]; !exists {
+        v.Errors[key] = messageKey
+        v.Params[key] = params
+    }
+}
+
+// Check adds an error message key to the map only if a validation check is not 'ok'
+func (v *Validator) Check(ok bool, key, messageKey string) {
     if !ok {
-        v.AddError(key, message)
+        v.AddError(key, messageKey)
+    }
+}
+
+// CheckParams is like Check, but also records the values to interpolate into the message
+func (v *Validator) CheckParams(ok bool, key, messageKey string, params map[string]string) {
+    if !ok {
+        v.AddErrorParams(key, messageKey, params)
     }
 }
 
@@ -67,17 +97,99 @@ func Unique(values []string) bool {
     return len(values) == len(uniqueValues)
 }
 
+// UniqueFold returns true if all string values in a slice are unique, ignoring
+// differences in case (so "Drama" and "drama" count as duplicates)
+func UniqueFold(values []string) bool {
+    uniqueValues := make(map[string]bool)
+
+    for _, value := range values {
+        uniqueValues[strings.ToLower(value)] = true
+    }
 
+    return len(values) == len(uniqueValues)
+}
 
+// Closest returns the candidate nearest to the value, ignoring case, if it is within
+// maxDistance edits (insertions, deletions or substitutions of a character) of it.
+// It is used to suggest what the client probably meant, such as "Drama" for "drma".
+// Ties go to the candidate which comes first.
+func Closest(value string, maxDistance int, candidates ...string) (string, bool) {
+    best, bestDistance := "", maxDistance+1
+
+    for _, candidate := range candidates {
+        d := editDistance(strings.ToLower(value), strings.ToLower(candidate))
+        if d < bestDistance {
+            best, bestDistance = candidate, d
+        }
+    }
 
+    return best, bestDistance <= maxDistance
+}
 
+// editDistance returns the Levenshtein distance between two strings, counting
+// characters rather than bytes. It keeps a single row of the usual table, which is
+// plenty for the short strings (such as genres) that it is used on.
+func editDistance(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
 
+    row := make([]int, len(rb)+1)
+    for j := range row {
+        row[j] = j
+    }
 
+    for i := 1; i <= len(ra); i++ {
+        prev := row[0]
+        row[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            current := row[j]
+            row[j] = smallest(row[j]+1, row[j-1]+1, prev+cost)
+            prev = current
+        }
+    }
 
+    return row[len(rb)]
+}
 
+func smallest(values ...int) int {
+    m := values[0]
+    for _, v := range values[1:] {
+        if v < m {
+            m = v
+        }
+    }
 
+    return m
+}
 
+// HTTPSURL returns true if a string value is an absolute https URL with a host,
+// such as "https://example.com/page".
+func HTTPSURL(value string) bool {
+    u, err := url.Parse(value)
+    if err != nil {
+        return false
+    }
 
+    return u.Scheme == "https" && u.Hostname() != "" && u.User == nil
+}
 
+// URLHostIn returns true if a string value is a URL whose host is one of the given
+// hosts or a subdomain of one of them (so "www.youtube.com" matches "youtube.com").
+func URLHostIn(value string, hosts ...string) bool {
+    u, err := url.Parse(value)
+    if err != nil {
+        return false
+    }
 
+    host := strings.ToLower(u.Hostname())
+    for _, h := range hosts {
+        if host == h || strings.HasSuffix(host, "."+h) {
+            return true
+        }
+    }
 
+    return false
+}
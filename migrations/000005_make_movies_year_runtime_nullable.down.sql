@@ -0,0 +1,2 @@
+ALTER TABLE movies ALTER COLUMN year SET NOT NULL;
+ALTER TABLE movies ALTER COLUMN runtime SET NOT NULL;
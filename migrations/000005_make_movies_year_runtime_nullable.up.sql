@@ -0,0 +1,2 @@
+ALTER TABLE movies ALTER COLUMN year DROP NOT NULL;
+ALTER TABLE movies ALTER COLUMN runtime DROP NOT NULL;
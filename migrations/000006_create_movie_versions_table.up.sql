@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS movie_versions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    changed_by bigint REFERENCES users ON DELETE SET NULL,
+    snapshot jsonb NOT NULL,
+    PRIMARY KEY (movie_id, version)
+);
+
+INSERT INTO movie_versions (movie_id, version, snapshot)
+SELECT id, version, to_jsonb(movies) FROM movies
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS uuid uuid NOT NULL DEFAULT gen_random_uuid();
+CREATE UNIQUE INDEX IF NOT EXISTS movies_uuid_idx ON movies (uuid);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_link_status_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS links_checked_at;
+ALTER TABLE movies DROP COLUMN IF EXISTS link_status;
+ALTER TABLE movies DROP COLUMN IF EXISTS homepage_url;
+ALTER TABLE movies DROP COLUMN IF EXISTS trailer_url;
@@ -0,0 +1,5 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS trailer_url text;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS homepage_url text;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS link_status text NOT NULL DEFAULT 'unchecked';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS links_checked_at timestamp(0) with time zone;
+CREATE INDEX IF NOT EXISTS movies_link_status_idx ON movies (link_status);
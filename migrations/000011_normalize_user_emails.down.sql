@@ -0,0 +1,2 @@
+-- The original form of the normalized email addresses isn't kept, so there is
+-- nothing to undo.
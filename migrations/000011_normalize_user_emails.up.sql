@@ -0,0 +1,28 @@
+-- Emails are stored lowercased and in Unicode normalization form C (NFC), so that
+-- the same address typed with different casing or composed differently (e.g. "é"
+-- as one code point or as "e" plus a combining accent) always matches. The citext
+-- unique constraint already ignores case, but not the normalization form, so check
+-- for users whose addresses only differ in that way first, and report them rather
+-- than failing on the constraint part way through the update.
+DO $$
+DECLARE
+    conflicts text;
+BEGIN
+    SELECT string_agg(format('%s (user ids %s)', normalized, ids), '; ')
+    INTO conflicts
+    FROM (
+        SELECT lower(normalize(email::text, NFC)) AS normalized, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY 1
+        HAVING count(*) > 1
+    ) duplicates;
+
+    IF conflicts IS NOT NULL THEN
+        RAISE EXCEPTION 'users share an email address once normalized: %', conflicts
+            USING HINT = 'Merge or change the email addresses of the conflicting users, then run the migration again.';
+    END IF;
+END $$;
+
+UPDATE users
+SET email = lower(normalize(email::text, NFC))
+WHERE email::text <> lower(normalize(email::text, NFC));
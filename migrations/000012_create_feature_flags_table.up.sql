@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name text PRIMARY KEY,
+    enabled boolean NOT NULL DEFAULT false,
+    rollout_percent integer NOT NULL DEFAULT 100,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT feature_flags_rollout_percent_check CHECK (rollout_percent BETWEEN 0 AND 100)
+);
@@ -0,0 +1,3 @@
+-- The pg_trgm extension is left installed, as other databases on the server (or
+-- objects created outside of our migrations) may depend on it.
+DROP INDEX IF EXISTS movies_title_trgm_idx;
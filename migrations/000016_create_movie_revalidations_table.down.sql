@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS movie_revalidation_results;
+DROP TABLE IF EXISTS movie_revalidations;
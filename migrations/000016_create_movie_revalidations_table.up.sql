@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS movie_revalidations (
+    id bigserial PRIMARY KEY,
+    fix boolean NOT NULL DEFAULT false,
+    status text NOT NULL DEFAULT 'running',
+    total bigint NOT NULL,
+    checked bigint NOT NULL DEFAULT 0,
+    invalid bigint NOT NULL DEFAULT 0,
+    fixed bigint NOT NULL DEFAULT 0,
+    last_movie_id bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    finished_at timestamp(0) with time zone
+);
+
+-- The results aren't tied to the movies table, so that the report of a run still
+-- shows the movies which have been deleted since.
+CREATE TABLE IF NOT EXISTS movie_revalidation_results (
+    run_id bigint NOT NULL REFERENCES movie_revalidations ON DELETE CASCADE,
+    movie_id bigint NOT NULL,
+    errors jsonb NOT NULL,
+    fixed boolean NOT NULL DEFAULT false,
+    PRIMARY KEY (run_id, movie_id)
+);
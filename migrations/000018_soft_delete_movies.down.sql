@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS movie_deletions;
+DELETE FROM movies WHERE deleted_at IS NOT NULL;
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
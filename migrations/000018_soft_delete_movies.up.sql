@@ -0,0 +1,14 @@
+-- Bulk deletes only mark the movies as deleted, so that a mistaken one can be put
+-- right. Every query of the movies leaves out those with a deleted_at time.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- The audit trail of the bulk deletes: who made each one, with which filter, and the
+-- IDs of the movies it deleted.
+CREATE TABLE IF NOT EXISTS movie_deletions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    client_ip text NOT NULL,
+    user_agent text NOT NULL,
+    filter jsonb NOT NULL,
+    movie_ids bigint[] NOT NULL DEFAULT '{}'
+);
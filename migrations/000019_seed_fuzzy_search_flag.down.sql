@@ -0,0 +1 @@
+DELETE FROM feature_flags WHERE name = 'movies.fuzzy_search';
@@ -0,0 +1,3 @@
+INSERT INTO feature_flags (name, enabled, rollout_percent)
+VALUES ('movies.fuzzy_search', true, 100)
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations, so that they can be applied without
+// the migrate tool, such as by the test harness in internal/data/datatest. The
+// files follow the migrate tool's naming scheme of <version>_<name>.up.sql and
+// <version>_<name>.down.sql.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS